@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/trigg3rX/go-backend/execute/aggregator"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/network"
+)
+
+const taskExpiry = 30 * time.Minute
+
+func main() {
+	log.Println("Starting aggregator...")
+
+	// Initialize database connection
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	client, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(os.Getenv("AGGREGATOR_PRIVATE_KEY"), "0x"))
+	if err != nil {
+		log.Fatalf("Invalid AGGREGATOR_PRIVATE_KEY: %v", err)
+	}
+
+	chainClient, err := aggregator.NewChainClient(client, privateKey, chain.HoleskyChainID)
+	if err != nil {
+		log.Fatalf("Failed to create chain client: %v", err)
+	}
+
+	agg := aggregator.NewAggregator(aggregator.NewDBTaskSource(conn), chainClient, chainClient)
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			agg.ExpireTasks(taskExpiry)
+		}
+	}()
+
+	// Keepers send task signatures over the p2p network or post them over HTTP
+	p2pAddress := os.Getenv("AGGREGATOR_P2P_ADDRESS")
+	if p2pAddress == "" {
+		p2pAddress = "/ip4/0.0.0.0/tcp/3010"
+	}
+	ctx := context.Background()
+	identity, err := network.IdentityFromEnv(network.AggregatorPeerName)
+	if err != nil {
+		log.Fatalf("Failed to load p2p identity: %v", err)
+	}
+	nat, err := network.NATConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to read NAT configuration: %v", err)
+	}
+	host, err := network.SetupP2P(ctx, network.P2PConfig{Name: network.AggregatorPeerName, Address: p2pAddress, Identity: identity, NAT: nat})
+	if err != nil {
+		log.Fatalf("Failed to create p2p host: %v", err)
+	}
+	defer host.Close()
+	// Signatures are only taken from registered operators and the task
+	// manager bound to a signer in TRIGGERX_PEER_SIGNERS
+	operators, err := network.NewChainOperatorSet(client, common.HexToAddress(chain.RegistryCoordinatorAddress))
+	if err != nil {
+		log.Fatalf("Failed to set up operator set: %v", err)
+	}
+	signerNames, err := network.SignerNamesFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to read peer signers: %v", err)
+	}
+	compression, err := network.CompressionFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to read compression: %v", err)
+	}
+	messaging := network.NewMessaging(host, network.AggregatorPeerName)
+	messaging.SetCompression(compression)
+	messaging.SetVerifier(network.NewVerifier(operators, signerNames))
+	network.HandleTyped(messaging, network.TaskSignatureMessage, agg.HandleTaskSignature)
+	messaging.Listen()
+	source, err := network.SourceFromEnv(host, conn)
+	if err != nil {
+		log.Fatalf("Failed to set up peer discovery: %v", err)
+	}
+	discovery := network.NewDiscovery(ctx, host, network.AggregatorPeerName, source)
+	if err := discovery.Advertise(); err != nil {
+		log.Printf("Failed to advertise aggregator: %v", err)
+	}
+	if err := discovery.AdvertiseOnChange(); err != nil {
+		log.Printf("Failed to watch aggregator addresses: %v", err)
+	}
+
+	http.HandleFunc("/signatures", agg.HandleSignature)
+
+	port := os.Getenv("AGGREGATOR_PORT")
+	if port == "" {
+		port = "8082"
+	}
+	log.Printf("Aggregator listening on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/trigg3rX/go-backend/execute/indexer"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+func main() {
+	startBlock := flag.Uint64("start-block", 0, "block to start indexing from when no cursor exists")
+	backfillFrom := flag.Uint64("backfill-from", 0, "first block to backfill")
+	backfillTo := flag.Uint64("backfill-to", 0, "last block to backfill, backfills and exits when set")
+	flag.Parse()
+
+	log.Println("Starting event indexer...")
+
+	// Initialize database connection
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	client, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+
+	config := indexer.NewConfig()
+	config.StartBlock = *startBlock
+
+	idx, err := indexer.NewIndexer(conn, client, config)
+	if err != nil {
+		log.Fatalf("Failed to create event indexer: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *backfillTo > 0 {
+		if err := idx.Backfill(ctx, *backfillFrom, *backfillTo); err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		log.Println("Backfill complete")
+		return
+	}
+
+	if err := idx.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatalf("Event indexer stopped: %v", err)
+	}
+	log.Println("Event indexer stopped")
+}
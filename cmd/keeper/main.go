@@ -1,88 +1,282 @@
 package main
 
-// import (
-// 	"context"
-// 	"flag"
-// 	"log"
-
-// 	"github.com/trigg3rX/go-backend/pkg/network"
-// 	"github.com/trigg3rX/go-backend/execute/manager"
-// 	"github.com/trigg3rX/triggerx-keeper/keeper"
-// )
-
-// const (
-//     WORKERS_COUNT     = 5
-//     KEEPER_COUNT     = 3
-// )
-
-// func main() {
-//     // Initialize networking components
-//     messaging := network.NewMessaging()
-//     discovery := network.NewDiscovery()
-
-//     // Initialize the job scheduler
-//     scheduler := manager.NewJobScheduler(WORKERS_COUNT)
-//     scheduler.SetResourceLimits(80.0, 80.0) // Set CPU and Memory thresholds to 80%
-
-//     // Initialize keepers
-//     keepers := make([]*keeper.Keeper, KEEPER_COUNT)
-//     for i := 0; i < KEEPER_COUNT; i++ {
-//         keeperName := fmt.Sprintf("keeper-%d", i+1)
-//         keepers[i] = keeper.NewKeeper(keeperName, messaging)
-//         keepers[i].Start()
-//     }
-
-//     // Example: Schedule a test job
-//     testJob := &manager.Job{
-//         JobID:           "test-job-1",
-//         ArgType:         "string",
-//         Arguments:       map[string]interface{}{"param1": "value1"},
-//         ChainID:         "chain_1",
-//         ContractAddress: "0x123...",
-//         TimeFrame:       3600,    // 1 hour
-//         TimeInterval:    60,      // Execute every 60 seconds
-//         UserID:          "user1",
-//         CreatedAt:       time.Now(),
-//         MaxRetries:      3,
-//         Status:          "pending",
-//     }
-
-//     err := scheduler.AddJob(testJob)
-//     if err != nil {
-//         log.Printf("Failed to add test job: %v", err)
-//     }
-
-//     // Set up graceful shutdown
-//     sigChan := make(chan os.Signal, 1)
-//     signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-//     // Start monitoring system metrics
-//     go monitorSystem(scheduler)
-
-//     // Wait for shutdown signal
-//     <-sigChan
-//     log.Println("Shutting down...")
-    
-//     // Cleanup
-//     scheduler.Stop()
-// }
-
-// // monitorSystem periodically logs system status
-// func monitorSystem(scheduler *manager.JobScheduler) {
-//     ticker := time.NewTicker(30 * time.Second)
-//     defer ticker.Stop()
-
-//     for {
-//         select {
-//         case <-ticker.C:
-//             metrics := scheduler.GetSystemMetrics()
-//             queueStatus := scheduler.GetQueueStatus()
-            
-//             log.Printf("System Status:")
-//             log.Printf("  CPU Usage: %.2f%%", metrics.CPUUsage)
-//             log.Printf("  Memory Usage: %.2f%%", metrics.MemoryUsage)
-//             log.Printf("  Active Jobs: %d", queueStatus["active_jobs"])
-//             log.Printf("  Waiting Jobs: %d", queueStatus["waiting_jobs"])
-//         }
-//     }
-// }
\ No newline at end of file
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/trigg3rX/go-backend/execute/keeper"
+	"github.com/trigg3rX/go-backend/execute/quorum"
+	regcoord "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/RegistryCoordinator"
+	"github.com/trigg3rX/go-backend/pkg/bls"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/network"
+)
+
+const usage = `Usage: keeper <command> [flags]
+
+Commands:
+  run                    start a keeper node
+  keys generate          create a new BLS key file
+  keys import            encrypt an existing BLS private key into a key file
+  keys show              print the public keys and operator ID of a key file
+  keys registration      print the pubkey registration params for an operator
+  identity show          print the peer ID and operator binding of a keeper
+  identity bind          sign a keeper's peer ID with its operator key
+
+The key file password is read from KEEPER_BLS_PASSWORD. Identity files are
+encrypted with TRIGGERX_IDENTITY_PASSWORD, the operator key is read from
+KEEPER_OPERATOR_PRIVATE_KEY. A running keeper is named on the network by
+its operator address and signs its messages with the operator key. With a
+BLS key, TRIGGERX_API_URL and TRIGGERX_VALIDATOR_URL set it also executes
+the jobs it accepts. Job scripts cached in -script-dir are shared with
+other registered keepers.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = run(os.Args[2:])
+	case "keys":
+		if len(os.Args) < 3 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		err = keys(os.Args[2], os.Args[3:])
+	case "identity":
+		if len(os.Args) < 3 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		err = identity(os.Args[2], os.Args[3:])
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	name := fs.String("name", "", "keeper name from network.KeeperConfigs")
+	keyFile := fs.String("key-file", "", "BLS key file used to sign task responses")
+	quorums := fs.String("quorums", "0", "comma separated quorums whose job topics to join")
+	scriptDir := fs.String("script-dir", "data/scripts", "directory of job scripts shared with other keepers, empty to disable")
+	fs.Parse(args)
+
+	// The operator key names the keeper on the network and signs its
+	// messages
+	operatorKey, err := crypto.HexToECDSA(strings.TrimPrefix(os.Getenv("KEEPER_OPERATOR_PRIVATE_KEY"), "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid KEEPER_OPERATOR_PRIVATE_KEY: %v", err)
+	}
+
+	ctx := context.Background()
+	node, err := keeper.NewNode(ctx, *name, operatorKey)
+	if err != nil {
+		return err
+	}
+	if err := node.StartTopics(ctx, strconv.FormatInt(chain.HoleskyChainID, 10), strings.Split(*quorums, ",")); err != nil {
+		return err
+	}
+
+	if *keyFile != "" {
+		keys, err := bls.ReadKeyFile(*keyFile, os.Getenv("KEEPER_BLS_PASSWORD"))
+		if err != nil {
+			return err
+		}
+		node.SetKeys(keys)
+		log.Printf("Loaded BLS key with operator ID %s", operatorID(keys))
+
+		// Accepted jobs are executed and their task responses sent to the
+		// aggregator once the validator has judged the execution
+		apiURL, validatorURL := os.Getenv("TRIGGERX_API_URL"), os.Getenv("TRIGGERX_VALIDATOR_URL")
+		if apiURL != "" && validatorURL != "" {
+			node.SetExecutor(keeper.NewExecutor(apiURL, validatorURL, operatorKey))
+		} else {
+			log.Printf("TRIGGERX_API_URL or TRIGGERX_VALIDATOR_URL is not set, jobs are only acknowledged")
+		}
+	}
+
+	// Messages are accepted from registered operators and from the task
+	// manager and aggregator bound to a signer in TRIGGERX_PEER_SIGNERS
+	client, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		return err
+	}
+	operators, err := network.NewChainOperatorSet(client, common.HexToAddress(chain.RegistryCoordinatorAddress))
+	if err != nil {
+		return err
+	}
+	names, err := network.SignerNamesFromEnv()
+	if err != nil {
+		return err
+	}
+	node.SetVerifier(network.NewVerifier(operators, names))
+
+	// Cached job scripts are served to registered keepers only
+	if *scriptDir != "" {
+		scripts, err := keeper.NewScriptCache(*scriptDir)
+		if err != nil {
+			return err
+		}
+		node.SetScriptCache(scripts)
+	}
+
+	return node.Start()
+}
+
+func keys(command string, args []string) error {
+	fs := flag.NewFlagSet("keys "+command, flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "path of the BLS key file")
+	privateKey := fs.String("private-key", "", "decimal BLS private key to import")
+	operator := fs.String("operator", "", "operator address the key is registered for")
+	fs.Parse(args)
+
+	if *keyFile == "" {
+		return fmt.Errorf("-key-file is required")
+	}
+	password := os.Getenv("KEEPER_BLS_PASSWORD")
+
+	switch command {
+	case "generate", "import":
+		if password == "" {
+			return fmt.Errorf("KEEPER_BLS_PASSWORD must be set to encrypt the key")
+		}
+		if _, err := os.Stat(*keyFile); err == nil {
+			return fmt.Errorf("%s already exists", *keyFile)
+		}
+
+		var keyPair *bls.KeyPair
+		var err error
+		if command == "generate" {
+			keyPair, err = bls.GenerateKeyPair()
+		} else {
+			keyPair, err = bls.KeyPairFromString(*privateKey)
+		}
+		if err != nil {
+			return err
+		}
+		if err := keyPair.SaveKeyFile(*keyFile, password); err != nil {
+			return err
+		}
+		log.Printf("Saved BLS key with operator ID %s to %s", operatorID(keyPair), *keyFile)
+		return nil
+
+	case "show":
+		keyPair, err := bls.ReadKeyFile(*keyFile, password)
+		if err != nil {
+			return err
+		}
+		return printJSON(map[string]string{
+			"operator_id": operatorID(keyPair),
+			"pubkey_g1":   hexutil.Encode(keyPair.PubG1.Bytes()),
+			"pubkey_g2":   hexutil.Encode(keyPair.PubG2.Bytes()),
+		})
+
+	case "registration":
+		if !common.IsHexAddress(*operator) {
+			return fmt.Errorf("-operator must be an address")
+		}
+		keyPair, err := bls.ReadKeyFile(*keyFile, password)
+		if err != nil {
+			return err
+		}
+
+		client, err := chain.Dial(chain.HoleskyChainID)
+		if err != nil {
+			return err
+		}
+		contract, err := regcoord.NewContractRegistryCoordinator(common.HexToAddress(chain.RegistryCoordinatorAddress), client)
+		if err != nil {
+			return fmt.Errorf("failed to bind registry coordinator: %v", err)
+		}
+
+		params, err := quorum.PubkeyRegistrationParams(context.Background(), contract, keyPair, common.HexToAddress(*operator))
+		if err != nil {
+			return err
+		}
+		return printJSON(params)
+
+	default:
+		return fmt.Errorf("unknown keys command %q", command)
+	}
+}
+
+func identity(command string, args []string) error {
+	fs := flag.NewFlagSet("identity "+command, flag.ExitOnError)
+	name := fs.String("name", "", "keeper name the identity file is named after")
+	fs.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("-name is required")
+	}
+	password := os.Getenv("TRIGGERX_IDENTITY_PASSWORD")
+	if password == "" {
+		return fmt.Errorf("TRIGGERX_IDENTITY_PASSWORD must be set")
+	}
+	path := network.IdentityPath(*name)
+
+	id, err := network.LoadOrCreateIdentity(path, password)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "show":
+	case "bind":
+		operatorKey, err := crypto.HexToECDSA(strings.TrimPrefix(os.Getenv("KEEPER_OPERATOR_PRIVATE_KEY"), "0x"))
+		if err != nil {
+			return fmt.Errorf("invalid KEEPER_OPERATOR_PRIVATE_KEY: %v", err)
+		}
+		if err := id.Bind(operatorKey); err != nil {
+			return err
+		}
+		if err := id.Save(path, password); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown identity command %q", command)
+	}
+
+	peerID, err := id.PeerID()
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]string{
+		"peer_id":   peerID.String(),
+		"operator":  id.Operator,
+		"signature": id.Signature,
+		"file":      path,
+	})
+}
+
+func operatorID(keyPair *bls.KeyPair) string {
+	id := bls.OperatorID(keyPair.PubG1)
+	return hexutil.Encode(id[:])
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
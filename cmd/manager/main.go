@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
 	"github.com/trigg3rX/go-backend/execute/manager"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/ledger"
+	"github.com/trigg3rX/go-backend/pkg/network"
 )
 
 // toUint converts various types to uint
@@ -38,62 +47,90 @@ func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 
 	// Initialize the job scheduler with 5 workers
-	jobScheduler := manager.NewJobScheduler(5)
+	jobScheduler, err := manager.NewJobScheduler(5)
+	if err != nil {
+		log.Fatalf("Failed to create job scheduler: %v", err)
+	}
 	jobScheduler.Cron.Start()
 	defer jobScheduler.Stop()
 
-	// Create multiple test jobs with varied properties
-	jobs := []struct {
-		jobID        string
-		timeFrame    int64
-		timeInterval int64
-		maxRetries   int
-	}{
-		{"job_1", 120, 15, 2},
-		{"job_2", 60, 5, 3},
-		{"job_3", 90, 30, 5},
-		{"job_4", 75, 20, 3},
-		{"job_5", 45, 10, 2},
-		{"job_6", 100, 25, 4},
-		{"job_7", 80, 15, 3},
-		{"job_8", 70, 20, 2},
-		{"job_9", 55, 10, 3},
-		{"job_10", 65, 25, 4},
-	}
-
-	// Add jobs to the scheduler
-	for _, jobConfig := range jobs {
-		job := &manager.Job{
-			JobID:             jobConfig.jobID,
-			ArgType:           "Dynamic",
-			Arguments:         map[string]interface{}{},
-			ChainID:           "chain_1",
-			ContractAddress:   "0xa5854f4835769c3D84319DcB41cb449f6b858F83",
-			JobCostPrediction: 0.5,
-			Stake:             1.0,
-			Status:            "pending",
-			TargetFunction:    "updatePrice",
-			TimeFrame:         jobConfig.timeFrame,
-			TimeInterval:      jobConfig.timeInterval,
-			UserID:            "system_test",
-			CreatedAt:         time.Now(),
-			MaxRetries:        jobConfig.maxRetries,
-			CodeURL:		   "QmPQcutXx7M4tPR1SkvNbosKcjFTaDxTZsizgKbZnVkA9e",
-		}
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	// Keepers are found through keeper_data and the other discovery backends
+	if err := jobScheduler.StartDiscovery(conn); err != nil {
+		log.Fatalf("Failed to start peer discovery: %v", err)
+	}
+
+	// Dispatch skips blacklisted keepers and holds jobs while the TriggerX
+	// contracts are paused
+	ethClient, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		log.Fatalf("Failed to connect to chain: %v", err)
+	}
+	guard, err := manager.NewContractGuard(ethClient)
+	if err != nil {
+		log.Fatalf("Failed to set up contract guard: %v", err)
+	}
+	jobScheduler.SetContractGuard(guard)
+	// The guard retries chain errors itself and holds jobs until it has
+	// read the pause state
+	go guard.Run(context.Background(), 12*time.Second)
+
+	// Job owners are charged from their stake ledger balance for every
+	// execution the validator finds valid
+	jobScheduler.SetLedger(ledger.NewLedger(conn), conn)
+
+	// Keepers are dispatched from the quorums kept current by the quorum snapshotter
+	if err := jobScheduler.LoadQuorums(conn); err != nil {
+		log.Fatalf("Failed to load quorums: %v", err)
+	}
+	go jobScheduler.WatchQuorums(conn, time.Minute)
 
-		if err := jobScheduler.AddJob(job); err != nil {
-			log.Printf("Failed to add job %s: %v", job.JobID, err)
-		} else {
-			log.Printf("Added job %s to scheduler with TimeFrame: %ds, Interval: %ds, MaxRetries: %d",
-				job.JobID, job.TimeFrame, job.TimeInterval, job.MaxRetries)
+	// Keeper messages, heartbeats and results are only accepted when signed
+	// by a registered operator
+	operators, err := network.NewChainOperatorSet(ethClient, common.HexToAddress(chain.RegistryCoordinatorAddress))
+	if err != nil {
+		log.Fatalf("Failed to set up operator set: %v", err)
+	}
+	signerNames, err := network.SignerNamesFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to read peer signers: %v", err)
+	}
+	jobScheduler.SetMessageVerifier(network.NewVerifier(operators, signerNames))
+
+	// Jobs are broadcast to quorums and keeper heartbeats collected over
+	// pub/sub topics
+	if err := jobScheduler.StartTopics(strconv.FormatInt(chain.HoleskyChainID, 10)); err != nil {
+		log.Fatalf("Failed to start pub/sub topics: %v", err)
+	}
+
+	// On-chain task creation is enabled when the manager has a key to send
+	// createNewTask with. The key also signs messages to keepers, which bind
+	// task_manager to it in TRIGGERX_PEER_SIGNERS.
+	if key := os.Getenv("MANAGER_PRIVATE_KEY"); key != "" {
+		privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(key, "0x"))
+		if err != nil {
+			log.Fatalf("Invalid MANAGER_PRIVATE_KEY: %v", err)
 		}
+		jobScheduler.SetMessageSigner(network.NewECDSASigner(privateKey))
 
-		// Smaller delay to spread out job starts
-		time.Sleep(1 * time.Second)
+		taskCreator, err := newTaskCreator(conn, key)
+		if err != nil {
+			log.Fatalf("Failed to set up task creation: %v", err)
+		}
+		jobScheduler.SetTaskCreator(taskCreator)
 	}
 
-	// Wait to allow jobs to process
-	time.Sleep(60 * time.Second)
+	// Active jobs are loaded from job_data, and jobs created through the API
+	// are picked up as they appear
+	if err := jobScheduler.LoadJobs(conn); err != nil {
+		log.Fatalf("Failed to load jobs: %v", err)
+	}
+	go jobScheduler.WatchJobs(conn, 30*time.Second)
 
 	// Keep the main goroutine alive and log system status periodically
 	statusTicker := time.NewTicker(10 * time.Second)
@@ -123,6 +160,18 @@ func main() {
 		json.NewEncoder(w).Encode(status)
 	})
 
+	http.HandleFunc("/contracts/status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(guard.Status())
+	})
+
+	http.HandleFunc("/keepers/heartbeats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jobScheduler.KeeperHeartbeats())
+	})
+
+	http.HandleFunc("/keepers/health", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jobScheduler.KeeperHealth())
+	})
+
 	http.HandleFunc("/job/", func(w http.ResponseWriter, r *http.Request) {
 		jobID := r.URL.Path[len("/job/"):]
 		if jobID == "" {
@@ -143,4 +192,40 @@ func main() {
 	serverAddr := ":8080"
 	fmt.Printf("Server starting on %s\n", serverAddr)
 	log.Fatal(http.ListenAndServe(serverAddr, nil))
-}
\ No newline at end of file
+}
+
+// newTaskCreator configures on-chain task creation from the environment:
+// TASK_QUORUM_NUMBERS (default "0"), TASK_QUORUM_THRESHOLD (default 66) and
+// TASK_BATCH_SIZE, the executions covered by one task (default 1)
+func newTaskCreator(conn *database.Connection, privateKeyHex string) (*manager.TaskCreator, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MANAGER_PRIVATE_KEY: %v", err)
+	}
+
+	config := manager.TaskConfig{QuorumNumbers: []byte{0}, QuorumThreshold: 66, BatchSize: 1}
+	if value := os.Getenv("TASK_QUORUM_NUMBERS"); value != "" {
+		if config.QuorumNumbers, err = manager.ParseQuorumNumbers(value); err != nil {
+			return nil, err
+		}
+	}
+	if value := os.Getenv("TASK_QUORUM_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || threshold > 100 {
+			return nil, fmt.Errorf("invalid TASK_QUORUM_THRESHOLD %q", value)
+		}
+		config.QuorumThreshold = uint8(threshold)
+	}
+	if value := os.Getenv("TASK_BATCH_SIZE"); value != "" {
+		if config.BatchSize, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid TASK_BATCH_SIZE %q", value)
+		}
+	}
+
+	client, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	return manager.NewTaskCreator(conn, client, privateKey, chain.HoleskyChainID, config)
+}
@@ -1,35 +1,480 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
-	"github.com/trigg3rX/go-backend/execute/quorum"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 
+	"github.com/trigg3rX/go-backend/execute/quorum"
 	regcoord "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/RegistryCoordinator"
+	"github.com/trigg3rX/go-backend/pkg/bls"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
 )
 
+const usage = `Usage: quorum <command> [flags]
+
+Commands:
+  create-quorum    create a quorum (registry coordinator owner only)
+  register         register the operator for quorums
+  deregister       deregister the operator from quorums
+  update-socket    change the operator's socket
+  list-quorums     print the parameters of every quorum
+  show-operator    print an operator's registration
+  plan             decide which quorum an operator should join and act on it
+  register-keeper  register the operator with the registry coordinator and the
+                   service manager and record it in keeper_data, resuming an
+                   interrupted registration
+
+Transactions are signed with QUORUM_PRIVATE_KEY. register-keeper sends
+registerKeeperToTriggerX with SERVICE_MANAGER_PRIVATE_KEY when it is set. Every command that sends a
+transaction accepts --dry-run to print the calldata instead. Run
+"quorum <command> -h" for the flags of a command.`
+
+// registerParams are the register flags, which can also be given as a JSON file
+type registerParams struct {
+	QuorumNumbers string `json:"quorum_numbers"`
+	Socket        string `json:"socket"`
+	BLSKeyFile    string `json:"bls_key_file"`
+	// Operator signature over the AVS registration digest
+	Signature string `json:"signature"`
+	Salt      string `json:"salt"`
+	Expiry    string `json:"expiry"`
+	// p2p address stored in keeper_data by register-keeper
+	ConnectionAddress string `json:"connection_address"`
+}
+
 func main() {
-	err := quorum.Create()
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), os.Args[1], os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the calldata instead of sending the transaction")
+	paramsFile := fs.String("params", "", "JSON file with the command parameters, flags override it")
+
+	switch command {
+	case "create-quorum":
+		params := quorum.QuorumParams{}
+		maxOperators := fs.Uint("max-operators", 0, fmt.Sprintf("maximum operators in the quorum (default %d)", quorum.MAX_OPERATORS_PER_QUORUM))
+		kickOperator := fs.Uint("kick-bips-operator", 0, "stake in BIPs a new operator needs over the kicked operator")
+		kickTotal := fs.Uint("kick-bips-total", 0, "stake in BIPs of the total below which an operator can be kicked")
+		minimumStake := fs.String("minimum-stake", "", "minimum stake in wei")
+		strategies := fs.String("strategies", "", "comma separated strategy:multiplier pairs")
+		fs.Parse(args)
+
+		if err := loadParams(*paramsFile, &params); err != nil {
+			return err
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "max-operators":
+				params.MaxOperatorCount = uint32(*maxOperators)
+			case "kick-bips-operator":
+				params.KickBIPsOfOperatorStake = uint16(*kickOperator)
+			case "kick-bips-total":
+				params.KickBIPsOfTotalStake = uint16(*kickTotal)
+			case "minimum-stake":
+				params.MinimumStake = *minimumStake
+			}
+		})
+		if *strategies != "" {
+			params.Strategies = nil
+			for _, pair := range strings.Split(*strategies, ",") {
+				parts := strings.SplitN(pair, ":", 2)
+				if len(parts) != 2 {
+					return fmt.Errorf("invalid strategy %q, expected strategy:multiplier", pair)
+				}
+				params.Strategies = append(params.Strategies, quorum.StrategyParams{Strategy: parts[0], Multiplier: parts[1]})
+			}
+		}
+
+		client, err := newClient(*dryRun)
+		if err != nil {
+			return err
+		}
+		return client.CreateQuorum(ctx, params)
+
+	case "register":
+		params := registerParams{}
+		quorums := fs.String("quorums", "", "comma separated quorum numbers")
+		socket := fs.String("socket", "", "operator socket")
+		keyFile := fs.String("bls-key-file", "", "BLS key file, its password is read from KEEPER_BLS_PASSWORD")
+		signature := fs.String("signature", "", "operator signature over the AVS registration digest")
+		salt := fs.String("salt", "", "32 byte salt of the operator signature")
+		expiry := fs.String("expiry", "", "expiry timestamp of the operator signature")
+		fs.Parse(args)
+
+		if err := loadParams(*paramsFile, &params); err != nil {
+			return err
+		}
+		override(fs, "quorums", &params.QuorumNumbers, *quorums)
+		override(fs, "socket", &params.Socket, *socket)
+		override(fs, "bls-key-file", &params.BLSKeyFile, *keyFile)
+		override(fs, "signature", &params.Signature, *signature)
+		override(fs, "salt", &params.Salt, *salt)
+		override(fs, "expiry", &params.Expiry, *expiry)
+
+		return register(ctx, params, *dryRun)
+
+	case "deregister":
+		quorums := fs.String("quorums", "", "comma separated quorum numbers")
+		fs.Parse(args)
+
+		quorumNumbers, err := parseQuorumNumbers(*quorums)
+		if err != nil {
+			return err
+		}
+		client, err := newClient(*dryRun)
+		if err != nil {
+			return err
+		}
+		return client.DeregisterOperator(ctx, quorumNumbers)
+
+	case "update-socket":
+		socket := fs.String("socket", "", "new operator socket")
+		fs.Parse(args)
+
+		if *socket == "" {
+			return fmt.Errorf("-socket is required")
+		}
+		client, err := newClient(*dryRun)
+		if err != nil {
+			return err
+		}
+		return client.UpdateSocket(ctx, *socket)
+
+	case "list-quorums":
+		fs.Parse(args)
+
+		client, err := newClient(false)
+		if err != nil {
+			return err
+		}
+		quorums, err := client.ListQuorums(ctx)
+		if err != nil {
+			return err
+		}
+		return printJSON(quorums)
+
+	case "show-operator":
+		operator := fs.String("operator", "", "operator address, defaults to the QUORUM_PRIVATE_KEY address")
+		fs.Parse(args)
+
+		client, err := newClient(false)
+		if err != nil {
+			return err
+		}
+		address, err := operatorAddress(client, *operator)
+		if err != nil {
+			return err
+		}
+		info, err := client.ShowOperator(ctx, address)
+		if err != nil {
+			return err
+		}
+		return printJSON(info)
+
+	case "plan":
+		params := registerParams{}
+		operator := fs.String("operator", "", "operator to place, defaults to the QUORUM_PRIVATE_KEY address")
+		stake := fs.String("stake", "", "operator stake in wei, needed to consider churn")
+		quorumParamsFile := fs.String("quorum-params", "", "JSON file with create-quorum parameters used when a quorum must be opened")
+		fs.Parse(args)
+
+		if err := loadParams(*paramsFile, &params); err != nil {
+			return err
+		}
+		return plan(ctx, *operator, *stake, *quorumParamsFile, params, *dryRun)
+
+	case "register-keeper":
+		params := registerParams{}
+		quorums := fs.String("quorums", "", "comma separated quorum numbers")
+		socket := fs.String("socket", "", "operator socket")
+		keyFile := fs.String("bls-key-file", "", "BLS key file, its password is read from KEEPER_BLS_PASSWORD")
+		connection := fs.String("connection-address", "", "keeper p2p address stored in keeper_data")
+		fs.Parse(args)
+
+		if err := loadParams(*paramsFile, &params); err != nil {
+			return err
+		}
+		override(fs, "quorums", &params.QuorumNumbers, *quorums)
+		override(fs, "socket", &params.Socket, *socket)
+		override(fs, "bls-key-file", &params.BLSKeyFile, *keyFile)
+		override(fs, "connection-address", &params.ConnectionAddress, *connection)
+
+		return registerKeeper(ctx, params)
+
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+// plan prints where an operator should be placed and, unless it is a dry
+// run, sends the transactions that carry the plan out
+func plan(ctx context.Context, operator, stake, quorumParamsFile string, params registerParams, dryRun bool) error {
+	client, err := newClient(dryRun)
 	if err != nil {
-		log.Fatalf("Error creating quorum: %v", err)
+		return err
+	}
+	address, err := operatorAddress(client, operator)
+	if err != nil {
+		return err
 	}
 
-	log.Println("Quorum created successfully")
+	var operatorStake *big.Int
+	if stake != "" {
+		var ok bool
+		if operatorStake, ok = new(big.Int).SetString(stake, 10); !ok {
+			return fmt.Errorf("invalid stake %q", stake)
+		}
+	}
 
-	// Example usage for registration
-	pubkeyParams := regcoord.IBLSApkRegistryPubkeyRegistrationParams{
-		// Fill in the required fields
+	ethClient, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		return err
 	}
-	signature := regcoord.ISignatureUtilsSignatureWithSaltAndExpiry{
-    // Fill in the required fields
+	snapshotter, err := quorum.NewSnapshotter(nil, ethClient)
+	if err != nil {
+		return err
 	}
-	err = quorum.RegisterOperator([]byte{0, 1}, "socket-address", pubkeyParams, signature)
+	states, err := snapshotter.LatestState(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// Example usage for deregistration
-	err = quorum.DeregisterOperator([]byte{0, 1})
+	placement := quorum.PlanPlacement(states, address, operatorStake)
+	if err := printJSON(placement); err != nil {
+		return err
+	}
+	// A dry run without registration params only reviews the plan
+	if dryRun && params.BLSKeyFile == "" {
+		return nil
+	}
+
+	switch placement.Action {
+	case quorum.ActionCreateQuorum:
+		if quorumParamsFile == "" {
+			return fmt.Errorf("-quorum-params is required to open quorum %d", placement.QuorumNumber)
+		}
+		quorumParams := quorum.QuorumParams{}
+		if err := loadParams(quorumParamsFile, &quorumParams); err != nil {
+			return err
+		}
+		if err := client.CreateQuorum(ctx, quorumParams); err != nil {
+			return err
+		}
+		params.QuorumNumbers = strconv.Itoa(int(placement.QuorumNumber))
+		return register(ctx, params, dryRun)
+
+	case quorum.ActionJoin:
+		params.QuorumNumbers = strconv.Itoa(int(placement.QuorumNumber))
+		return register(ctx, params, dryRun)
+
+	case quorum.ActionChurn:
+		return fmt.Errorf("replacing %s needs a churn approver signature, register with churn once it is approved",
+			placement.Kick.Operator.Hex())
+	}
+	return nil
+}
+
+func register(ctx context.Context, params registerParams, dryRun bool) error {
+	quorumNumbers, err := parseQuorumNumbers(params.QuorumNumbers)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if params.BLSKeyFile == "" {
+		return fmt.Errorf("a BLS key file is required")
+	}
+	keys, err := bls.ReadKeyFile(params.BLSKeyFile, os.Getenv("KEEPER_BLS_PASSWORD"))
+	if err != nil {
+		return err
+	}
+	operatorSignature, err := parseOperatorSignature(params)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(dryRun)
+	if err != nil {
+		return err
+	}
+	operator, err := client.Sender()
+	if err != nil {
+		return err
+	}
+	pubkeyParams, err := client.PubkeyRegistrationParams(ctx, keys, operator)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.RegisterOperator(ctx, quorumNumbers, params.Socket, pubkeyParams, operatorSignature)
+	return err
+}
+
+// registerKeeper runs the full keeper registration. Progress is kept in the
+// database, so running it again after a failure continues where it stopped.
+func registerKeeper(ctx context.Context, params registerParams) error {
+	quorumNumbers, err := parseQuorumNumbers(params.QuorumNumbers)
+	if err != nil {
+		return err
+	}
+	var keys *bls.KeyPair
+	if params.BLSKeyFile != "" {
+		if keys, err = bls.ReadKeyFile(params.BLSKeyFile, os.Getenv("KEEPER_BLS_PASSWORD")); err != nil {
+			return err
+		}
+	}
+
+	key := os.Getenv("QUORUM_PRIVATE_KEY")
+	if key == "" {
+		return fmt.Errorf("QUORUM_PRIVATE_KEY is required")
+	}
+	operatorKey, err := quorum.PrivateKeyFromHex(key)
+	if err != nil {
+		return err
+	}
+	var serviceManagerKey *ecdsa.PrivateKey
+	if key := os.Getenv("SERVICE_MANAGER_PRIVATE_KEY"); key != "" {
+		if serviceManagerKey, err = quorum.PrivateKeyFromHex(key); err != nil {
+			return err
+		}
+	}
+
+	client, err := quorum.NewClient(operatorKey, false)
+	if err != nil {
+		return err
+	}
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	registrar, err := quorum.NewRegistrar(ctx, conn, client, operatorKey, serviceManagerKey)
+	if err != nil {
+		return err
+	}
+	state, err := registrar.Register(ctx, quorum.RegistrationRequest{
+		QuorumNumbers:     quorumNumbers,
+		Socket:            params.Socket,
+		BLSKeys:           keys,
+		ConnectionAddress: params.ConnectionAddress,
+	})
+	if state != nil {
+		if printErr := printJSON(state); printErr != nil {
+			return printErr
+		}
+	}
+	return err
+}
+
+func newClient(dryRun bool) (*quorum.Client, error) {
+	key := os.Getenv("QUORUM_PRIVATE_KEY")
+	if key == "" {
+		return quorum.NewClient(nil, dryRun)
+	}
+	privateKey, err := quorum.PrivateKeyFromHex(key)
+	if err != nil {
+		return nil, err
+	}
+	return quorum.NewClient(privateKey, dryRun)
+}
+
+func operatorAddress(client *quorum.Client, operator string) (common.Address, error) {
+	if operator == "" {
+		return client.Sender()
+	}
+	if !common.IsHexAddress(operator) {
+		return common.Address{}, fmt.Errorf("invalid operator address %q", operator)
+	}
+	return common.HexToAddress(operator), nil
+}
+
+func parseOperatorSignature(params registerParams) (regcoord.ISignatureUtilsSignatureWithSaltAndExpiry, error) {
+	var sig regcoord.ISignatureUtilsSignatureWithSaltAndExpiry
+
+	signature, err := hexutil.Decode(params.Signature)
+	if err != nil {
+		return sig, fmt.Errorf("invalid operator signature: %v", err)
+	}
+	salt, err := hexutil.Decode(params.Salt)
+	if err != nil || len(salt) != 32 {
+		return sig, fmt.Errorf("salt must be 32 bytes of hex")
+	}
+	expiry, ok := new(big.Int).SetString(params.Expiry, 10)
+	if !ok {
+		return sig, fmt.Errorf("invalid expiry %q", params.Expiry)
+	}
+
+	sig.Signature = signature
+	copy(sig.Salt[:], salt)
+	sig.Expiry = expiry
+	return sig, nil
+}
+
+func parseQuorumNumbers(value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("quorum numbers are required")
+	}
+	var quorums []byte
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quorum number %q", part)
+		}
+		quorums = append(quorums, byte(n))
+	}
+	return quorums, nil
+}
+
+func loadParams(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read params file: %v", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid params file: %v", err)
+	}
+	return nil
+}
+
+// override replaces a value loaded from the params file when the flag was set
+func override(fs *flag.FlagSet, name string, target *string, value string) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			*target = value
+		}
+	})
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
 	}
-}
\ No newline at end of file
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/trigg3rX/go-backend/execute/quorum"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+func main() {
+	interval := flag.Duration("interval", 5*time.Minute, "time between snapshots")
+	block := flag.Uint64("block", 0, "take a single snapshot at this block and exit")
+	flag.Parse()
+
+	log.Println("Starting quorum snapshotter...")
+
+	// Initialize database connection
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	client, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+
+	snapshotter, err := quorum.NewSnapshotter(conn, client)
+	if err != nil {
+		log.Fatalf("Failed to create quorum snapshotter: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *block > 0 {
+		if _, err := snapshotter.SnapshotAt(ctx, uint32(*block)); err != nil {
+			log.Fatalf("Snapshot failed: %v", err)
+		}
+		return
+	}
+
+	snapshotter.Run(ctx, *interval)
+	log.Println("Quorum snapshotter stopped")
+}
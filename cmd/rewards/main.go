@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/trigg3rX/go-backend/execute/rewards"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+const usage = `Usage: rewards <command> [flags]
+
+Commands:
+  propose   tally keeper work in an epoch and store the rewards submission for review
+  show      print the stored proposal of an epoch
+  approve   approve a proposal by the digest printed by propose
+  submit    send an approved proposal from the rewards initiator
+
+Epochs are given with -epoch YYYY-MM-DD (UTC) and -days, the epoch length.
+submit signs with REWARDS_PRIVATE_KEY.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1], os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	epochStart := fs.String("epoch", "", "first day of the epoch, YYYY-MM-DD in UTC")
+	days := fs.Int("days", 1, "length of the epoch in days")
+
+	var configFile, digest, approver *string
+	switch command {
+	case "propose":
+		configFile = fs.String("config", "", "JSON file with the reward token, strategies and formula")
+	case "approve":
+		digest = fs.String("digest", "", "digest of the reviewed proposal")
+		approver = fs.String("by", "", "name of the approver")
+	case "show", "submit":
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	fs.Parse(args)
+
+	epoch, err := parseEpoch(*epochStart, *days)
+	if err != nil {
+		return err
+	}
+
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	switch command {
+	case "propose":
+		config, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		proposal, err := rewards.Propose(conn, config, epoch)
+		if err != nil {
+			return err
+		}
+		if err := printJSON(proposal); err != nil {
+			return err
+		}
+		fmt.Printf("\nReview the proposal, then approve it with -digest %s\n", proposal.Digest)
+		return nil
+
+	case "show":
+		proposal, found, err := rewards.LoadProposal(conn, epoch)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no rewards proposal for epoch %s", *epochStart)
+		}
+		return printJSON(proposal)
+
+	case "approve":
+		proposal, err := rewards.Approve(conn, epoch, *digest, *approver)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rewards proposal for epoch %s approved by %s", *epochStart, proposal.ApprovedBy)
+		return nil
+
+	case "submit":
+		key := os.Getenv("REWARDS_PRIVATE_KEY")
+		if key == "" {
+			return fmt.Errorf("REWARDS_PRIVATE_KEY is required")
+		}
+		privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(key, "0x"))
+		if err != nil {
+			return fmt.Errorf("invalid REWARDS_PRIVATE_KEY: %v", err)
+		}
+		client, err := chain.Dial(chain.HoleskyChainID)
+		if err != nil {
+			return err
+		}
+		submitter, err := rewards.NewSubmitter(conn, client, privateKey)
+		if err != nil {
+			return err
+		}
+		proposal, err := submitter.Submit(ctx, epoch)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rewards for epoch %s submitted in %s", *epochStart, proposal.TxHash)
+	}
+	return nil
+}
+
+func parseEpoch(start string, days int) (rewards.Epoch, error) {
+	if start == "" {
+		return rewards.Epoch{}, fmt.Errorf("-epoch is required")
+	}
+	day, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return rewards.Epoch{}, fmt.Errorf("invalid epoch %q: %v", start, err)
+	}
+	return rewards.NewEpoch(day, time.Duration(days)*rewards.CalculationInterval)
+}
+
+func loadConfig(path string) (rewards.Config, error) {
+	var config rewards.Config
+	if path == "" {
+		return config, fmt.Errorf("-config is required")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read rewards config: %v", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid rewards config: %v", err)
+	}
+	return config, nil
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
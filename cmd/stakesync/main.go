@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/trigg3rX/go-backend/execute/stakesync"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+func main() {
+	startBlock := flag.Uint64("start-block", 0, "block to start indexing from when no checkpoint exists")
+	reconcile := flag.Bool("reconcile", false, "check every known user against on-chain stake before syncing")
+	openAccounts := flag.Bool("open-accounts", false, "record the stake_amount of users created before the ledger as opening deposits and exit")
+	flag.Parse()
+
+	log.Println("Starting stake sync...")
+
+	// Initialize database connection
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	client, err := chain.Dial(chain.OpSepoliaChainID)
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+
+	config := stakesync.NewConfig()
+	config.StartBlock = *startBlock
+
+	syncer, err := stakesync.NewSyncer(conn, client, config)
+	if err != nil {
+		log.Fatalf("Failed to create stake syncer: %v", err)
+	}
+
+	if *openAccounts {
+		if _, err := syncer.OpenAccounts(); err != nil {
+			log.Fatalf("Failed to open ledger accounts: %v", err)
+		}
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *reconcile {
+		if err := syncer.ReconcileAll(ctx); err != nil {
+			log.Printf("Failed to reconcile users: %v", err)
+		}
+	}
+
+	if err := syncer.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatalf("Stake sync stopped: %v", err)
+	}
+	log.Println("Stake sync stopped")
+}
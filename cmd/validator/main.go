@@ -1,8 +1,48 @@
 package main
 
-import "fmt"
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/trigg3rX/go-backend/execute/validator"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/network"
+)
 
 // TaskValidator is the main entry point for the Task Validator
 func main() {
-	fmt.Println("Initializing Validator...")
+	log.Println("Initializing Validator...")
+
+	// Initialize database connection
+	conn, err := database.NewConnection(database.NewConfig())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	// Reports are only accepted from registered operators
+	client, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		log.Fatalf("Failed to connect to chain: %v", err)
+	}
+	operators, err := network.NewChainOperatorSet(client, common.HexToAddress(chain.RegistryCoordinatorAddress))
+	if err != nil {
+		log.Fatalf("Failed to set up operator set: %v", err)
+	}
+
+	taskValidator := validator.NewValidator(conn, operators)
+
+	// Keepers post their execution reports here
+	http.HandleFunc("/reports", taskValidator.HandleReport)
+
+	port := os.Getenv("VALIDATOR_PORT")
+	if port == "" {
+		port = "8081"
+	}
+	log.Printf("Validator listening on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
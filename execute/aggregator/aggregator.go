@@ -0,0 +1,366 @@
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+	"github.com/trigg3rX/go-backend/pkg/bls"
+)
+
+var (
+	ErrUnknownOperator    = errors.New("operator is not in the task's quorums")
+	ErrInvalidSignature   = errors.New("invalid BLS signature")
+	ErrDuplicateSignature = errors.New("operator already signed this task")
+	ErrAlreadyResponded   = errors.New("task has already been responded to")
+)
+
+// TaskSource looks up the on-chain task a signature refers to
+type TaskSource interface {
+	Task(ctx context.Context, taskID [8]byte) (taskmanager.ITriggerXTaskManagerTask, error)
+}
+
+// SignedResponse is a keeper's BLS signature over a task response hash
+type SignedResponse struct {
+	TaskID       [8]byte
+	ResponseHash [32]byte
+	OperatorID   [32]byte
+	Signature    *bls.Signature
+	PubkeyG2     *bls.G2Point
+}
+
+// Result reports the progress of a task after a signature was processed
+type Result struct {
+	Responded   bool
+	TxHash      common.Hash
+	SignedStake []*big.Int
+	TotalStake  []*big.Int
+}
+
+type member struct {
+	operator Operator
+	pubkeyG1 *bls.G1Point
+	stakes   []*big.Int // stake per task quorum, nil where not a member
+	quorums  int64      // number of task quorums the operator belongs to
+}
+
+// responseAggregate collects the signatures of keepers that agree on one
+// response hash
+type responseAggregate struct {
+	signers     map[[32]byte]struct{}
+	signedStake []*big.Int
+	sigma       *bls.Signature
+	apkG2       *bls.G2Point
+}
+
+type taskState struct {
+	mu         sync.Mutex
+	taskID     [8]byte
+	task       taskmanager.ITriggerXTaskManagerTask
+	members    map[[32]byte]*member
+	quorumApks []*bls.G1Point
+	totalStake []*big.Int
+	responses  map[[32]byte]*responseAggregate
+	createdAt  time.Time
+	responded  bool
+	txHash     common.Hash
+}
+
+// Aggregator collects keeper signatures per task and submits RespondToTask
+// once the signed stake reaches the quorum threshold in every quorum
+type Aggregator struct {
+	tasks     TaskSource
+	reader    OperatorStateReader
+	submitter ResponseSubmitter
+
+	mu     sync.Mutex
+	states map[[8]byte]*taskState
+}
+
+func NewAggregator(tasks TaskSource, reader OperatorStateReader, submitter ResponseSubmitter) *Aggregator {
+	return &Aggregator{
+		tasks:     tasks,
+		reader:    reader,
+		submitter: submitter,
+		states:    make(map[[8]byte]*taskState),
+	}
+}
+
+// ProcessSignature verifies a keeper's signature, adds it to the aggregate of
+// its response hash and responds to the task when the threshold is reached
+func (a *Aggregator) ProcessSignature(ctx context.Context, signed SignedResponse) (*Result, error) {
+	state, err := a.taskState(ctx, signed.TaskID)
+	if err != nil {
+		return nil, err
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.responded {
+		return nil, ErrAlreadyResponded
+	}
+
+	m, exists := state.members[signed.OperatorID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownOperator, hexutil.Encode(signed.OperatorID[:]))
+	}
+	if err := verifySignature(m, signed); err != nil {
+		return nil, err
+	}
+
+	aggregate := state.responses[signed.ResponseHash]
+	if aggregate == nil {
+		aggregate = newResponseAggregate(len(state.totalStake))
+		state.responses[signed.ResponseHash] = aggregate
+	}
+	if _, signedBefore := aggregate.signers[signed.OperatorID]; signedBefore {
+		return nil, ErrDuplicateSignature
+	}
+
+	// The signature checker sums quorum APKs, so an operator in several task
+	// quorums has to be counted once per quorum in sigma and apkG2 as well
+	multiplier := big.NewInt(m.quorums)
+	sigma := &bls.Signature{}
+	sigma.ScalarMultiplication(&signed.Signature.G1Affine, multiplier)
+	pubkeyG2 := &bls.G2Point{}
+	pubkeyG2.ScalarMultiplication(&signed.PubkeyG2.G2Affine, multiplier)
+
+	aggregate.signers[signed.OperatorID] = struct{}{}
+	aggregate.sigma.Add(sigma)
+	aggregate.apkG2.Add(pubkeyG2)
+	for i, stake := range m.stakes {
+		if stake != nil {
+			aggregate.signedStake[i].Add(aggregate.signedStake[i], stake)
+		}
+	}
+
+	result := &Result{SignedStake: copyStakes(aggregate.signedStake), TotalStake: copyStakes(state.totalStake)}
+	log.Printf("Task %s: %s signed response %s, signed stake %v of %v",
+		hexutil.Encode(signed.TaskID[:]), m.operator.Address.Hex(), hexutil.Encode(signed.ResponseHash[:]),
+		result.SignedStake, result.TotalStake)
+
+	if !thresholdMet(aggregate.signedStake, state.totalStake, state.task.QuorumThreshold) {
+		return result, nil
+	}
+
+	txHash, err := a.respond(ctx, state, signed.ResponseHash, aggregate)
+	if err != nil {
+		return result, err
+	}
+	state.responded = true
+	state.txHash = txHash
+	result.Responded = true
+	result.TxHash = txHash
+	return result, nil
+}
+
+// ExpireTasks forgets tasks that were first seen before maxAge ago
+func (a *Aggregator) ExpireTasks(maxAge time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	for taskID, state := range a.states {
+		if state.createdAt.Before(cutoff) {
+			delete(a.states, taskID)
+		}
+	}
+}
+
+func (a *Aggregator) taskState(ctx context.Context, taskID [8]byte) (*taskState, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if state, exists := a.states[taskID]; exists {
+		return state, nil
+	}
+
+	task, err := a.tasks.Task(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load task %s: %v", hexutil.Encode(taskID[:]), err)
+	}
+	state, err := a.initializeTask(ctx, taskID, task)
+	if err != nil {
+		return nil, err
+	}
+
+	a.states[taskID] = state
+	return state, nil
+}
+
+// initializeTask reads the quorum members, their stakes and public keys at
+// the task's reference block
+func (a *Aggregator) initializeTask(ctx context.Context, taskID [8]byte, task taskmanager.ITriggerXTaskManagerTask) (*taskState, error) {
+	quorums, err := a.reader.OperatorState(ctx, task.QuorumNumbers, task.TaskCreatedBlock)
+	if err != nil {
+		return nil, err
+	}
+	if len(quorums) != len(task.QuorumNumbers) {
+		return nil, fmt.Errorf("operator state has %d quorums, task has %d", len(quorums), len(task.QuorumNumbers))
+	}
+
+	state := &taskState{
+		taskID:     taskID,
+		task:       task,
+		members:    make(map[[32]byte]*member),
+		quorumApks: make([]*bls.G1Point, len(quorums)),
+		totalStake: make([]*big.Int, len(quorums)),
+		responses:  make(map[[32]byte]*responseAggregate),
+		createdAt:  time.Now(),
+	}
+
+	for i, operators := range quorums {
+		state.quorumApks[i] = bls.NewZeroG1Point()
+		state.totalStake[i] = new(big.Int)
+
+		for _, operator := range operators {
+			m, exists := state.members[operator.OperatorID]
+			if !exists {
+				pubkey, err := a.reader.PubkeyG1(ctx, operator.Address)
+				if err != nil {
+					return nil, err
+				}
+				if bls.OperatorID(pubkey) != operator.OperatorID {
+					return nil, fmt.Errorf("registered pubkey of %s does not match its operator ID", operator.Address.Hex())
+				}
+				m = &member{operator: operator, pubkeyG1: pubkey, stakes: make([]*big.Int, len(quorums))}
+				state.members[operator.OperatorID] = m
+			}
+
+			m.stakes[i] = operator.Stake
+			m.quorums++
+			state.quorumApks[i].Add(m.pubkeyG1)
+			state.totalStake[i].Add(state.totalStake[i], operator.Stake)
+		}
+	}
+
+	return state, nil
+}
+
+// respond builds the non-signer data expected by BLSSignatureChecker and
+// submits the response
+func (a *Aggregator) respond(ctx context.Context, state *taskState, responseHash [32]byte, aggregate *responseAggregate) (common.Hash, error) {
+	var nonSigners []*member
+	for operatorID, m := range state.members {
+		if _, signed := aggregate.signers[operatorID]; !signed {
+			nonSigners = append(nonSigners, m)
+		}
+	}
+	// The signature checker requires non-signers sorted by operator ID
+	sort.Slice(nonSigners, func(i, j int) bool {
+		return bytes.Compare(nonSigners[i].operator.OperatorID[:], nonSigners[j].operator.OperatorID[:]) < 0
+	})
+
+	nonSignerIDs := make([][32]byte, len(nonSigners))
+	nonSignerPubkeys := make([]taskmanager.BN254G1Point, len(nonSigners))
+	for i, m := range nonSigners {
+		nonSignerIDs[i] = m.operator.OperatorID
+		nonSignerPubkeys[i] = g1ToBinding(m.pubkeyG1)
+	}
+
+	indices, err := a.reader.CheckSignaturesIndices(ctx, state.task.TaskCreatedBlock, state.task.QuorumNumbers, nonSignerIDs)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	quorumApks := make([]taskmanager.BN254G1Point, len(state.quorumApks))
+	for i, apk := range state.quorumApks {
+		quorumApks[i] = g1ToBinding(apk)
+	}
+	apkG2X, apkG2Y := aggregate.apkG2.BigInts()
+
+	signature := taskmanager.IBLSSignatureCheckerNonSignerStakesAndSignature{
+		NonSignerQuorumBitmapIndices: indices.NonSignerQuorumBitmapIndices,
+		NonSignerPubkeys:             nonSignerPubkeys,
+		QuorumApks:                   quorumApks,
+		ApkG2:                        taskmanager.BN254G2Point{X: apkG2X, Y: apkG2Y},
+		Sigma:                        g1ToBinding(&aggregate.sigma.G1Point),
+		QuorumApkIndices:             indices.QuorumApkIndices,
+		TotalStakeIndices:            indices.TotalStakeIndices,
+		NonSignerStakeIndices:        indices.NonSignerStakeIndices,
+	}
+	response := taskmanager.ITriggerXTaskManagerTaskResponse{
+		TaskId:           state.taskID,
+		TaskResponseHash: responseHash,
+	}
+
+	txHash, err := a.submitter.RespondToTask(ctx, state.task, response, signature)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	log.Printf("Responded to task %s with %d signers and %d non-signers in tx %s",
+		hexutil.Encode(state.taskID[:]), len(aggregate.signers), len(nonSigners), txHash.Hex())
+	return txHash, nil
+}
+
+func verifySignature(m *member, signed SignedResponse) error {
+	if signed.Signature == nil || signed.PubkeyG2 == nil {
+		return ErrInvalidSignature
+	}
+
+	// The G2 key is supplied by the keeper, so tie it to the registered G1 key
+	matches, err := bls.CheckG1AndG2DiscreteLogEquality(m.pubkeyG1, signed.PubkeyG2)
+	if err != nil || !matches {
+		return fmt.Errorf("%w: G2 pubkey does not match registered pubkey", ErrInvalidSignature)
+	}
+
+	valid, err := signed.Signature.Verify(signed.PubkeyG2, signed.ResponseHash)
+	if err != nil || !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// thresholdMet reports whether signed stake is at least threshold percent of
+// the total stake in every quorum
+func thresholdMet(signed, total []*big.Int, threshold uint8) bool {
+	for i := range total {
+		if total[i].Sign() == 0 {
+			return false
+		}
+		lhs := new(big.Int).Mul(signed[i], big.NewInt(100))
+		rhs := new(big.Int).Mul(total[i], big.NewInt(int64(threshold)))
+		if lhs.Cmp(rhs) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func newResponseAggregate(quorums int) *responseAggregate {
+	aggregate := &responseAggregate{
+		signers:     make(map[[32]byte]struct{}),
+		signedStake: make([]*big.Int, quorums),
+		sigma:       bls.NewZeroSignature(),
+		apkG2:       bls.NewZeroG2Point(),
+	}
+	for i := range aggregate.signedStake {
+		aggregate.signedStake[i] = new(big.Int)
+	}
+	return aggregate
+}
+
+func copyStakes(stakes []*big.Int) []*big.Int {
+	copied := make([]*big.Int, len(stakes))
+	for i, stake := range stakes {
+		copied[i] = new(big.Int).Set(stake)
+	}
+	return copied
+}
+
+func g1ToBinding(p *bls.G1Point) taskmanager.BN254G1Point {
+	x, y := p.BigInts()
+	return taskmanager.BN254G1Point{X: x, Y: y}
+}
@@ -0,0 +1,293 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	stateretriever "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/OperatorStateRetriever"
+	taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+	"github.com/trigg3rX/go-backend/pkg/bls"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+)
+
+type testKeeper struct {
+	address common.Address
+	keys    *bls.KeyPair
+	stake   int64
+	quorums []byte
+}
+
+// fakeChain plays the task manager, operator state retriever and APK
+// registry. RespondToTask verifies signatures the way BLSSignatureChecker does.
+type fakeChain struct {
+	task      taskmanager.ITriggerXTaskManagerTask
+	keepers   []*testKeeper
+	responses []taskmanager.IBLSSignatureCheckerNonSignerStakesAndSignature
+	responded []taskmanager.ITriggerXTaskManagerTaskResponse
+}
+
+func newFakeChain(t *testing.T, threshold uint8, quorumNumbers []byte, memberships ...[]byte) *fakeChain {
+	t.Helper()
+	c := &fakeChain{
+		task: taskmanager.ITriggerXTaskManagerTask{
+			JobId:            7,
+			TaskNum:          1,
+			TaskCreatedBlock: 100,
+			QuorumNumbers:    quorumNumbers,
+			QuorumThreshold:  threshold,
+		},
+	}
+	for i, quorums := range memberships {
+		keys, err := bls.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.keepers = append(c.keepers, &testKeeper{
+			address: common.BigToAddress(big.NewInt(int64(i + 1))),
+			keys:    keys,
+			stake:   100,
+			quorums: quorums,
+		})
+	}
+	return c
+}
+
+func (c *fakeChain) Task(ctx context.Context, taskID [8]byte) (taskmanager.ITriggerXTaskManagerTask, error) {
+	return c.task, nil
+}
+
+func (c *fakeChain) OperatorState(ctx context.Context, quorumNumbers []byte, referenceBlock uint32) ([][]Operator, error) {
+	state := make([][]Operator, len(quorumNumbers))
+	for i, quorum := range quorumNumbers {
+		for _, k := range c.keepers {
+			if k.inQuorum(quorum) {
+				state[i] = append(state[i], Operator{
+					Address:    k.address,
+					OperatorID: bls.OperatorID(k.keys.PubG1),
+					Stake:      big.NewInt(k.stake),
+				})
+			}
+		}
+	}
+	return state, nil
+}
+
+func (c *fakeChain) CheckSignaturesIndices(ctx context.Context, referenceBlock uint32, quorumNumbers []byte, nonSignerOperatorIDs [][32]byte) (stateretriever.OperatorStateRetrieverCheckSignaturesIndices, error) {
+	return stateretriever.OperatorStateRetrieverCheckSignaturesIndices{
+		NonSignerQuorumBitmapIndices: make([]uint32, len(nonSignerOperatorIDs)),
+		QuorumApkIndices:             make([]uint32, len(quorumNumbers)),
+		TotalStakeIndices:            make([]uint32, len(quorumNumbers)),
+		NonSignerStakeIndices:        make([][]uint32, len(quorumNumbers)),
+	}, nil
+}
+
+func (c *fakeChain) PubkeyG1(ctx context.Context, operator common.Address) (*bls.G1Point, error) {
+	for _, k := range c.keepers {
+		if k.address == operator {
+			return k.keys.PubG1.Clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s not registered", operator.Hex())
+}
+
+func (c *fakeChain) RespondToTask(ctx context.Context, task taskmanager.ITriggerXTaskManagerTask, response taskmanager.ITriggerXTaskManagerTaskResponse, signature taskmanager.IBLSSignatureCheckerNonSignerStakesAndSignature) (common.Hash, error) {
+	// apk = sum of quorum APKs minus each non-signer once per quorum it is in
+	apk := bls.NewZeroG1Point()
+	for _, quorumApk := range signature.QuorumApks {
+		apk.Add(bls.NewG1Point(quorumApk.X, quorumApk.Y))
+	}
+	for _, pubkey := range signature.NonSignerPubkeys {
+		nonSigner := bls.NewG1Point(pubkey.X, pubkey.Y)
+		for _, quorum := range task.QuorumNumbers {
+			if c.keeperByPubkey(nonSigner).inQuorum(quorum) {
+				apk.Sub(nonSigner)
+			}
+		}
+	}
+
+	apkG2 := bls.NewG2Point(signature.ApkG2.X, signature.ApkG2.Y)
+	if ok, err := bls.CheckG1AndG2DiscreteLogEquality(apk, apkG2); err != nil || !ok {
+		return common.Hash{}, fmt.Errorf("apkG2 does not match quorum APKs minus non-signers")
+	}
+	sigma := &bls.Signature{G1Point: *bls.NewG1Point(signature.Sigma.X, signature.Sigma.Y)}
+	if ok, err := sigma.Verify(apkG2, response.TaskResponseHash); err != nil || !ok {
+		return common.Hash{}, fmt.Errorf("aggregate signature does not verify")
+	}
+
+	c.responses = append(c.responses, signature)
+	c.responded = append(c.responded, response)
+	return common.HexToHash("0xabc"), nil
+}
+
+func (c *fakeChain) keeperByPubkey(pubkey *bls.G1Point) *testKeeper {
+	for _, k := range c.keepers {
+		if k.keys.PubG1.Equal(&pubkey.G1Affine) {
+			return k
+		}
+	}
+	return nil
+}
+
+func (k *testKeeper) inQuorum(quorum byte) bool {
+	for _, q := range k.quorums {
+		if q == quorum {
+			return true
+		}
+	}
+	return false
+}
+
+// sign produces the message a keeper sends and round-trips it through the
+// wire format
+func (k *testKeeper) sign(t *testing.T, taskID [8]byte, responseHash [32]byte) SignedResponse {
+	t.Helper()
+	signed := SignedResponse{
+		TaskID:       taskID,
+		ResponseHash: responseHash,
+		OperatorID:   bls.OperatorID(k.keys.PubG1),
+		Signature:    k.keys.SignMessage(responseHash),
+		PubkeyG2:     k.keys.PubG2,
+	}
+	decoded, err := DecodeSignature(EncodeSignature(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func testResponse(valid bool) ([8]byte, [32]byte) {
+	taskID := chain.Int64ToTaskID(42)
+	return taskID, chain.TaskResponseHash(taskID, common.HexToHash("0x1234"), valid)
+}
+
+func TestAggregatorRespondsAtThreshold(t *testing.T) {
+	c := newFakeChain(t, 66, []byte{0}, []byte{0}, []byte{0}, []byte{0}, []byte{0})
+	agg := NewAggregator(c, c, c)
+	taskID, responseHash := testResponse(true)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		result, err := agg.ProcessSignature(ctx, c.keepers[i].sign(t, taskID, responseHash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Responded {
+			t.Fatalf("responded after %d of 4 signatures", i+1)
+		}
+	}
+
+	result, err := agg.ProcessSignature(ctx, c.keepers[2].sign(t, taskID, responseHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Responded {
+		t.Fatalf("expected response at 300 of 400 stake, signed %v", result.SignedStake)
+	}
+	if len(c.responses) != 1 {
+		t.Fatalf("expected one RespondToTask call, got %d", len(c.responses))
+	}
+	if c.responded[0].TaskId != taskID || c.responded[0].TaskResponseHash != responseHash {
+		t.Fatalf("submitted wrong task response: %+v", c.responded[0])
+	}
+
+	nonSigners := c.responses[0].NonSignerPubkeys
+	if len(nonSigners) != 1 || c.keeperByPubkey(bls.NewG1Point(nonSigners[0].X, nonSigners[0].Y)) != c.keepers[3] {
+		t.Fatalf("expected the fourth keeper as the only non-signer, got %d non-signers", len(nonSigners))
+	}
+
+	_, err = agg.ProcessSignature(ctx, c.keepers[3].sign(t, taskID, responseHash))
+	if !errors.Is(err, ErrAlreadyResponded) {
+		t.Fatalf("expected ErrAlreadyResponded, got %v", err)
+	}
+}
+
+func TestAggregatorRejectsBadSignatures(t *testing.T) {
+	c := newFakeChain(t, 50, []byte{0}, []byte{0}, []byte{0})
+	agg := NewAggregator(c, c, c)
+	taskID, responseHash := testResponse(true)
+	ctx := context.Background()
+
+	// Signed with another keeper's key
+	forged := c.keepers[0].sign(t, taskID, responseHash)
+	forged.Signature = c.keepers[1].keys.SignMessage(responseHash)
+	if _, err := agg.ProcessSignature(ctx, forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature for forged signature, got %v", err)
+	}
+
+	// A G2 key that does not belong to the registered G1 key
+	mismatched := c.keepers[0].sign(t, taskID, responseHash)
+	mismatched.PubkeyG2 = c.keepers[1].keys.PubG2
+	if _, err := agg.ProcessSignature(ctx, mismatched); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature for mismatched G2 key, got %v", err)
+	}
+
+	outsider, err := bls.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := (&testKeeper{keys: outsider}).sign(t, taskID, responseHash)
+	if _, err := agg.ProcessSignature(ctx, unknown); !errors.Is(err, ErrUnknownOperator) {
+		t.Fatalf("expected ErrUnknownOperator, got %v", err)
+	}
+
+	if _, err := agg.ProcessSignature(ctx, c.keepers[0].sign(t, taskID, responseHash)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.responses) != 1 {
+		t.Fatalf("expected response at 50%% threshold, got %d responses", len(c.responses))
+	}
+}
+
+func TestAggregatorSeparatesResponses(t *testing.T) {
+	c := newFakeChain(t, 60, []byte{0}, []byte{0}, []byte{0}, []byte{0}, []byte{0}, []byte{0})
+	agg := NewAggregator(c, c, c)
+	taskID, validHash := testResponse(true)
+	_, invalidHash := testResponse(false)
+	ctx := context.Background()
+
+	for i, hash := range [][32]byte{validHash, invalidHash, validHash, invalidHash} {
+		result, err := agg.ProcessSignature(ctx, c.keepers[i].sign(t, taskID, hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Responded {
+			t.Fatalf("split responses should not reach the threshold")
+		}
+	}
+
+	if _, err := agg.ProcessSignature(ctx, c.keepers[4].sign(t, taskID, validHash)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.responded) != 1 || c.responded[0].TaskResponseHash != validHash {
+		t.Fatalf("expected the majority response to be submitted")
+	}
+	if got := len(c.responses[0].NonSignerPubkeys); got != 2 {
+		t.Fatalf("expected keepers signing the other response as non-signers, got %d", got)
+	}
+}
+
+func TestAggregatorMultipleQuorums(t *testing.T) {
+	// The first keeper is in both quorums and is counted once per quorum
+	c := newFakeChain(t, 60, []byte{0, 1}, []byte{0, 1}, []byte{0}, []byte{1}, []byte{1})
+	agg := NewAggregator(c, c, c)
+	taskID, responseHash := testResponse(true)
+	ctx := context.Background()
+
+	for _, k := range c.keepers[:3] {
+		if _, err := agg.ProcessSignature(ctx, k.sign(t, taskID, responseHash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(c.responses) != 1 {
+		t.Fatalf("expected a response with quorum 0 at 200/200 and quorum 1 at 200/300, got %d", len(c.responses))
+	}
+	if len(c.responses[0].QuorumApks) != 2 {
+		t.Fatalf("expected two quorum APKs, got %d", len(c.responses[0].QuorumApks))
+	}
+}
@@ -0,0 +1,132 @@
+package aggregator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	apkregistry "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/ApkRegistry"
+	stateretriever "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/OperatorStateRetriever"
+	taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+	"github.com/trigg3rX/go-backend/pkg/bls"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+)
+
+// Operator is a member of a quorum at the task's reference block
+type Operator struct {
+	Address    common.Address
+	OperatorID [32]byte
+	Stake      *big.Int
+}
+
+// OperatorStateReader reads the quorum state a task is checked against
+type OperatorStateReader interface {
+	// OperatorState returns the operators of each quorum, in the order of quorumNumbers
+	OperatorState(ctx context.Context, quorumNumbers []byte, referenceBlock uint32) ([][]Operator, error)
+	CheckSignaturesIndices(ctx context.Context, referenceBlock uint32, quorumNumbers []byte, nonSignerOperatorIDs [][32]byte) (stateretriever.OperatorStateRetrieverCheckSignaturesIndices, error)
+	PubkeyG1(ctx context.Context, operator common.Address) (*bls.G1Point, error)
+}
+
+// ResponseSubmitter sends an aggregated response to the task manager
+type ResponseSubmitter interface {
+	RespondToTask(ctx context.Context, task taskmanager.ITriggerXTaskManagerTask, response taskmanager.ITriggerXTaskManagerTaskResponse, signature taskmanager.IBLSSignatureCheckerNonSignerStakesAndSignature) (common.Hash, error)
+}
+
+// ChainClient implements OperatorStateReader and ResponseSubmitter with the
+// deployed contracts
+type ChainClient struct {
+	client              *ethclient.Client
+	registryCoordinator common.Address
+	stateRetriever      *stateretriever.ContractOperatorStateRetriever
+	apkRegistry         *apkregistry.ContractApkRegistry
+	taskManager         *taskmanager.ContractTriggerXTaskManager
+	auth                *bind.TransactOpts
+}
+
+func NewChainClient(client *ethclient.Client, privateKey *ecdsa.PrivateKey, chainID int64) (*ChainClient, error) {
+	stateRetriever, err := stateretriever.NewContractOperatorStateRetriever(common.HexToAddress(chain.OperatorStateRetrieverAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind operator state retriever: %v", err)
+	}
+	apkRegistry, err := apkregistry.NewContractApkRegistry(common.HexToAddress(chain.ApkRegistryAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind APK registry: %v", err)
+	}
+	taskManager, err := taskmanager.NewContractTriggerXTaskManager(common.HexToAddress(chain.TaskManagerAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind task manager: %v", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(chainID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transactor: %v", err)
+	}
+
+	return &ChainClient{
+		client:              client,
+		registryCoordinator: common.HexToAddress(chain.RegistryCoordinatorAddress),
+		stateRetriever:      stateRetriever,
+		apkRegistry:         apkRegistry,
+		taskManager:         taskManager,
+		auth:                auth,
+	}, nil
+}
+
+func (c *ChainClient) OperatorState(ctx context.Context, quorumNumbers []byte, referenceBlock uint32) ([][]Operator, error) {
+	state, err := c.stateRetriever.GetOperatorState(&bind.CallOpts{Context: ctx}, c.registryCoordinator, quorumNumbers, referenceBlock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get operator state: %v", err)
+	}
+
+	quorums := make([][]Operator, len(state))
+	for i, operators := range state {
+		for _, operator := range operators {
+			quorums[i] = append(quorums[i], Operator{
+				Address:    operator.Operator,
+				OperatorID: operator.OperatorId,
+				Stake:      operator.Stake,
+			})
+		}
+	}
+	return quorums, nil
+}
+
+func (c *ChainClient) CheckSignaturesIndices(ctx context.Context, referenceBlock uint32, quorumNumbers []byte, nonSignerOperatorIDs [][32]byte) (stateretriever.OperatorStateRetrieverCheckSignaturesIndices, error) {
+	indices, err := c.stateRetriever.GetCheckSignaturesIndices(&bind.CallOpts{Context: ctx}, c.registryCoordinator, referenceBlock, quorumNumbers, nonSignerOperatorIDs)
+	if err != nil {
+		return indices, fmt.Errorf("failed to get check signatures indices: %v", err)
+	}
+	return indices, nil
+}
+
+func (c *ChainClient) PubkeyG1(ctx context.Context, operator common.Address) (*bls.G1Point, error) {
+	pubkey, _, err := c.apkRegistry.GetRegisteredPubkey(&bind.CallOpts{Context: ctx}, operator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pubkey of %s: %v", operator.Hex(), err)
+	}
+	return bls.NewG1Point(pubkey.X, pubkey.Y), nil
+}
+
+func (c *ChainClient) RespondToTask(ctx context.Context, task taskmanager.ITriggerXTaskManagerTask, response taskmanager.ITriggerXTaskManagerTaskResponse, signature taskmanager.IBLSSignatureCheckerNonSignerStakesAndSignature) (common.Hash, error) {
+	opts := *c.auth
+	opts.Context = ctx
+
+	tx, err := c.taskManager.RespondToTask(&opts, task, response, signature)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to send RespondToTask: %v", err)
+	}
+
+	receipt, err := bind.WaitMined(ctx, c.client, tx)
+	if err != nil {
+		return tx.Hash(), fmt.Errorf("failed waiting for RespondToTask %s: %v", tx.Hash().Hex(), err)
+	}
+	if receipt.Status != 1 {
+		return tx.Hash(), fmt.Errorf("RespondToTask %s reverted", tx.Hash().Hex())
+	}
+
+	return tx.Hash(), nil
+}
@@ -0,0 +1,131 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/trigg3rX/go-backend/pkg/bls"
+	"github.com/trigg3rX/go-backend/pkg/network"
+	"github.com/trigg3rX/go-backend/pkg/types"
+)
+
+// processTimeout bounds the chain calls made for a signature received over
+// the network, HTTP requests use the request context instead
+const processTimeout = 2 * time.Minute
+
+// DecodeSignature converts the wire format sent by keepers
+func DecodeSignature(msg types.TaskSignature) (SignedResponse, error) {
+	var signed SignedResponse
+
+	if err := decodeFixed(msg.TaskID, signed.TaskID[:]); err != nil {
+		return signed, fmt.Errorf("invalid task_id: %v", err)
+	}
+	if err := decodeFixed(msg.ResponseHash, signed.ResponseHash[:]); err != nil {
+		return signed, fmt.Errorf("invalid response_hash: %v", err)
+	}
+	if err := decodeFixed(msg.OperatorID, signed.OperatorID[:]); err != nil {
+		return signed, fmt.Errorf("invalid operator_id: %v", err)
+	}
+
+	raw, err := hexutil.Decode(msg.Signature)
+	if err != nil {
+		return signed, fmt.Errorf("invalid signature: %v", err)
+	}
+	if signed.Signature, err = bls.SignatureFromBytes(raw); err != nil {
+		return signed, err
+	}
+
+	raw, err = hexutil.Decode(msg.PubkeyG2)
+	if err != nil {
+		return signed, fmt.Errorf("invalid pubkey_g2: %v", err)
+	}
+	if signed.PubkeyG2, err = bls.G2PointFromBytes(raw); err != nil {
+		return signed, err
+	}
+
+	return signed, nil
+}
+
+// EncodeSignature converts a signed response to the wire format
+func EncodeSignature(signed SignedResponse) types.TaskSignature {
+	return types.TaskSignature{
+		TaskID:       hexutil.Encode(signed.TaskID[:]),
+		ResponseHash: hexutil.Encode(signed.ResponseHash[:]),
+		OperatorID:   hexutil.Encode(signed.OperatorID[:]),
+		Signature:    hexutil.Encode(signed.Signature.Bytes()),
+		PubkeyG2:     hexutil.Encode(signed.PubkeyG2.Bytes()),
+	}
+}
+
+func decodeFixed(value string, out []byte) error {
+	raw, err := hexutil.Decode(value)
+	if err != nil {
+		return err
+	}
+	if len(raw) != len(out) {
+		return fmt.Errorf("expected %d bytes, got %d", len(out), len(raw))
+	}
+	copy(out, raw)
+	return nil
+}
+
+// HandleSignature accepts a keeper's task signature over HTTP
+func (a *Aggregator) HandleSignature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg types.TaskSignature
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid signature message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	signed, err := DecodeSignature(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := a.ProcessSignature(r.Context(), signed)
+	switch {
+	case errors.Is(err, ErrInvalidSignature), errors.Is(err, ErrUnknownOperator):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrDuplicateSignature), errors.Is(err, ErrAlreadyResponded):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to process signature for task %s: %v", msg.TaskID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// HandleTaskSignature accepts task signatures sent by keepers over
+// pkg/network. Errors are sent back to the keeper.
+func (a *Aggregator) HandleTaskSignature(msg network.Message, sig types.TaskSignature) error {
+	signed, err := DecodeSignature(sig)
+	if err != nil {
+		log.Printf("Invalid signature from %s: %v", msg.From, err)
+		return &network.ReplyError{Code: network.ReplyInvalidPayload, Message: err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), processTimeout)
+	defer cancel()
+	if _, err := a.ProcessSignature(ctx, signed); err != nil {
+		log.Printf("Rejected signature from %s for task %s: %v", msg.From, sig.TaskID, err)
+		return err
+	}
+	return nil
+}
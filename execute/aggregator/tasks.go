@@ -0,0 +1,67 @@
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/gocql/gocql"
+	"gopkg.in/inf.v0"
+
+	taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// DBTaskSource rebuilds on-chain tasks from task_data
+type DBTaskSource struct {
+	db *database.Connection
+}
+
+func NewDBTaskSource(db *database.Connection) *DBTaskSource {
+	return &DBTaskSource{db: db}
+}
+
+func (s *DBTaskSource) Task(ctx context.Context, taskID [8]byte) (taskmanager.ITriggerXTaskManagerTask, error) {
+	var jobID, createdBlock int64
+	var quorumNumber int
+	// task_no stays null until the task creator or the indexer resolves it
+	var taskNo *int
+	var quorumNumbers []byte
+	var threshold *inf.Dec
+	err := s.db.Session().Query(`
+        SELECT job_id, task_no, task_created_block, quorum_number, quorum_numbers, quorum_threshold
+        FROM triggerx.task_data WHERE task_id = ?`,
+		chain.TaskIDToInt64(taskID)).WithContext(ctx).Scan(&jobID, &taskNo, &createdBlock, &quorumNumber, &quorumNumbers, &threshold)
+	if err == gocql.ErrNotFound {
+		return taskmanager.ITriggerXTaskManagerTask{}, fmt.Errorf("task not found")
+	}
+	if err != nil {
+		return taskmanager.ITriggerXTaskManagerTask{}, err
+	}
+	if createdBlock == 0 {
+		return taskmanager.ITriggerXTaskManagerTask{}, fmt.Errorf("task has not been created on-chain yet")
+	}
+	if taskNo == nil {
+		return taskmanager.ITriggerXTaskManagerTask{}, fmt.Errorf("task number has not been resolved yet")
+	}
+	// Tasks stored before quorum_numbers existed had a single quorum
+	if len(quorumNumbers) == 0 {
+		quorumNumbers = []byte{byte(quorumNumber)}
+	}
+	if threshold == nil {
+		return taskmanager.ITriggerXTaskManagerTask{}, fmt.Errorf("task has no quorum threshold")
+	}
+	value, ok := new(big.Int).SetString(threshold.String(), 10)
+	if !ok || !value.IsUint64() || value.Uint64() > 255 {
+		return taskmanager.ITriggerXTaskManagerTask{}, fmt.Errorf("task has an invalid quorum threshold %s", threshold)
+	}
+
+	return taskmanager.ITriggerXTaskManagerTask{
+		JobId:            uint32(jobID),
+		TaskNum:          uint32(*taskNo),
+		TaskCreatedBlock: uint32(createdBlock),
+		QuorumNumbers:    quorumNumbers,
+		QuorumThreshold:  uint8(value.Uint64()),
+	}, nil
+}
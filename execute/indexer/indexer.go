@@ -0,0 +1,176 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	servicemanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXServiceManager"
+	taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+const (
+	CheckpointName = "event_indexer"
+
+	DefaultPollInterval = 12 * time.Second
+
+	EventKeeperAdded       = "keeper_added"
+	EventKeeperRemoved     = "keeper_removed"
+	EventKeeperBlacklisted = "keeper_blacklisted"
+)
+
+type Config struct {
+	ChainID               int64
+	TaskManagerAddress    string
+	ServiceManagerAddress string
+	StartBlock            uint64
+	BatchSize             uint64
+	PollInterval          time.Duration
+}
+
+func NewConfig() Config {
+	return Config{
+		ChainID:               chain.HoleskyChainID,
+		TaskManagerAddress:    chain.TaskManagerAddress,
+		ServiceManagerAddress: chain.ServiceManagerAddress,
+		BatchSize:             chain.DefaultBatchSize,
+		PollInterval:          DefaultPollInterval,
+	}
+}
+
+// Indexer follows TaskManager and ServiceManager logs and writes them to
+// task_data, task_history, keeper_data and keeper_events
+type Indexer struct {
+	db             *database.Connection
+	client         *ethclient.Client
+	taskManager    *taskmanager.ContractTriggerXTaskManager
+	serviceManager *servicemanager.ContractTriggerXServiceManager
+	taskManagerABI *abi.ABI
+	tracker        *chain.BlockTracker
+	config         Config
+}
+
+func NewIndexer(db *database.Connection, client *ethclient.Client, config Config) (*Indexer, error) {
+	taskManager, err := taskmanager.NewContractTriggerXTaskManager(common.HexToAddress(config.TaskManagerAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind task manager: %v", err)
+	}
+	serviceManager, err := servicemanager.NewContractTriggerXServiceManager(common.HexToAddress(config.ServiceManagerAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind service manager: %v", err)
+	}
+	taskManagerABI, err := taskmanager.ContractTriggerXTaskManagerMetaData.GetAbi()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse task manager ABI: %v", err)
+	}
+	tracker, err := chain.NewBlockTrackerForChain(client, config.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	tracker.SetBatchSize(config.BatchSize)
+
+	return &Indexer{
+		db:             db,
+		client:         client,
+		taskManager:    taskManager,
+		serviceManager: serviceManager,
+		taskManagerABI: taskManagerABI,
+		tracker:        tracker,
+		config:         config,
+	}, nil
+}
+
+// Run indexes new blocks until the context is cancelled
+func (i *Indexer) Run(ctx context.Context) error {
+	cursor, hashes, found, err := database.LoadCheckpoint(i.db.Session(), CheckpointName)
+	if err != nil {
+		return err
+	}
+	if found {
+		i.tracker.Resume(chain.CheckpointFromHex(cursor, hashes))
+	} else {
+		i.tracker.Start(i.config.StartBlock)
+	}
+
+	ticker := time.NewTicker(i.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := i.tracker.Poll(ctx, i); err != nil {
+			log.Printf("Event indexing failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Backfill re-indexes a closed block range without moving the cursor. Every
+// write is an upsert keyed by task ID or log position, so ranges that were
+// already indexed can be replayed safely.
+func (i *Indexer) Backfill(ctx context.Context, from, to uint64) error {
+	if to < from {
+		return fmt.Errorf("invalid backfill range %d-%d", from, to)
+	}
+	log.Printf("Backfilling events in blocks %d-%d", from, to)
+
+	for from <= to {
+		end := from + i.config.BatchSize - 1
+		if end > to {
+			end = to
+		}
+		if err := i.HandleBlocks(ctx, chain.NewBlocks(i.client, from, end)); err != nil {
+			return err
+		}
+		from = end + 1
+	}
+
+	return nil
+}
+
+// Checkpoint implements chain.BlockHandler
+func (i *Indexer) Checkpoint(checkpoint chain.Checkpoint) error {
+	return database.SaveCheckpoint(i.db.Session(), CheckpointName, checkpoint.Block, checkpoint.Hex())
+}
+
+// HandleBlocks implements chain.BlockHandler
+func (i *Indexer) HandleBlocks(ctx context.Context, blocks *chain.Blocks) error {
+	from, to := blocks.From, blocks.To
+	created, err := i.indexTaskCreated(ctx, blocks)
+	if err != nil {
+		return err
+	}
+	responded, err := i.indexTaskResponded(ctx, blocks)
+	if err != nil {
+		return err
+	}
+	keepers, err := i.indexKeeperEvents(ctx, blocks)
+	if err != nil {
+		return err
+	}
+
+	if created+responded+keepers > 0 {
+		log.Printf("Indexed blocks %d-%d: %d tasks created, %d tasks responded, %d keeper events",
+			from, to, created, responded, keepers)
+	}
+	return nil
+}
+
+// Rollback implements chain.BlockHandler by clearing everything the indexer
+// derived from the orphaned blocks
+func (i *Indexer) Rollback(ctx context.Context, from, to uint64) error {
+	if err := i.rollbackTasks(from, to); err != nil {
+		return err
+	}
+	return i.rollbackKeeperEvents(from, to)
+}
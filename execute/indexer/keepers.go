@@ -0,0 +1,230 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gocql/gocql"
+
+	"github.com/trigg3rX/go-backend/pkg/chain"
+)
+
+// keeperEvent is a KeeperAdded, KeeperRemoved or KeeperBlacklisted log
+type keeperEvent struct {
+	Type     string
+	Operator common.Address
+	Raw      types.Log
+}
+
+func (i *Indexer) indexKeeperEvents(ctx context.Context, blocks *chain.Blocks) (int, error) {
+	events, err := i.fetchKeeperEvents(ctx, blocks.From, blocks.To)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keeper status is derived from the order of events, so none is applied
+	// unless all are canonical
+	for _, event := range events {
+		if err := blocks.Check(ctx, event.Raw); err != nil {
+			return 0, err
+		}
+	}
+	for _, event := range events {
+		if err := i.saveKeeperEvent(event); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(events), nil
+}
+
+func (i *Indexer) fetchKeeperEvents(ctx context.Context, from, to uint64) ([]keeperEvent, error) {
+	opts := &bind.FilterOpts{Start: from, End: &to, Context: ctx}
+	var events []keeperEvent
+
+	added, err := i.serviceManager.FilterKeeperAdded(opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter KeeperAdded events: %v", err)
+	}
+	for added.Next() {
+		events = append(events, keeperEvent{Type: EventKeeperAdded, Operator: added.Event.Operator, Raw: added.Event.Raw})
+	}
+	if err := added.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read KeeperAdded events: %v", err)
+	}
+	added.Close()
+
+	removed, err := i.serviceManager.FilterKeeperRemoved(opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter KeeperRemoved events: %v", err)
+	}
+	for removed.Next() {
+		events = append(events, keeperEvent{Type: EventKeeperRemoved, Operator: removed.Event.Operator, Raw: removed.Event.Raw})
+	}
+	if err := removed.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read KeeperRemoved events: %v", err)
+	}
+	removed.Close()
+
+	blacklisted, err := i.serviceManager.FilterKeeperBlacklisted(opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter KeeperBlacklisted events: %v", err)
+	}
+	for blacklisted.Next() {
+		events = append(events, keeperEvent{Type: EventKeeperBlacklisted, Operator: blacklisted.Event.Operator, Raw: blacklisted.Event.Raw})
+	}
+	if err := blacklisted.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read KeeperBlacklisted events: %v", err)
+	}
+	blacklisted.Close()
+
+	// Keeper status depends on the order of events
+	sort.Slice(events, func(a, b int) bool {
+		if events[a].Raw.BlockNumber != events[b].Raw.BlockNumber {
+			return events[a].Raw.BlockNumber < events[b].Raw.BlockNumber
+		}
+		return events[a].Raw.Index < events[b].Raw.Index
+	})
+
+	return events, nil
+}
+
+func (i *Indexer) saveKeeperEvent(event keeperEvent) error {
+	if err := i.db.Session().Query(`
+        INSERT INTO triggerx.keeper_events (
+            tx_hash, log_index, block_number, event_type, operator_address
+        ) VALUES (?, ?, ?, ?, ?)`,
+		event.Raw.TxHash.Hex(), int(event.Raw.Index), int64(event.Raw.BlockNumber),
+		event.Type, strings.ToLower(event.Operator.Hex())).Exec(); err != nil {
+		return fmt.Errorf("failed to record %s event %s: %v", event.Type, event.Raw.TxHash.Hex(), err)
+	}
+
+	return i.applyKeeperStatus(event.Operator, event.Type, event.Raw.TxHash.Hex())
+}
+
+// applyKeeperStatus updates keeper_data for the latest event of an operator.
+// An empty event type means the operator has no remaining events.
+func (i *Indexer) applyKeeperStatus(operator common.Address, eventType, txHash string) error {
+	session := i.db.Session()
+
+	keeperID, err := i.keeperID(operator)
+	if err == gocql.ErrNotFound {
+		log.Printf("No keeper_data row for operator %s, recorded %s event only", operator.Hex(), eventType)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if eventType == EventKeeperAdded {
+		err = session.Query(`
+            UPDATE triggerx.keeper_data SET status = ?, registered_tx = ? WHERE keeper_id = ?`,
+			true, txHash, keeperID).Exec()
+	} else {
+		err = session.Query(`
+            UPDATE triggerx.keeper_data SET status = ? WHERE keeper_id = ?`,
+			false, keeperID).Exec()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update keeper %d: %v", keeperID, err)
+	}
+
+	return nil
+}
+
+// rollbackKeeperEvents removes keeper events from orphaned blocks and
+// restores each affected keeper to the status of its latest remaining event
+func (i *Indexer) rollbackKeeperEvents(from, to uint64) error {
+	session := i.db.Session()
+
+	iter := session.Query(`
+        SELECT tx_hash, log_index, operator_address FROM triggerx.keeper_events
+        WHERE block_number >= ? AND block_number <= ? ALLOW FILTERING`,
+		int64(from), int64(to)).Iter()
+
+	operators := make(map[string]struct{})
+	var orphaned []keeperEvent
+	var txHash, operator string
+	var logIndex int
+	for iter.Scan(&txHash, &logIndex, &operator) {
+		orphaned = append(orphaned, keeperEvent{
+			Operator: common.HexToAddress(operator),
+			Raw:      types.Log{TxHash: common.HexToHash(txHash), Index: uint(logIndex)},
+		})
+		operators[operator] = struct{}{}
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("failed to read orphaned keeper events: %v", err)
+	}
+
+	for _, event := range orphaned {
+		if err := session.Query(`
+            DELETE FROM triggerx.keeper_events WHERE tx_hash = ? AND log_index = ?`,
+			event.Raw.TxHash.Hex(), int(event.Raw.Index)).Exec(); err != nil {
+			return fmt.Errorf("failed to remove orphaned keeper event %s: %v", event.Raw.TxHash.Hex(), err)
+		}
+	}
+
+	for operator := range operators {
+		eventType, txHash, err := i.latestKeeperEvent(operator)
+		if err != nil {
+			return err
+		}
+		if err := i.applyKeeperStatus(common.HexToAddress(operator), eventType, txHash); err != nil {
+			return err
+		}
+	}
+
+	if len(orphaned) > 0 {
+		log.Printf("Rolled back %d keeper events in blocks %d-%d", len(orphaned), from, to)
+	}
+	return nil
+}
+
+func (i *Indexer) latestKeeperEvent(operator string) (string, string, error) {
+	iter := i.db.Session().Query(`
+        SELECT tx_hash, log_index, block_number, event_type FROM triggerx.keeper_events
+        WHERE operator_address = ? ALLOW FILTERING`, operator).Iter()
+
+	var latestType, latestTx string
+	var latestBlock int64 = -1
+	var latestIndex int
+	var txHash, eventType string
+	var logIndex int
+	var blockNumber int64
+	for iter.Scan(&txHash, &logIndex, &blockNumber, &eventType) {
+		if blockNumber > latestBlock || (blockNumber == latestBlock && logIndex > latestIndex) {
+			latestType, latestTx, latestBlock, latestIndex = eventType, txHash, blockNumber, logIndex
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return "", "", fmt.Errorf("failed to read keeper events of %s: %v", operator, err)
+	}
+
+	return latestType, latestTx, nil
+}
+
+// keeperID finds the keeper row for an operator. Keepers are stored by their
+// withdrawal address in either checksummed or lowercase form.
+func (i *Indexer) keeperID(operator common.Address) (int64, error) {
+	var keeperID int64
+	for _, address := range []string{operator.Hex(), strings.ToLower(operator.Hex())} {
+		err := i.db.Session().Query(`
+            SELECT keeper_id FROM triggerx.keeper_data WHERE withdrawal_address = ? ALLOW FILTERING`,
+			address).Scan(&keeperID)
+		if err == nil {
+			return keeperID, nil
+		}
+		if err != gocql.ErrNotFound {
+			return 0, fmt.Errorf("failed to look up keeper %s: %v", address, err)
+		}
+	}
+
+	return 0, gocql.ErrNotFound
+}
@@ -0,0 +1,240 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"gopkg.in/inf.v0"
+
+	taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+)
+
+// maxTaskNumLookback bounds the search for a task number when several tasks
+// of the same job were created in one block
+const maxTaskNumLookback = 16
+
+// taskDetails are the createNewTask arguments behind a TaskCreated event
+type taskDetails struct {
+	JobID           uint32
+	TaskNum         uint32
+	QuorumNumbers   []byte
+	QuorumThreshold uint8
+}
+
+func (i *Indexer) indexTaskCreated(ctx context.Context, blocks *chain.Blocks) (int, error) {
+	iter, err := i.taskManager.FilterTaskCreated(&bind.FilterOpts{Start: blocks.From, End: &blocks.To, Context: ctx})
+	if err != nil {
+		return 0, fmt.Errorf("failed to filter TaskCreated events: %v", err)
+	}
+	defer iter.Close()
+
+	count := 0
+	for iter.Next() {
+		if err := blocks.Check(ctx, iter.Event.Raw); err != nil {
+			return count, err
+		}
+		if err := i.saveTaskCreated(ctx, iter.Event); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := iter.Error(); err != nil {
+		return count, fmt.Errorf("failed to read TaskCreated events: %v", err)
+	}
+
+	return count, nil
+}
+
+func (i *Indexer) saveTaskCreated(ctx context.Context, event *taskmanager.ContractTriggerXTaskManagerTaskCreated) error {
+	taskID := chain.TaskIDToInt64(event.TaskId)
+	session := i.db.Session()
+
+	if err := session.Query(`
+        UPDATE triggerx.task_data
+        SET task_created_block = ?, task_created_tx_hash = ?, task_hash = ?
+        WHERE task_id = ?`,
+		int64(event.Raw.BlockNumber), event.Raw.TxHash.Hex(), hexutil.Encode(event.TaskHash[:]),
+		taskID).Exec(); err != nil {
+		return fmt.Errorf("failed to index TaskCreated for task %d: %v", taskID, err)
+	}
+
+	details, err := i.taskDetails(ctx, event)
+	if err != nil {
+		// The creation block and hash are already stored, the job fields can
+		// be filled in by a later backfill
+		log.Printf("Cannot resolve job of task %s: %v", hexutil.Encode(event.TaskId[:]), err)
+		return nil
+	}
+
+	quorumNumber := 0
+	if len(details.QuorumNumbers) > 0 {
+		quorumNumber = int(details.QuorumNumbers[0])
+	}
+	if err := session.Query(`
+        UPDATE triggerx.task_data
+        SET job_id = ?, task_no = ?, quorum_number = ?, quorum_numbers = ?, quorum_threshold = ?
+        WHERE task_id = ?`,
+		int64(details.JobID), int(details.TaskNum), quorumNumber, details.QuorumNumbers,
+		inf.NewDec(int64(details.QuorumThreshold), 0),
+		taskID).Exec(); err != nil {
+		return fmt.Errorf("failed to index job of task %d: %v", taskID, err)
+	}
+
+	return nil
+}
+
+// taskDetails decodes the createNewTask call that emitted a TaskCreated
+// event and finds the task number that produced its task ID
+func (i *Indexer) taskDetails(ctx context.Context, event *taskmanager.ContractTriggerXTaskManagerTaskCreated) (*taskDetails, error) {
+	tx, _, err := i.client.TransactionByHash(ctx, event.Raw.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction %s: %v", event.Raw.TxHash.Hex(), err)
+	}
+	data := tx.Data()
+	if len(data) < 4 {
+		return nil, fmt.Errorf("transaction %s has no calldata", event.Raw.TxHash.Hex())
+	}
+
+	method, err := i.taskManagerABI.MethodById(data[:4])
+	if err != nil || method.Name != "createNewTask" {
+		return nil, fmt.Errorf("transaction %s is not a direct createNewTask call", event.Raw.TxHash.Hex())
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil || len(args) != 3 {
+		return nil, fmt.Errorf("failed to decode createNewTask arguments: %v", err)
+	}
+
+	details := &taskDetails{
+		JobID:           args[0].(uint32),
+		QuorumNumbers:   args[1].([]byte),
+		QuorumThreshold: args[2].(uint8),
+	}
+
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(event.Raw.BlockNumber)}
+	counter, err := i.taskManager.JobToTaskCounter(opts, details.JobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task counter of job %d: %v", details.JobID, err)
+	}
+
+	for n := int64(counter); n >= 0 && int64(counter)-n < maxTaskNumLookback; n-- {
+		taskID, err := i.taskManager.GenerateTaskId(opts, details.JobID, uint32(n))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate task ID: %v", err)
+		}
+		if taskID == event.TaskId {
+			details.TaskNum = uint32(n)
+			return details, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no task number of job %d matches task ID %s", details.JobID, hexutil.Encode(event.TaskId[:]))
+}
+
+func (i *Indexer) indexTaskResponded(ctx context.Context, blocks *chain.Blocks) (int, error) {
+	iter, err := i.taskManager.FilterTaskResponded(&bind.FilterOpts{Start: blocks.From, End: &blocks.To, Context: ctx})
+	if err != nil {
+		return 0, fmt.Errorf("failed to filter TaskResponded events: %v", err)
+	}
+	defer iter.Close()
+
+	count := 0
+	for iter.Next() {
+		if err := blocks.Check(ctx, iter.Event.Raw); err != nil {
+			return count, err
+		}
+		if err := i.saveTaskResponded(iter.Event); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := iter.Error(); err != nil {
+		return count, fmt.Errorf("failed to read TaskResponded events: %v", err)
+	}
+
+	return count, nil
+}
+
+func (i *Indexer) saveTaskResponded(event *taskmanager.ContractTriggerXTaskManagerTaskResponded) error {
+	taskID := chain.TaskIDToInt64(event.TaskId)
+	session := i.db.Session()
+
+	if err := session.Query(`
+        UPDATE triggerx.task_data
+        SET task_responded_block = ?, task_responded_tx_hash = ?, task_response_hash = ?
+        WHERE task_id = ?`,
+		int64(event.Raw.BlockNumber), event.Raw.TxHash.Hex(), hexutil.Encode(event.TaskResponseHash[:]),
+		taskID).Exec(); err != nil {
+		return fmt.Errorf("failed to index TaskResponded for task %d: %v", taskID, err)
+	}
+
+	if err := session.Query(`
+        UPDATE triggerx.task_history SET tx_hash = ? WHERE task_id = ?`,
+		event.Raw.TxHash.Hex(), taskID).Exec(); err != nil {
+		return fmt.Errorf("failed to update task history for task %d: %v", taskID, err)
+	}
+
+	return nil
+}
+
+// rollbackTasks clears the creation and response fields written from
+// orphaned blocks. The task rows themselves are owned by the task creator.
+func (i *Indexer) rollbackTasks(from, to uint64) error {
+	session := i.db.Session()
+
+	created, err := i.tasksInRange("task_created_block", from, to)
+	if err != nil {
+		return err
+	}
+	for _, taskID := range created {
+		if err := session.Query(`
+            DELETE task_created_block, task_created_tx_hash, task_hash
+            FROM triggerx.task_data WHERE task_id = ?`, taskID).Exec(); err != nil {
+			return fmt.Errorf("failed to roll back creation of task %d: %v", taskID, err)
+		}
+	}
+
+	responded, err := i.tasksInRange("task_responded_block", from, to)
+	if err != nil {
+		return err
+	}
+	for _, taskID := range responded {
+		if err := session.Query(`
+            DELETE task_responded_block, task_responded_tx_hash, task_response_hash
+            FROM triggerx.task_data WHERE task_id = ?`, taskID).Exec(); err != nil {
+			return fmt.Errorf("failed to roll back response of task %d: %v", taskID, err)
+		}
+		if err := session.Query(`
+            DELETE tx_hash FROM triggerx.task_history WHERE task_id = ?`, taskID).Exec(); err != nil {
+			return fmt.Errorf("failed to roll back task history of task %d: %v", taskID, err)
+		}
+	}
+
+	if len(created)+len(responded) > 0 {
+		log.Printf("Rolled back %d task creations and %d task responses in blocks %d-%d",
+			len(created), len(responded), from, to)
+	}
+	return nil
+}
+
+func (i *Indexer) tasksInRange(column string, from, to uint64) ([]int64, error) {
+	iter := i.db.Session().Query(`
+        SELECT task_id FROM triggerx.task_data
+        WHERE `+column+` >= ? AND `+column+` <= ? ALLOW FILTERING`,
+		int64(from), int64(to)).Iter()
+
+	var taskIDs []int64
+	var taskID int64
+	for iter.Scan(&taskID) {
+		taskIDs = append(taskIDs, taskID)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to find tasks by %s: %v", column, err)
+	}
+
+	return taskIDs, nil
+}
@@ -0,0 +1,135 @@
+package keeper
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/network"
+	"github.com/trigg3rX/go-backend/pkg/types"
+)
+
+// cosignedTaskTTL is how long a co-signed task is remembered, so an
+// announcement seen again is not signed twice
+const cosignedTaskTTL = 30 * time.Minute
+
+// handleResults co-signs the executions other keepers of a quorum announce
+func (n *Node) handleResults(ctx context.Context, subscription *network.Subscription) {
+	for {
+		msg, err := subscription.Next(ctx)
+		if err != nil {
+			return
+		}
+		if msg.From == n.name {
+			continue
+		}
+
+		var result types.TaskResult
+		if err := msg.Decode(&result); err != nil {
+			log.Printf("Invalid task result from %s on %s: %v", msg.From, msg.Topic, err)
+			continue
+		}
+		go n.cosign(msg.From, result)
+	}
+}
+
+// cosign checks an execution announced by keeper against the chain and
+// sends the aggregator this node's signature over the response it supports.
+// Only executions by the keeper the task was assigned to are checked.
+func (n *Node) cosign(keeper string, result types.TaskResult) {
+	if n.executor == nil || n.keys == nil {
+		return
+	}
+	rawTaskID, err := hexutil.Decode(result.TaskID)
+	if err != nil || len(rawTaskID) != 8 {
+		log.Printf("Task result from %s has an invalid task ID %q", keeper, result.TaskID)
+		return
+	}
+	var taskID [8]byte
+	copy(taskID[:], rawTaskID)
+	taskNum := chain.TaskIDToInt64(taskID)
+
+	if !n.markCosigned(result.TaskID) {
+		return
+	}
+	signed := false
+	defer func() {
+		// A task that could not be checked may be signed on a later announcement
+		if !signed {
+			n.cosignedMu.Lock()
+			delete(n.cosigned, result.TaskID)
+			n.cosignedMu.Unlock()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(n.ctx, executionTimeout)
+	defer cancel()
+
+	task, err := n.executor.LoadTask(ctx, taskNum)
+	if err != nil {
+		log.Printf("Not co-signing task %d: %v", taskNum, err)
+		return
+	}
+	if !strings.EqualFold(task.AssignedKeeper, keeper) || task.JobID != result.JobID {
+		log.Printf("Not co-signing task %d: %s announced job %d, the task is job %d of %s",
+			taskNum, keeper, result.JobID, task.JobID, task.AssignedKeeper)
+		return
+	}
+	if task.TaskCreatedBlock <= 0 {
+		log.Printf("Not co-signing task %d: its creation is not indexed yet", taskNum)
+		return
+	}
+
+	job, err := n.executor.LoadJob(ctx, task.JobID)
+	if err != nil {
+		log.Printf("Not co-signing task %d: %v", taskNum, err)
+		return
+	}
+	reason, err := n.executor.Check(ctx, job, common.HexToAddress(keeper), uint64(task.TaskCreatedBlock), result.TxHash)
+	if err != nil {
+		log.Printf("Not co-signing task %d: %v", taskNum, err)
+		return
+	}
+	valid := reason == ""
+	if valid != result.Valid {
+		log.Printf("Task %d: %s announced execution %s as valid=%t, this keeper finds valid=%t %s",
+			taskNum, keeper, result.TxHash, result.Valid, valid, reason)
+	}
+
+	// The signature is over this keeper's own verdict, the aggregator keeps
+	// signatures over different responses apart
+	signature, err := n.SignTaskResponse(taskID, common.HexToHash(result.TxHash), valid)
+	if err != nil {
+		log.Printf("Failed to co-sign task %d: %v", taskNum, err)
+		return
+	}
+	if err := n.sendSignature(signature); err != nil {
+		log.Printf("Failed to co-sign task %d: %v", taskNum, err)
+		return
+	}
+	signed = true
+	log.Printf("Co-signed task %d executed by %s in %s", taskNum, keeper, result.TxHash)
+}
+
+// markCosigned records that a task is being co-signed and reports whether
+// it was not already
+func (n *Node) markCosigned(taskID string) bool {
+	n.cosignedMu.Lock()
+	defer n.cosignedMu.Unlock()
+
+	for id, signedAt := range n.cosigned {
+		if time.Since(signedAt) > cosignedTaskTTL {
+			delete(n.cosigned, id)
+		}
+	}
+	if _, exists := n.cosigned[taskID]; exists {
+		return false
+	}
+	n.cosigned[taskID] = time.Now()
+	return true
+}
@@ -0,0 +1,224 @@
+package keeper
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/trigg3rX/go-backend/execute/validator"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+// executionTimeout bounds one execution, from loading the job to the
+// validator's verdict
+const executionTimeout = 5 * time.Minute
+
+// transmittedJob is an accepted job and the task it was assigned to
+type transmittedJob struct {
+	JobID  string `json:"JobID"`
+	TaskID int64  `json:"TaskID"`
+}
+
+// Executor runs jobs assigned to the keeper: it sends the job's call from
+// the operator key, reports the transaction to the validator and signs the
+// task response for the aggregator
+type Executor struct {
+	apiURL       string
+	validatorURL string
+	operatorKey  *ecdsa.PrivateKey
+	client       *http.Client
+}
+
+// NewExecutor loads job definitions from the API at apiURL and reports
+// executions to the validator at validatorURL
+func NewExecutor(apiURL, validatorURL string, operatorKey *ecdsa.PrivateKey) *Executor {
+	return &Executor{
+		apiURL:       strings.TrimSuffix(apiURL, "/"),
+		validatorURL: strings.TrimSuffix(validatorURL, "/"),
+		operatorKey:  operatorKey,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// SetExecutor makes the node execute the jobs it accepts. Without an
+// executor jobs are only acknowledged.
+func (n *Node) SetExecutor(executor *Executor) {
+	n.executor = executor
+}
+
+// executeJob runs an accepted job and submits the signed task response
+func (n *Node) executeJob(job transmittedJob) {
+	if job.TaskID == 0 {
+		log.Printf("Job %s is not covered by an on-chain task, not executing it", job.JobID)
+		return
+	}
+	jobID, err := strconv.ParseInt(job.JobID, 10, 64)
+	if err != nil {
+		log.Printf("Job %s has no numeric job ID, not executing it", job.JobID)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, executionTimeout)
+	defer cancel()
+
+	jobData, err := n.executor.LoadJob(ctx, jobID)
+	if err != nil {
+		log.Printf("Failed to execute job %d for task %d: %v", jobID, job.TaskID, err)
+		return
+	}
+	n.cacheScript(ctx, jobData.ScriptIpfsUrl)
+
+	txHash, validation, err := n.executor.Execute(ctx, jobData, job.TaskID)
+	if err != nil {
+		log.Printf("Failed to execute job %d for task %d: %v", jobID, job.TaskID, err)
+		return
+	}
+	if !validation.Valid {
+		log.Printf("Execution %s of job %d was judged invalid: %s", txHash.Hex(), jobID, validation.Reason)
+	}
+
+	if err := n.SubmitTaskResponse(chain.Int64ToTaskID(job.TaskID), jobID, txHash, validation.Valid); err != nil {
+		log.Printf("Failed to submit response for task %d: %v", job.TaskID, err)
+	}
+}
+
+// LoadJob loads the definition of a job from the API
+func (e *Executor) LoadJob(ctx context.Context, jobID int64) (*models.JobData, error) {
+	var job models.JobData
+	if err := e.getJSON(ctx, fmt.Sprintf("%s/api/jobs/%d", e.apiURL, jobID), &job); err != nil {
+		return nil, fmt.Errorf("failed to load job %d: %v", jobID, err)
+	}
+	return &job, nil
+}
+
+// LoadTask loads a task from the API
+func (e *Executor) LoadTask(ctx context.Context, taskID int64) (*models.TaskData, error) {
+	var task models.TaskData
+	if err := e.getJSON(ctx, fmt.Sprintf("%s/api/tasks/%d", e.apiURL, taskID), &task); err != nil {
+		return nil, fmt.Errorf("failed to load task %d: %v", taskID, err)
+	}
+	return &task, nil
+}
+
+// Check checks a transaction another keeper executed a job with the way the
+// validator does, and returns why it is invalid, empty when it is valid
+func (e *Executor) Check(ctx context.Context, job *models.JobData, keeper common.Address, createdBlock uint64, txHash string) (string, error) {
+	client, err := chain.Dial(int64(job.ChainID))
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	return validator.CheckExecution(ctx, client, job, keeper, createdBlock, txHash)
+}
+
+// Execute sends the call of a job and returns its transaction with the
+// validator's verdict on it
+func (e *Executor) Execute(ctx context.Context, job *models.JobData, taskID int64) (common.Hash, *models.TaskValidation, error) {
+	jobID := job.JobID
+	calldata, err := chain.EncodeCall(job.TargetFunction, job.Arguments)
+	if err != nil {
+		return common.Hash{}, nil, fmt.Errorf("failed to encode call of job %d: %v", jobID, err)
+	}
+
+	client, err := chain.Dial(int64(job.ChainID))
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	defer client.Close()
+
+	opts, err := bind.NewKeyedTransactorWithChainID(e.operatorKey, big.NewInt(int64(job.ChainID)))
+	if err != nil {
+		return common.Hash{}, nil, fmt.Errorf("failed to create transactor: %v", err)
+	}
+	opts.Context = ctx
+	contract := bind.NewBoundContract(common.HexToAddress(job.ContractAddress), abi.ABI{}, client, client, client)
+	tx, err := contract.RawTransact(opts, calldata)
+	if err != nil {
+		return common.Hash{}, nil, fmt.Errorf("failed to send call of job %d: %v", jobID, err)
+	}
+	if _, err := bind.WaitMined(ctx, client, tx); err != nil {
+		return common.Hash{}, nil, fmt.Errorf("failed waiting for %s: %v", tx.Hash().Hex(), err)
+	}
+	log.Printf("Executed job %d for task %d in %s", jobID, taskID, tx.Hash().Hex())
+
+	validation, err := e.report(ctx, jobID, taskID, tx.Hash())
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	return tx.Hash(), validation, nil
+}
+
+// report sends a signed execution report to the validator
+func (e *Executor) report(ctx context.Context, jobID, taskID int64, txHash common.Hash) (*models.TaskValidation, error) {
+	keeper := crypto.PubkeyToAddress(e.operatorKey.PublicKey)
+	report := models.ExecutionReport{
+		TaskID:     taskID,
+		JobID:      jobID,
+		Keeper:     keeper.Hex(),
+		TxHash:     txHash.Hex(),
+		ExecutedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	digest := chain.ExecutionReportHash(taskID, jobID, keeper, txHash, report.ExecutedAt.Unix())
+	signature, err := crypto.Sign(digest[:], e.operatorKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign report: %v", err)
+	}
+	report.Signature = hexutil.Encode(signature)
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.validatorURL+"/reports", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to report %s: %v", txHash.Hex(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("validator rejected report of %s: %s", txHash.Hex(), resp.Status)
+	}
+
+	var validation models.TaskValidation
+	if err := json.NewDecoder(resp.Body).Decode(&validation); err != nil {
+		return nil, fmt.Errorf("invalid verdict on %s: %v", txHash.Hex(), err)
+	}
+	return &validation, nil
+}
+
+func (e *Executor) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
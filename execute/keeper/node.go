@@ -3,32 +3,75 @@ package keeper
 import (
 	"bufio"
 	"context"
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
-    "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/trigg3rX/go-backend/pkg/bls"
 	"github.com/trigg3rX/go-backend/pkg/network"
 )
 
+// peerCheckInterval is how often peers are pinged or redialed
+const peerCheckInterval = 10 * time.Second
+
+// acceptedJobTTL is how long an accepted job waits for its task assignment,
+// which follows once the task manager's createNewTask is mined
+const acceptedJobTTL = 5 * time.Minute
+
 type Node struct {
 	name      string
 	messaging *network.Messaging
+	rpc       *network.RPC
 	discovery *network.Discovery
-	peers     map[string]string // name -> peer ID
+	peers     *network.PeerManager
+	ctx       context.Context
+	keys      *bls.KeyPair
+	pubsub    *network.PubSub
+	chainID   string
+	quorums   []string
+	executor  *Executor
+	scripts   *ScriptCache
+
+	acceptedMu sync.Mutex
+	accepted   map[string]time.Time
+
+	cosignedMu sync.Mutex
+	cosigned   map[string]time.Time
 }
 
-func NewNode(ctx context.Context, name string) (*Node, error) {
+// NewNode starts a keeper listening on the address of name in
+// network.KeeperConfigs. On the network the keeper is named by the address
+// of its operator key, which also signs its messages.
+func NewNode(ctx context.Context, name string, operatorKey *ecdsa.PrivateKey) (*Node, error) {
 	addr, exists := network.KeeperConfigs[name]
 	if !exists {
 		return nil, fmt.Errorf("invalid keeper name")
 	}
+	operator := crypto.PubkeyToAddress(operatorKey.PublicKey).Hex()
+
+	identity, err := network.IdentityFromEnv(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// NAT traversal is set up from TRIGGERX_* settings, see
+	// network.NATConfigFromEnv
+	nat, err := network.NATConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
 	config := network.P2PConfig{
-		Name:    name,
-		Address: addr,
+		Name:     operator,
+		Address:  addr,
+		Identity: identity,
+		NAT:      nat,
 	}
 
 	host, err := network.SetupP2P(ctx, config)
@@ -36,28 +79,104 @@ func NewNode(ctx context.Context, name string) (*Node, error) {
 		return nil, err
 	}
 
-	messaging := network.NewMessaging(host, name)
-	discovery := network.NewDiscovery(ctx, host, name)
+	source, err := network.SourceFromEnv(host, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	compression, err := network.CompressionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	messaging := network.NewMessaging(host, operator)
+	messaging.SetCompression(compression)
+	messaging.SetSigner(network.NewECDSASigner(operatorKey))
+	discovery := network.NewDiscovery(ctx, host, operator, source)
 
 	node := &Node{
-		name:      name,
+		name:      operator,
 		messaging: messaging,
+		rpc:       network.NewRPC(messaging),
 		discovery: discovery,
-		peers:     make(map[string]string),
+		peers:     network.NewPeerManager(discovery),
+		ctx:       ctx,
+		accepted:  make(map[string]time.Time),
+		cosigned:  make(map[string]time.Time),
 	}
 
-	messaging.InitMessageHandling(node.handleMessage)
+	messaging.Handle(network.JobTransmissionMessage, node.handleMessage)
+	messaging.Handle(network.JSONMessage, node.handleMessage)
+	messaging.Listen()
+	node.rpc.Register(network.JobTransmissionMessage, node.acceptJob)
+	node.rpc.Register(network.TaskAssignmentMessage, node.acceptTask)
 
 	return node, nil
 }
 
-func (n *Node) handleMessage(msg network.Message) {
+func (n *Node) handleMessage(msg network.Message, content json.RawMessage) error {
+	msg.Content = content
 	prettyJSON, _ := json.MarshalIndent(msg, "", "  ")
 	fmt.Printf("\nReceived message from %s:\n%s\n", msg.From, string(prettyJSON))
+	return nil
+}
+
+// acceptJob acknowledges jobs sent to this keeper. A job is executed once
+// the task manager has created its on-chain task and assigned it, see
+// acceptTask.
+func (n *Node) acceptJob(ctx context.Context, msg network.Message, content json.RawMessage) (interface{}, error) {
+	if msg.From != network.ManagerPeerName {
+		return nil, &network.ReplyError{Code: network.ReplyRejected, Message: "jobs are only accepted from the task manager"}
+	}
+	var job transmittedJob
+	if err := json.Unmarshal(content, &job); err != nil || job.JobID == "" {
+		return nil, &network.ReplyError{Code: network.ReplyInvalidPayload, Message: "job without an ID"}
+	}
+
+	if err := n.handleMessage(msg, content); err != nil {
+		return nil, err
+	}
+	if n.executor != nil {
+		n.acceptedMu.Lock()
+		for jobID, acceptedAt := range n.accepted {
+			if time.Since(acceptedAt) > acceptedJobTTL {
+				delete(n.accepted, jobID)
+			}
+		}
+		n.accepted[job.JobID] = time.Now()
+		n.acceptedMu.Unlock()
+	}
+	return network.JobAck{JobID: job.JobID, Keeper: n.name}, nil
+}
+
+// acceptTask executes an accepted job under the on-chain task the task
+// manager created for it
+func (n *Node) acceptTask(ctx context.Context, msg network.Message, content json.RawMessage) (interface{}, error) {
+	if msg.From != network.ManagerPeerName {
+		return nil, &network.ReplyError{Code: network.ReplyRejected, Message: "tasks are only assigned by the task manager"}
+	}
+	var assignment network.TaskAssignment
+	if err := json.Unmarshal(content, &assignment); err != nil || assignment.JobID == "" || assignment.TaskID == 0 {
+		return nil, &network.ReplyError{Code: network.ReplyInvalidPayload, Message: "assignment without a job and task"}
+	}
+
+	n.acceptedMu.Lock()
+	acceptedAt, accepted := n.accepted[assignment.JobID]
+	delete(n.accepted, assignment.JobID)
+	n.acceptedMu.Unlock()
+	if !accepted || time.Since(acceptedAt) > acceptedJobTTL {
+		return nil, &network.ReplyError{Code: network.ReplyRejected, Message: fmt.Sprintf("job %s is not awaiting a task", assignment.JobID)}
+	}
+
+	go n.executeJob(transmittedJob{JobID: assignment.JobID, TaskID: assignment.TaskID})
+	return network.JobAck{JobID: assignment.JobID, Keeper: n.name}, nil
 }
 
 func (n *Node) Start() error {
-	if err := n.discovery.SavePeerInfo(); err != nil {
+	if err := n.discovery.Advertise(); err != nil {
+		return err
+	}
+	if err := n.discovery.AdvertiseOnChange(); err != nil {
 		return err
 	}
 
@@ -67,43 +186,29 @@ func (n *Node) Start() error {
 	return nil
 }
 
+// servicePeers are the peers a keeper stays connected to. Other keepers are
+// dialed on demand and left to the connection manager, so watermarks can
+// trim them.
+var servicePeers = []string{network.ManagerPeerName, network.AggregatorPeerName}
+
+// autoConnectToPeers keeps the service peers tracked. The peer manager
+// keeps them connected and drops them after staying unreachable, so they
+// are tracked again every interval.
 func (n *Node) autoConnectToPeers() {
+	go n.peers.Run(n.ctx, peerCheckInterval)
+
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
+		for _, name := range servicePeers {
+			n.peers.Track(name)
+		}
+
 		select {
+		case <-n.ctx.Done():
+			return
 		case <-ticker.C:
-			file, err := os.Open(network.PeerInfoFilePath)
-			if err != nil {
-				continue
-			}
-
-			var peerInfos map[string]network.PeerInfo
-			decoder := json.NewDecoder(file)
-			err = decoder.Decode(&peerInfos)
-			file.Close()
-			if err != nil {
-				continue
-			}
-
-			for name, info := range peerInfos {
-				if name == n.name {
-					continue
-				}
-
-				if _, exists := n.peers[name]; exists {
-					continue
-				}
-
-				peerID, err := n.discovery.ConnectToPeer(info)
-				if err != nil {
-					log.Printf("Failed to connect to %s: %v", name, err)
-					continue
-				}
-
-				n.peers[name] = peerID.String()
-			}
 		}
 	}
 }
@@ -112,8 +217,10 @@ func (n *Node) startMessageLoop() {
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
 		fmt.Println("\nConnected peers:")
-		for peerName := range n.peers {
-			fmt.Printf("- %s\n", peerName)
+		for peerName, health := range n.peers.Peers() {
+			if health.Connected {
+				fmt.Printf("- %s (%s)\n", peerName, health.Latency)
+			}
 		}
 
 		fmt.Print("Enter keeper name to send message: ")
@@ -129,19 +236,15 @@ func (n *Node) startMessageLoop() {
 			"messageId": "123456789",
 		}
 
-		if peerIDStr, ok := n.peers[recipient]; ok {
-			peerID, err := peer.Decode(peerIDStr)
-			if err != nil {
-				log.Printf("Error decoding peer ID: %v", err)
-				continue
-			}
+		peerID, err := n.peers.Connect(recipient)
+		if err != nil {
+			log.Printf("Peer %s not connected: %v", recipient, err)
+			continue
+		}
 
-			err = n.messaging.SendMessage(recipient, peerID, testMessage)
-			if err != nil {
-				log.Printf("Error sending message: %v", err)
-			}
-		} else {
-			log.Printf("Peer %s not connected", recipient)
+		err = n.messaging.SendMessage(recipient, peerID, testMessage)
+		if err != nil {
+			log.Printf("Error sending message: %v", err)
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,218 @@
+package keeper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-cid"
+
+	"github.com/trigg3rX/go-backend/pkg/ipfs"
+	"github.com/trigg3rX/go-backend/pkg/network"
+)
+
+// maxScriptSources is how many keepers are asked for a missing script
+const maxScriptSources = 3
+
+// ScriptCache holds job scripts under the IPFS CID they were published
+// under, one CAR file per script. Scripts are verified against their CID
+// before they are cached, wherever they came from.
+type ScriptCache struct {
+	dir  string
+	ipfs *ipfs.Client
+}
+
+// NewScriptCache keeps scripts in dir, creating it if needed. Scripts no
+// keeper has are fetched through the gateway from ipfs.GatewayFromEnv.
+func NewScriptCache(dir string) (*ScriptCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create script cache %s: %v", dir, err)
+	}
+	return &ScriptCache{dir: dir, ipfs: ipfs.NewClient(ipfs.GatewayFromEnv())}, nil
+}
+
+// ScriptKey returns the CID a script URL ends in. Keys are alphanumeric, so
+// they cannot name a file outside the cache.
+func ScriptKey(url string) (string, error) {
+	key := path.Base(strings.TrimSuffix(url, "/"))
+	if len(key) > 128 {
+		return "", fmt.Errorf("invalid script URL %q", url)
+	}
+	for _, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return "", fmt.Errorf("invalid script URL %q", url)
+		}
+	}
+	if _, err := cid.Decode(key); err != nil {
+		return "", fmt.Errorf("script URL %q does not end in a CID: %v", url, err)
+	}
+	return key, nil
+}
+
+// Has reports whether a script is cached
+func (c *ScriptCache) Has(key string) bool {
+	_, err := os.Stat(filepath.Join(c.dir, key))
+	return err == nil
+}
+
+// Open opens a cached script and returns its size
+func (c *ScriptCache) Open(key string) (io.ReadCloser, int64, error) {
+	if _, err := ScriptKey(key); err != nil {
+		return nil, 0, network.ErrItemNotFound
+	}
+	file, err := os.Open(filepath.Join(c.dir, key))
+	if os.IsNotExist(err) {
+		return nil, 0, network.ErrItemNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// store verifies the CAR of a script against its CID and writes it
+// through a temporary file, so the cache only ever holds complete scripts
+func (c *ScriptCache) store(key string, car []byte) error {
+	root, err := cid.Decode(key)
+	if err != nil {
+		return fmt.Errorf("invalid script key %s: %v", key, err)
+	}
+	if err := ipfs.VerifyCAR(root, car); err != nil {
+		return fmt.Errorf("script %s failed verification: %v", key, err)
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".fetch-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(car); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(c.dir, key))
+}
+
+// limitedBuffer fails writes past ipfs.MaxCARSize
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > ipfs.MaxCARSize {
+		return 0, fmt.Errorf("script is larger than %d bytes", ipfs.MaxCARSize)
+	}
+	return b.Buffer.Write(p)
+}
+
+// SetScriptCache serves the scripts in cache to other keepers over
+// network.TransferProtocol and fetches the scripts of executed jobs that are
+// missing from it
+func (n *Node) SetScriptCache(cache *ScriptCache) {
+	n.scripts = cache
+	network.ServeItems(n.messaging, n.serveScript)
+}
+
+// serveScript hands cached scripts to keepers only. Keepers are named by
+// their operator address, which the verifier checked is registered.
+func (n *Node) serveScript(requester, key string) (io.ReadCloser, int64, error) {
+	if n.messaging.Verifier() == nil {
+		return nil, 0, fmt.Errorf("scripts are only served to verified keepers")
+	}
+	if !common.IsHexAddress(requester) {
+		return nil, 0, fmt.Errorf("scripts are only served to keepers")
+	}
+	return n.scripts.Open(key)
+}
+
+// FetchScript copies a script cached by another keeper into the cache. The
+// keeper is dialed without being tracked, so the connection can be trimmed.
+func (n *Node) FetchScript(ctx context.Context, keeper, key string) error {
+	if _, err := ScriptKey(key); err != nil {
+		return err
+	}
+	peerID, err := n.discovery.Connect(keeper)
+	if err != nil {
+		return err
+	}
+	var car limitedBuffer
+	if _, err := network.FetchItem(ctx, n.messaging, peerID, key, &car); err != nil {
+		return err
+	}
+	return n.scripts.store(key, car.Bytes())
+}
+
+// FetchScriptFromIPFS copies a script from the IPFS gateway into the cache
+func (n *Node) FetchScriptFromIPFS(ctx context.Context, url, key string) error {
+	root, err := cid.Decode(key)
+	if err != nil {
+		return err
+	}
+	car, err := n.scripts.ipfs.FetchCAR(ctx, url, root)
+	if err != nil {
+		return err
+	}
+	return n.scripts.store(key, car)
+}
+
+// cacheScript makes sure the script of a job is cached, asking the best
+// ranked keepers for it and then IPFS
+func (n *Node) cacheScript(ctx context.Context, url string) {
+	if n.scripts == nil || url == "" {
+		return
+	}
+	key, err := ScriptKey(url)
+	if err != nil {
+		log.Printf("Not caching script: %v", err)
+		return
+	}
+	if n.scripts.Has(key) {
+		return
+	}
+
+	peers, err := n.discovery.Peers()
+	if err != nil {
+		log.Printf("Failed to find keepers caching script %s: %v", key, err)
+	}
+	var keepers []string
+	for name := range peers {
+		if name != n.name && common.IsHexAddress(name) {
+			keepers = append(keepers, name)
+		}
+	}
+	keepers = n.peers.Rank(keepers)
+	if len(keepers) > maxScriptSources {
+		keepers = keepers[:maxScriptSources]
+	}
+
+	for _, keeper := range keepers {
+		if err := n.FetchScript(ctx, keeper, key); err != nil {
+			log.Printf("Failed to fetch script %s from %s: %v", key, keeper, err)
+			continue
+		}
+		log.Printf("Cached script %s from %s", key, keeper)
+		return
+	}
+
+	if err := n.FetchScriptFromIPFS(ctx, url, key); err != nil {
+		log.Printf("Failed to fetch script %s from IPFS: %v", key, err)
+		return
+	}
+	log.Printf("Cached script %s from IPFS", key)
+}
@@ -0,0 +1,92 @@
+package keeper
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/trigg3rX/go-backend/pkg/bls"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/network"
+	"github.com/trigg3rX/go-backend/pkg/types"
+)
+
+// SetKeys sets the BLS key pair the node signs task responses with.
+// Messages are signed with the operator key the node was created with.
+func (n *Node) SetKeys(keys *bls.KeyPair) {
+	n.keys = keys
+}
+
+// SetVerifier makes the node drop messages and topic messages the verifier
+// does not authenticate
+func (n *Node) SetVerifier(verifier *network.Verifier) {
+	n.messaging.SetVerifier(verifier)
+	if n.pubsub != nil {
+		n.pubsub.SetVerifier(verifier)
+	}
+}
+
+// SignTaskResponse signs the response hash of an executed task
+func (n *Node) SignTaskResponse(taskID [8]byte, txHash common.Hash, valid bool) (types.TaskSignature, error) {
+	if n.keys == nil {
+		return types.TaskSignature{}, fmt.Errorf("no BLS key loaded")
+	}
+
+	responseHash := chain.TaskResponseHash(taskID, txHash, valid)
+	operatorID := bls.OperatorID(n.keys.PubG1)
+
+	return types.TaskSignature{
+		TaskID:       hexutil.Encode(taskID[:]),
+		ResponseHash: responseHash.Hex(),
+		OperatorID:   hexutil.Encode(operatorID[:]),
+		Signature:    hexutil.Encode(n.keys.SignMessage(responseHash).Bytes()),
+		PubkeyG2:     hexutil.Encode(n.keys.PubG2.Bytes()),
+	}, nil
+}
+
+// SubmitTaskResponse signs the response of a task the node executed, sends
+// it to the aggregator and announces the execution so the other keepers of
+// its quorums can sign it too
+func (n *Node) SubmitTaskResponse(taskID [8]byte, jobID int64, txHash common.Hash, valid bool) error {
+	signature, err := n.SignTaskResponse(taskID, txHash, valid)
+	if err != nil {
+		return err
+	}
+	if err := n.sendSignature(signature); err != nil {
+		return err
+	}
+
+	n.AnnounceResult(types.TaskResult{
+		TaskID:       signature.TaskID,
+		JobID:        jobID,
+		TxHash:       txHash.Hex(),
+		Valid:        valid,
+		ResponseHash: signature.ResponseHash,
+	})
+	return nil
+}
+
+// sendSignature sends a task response signature to the aggregator
+func (n *Node) sendSignature(signature types.TaskSignature) error {
+	peerID, err := n.aggregatorPeer()
+	if err != nil {
+		return err
+	}
+
+	if err := n.messaging.SendTypedMessage(network.AggregatorPeerName, peerID, network.TaskSignatureMessage, signature); err != nil {
+		return fmt.Errorf("failed to send signature of task %s: %v", signature.TaskID, err)
+	}
+	return nil
+}
+
+// aggregatorPeer returns the aggregator's connection, finding and dialing
+// it through peer discovery when there is none
+func (n *Node) aggregatorPeer() (peer.ID, error) {
+	peerID, err := n.peers.Connect(network.AggregatorPeerName)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach aggregator: %v", err)
+	}
+	return peerID, nil
+}
@@ -0,0 +1,117 @@
+package keeper
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/trigg3rX/go-backend/pkg/network"
+)
+
+// HeartbeatInterval is how often a keeper publishes a heartbeat
+const HeartbeatInterval = 30 * time.Second
+
+// heartbeat is the content of a keeper heartbeat
+type heartbeat struct {
+	Quorums []string `json:"quorums"`
+	Peers   int      `json:"peers"`
+}
+
+// jobBroadcast is the part of the task manager's job broadcasts a keeper
+// reads
+type jobBroadcast struct {
+	Job struct {
+		JobID string `json:"JobID"`
+	} `json:"job"`
+	Keeper   string `json:"keeper"`
+	QuorumID string `json:"quorum_id"`
+}
+
+// StartTopics joins the job topics of the node's quorums, accepting jobs
+// from the task manager only, and their result topics to co-sign the
+// executions of other keepers. It publishes heartbeats until ctx ends.
+func (n *Node) StartTopics(ctx context.Context, chainID string, quorums []string) error {
+	pubsub, err := network.NewPubSub(ctx, n.messaging.GetHost(), n.name)
+	if err != nil {
+		return err
+	}
+	pubsub.SetCompression(n.messaging.Compression())
+	pubsub.SetSigner(n.messaging.Signer())
+	if verifier := n.messaging.Verifier(); verifier != nil {
+		pubsub.SetVerifier(verifier)
+	}
+	managerOnly := network.NewPublisherSet(network.ManagerPeerName)
+
+	for _, quorumID := range quorums {
+		topic := network.JobTopic(chainID, quorumID)
+		pubsub.SetAccessControl(topic, managerOnly)
+		jobs, err := pubsub.Subscribe(topic)
+		if err != nil {
+			return err
+		}
+		results, err := pubsub.Subscribe(network.ResultTopic(chainID, quorumID))
+		if err != nil {
+			return err
+		}
+		go n.handleJobBroadcasts(ctx, jobs)
+		go n.handleResults(ctx, results)
+	}
+
+	n.pubsub = pubsub
+	n.chainID = chainID
+	n.quorums = quorums
+	go n.publishHeartbeats(ctx)
+	return nil
+}
+
+// AnnounceResult publishes a task result on the result topic of each of the
+// node's quorums
+func (n *Node) AnnounceResult(result interface{}) {
+	if n.pubsub == nil {
+		return
+	}
+	for _, quorumID := range n.quorums {
+		if err := n.pubsub.Publish(network.ResultTopic(n.chainID, quorumID), network.ResultMessage, result); err != nil {
+			log.Printf("Failed to announce result to quorum %s: %v", quorumID, err)
+		}
+	}
+}
+
+func (n *Node) publishHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	topic := network.HeartbeatTopic(n.chainID)
+	for {
+		beat := heartbeat{Quorums: n.quorums, Peers: len(n.messaging.GetHost().Network().Peers())}
+		if err := n.pubsub.Publish(topic, network.HeartbeatMessage, beat); err != nil {
+			log.Printf("Failed to publish heartbeat: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *Node) handleJobBroadcasts(ctx context.Context, subscription *network.Subscription) {
+	for {
+		msg, err := subscription.Next(ctx)
+		if err != nil {
+			return
+		}
+
+		var broadcast jobBroadcast
+		if err := msg.Decode(&broadcast); err != nil {
+			log.Printf("Invalid job broadcast on %s: %v", msg.Topic, err)
+			continue
+		}
+		if broadcast.Keeper == n.name {
+			log.Printf("Job %s assigned to this keeper in quorum %s", broadcast.Job.JobID, broadcast.QuorumID)
+		} else {
+			log.Printf("Job %s assigned to %s in quorum %s", broadcast.Job.JobID, broadcast.Keeper, broadcast.QuorumID)
+		}
+	}
+}
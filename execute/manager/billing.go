@@ -0,0 +1,236 @@
+// github.com/trigg3rX/go-backend/execute/manager/billing.go
+package manager
+
+import (
+    "fmt"
+    "log"
+    "math/big"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/trigg3rX/go-backend/pkg/database"
+    "github.com/trigg3rX/go-backend/pkg/estimator"
+    "github.com/trigg3rX/go-backend/pkg/ledger"
+)
+
+const (
+    // verdictTimeout is how long an execution waits for the validator's
+    // verdict before it is given up on without a charge
+    verdictTimeout = 10 * time.Minute
+    // verdictPollInterval is how often task_validations is checked for it
+    verdictPollInterval = 15 * time.Second
+    // responseTimeout is how long a charged execution waits for the quorum's
+    // on-chain task response before its fee is refunded
+    responseTimeout = 30 * time.Minute
+)
+
+// SetLedger enables per-execution billing against the stake ledger.
+// Executions are charged once the validator's verdict on them is in db, so
+// billing needs on-chain task creation.
+func (js *JobScheduler) SetLedger(l *ledger.Ledger, db *database.Connection) {
+    js.mu.Lock()
+    defer js.mu.Unlock()
+    js.ledger = l
+    js.db = db
+}
+
+// executionCost returns the per-execution share of a job's predicted cost in Gwei
+func executionCost(job *Job) *big.Int {
+    executions := estimator.Executions(job.TimeFrame, int(job.TimeInterval))
+    total, _ := new(big.Float).SetFloat64(job.JobCostPrediction).Int(nil)
+    cost := new(big.Int).Div(total, big.NewInt(executions))
+    if cost.Sign() == 0 && total.Sign() > 0 {
+        cost.SetInt64(1)
+    }
+    return cost
+}
+
+// billingIDs returns the numeric user and job IDs used by the ledger.
+// Jobs with non-numeric IDs have no job_data row and run unbilled, without
+// on-chain tasks. A job_data job
+// must belong to a numeric user, otherwise it is an error rather than a
+// free job.
+func billingIDs(job *Job) (userID, jobID int64, billed bool, err error) {
+    jobID, err = strconv.ParseInt(job.JobID, 10, 64)
+    if err != nil {
+        return 0, 0, false, nil
+    }
+    userID, err = strconv.ParseInt(job.UserID, 10, 64)
+    if err != nil {
+        return 0, jobID, false, fmt.Errorf("job %s has no billable user %q", job.JobID, job.UserID)
+    }
+    return userID, jobID, true, nil
+}
+
+// ensureFunded checks that the job owner can pay for the next execution and
+// pauses the job when they can't
+func (js *JobScheduler) ensureFunded(job *Job) bool {
+    if js.ledger == nil {
+        return true
+    }
+    userID, jobID, billed, err := billingIDs(job)
+    if err != nil {
+        js.pauseJob(job, jobID, err.Error())
+        return false
+    }
+    if !billed {
+        return true
+    }
+
+    canCover, err := js.ledger.CanCover(userID, executionCost(job))
+    if err != nil {
+        // Don't pause on a ledger read failure, the next run checks again
+        log.Printf("Failed to check balance for job %s: %v", job.JobID, err)
+        return true
+    }
+    if canCover {
+        return true
+    }
+
+    js.pauseJob(job, jobID, "insufficient balance for next execution")
+    return false
+}
+
+// pauseJob stops scheduling a job and persists the pause for job_data jobs
+func (js *JobScheduler) pauseJob(job *Job, jobID int64, reason string) {
+    js.mu.Lock()
+    job.Status = "paused"
+    job.Error = reason
+    js.mu.Unlock()
+
+    if jobID == 0 {
+        return
+    }
+    if err := js.ledger.PauseJob(jobID); err != nil {
+        log.Printf("Failed to persist pause for job %s: %v", job.JobID, err)
+    }
+}
+
+// chargeOnVerdict waits for the validator's verdict on the execution a
+// keeper was sent and charges the job owner when it is valid. The fee is
+// refunded when the quorum never responds to the task. An invalid execution
+// is not charged and its fee is slashed from the keeper's earnings, missing
+// executions are not charged.
+func (js *JobScheduler) chargeOnVerdict(job *Job, keeperName string) {
+    js.mu.RLock()
+    l, db := js.ledger, js.db
+    taskID := job.TaskID
+    js.mu.RUnlock()
+    if l == nil {
+        return
+    }
+    userID, jobID, billed, err := billingIDs(job)
+    if err != nil || !billed {
+        return
+    }
+    if taskID == 0 {
+        log.Printf("Execution of job %s has no on-chain task to be validated under, not charging it", job.JobID)
+        return
+    }
+    cost := executionCost(job)
+    since := time.Now().Add(-time.Second)
+
+    go func() {
+        txHash, valid, err := js.waitForVerdict(db, taskID, keeperName, since)
+        if err != nil {
+            log.Printf("Not charging execution of job %s by %s: %v", job.JobID, keeperName, err)
+            return
+        }
+        // The transaction identifies the execution, so a verdict seen twice
+        // is charged or slashed once
+        execution := strings.ToLower(txHash)
+        if !valid {
+            log.Printf("Not charging invalid execution %s of job %s by %s", txHash, job.JobID, keeperName)
+            slashed, err := l.Slash(keeperName, cost, taskID, "invalid execution "+txHash, "slash:"+execution)
+            if err != nil {
+                log.Printf("Failed to slash keeper %s for execution %s: %v", keeperName, txHash, err)
+            } else if slashed.Sign() > 0 {
+                log.Printf("Slashed %v Gwei from keeper %s for invalid execution %s", slashed, keeperName, txHash)
+            }
+            return
+        }
+
+        if _, err := l.ChargeExecution(userID, keeperName, cost, jobID, taskID, "execution:"+execution); err != nil {
+            log.Printf("Failed to charge execution %s of job %s to keeper %s: %v", txHash, job.JobID, keeperName, err)
+            return
+        }
+
+        if err := js.waitForResponse(db, taskID); err != nil {
+            log.Printf("Refunding execution %s of job %s: %v", txHash, job.JobID, err)
+            if _, err := l.Refund(userID, keeperName, cost, jobID, taskID, "task not responded", "refund:"+execution); err != nil {
+                log.Printf("Failed to refund execution %s of job %s: %v", txHash, job.JobID, err)
+            }
+        }
+    }()
+}
+
+// waitForResponse polls task_data until the quorum's response to a task
+// is indexed
+func (js *JobScheduler) waitForResponse(db *database.Connection, taskID int64) error {
+    deadline := time.NewTimer(responseTimeout)
+    defer deadline.Stop()
+    ticker := time.NewTicker(verdictPollInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-js.ctx.Done():
+            return js.ctx.Err()
+        case <-deadline.C:
+            return fmt.Errorf("no response to task %d within %s", taskID, responseTimeout)
+        case <-ticker.C:
+        }
+
+        var respondedBlock int64
+        if err := db.Session().Query(`
+            SELECT task_responded_block FROM triggerx.task_data WHERE task_id = ?`,
+            taskID).Scan(&respondedBlock); err != nil {
+            log.Printf("Failed to read response of task %d: %v", taskID, err)
+            continue
+        }
+        if respondedBlock > 0 {
+            return nil
+        }
+    }
+}
+
+// waitForVerdict polls task_validations for the first authenticated verdict
+// a keeper got on a task since an execution was sent to it
+func (js *JobScheduler) waitForVerdict(db *database.Connection, taskID int64, keeperName string, since time.Time) (string, bool, error) {
+    deadline := time.NewTimer(verdictTimeout)
+    defer deadline.Stop()
+    ticker := time.NewTicker(verdictPollInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-js.ctx.Done():
+            return "", false, js.ctx.Err()
+        case <-deadline.C:
+            return "", false, fmt.Errorf("no verdict on task %d within %s", taskID, verdictTimeout)
+        case <-ticker.C:
+        }
+
+        // Validations are clustered newest first, the last match is the
+        // first verdict
+        iter := db.Session().Query(`
+            SELECT keeper, tx_hash, valid, authenticated, validated_at
+            FROM triggerx.task_validations WHERE task_id = ?`, taskID).Iter()
+        var keeper, txHash, foundTx string
+        var valid, authenticated, found, foundValid bool
+        var validatedAt time.Time
+        for iter.Scan(&keeper, &txHash, &valid, &authenticated, &validatedAt) {
+            if authenticated && strings.EqualFold(keeper, keeperName) && !validatedAt.Before(since) {
+                found, foundTx, foundValid = true, txHash, valid
+            }
+        }
+        if err := iter.Close(); err != nil {
+            log.Printf("Failed to read verdicts of task %d: %v", taskID, err)
+            continue
+        }
+        if found {
+            return foundTx, foundValid, nil
+        }
+    }
+}
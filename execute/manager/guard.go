@@ -0,0 +1,480 @@
+// github.com/trigg3rX/go-backend/execute/manager/guard.go
+package manager
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "math/big"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/ethereum/go-ethereum/accounts/abi/bind"
+    "github.com/ethereum/go-ethereum/common"
+    "github.com/ethereum/go-ethereum/core/types"
+    "github.com/ethereum/go-ethereum/ethclient"
+
+    servicemanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXServiceManager"
+    taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+    "github.com/trigg3rX/go-backend/pkg/chain"
+)
+
+// ContractGuard caches which keepers the service manager has blacklisted and
+// whether the task manager or service manager is paused. The cache is seeded
+// from contract calls and then kept current from events.
+type ContractGuard struct {
+    client         *ethclient.Client
+    serviceManager *servicemanager.ContractTriggerXServiceManager
+    taskManager    *taskmanager.ContractTriggerXTaskManager
+    tracker        *chain.BlockTracker
+    confirmations  uint64
+
+    mu                   sync.RWMutex
+    blacklisted          map[string]bool // lowercase operator address -> blacklisted
+    taskManagerPaused    *big.Int
+    serviceManagerPaused *big.Int
+    // headPaused is the pause state read at the chain head, pause events
+    // only reach the fields above once confirmed
+    headPaused bool
+    // ready is set once the pause state has been read, until then jobs are
+    // held
+    ready     bool
+    lastBlock uint64
+    updatedAt time.Time
+}
+
+const (
+    // guardRetryMin and guardRetryMax bound the backoff between attempts
+    // to start the guard
+    guardRetryMin = 5 * time.Second
+    guardRetryMax = 5 * time.Minute
+)
+
+// GuardStatus is the cached contract state served by the manager API
+type GuardStatus struct {
+    Ready                      bool      `json:"ready"`
+    HeadPaused                 bool      `json:"head_paused"`
+    TaskManagerPaused          bool      `json:"task_manager_paused"`
+    TaskManagerPausedStatus    string    `json:"task_manager_paused_status"`
+    ServiceManagerPaused       bool      `json:"service_manager_paused"`
+    ServiceManagerPausedStatus string    `json:"service_manager_paused_status"`
+    BlacklistedKeepers         []string  `json:"blacklisted_keepers"`
+    LastBlock                  uint64    `json:"last_block"`
+    UpdatedAt                  time.Time `json:"updated_at"`
+}
+
+// pauseEvent is a Paused or Unpaused log, both carry the full pause bitmap
+type pauseEvent struct {
+    status *big.Int
+    raw    types.Log
+}
+
+// blacklistEvent is a KeeperBlacklisted or KeeperUnblacklisted log
+type blacklistEvent struct {
+    operator    common.Address
+    blacklisted bool
+    raw         types.Log
+}
+
+func NewContractGuard(client *ethclient.Client) (*ContractGuard, error) {
+    serviceManager, err := servicemanager.NewContractTriggerXServiceManager(common.HexToAddress(chain.ServiceManagerAddress), client)
+    if err != nil {
+        return nil, fmt.Errorf("failed to bind service manager: %v", err)
+    }
+    taskManager, err := taskmanager.NewContractTriggerXTaskManager(common.HexToAddress(chain.TaskManagerAddress), client)
+    if err != nil {
+        return nil, fmt.Errorf("failed to bind task manager: %v", err)
+    }
+    config, err := chain.GetConfig(chain.HoleskyChainID)
+    if err != nil {
+        return nil, err
+    }
+
+    return &ContractGuard{
+        client:               client,
+        serviceManager:       serviceManager,
+        taskManager:          taskManager,
+        tracker:              chain.NewBlockTracker(client, config.Confirmations),
+        confirmations:        config.Confirmations,
+        blacklisted:          make(map[string]bool),
+        taskManagerPaused:    new(big.Int),
+        serviceManagerPaused: new(big.Int),
+    }, nil
+}
+
+// SetContractGuard makes dispatch honour the guard's blacklist and pause state
+func (js *JobScheduler) SetContractGuard(guard *ContractGuard) {
+    js.mu.Lock()
+    defer js.mu.Unlock()
+    js.guard = guard
+}
+
+// Run reads the current pause state, then follows blacklist and pause
+// events every interval until ctx is cancelled. The pause state is also
+// read at the head every interval, so a pause holds jobs before its event
+// is confirmed. Chain errors are retried with backoff, Run only returns
+// once ctx ends.
+func (g *ContractGuard) Run(ctx context.Context, interval time.Duration) error {
+    backoff := guardRetryMin
+    for {
+        err := g.start(ctx)
+        if err == nil {
+            break
+        }
+        log.Printf("Contract guard failed to start, retrying in %s: %v", backoff, err)
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > guardRetryMax {
+            backoff = guardRetryMax
+        }
+    }
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        if err := g.tracker.Poll(ctx, g); err != nil {
+            log.Printf("Contract guard update failed: %v", err)
+        }
+        if err := g.pollHead(ctx); err != nil {
+            log.Printf("Contract guard pause check failed: %v", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+        }
+    }
+}
+
+// start seeds the pause state and starts the tracker at the first
+// unconfirmed block
+func (g *ContractGuard) start(ctx context.Context) error {
+    head, err := g.client.BlockNumber(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to get block number: %v", err)
+    }
+    if err := g.refreshPaused(ctx); err != nil {
+        return err
+    }
+    // Unconfirmed blocks are already reflected in the state read above, the
+    // tracker replays them once confirmed and applying them again is harmless
+    start := uint64(1)
+    if head > g.confirmations {
+        start = head - g.confirmations + 1
+    }
+    g.tracker.Start(start)
+    return nil
+}
+
+// CheckKeepers asks the service manager about keepers the guard has not seen
+// yet. Later changes arrive as events.
+func (g *ContractGuard) CheckKeepers(ctx context.Context, keepers []string) error {
+    for _, keeper := range keepers {
+        key := strings.ToLower(keeper)
+        g.mu.RLock()
+        _, known := g.blacklisted[key]
+        g.mu.RUnlock()
+        if known || !common.IsHexAddress(keeper) {
+            continue
+        }
+
+        blacklisted, err := g.serviceManager.IsBlackListed(&bind.CallOpts{Context: ctx}, common.HexToAddress(keeper))
+        if err != nil {
+            return fmt.Errorf("failed to check blacklist status of %s: %v", keeper, err)
+        }
+        g.mu.Lock()
+        if _, known := g.blacklisted[key]; !known {
+            g.blacklisted[key] = blacklisted
+        }
+        g.mu.Unlock()
+    }
+    return nil
+}
+
+// IsBlacklisted reports whether dispatch must skip a keeper
+func (g *ContractGuard) IsBlacklisted(keeper string) bool {
+    g.mu.RLock()
+    defer g.mu.RUnlock()
+    return g.blacklisted[strings.ToLower(keeper)]
+}
+
+// Paused reports whether either contract has any pause flag set, in
+// confirmed blocks or at the head. It also holds jobs until the pause state
+// has been read.
+func (g *ContractGuard) Paused() bool {
+    g.mu.RLock()
+    defer g.mu.RUnlock()
+    return !g.ready || g.headPaused || g.taskManagerPaused.Sign() != 0 || g.serviceManagerPaused.Sign() != 0
+}
+
+func (g *ContractGuard) Status() GuardStatus {
+    g.mu.RLock()
+    defer g.mu.RUnlock()
+
+    status := GuardStatus{
+        Ready:                      g.ready,
+        HeadPaused:                 g.headPaused,
+        TaskManagerPaused:          g.taskManagerPaused.Sign() != 0,
+        TaskManagerPausedStatus:    g.taskManagerPaused.String(),
+        ServiceManagerPaused:       g.serviceManagerPaused.Sign() != 0,
+        ServiceManagerPausedStatus: g.serviceManagerPaused.String(),
+        BlacklistedKeepers:         []string{},
+        LastBlock:                  g.lastBlock,
+        UpdatedAt:                  g.updatedAt,
+    }
+    for keeper, blacklisted := range g.blacklisted {
+        if blacklisted {
+            status.BlacklistedKeepers = append(status.BlacklistedKeepers, keeper)
+        }
+    }
+    sort.Strings(status.BlacklistedKeepers)
+    return status
+}
+
+// HandleBlocks implements chain.BlockHandler
+func (g *ContractGuard) HandleBlocks(ctx context.Context, blocks *chain.Blocks) error {
+    opts := &bind.FilterOpts{Start: blocks.From, End: &blocks.To, Context: ctx}
+
+    blacklist, err := g.fetchBlacklistEvents(opts)
+    if err != nil {
+        return err
+    }
+    taskManagerPause, err := g.fetchTaskManagerPauseEvents(opts)
+    if err != nil {
+        return err
+    }
+    serviceManagerPause, err := g.fetchServiceManagerPauseEvents(opts)
+    if err != nil {
+        return err
+    }
+
+    for _, event := range blacklist {
+        if err := blocks.Check(ctx, event.raw); err != nil {
+            return err
+        }
+    }
+    for _, event := range append(taskManagerPause, serviceManagerPause...) {
+        if err := blocks.Check(ctx, event.raw); err != nil {
+            return err
+        }
+    }
+
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    for _, event := range blacklist {
+        g.blacklisted[strings.ToLower(event.operator.Hex())] = event.blacklisted
+        if event.blacklisted {
+            log.Printf("Keeper %s blacklisted in block %d", event.operator.Hex(), event.raw.BlockNumber)
+        } else {
+            log.Printf("Keeper %s unblacklisted in block %d", event.operator.Hex(), event.raw.BlockNumber)
+        }
+    }
+    if n := len(taskManagerPause); n > 0 {
+        g.taskManagerPaused = taskManagerPause[n-1].status
+        log.Printf("Task manager pause status is %s", g.taskManagerPaused)
+    }
+    if n := len(serviceManagerPause); n > 0 {
+        g.serviceManagerPaused = serviceManagerPause[n-1].status
+        log.Printf("Service manager pause status is %s", g.serviceManagerPaused)
+    }
+    return nil
+}
+
+// Rollback implements chain.BlockHandler. Events cannot be undone one by
+// one, so the cached state is read again from the contracts.
+func (g *ContractGuard) Rollback(ctx context.Context, from, to uint64) error {
+    if err := g.refreshPaused(ctx); err != nil {
+        return err
+    }
+
+    g.mu.RLock()
+    keepers := make([]string, 0, len(g.blacklisted))
+    for keeper := range g.blacklisted {
+        keepers = append(keepers, keeper)
+    }
+    g.mu.RUnlock()
+
+    blacklisted := make(map[string]bool, len(keepers))
+    for _, keeper := range keepers {
+        status, err := g.serviceManager.IsBlackListed(&bind.CallOpts{Context: ctx}, common.HexToAddress(keeper))
+        if err != nil {
+            return fmt.Errorf("failed to check blacklist status of %s: %v", keeper, err)
+        }
+        blacklisted[keeper] = status
+    }
+
+    g.mu.Lock()
+    g.blacklisted = blacklisted
+    g.mu.Unlock()
+    return nil
+}
+
+// Checkpoint implements chain.BlockHandler. The cache lives in memory, so
+// only the block is recorded for the status endpoint.
+func (g *ContractGuard) Checkpoint(checkpoint chain.Checkpoint) error {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.lastBlock = checkpoint.Block
+    g.updatedAt = time.Now()
+    return nil
+}
+
+// refreshPaused replaces the cached pause state with the state at the head
+func (g *ContractGuard) refreshPaused(ctx context.Context) error {
+    taskManagerPaused, serviceManagerPaused, err := g.readPaused(ctx)
+    if err != nil {
+        return err
+    }
+
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    g.taskManagerPaused = taskManagerPaused
+    g.serviceManagerPaused = serviceManagerPaused
+    g.headPaused = taskManagerPaused.Sign() != 0 || serviceManagerPaused.Sign() != 0
+    g.ready = true
+    g.updatedAt = time.Now()
+    return nil
+}
+
+// pollHead reads whether either contract is paused at the head
+func (g *ContractGuard) pollHead(ctx context.Context) error {
+    taskManagerPaused, serviceManagerPaused, err := g.readPaused(ctx)
+    if err != nil {
+        return err
+    }
+
+    paused := taskManagerPaused.Sign() != 0 || serviceManagerPaused.Sign() != 0
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if paused != g.headPaused {
+        log.Printf("Contracts paused at head: %v", paused)
+    }
+    g.headPaused = paused
+    return nil
+}
+
+func (g *ContractGuard) readPaused(ctx context.Context) (*big.Int, *big.Int, error) {
+    opts := &bind.CallOpts{Context: ctx}
+    taskManagerPaused, err := g.taskManager.Paused0(opts)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to get task manager pause status: %v", err)
+    }
+    serviceManagerPaused, err := g.serviceManager.Paused0(opts)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to get service manager pause status: %v", err)
+    }
+    return taskManagerPaused, serviceManagerPaused, nil
+}
+
+func (g *ContractGuard) fetchBlacklistEvents(opts *bind.FilterOpts) ([]blacklistEvent, error) {
+    var events []blacklistEvent
+
+    blacklisted, err := g.serviceManager.FilterKeeperBlacklisted(opts, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to filter KeeperBlacklisted events: %v", err)
+    }
+    for blacklisted.Next() {
+        events = append(events, blacklistEvent{operator: blacklisted.Event.Operator, blacklisted: true, raw: blacklisted.Event.Raw})
+    }
+    if err := blacklisted.Error(); err != nil {
+        return nil, fmt.Errorf("failed to read KeeperBlacklisted events: %v", err)
+    }
+    blacklisted.Close()
+
+    unblacklisted, err := g.serviceManager.FilterKeeperUnblacklisted(opts, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to filter KeeperUnblacklisted events: %v", err)
+    }
+    for unblacklisted.Next() {
+        events = append(events, blacklistEvent{operator: unblacklisted.Event.Operator, blacklisted: false, raw: unblacklisted.Event.Raw})
+    }
+    if err := unblacklisted.Error(); err != nil {
+        return nil, fmt.Errorf("failed to read KeeperUnblacklisted events: %v", err)
+    }
+    unblacklisted.Close()
+
+    sort.Slice(events, func(a, b int) bool {
+        return logBefore(events[a].raw, events[b].raw)
+    })
+    return events, nil
+}
+
+func (g *ContractGuard) fetchTaskManagerPauseEvents(opts *bind.FilterOpts) ([]pauseEvent, error) {
+    var events []pauseEvent
+
+    paused, err := g.taskManager.FilterPaused(opts, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to filter task manager Paused events: %v", err)
+    }
+    for paused.Next() {
+        events = append(events, pauseEvent{status: paused.Event.NewPausedStatus, raw: paused.Event.Raw})
+    }
+    if err := paused.Error(); err != nil {
+        return nil, fmt.Errorf("failed to read task manager Paused events: %v", err)
+    }
+    paused.Close()
+
+    unpaused, err := g.taskManager.FilterUnpaused(opts, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to filter task manager Unpaused events: %v", err)
+    }
+    for unpaused.Next() {
+        events = append(events, pauseEvent{status: unpaused.Event.NewPausedStatus, raw: unpaused.Event.Raw})
+    }
+    if err := unpaused.Error(); err != nil {
+        return nil, fmt.Errorf("failed to read task manager Unpaused events: %v", err)
+    }
+    unpaused.Close()
+
+    sort.Slice(events, func(a, b int) bool {
+        return logBefore(events[a].raw, events[b].raw)
+    })
+    return events, nil
+}
+
+func (g *ContractGuard) fetchServiceManagerPauseEvents(opts *bind.FilterOpts) ([]pauseEvent, error) {
+    var events []pauseEvent
+
+    paused, err := g.serviceManager.FilterPaused(opts, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to filter service manager Paused events: %v", err)
+    }
+    for paused.Next() {
+        events = append(events, pauseEvent{status: paused.Event.NewPausedStatus, raw: paused.Event.Raw})
+    }
+    if err := paused.Error(); err != nil {
+        return nil, fmt.Errorf("failed to read service manager Paused events: %v", err)
+    }
+    paused.Close()
+
+    unpaused, err := g.serviceManager.FilterUnpaused(opts, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to filter service manager Unpaused events: %v", err)
+    }
+    for unpaused.Next() {
+        events = append(events, pauseEvent{status: unpaused.Event.NewPausedStatus, raw: unpaused.Event.Raw})
+    }
+    if err := unpaused.Error(); err != nil {
+        return nil, fmt.Errorf("failed to read service manager Unpaused events: %v", err)
+    }
+    unpaused.Close()
+
+    sort.Slice(events, func(a, b int) bool {
+        return logBefore(events[a].raw, events[b].raw)
+    })
+    return events, nil
+}
+
+func logBefore(a, b types.Log) bool {
+    if a.BlockNumber != b.BlockNumber {
+        return a.BlockNumber < b.BlockNumber
+    }
+    return a.Index < b.Index
+}
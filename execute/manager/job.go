@@ -2,6 +2,7 @@
 package manager
 
 import (
+    "errors"
     "log"
     "math/rand"
     "time"
@@ -30,6 +31,9 @@ type Job struct {
     Error            string
     Payload       map[string]interface{}
     CodeURL       string
+    TaskID        int64 // task_data key of the on-chain task covering the current execution
+    TaskExecutions int  // executions run under TaskID so far
+    TaskKeeper    string // keeper TaskID is assigned to
 }
 
 // Quorum represents a group of nodes that can execute jobs
@@ -48,24 +52,9 @@ func init() {
     rand.Seed(time.Now().UnixNano())
 }
 
-// initializeQuorums sets up initial quorums for the scheduler
-func (js *JobScheduler) initializeQuorums() {
-    defaultQuorum := &Quorum{
-        QuorumID:    "default",
-        NodeCount:   3,
-        ActiveNodes: []string{"node1", "node2", "node3"},
-        Status:      "active",
-        ChainID:     "chain_1",
-        CreatedAt:   time.Now(),
-        UpdatedAt:   time.Now(),
-    }
-
-    js.mu.Lock()
-    js.quorums["default"] = defaultQuorum
-    js.mu.Unlock()
-}
-
-func (js *JobScheduler) selectRandomKeeper() (string, error) {
+// selectKeeper picks a random keeper from the best tier of the active,
+// non-blacklisted keepers of every quorum
+func (js *JobScheduler) selectKeeper() (string, error) {
     // Acquire a read lock to safely access quorums
     js.mu.RLock()
     defer js.mu.RUnlock()
@@ -75,23 +64,35 @@ func (js *JobScheduler) selectRandomKeeper() (string, error) {
         return "", fmt.Errorf("no quorums available")
     }
 
-    // Iterate through available quorums
+    // Collect the active nodes of every quorum
+    var candidates []string
     for _, quorum := range js.quorums {
-        // Ensure the quorum has active nodes
-        if len(quorum.ActiveNodes) > 0 {
-            // Randomly select a keeper from the active nodes in this quorum
-            //randomIndex := rand.Intn(len(quorum.ActiveNodes))
-            return quorum.ActiveNodes[0], nil
+        for _, keeper := range quorum.ActiveNodes {
+            // Blacklisted keepers are never sent jobs
+            if js.guard != nil && js.guard.IsBlacklisted(keeper) {
+                continue
+            }
+            candidates = append(candidates, keeper)
         }
     }
 
     // If no active nodes are found in any quorum
-    return "", fmt.Errorf("no active keepers found")
+    if len(candidates) == 0 {
+        return "", fmt.Errorf("no active keepers found")
+    }
+
+    // Spread jobs over the best tier of keepers rather than always
+    // picking the fastest
+    if js.peerManager != nil {
+        candidates = js.peerManager.TopTier(candidates)
+    }
+    return candidates[rand.Intn(len(candidates))], nil
 }
+
 // processJob handles the execution of a job
 func (js *JobScheduler) processJob(workerID int, job *Job) {
     js.mu.Lock()
-    if job.Status == "completed" || job.Status == "failed" {
+    if job.Status == "completed" || job.Status == "failed" || job.Status == "paused" {
         js.mu.Unlock()
         return
     }
@@ -106,31 +107,39 @@ func (js *JobScheduler) processJob(workerID int, job *Job) {
     log.Printf("[Worker %d] Starting to process Job %s (Target: %s, ChainID: %s)", 
         workerID, job.JobID, job.TargetFunction, job.ChainID)
 
-        selectedKeeper, err := js.selectRandomKeeper()
-        if err != nil {
-            log.Printf("Failed to select keeper for job %s: %v", job.JobID, err)
-            // Handle failure - maybe retry or mark job as failed
+        if !js.ensureFunded(job) {
+            log.Printf("[Worker %d] Job %s paused: balance cannot cover the next execution", workerID, job.JobID)
             return
         }
 
-        err = js.transmitJobToKeeper(selectedKeeper, job)
-    if err != nil {
-        log.Printf("Job transmission failed: %v", err)
-    }
+        if js.guard != nil && js.guard.Paused() {
+            log.Printf("[Worker %d] Job %s held: TriggerX contracts are paused", workerID, job.JobID)
+            js.mu.Lock()
+            job.Status = "pending"
+            js.mu.Unlock()
+            return
+        }
 
-    // Simulate job execution with random success/failure
-    // executionTime := time.Duration(2+rand.Intn(3)) * time.Second
-    // log.Printf("[Worker %d] ⏳ Job %s will take approximately %v to complete", 
-    //     workerID, job.JobID, executionTime)
-    // time.Sleep(executionTime)
+        selectedKeeper, err := js.dispatch(job)
+    if errors.Is(err, ErrJobRejected) {
+        log.Printf("Job %s rejected by keeper %s: %v", job.JobID, selectedKeeper, err)
+    } else if err != nil {
+        log.Printf("Job %s dispatch failed: %v", job.JobID, err)
+    } else {
+        js.chargeOnVerdict(job, selectedKeeper)
+        if err := js.broadcastJob(selectedKeeper, job); err != nil {
+            log.Printf("Failed to broadcast job %s: %v", job.JobID, err)
+        }
+    }
 
     js.mu.Lock()
     defer js.mu.Unlock()
 
-    // if rand.Float64() < 0.8 { // 80% success rate
-    //     job.Status = "completed"
-    //     log.Printf("[Worker %d] Successfully completed Job %s", workerID, job.JobID)
-    // } else {
+    if err == nil {
+        job.CurrentRetries = 0
+        job.Status = "pending"
+        return
+    }
         job.CurrentRetries++
         if job.CurrentRetries >= job.MaxRetries {
             job.Status = "failed"
@@ -142,8 +151,45 @@ func (js *JobScheduler) processJob(workerID int, job *Job) {
             log.Printf("[Worker %d] Job %s failed, scheduling retry (%d/%d)", 
                 workerID, job.JobID, job.CurrentRetries, job.MaxRetries)
         }
-    // }
-    
+}
+
+// dispatch hands the next execution of a job to a keeper. The keeper has to
+// accept the job before an on-chain task is created for it, and executes it
+// once it is assigned the task. Executions batched under one task go to the
+// task's keeper.
+func (js *JobScheduler) dispatch(job *Job) (string, error) {
+    js.mu.RLock()
+    keeper := ""
+    if js.taskCreator != nil && job.TaskID != 0 && job.TaskExecutions < js.taskCreator.config.BatchSize {
+        keeper = job.TaskKeeper
+    }
+    js.mu.RUnlock()
+
+    if keeper == "" {
+        selected, err := js.selectKeeper()
+        if err != nil {
+            return "", fmt.Errorf("failed to select keeper: %v", err)
+        }
+        keeper = selected
+    }
+
+    if err := js.transmitJobToKeeper(keeper, job); err != nil {
+        // The next execution starts a new task with another keeper
+        js.mu.Lock()
+        if job.TaskKeeper == keeper {
+            job.TaskID, job.TaskExecutions, job.TaskKeeper = 0, 0, ""
+        }
+        js.mu.Unlock()
+        return keeper, err
+    }
+
+    if err := js.assignTask(job, keeper); err != nil {
+        return keeper, fmt.Errorf("failed to create on-chain task: %v", err)
+    }
+    if err := js.sendTaskAssignment(keeper, job); err != nil {
+        return keeper, err
+    }
+    return keeper, nil
 }
 
 
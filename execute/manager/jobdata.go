@@ -0,0 +1,140 @@
+// github.com/trigg3rX/go-backend/execute/manager/jobdata.go
+package manager
+
+import (
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+
+    "github.com/trigg3rX/go-backend/pkg/database"
+    "github.com/trigg3rX/go-backend/pkg/models"
+)
+
+// defaultMaxRetries is how many failed dispatches in a row fail a job
+const defaultMaxRetries = 3
+
+// jobFromData converts a job_data row to a scheduler job
+func jobFromData(data models.JobData) *Job {
+    arguments := make(map[string]interface{}, len(data.Arguments))
+    for i, argument := range data.Arguments {
+        arguments[strconv.Itoa(i)] = argument
+    }
+    createdAt := data.TimeCheck
+    if createdAt.IsZero() {
+        createdAt = time.Now()
+    }
+
+    return &Job{
+        JobID:             strconv.FormatInt(data.JobID, 10),
+        ArgType:           strconv.Itoa(data.ArgType),
+        Arguments:         arguments,
+        ChainID:           strconv.Itoa(data.ChainID),
+        ContractAddress:   data.ContractAddress,
+        JobCostPrediction: float64(data.JobCostPrediction),
+        Status:            "pending",
+        TargetFunction:    data.TargetFunction,
+        TimeFrame:         data.TimeFrame,
+        TimeInterval:      int64(data.TimeInterval),
+        UserID:            strconv.FormatInt(data.UserID, 10),
+        CreatedAt:         createdAt,
+        MaxRetries:        defaultMaxRetries,
+        CodeURL:           data.ScriptIpfsUrl,
+    }
+}
+
+// LoadJobs schedules the active job_data jobs that are within their time
+// frame and not scheduled yet. Jobs paused or resumed in job_data since the
+// last load are paused or resumed in the scheduler.
+func (js *JobScheduler) LoadJobs(db *database.Connection) error {
+    iter := db.Session().Query(`
+        SELECT job_id, user_id, chain_id, time_frame, time_interval, contract_address,
+               target_function, arg_type, arguments, status, job_cost_prediction,
+               script_ipfs_url, time_check
+        FROM triggerx.job_data`).Iter()
+
+    var rows []models.JobData
+    var data models.JobData
+    for iter.Scan(&data.JobID, &data.UserID, &data.ChainID, &data.TimeFrame, &data.TimeInterval,
+        &data.ContractAddress, &data.TargetFunction, &data.ArgType, &data.Arguments, &data.Status,
+        &data.JobCostPrediction, &data.ScriptIpfsUrl, &data.TimeCheck) {
+        rows = append(rows, data)
+        data = models.JobData{}
+    }
+    if err := iter.Close(); err != nil {
+        return fmt.Errorf("failed to load jobs: %v", err)
+    }
+
+    added := 0
+    for _, row := range rows {
+        job := jobFromData(row)
+        if js.syncScheduled(job.JobID, row.Status) {
+            continue
+        }
+        if !row.Status || time.Since(job.CreatedAt) > time.Duration(job.TimeFrame)*time.Second {
+            continue
+        }
+        if job.TimeInterval <= 0 {
+            log.Printf("Not scheduling job %s: invalid time interval %d", job.JobID, job.TimeInterval)
+            continue
+        }
+
+        if err := js.AddJob(job); err != nil {
+            log.Printf("Failed to add job %s: %v", job.JobID, err)
+            continue
+        }
+        added++
+    }
+
+    if added > 0 {
+        log.Printf("Loaded %d jobs from job_data", added)
+    }
+    return nil
+}
+
+// syncScheduled applies the job_data status of a job the scheduler already
+// knows and reports whether it knows it
+func (js *JobScheduler) syncScheduled(jobID string, active bool) bool {
+    js.mu.Lock()
+    defer js.mu.Unlock()
+
+    job, scheduled := js.jobs[jobID]
+    if !scheduled {
+        js.waitingQueueMu.RLock()
+        defer js.waitingQueueMu.RUnlock()
+        for _, waiting := range js.waitingQueue {
+            if waiting.Job.JobID == jobID {
+                return true
+            }
+        }
+        return false
+    }
+
+    switch {
+    case active && job.Status == "paused":
+        job.Status = "pending"
+        job.Error = ""
+        log.Printf("Job %s resumed", jobID)
+    case !active && job.Status != "paused" && job.Status != "completed" && job.Status != "failed":
+        job.Status = "paused"
+        log.Printf("Job %s paused in job_data", jobID)
+    }
+    return true
+}
+
+// WatchJobs loads new jobs every interval until the scheduler stops
+func (js *JobScheduler) WatchJobs(db *database.Connection, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-js.ctx.Done():
+            return
+        case <-ticker.C:
+            if err := js.LoadJobs(db); err != nil {
+                log.Printf("Failed to reload jobs: %v", err)
+            }
+        }
+    }
+}
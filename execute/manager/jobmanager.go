@@ -2,29 +2,39 @@
 package manager
 
 import (
-    "encoding/json"
+    "errors"
     "fmt"
     "log"
-    "os"
-    "strings"
     "sync"
     "time"
 
     "context"
     "github.com/libp2p/go-libp2p"
-    "github.com/libp2p/go-libp2p/core/peer"
     "github.com/shirou/gopsutil/v3/cpu"
     "github.com/shirou/gopsutil/v3/mem"
     "github.com/robfig/cron/v3"
+    "github.com/trigg3rX/go-backend/pkg/database"
+    "github.com/trigg3rX/go-backend/pkg/ledger"
     "github.com/trigg3rX/go-backend/pkg/network"
-    "github.com/multiformats/go-multiaddr"
 
 )
 
 var (
     ErrInvalidTimeframe = fmt.Errorf("invalid timeframe specified")
+
+    // ErrKeeperUnreachable means a job never got an answer from its keeper
+    ErrKeeperUnreachable = errors.New("keeper unreachable")
+    // ErrJobRejected means the keeper answered and refused the job
+    ErrJobRejected = errors.New("keeper rejected job")
 )
 
+// jobTransmissionTimeout bounds sending a job and waiting for the keeper's
+// acknowledgement, retries included
+const jobTransmissionTimeout = 30 * time.Second
+
+// peerCheckInterval is how often keepers are pinged or redialed
+const peerCheckInterval = 15 * time.Second
+
 // SystemResources tracks system resource usage
 type SystemResources struct {
     CPUUsage    float64
@@ -54,20 +64,50 @@ type JobScheduler struct {
     metricsInterval   time.Duration
     waitingQueueMu    sync.RWMutex
     networkClient *network.Messaging 
+    rpc           *network.RPC
+    ledger        *ledger.Ledger
+    db            *database.Connection
+    taskCreator   *TaskCreator
+    discovery         *network.Discovery
+    peerManager       *network.PeerManager
+    guard             *ContractGuard
+    pubsub            *network.PubSub
+    keeperPublishers  *network.PublisherSet
+    joinedQuorums     map[string]bool
+    heartbeats        map[string]time.Time
 }
 
 // NewJobScheduler creates an enhanced scheduler with resource limits
-func NewJobScheduler(workersCount int) *JobScheduler {
-    ctx, cancel := context.WithCancel(context.Background())
-    cronInstance := cron.New(cron.WithSeconds())
-    
-    host, err := libp2p.New()
+func NewJobScheduler(workersCount int) (*JobScheduler, error) {
+    // The task manager keeps its peer ID across restarts
+    identity, err := network.IdentityFromEnv(network.ManagerPeerName)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load p2p identity: %v", err)
+    }
+    connManager, err := network.ConnManagerOption()
     if err != nil {
-        log.Fatalf("Failed to create libp2p host: %v", err)
+        return nil, fmt.Errorf("failed to create libp2p host: %v", err)
+    }
+    nat, err := network.NATConfigFromEnv()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read NAT configuration: %v", err)
+    }
+    compression, err := network.CompressionFromEnv()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read compression: %v", err)
+    }
+    options := append([]libp2p.Option{libp2p.Identity(identity.Key), connManager}, nat.Options()...)
+    host, err := libp2p.New(options...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create libp2p host: %v", err)
     }
 
-    networkClient := network.NewMessaging(host, "task_manager")
-    
+    network.ServeIdentity(host, identity)
+    networkClient := network.NewMessaging(host, network.ManagerPeerName)
+    networkClient.SetCompression(compression)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cronInstance := cron.New(cron.WithSeconds())
     scheduler := &JobScheduler{
         jobs:             make(map[string]*Job),
         quorums:          make(map[string]*Quorum),
@@ -83,22 +123,20 @@ func NewJobScheduler(workersCount int) *JobScheduler {
         workersCount:    workersCount,
         metricsInterval: 5 * time.Second,
         networkClient: networkClient,
+        rpc:           network.NewRPC(networkClient),
+        keeperPublishers: network.NewPublisherSet(),
+        joinedQuorums:    make(map[string]bool),
+        heartbeats:       make(map[string]time.Time),
     }
 
     
-        scheduler.initializeQuorums()
         scheduler.startWorkers()
         go scheduler.monitorResources()
         go scheduler.processWaitingQueue()
         
     
 
-    discovery := network.NewDiscovery(ctx, host, "task_manager")
-    if err := discovery.SavePeerInfo(); err != nil {
-        log.Printf("Failed to save task manager peer info: %v", err)
-    }
-
-    return scheduler
+    return scheduler, nil
 }
 
 func (js *JobScheduler) transmitJobToKeeper(keeperName string, job *Job) error {
@@ -107,90 +145,152 @@ func (js *JobScheduler) transmitJobToKeeper(keeperName string, job *Job) error {
         return fmt.Errorf("network client not initialized")
     }
 
-    // Load peer information
-    peerInfos, err := js.loadPeerInfo()
-    if err != nil {
-        return fmt.Errorf("failed to load peer info: %v", err)
+    js.mu.RLock()
+    peerManager := js.peerManager
+    js.mu.RUnlock()
+    if peerManager == nil {
+        return fmt.Errorf("peer discovery not started")
     }
 
-    // Find the specific keeper's peer information
-    peerInfo, exists := peerInfos[keeperName]
-    if !exists {
-        return fmt.Errorf("keeper %s not found in peer information", keeperName)
+    // Reuse the keeper's connection, dialing only when it was lost
+    peerID, err := peerManager.Connect(keeperName)
+    if err != nil {
+        return fmt.Errorf("%w: %s: %v", ErrKeeperUnreachable, keeperName, err)
     }
 
-    // Split multiple addresses and get the first one
-    addresses := strings.Split(peerInfo.Address, ",")
-    if len(addresses) == 0 {
-        return fmt.Errorf("no addresses found for keeper %s", keeperName)
+    // Jobs carry their ID, so retrying an unanswered transmission cannot
+    // assign a job twice
+    ctx, cancel := context.WithTimeout(js.ctx, jobTransmissionTimeout)
+    defer cancel()
+    var ack network.JobAck
+    err = js.rpc.Call(ctx, keeperName, peerID, network.JobTransmissionMessage, job, &ack,
+        network.CallOptions{Idempotent: true, Retries: 2})
+    if errors.Is(err, network.ErrUnreachable) {
+        peerManager.ReportFailure(keeperName, err)
+        return fmt.Errorf("%w: %s: %v", ErrKeeperUnreachable, keeperName, err)
     }
-
-    // Extract peer ID from the first address
-    parts := strings.Split(addresses[0], "/p2p/")
-    if len(parts) < 2 {
-        return fmt.Errorf("invalid peer address format for keeper %s", keeperName)
+    var rejection *network.ReplyError
+    if errors.As(err, &rejection) {
+        return fmt.Errorf("%w: %s: %v", ErrJobRejected, keeperName, err)
     }
-
-    // Convert peer address to peer ID
-    peerID, err := peer.Decode(parts[1])
     if err != nil {
-        return fmt.Errorf("invalid peer ID for keeper %s: %v", keeperName, err)
+        return fmt.Errorf("failed to send job to keeper %s: %v", keeperName, err)
+    }
+    if ack.JobID != job.JobID {
+        return fmt.Errorf("keeper %s acknowledged job %q instead of %s", keeperName, ack.JobID, job.JobID)
     }
 
-    // Additional connection attempt with timeout
-    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-    defer cancel()
+    log.Printf("Job %s accepted by keeper %s", job.JobID, keeperName)
+    return nil
+}
 
-    // Parse multiaddress
-    maddr, err := multiaddr.NewMultiaddr(addresses[0])
-    if err != nil {
-        return fmt.Errorf("failed to parse multiaddress: %v", err)
+// sendTaskAssignment tells a keeper that accepted a job the on-chain task to
+// execute it under
+func (js *JobScheduler) sendTaskAssignment(keeperName string, job *Job) error {
+    js.mu.RLock()
+    assignment := network.TaskAssignment{JobID: job.JobID, TaskID: job.TaskID}
+    peerManager := js.peerManager
+    js.mu.RUnlock()
+    if assignment.TaskID == 0 {
+        log.Printf("Job %s has no on-chain task, keeper %s will not execute it", job.JobID, keeperName)
+        return nil
     }
 
-    // Attempt to connect to the peer before sending message
-    if err := js.networkClient.GetHost().Connect(ctx, peer.AddrInfo{
-        ID:    peerID,
-        Addrs: []multiaddr.Multiaddr{maddr},
-    }); err != nil {
-        return fmt.Errorf("failed to connect to peer: %v", err)
+    peerID, err := peerManager.Connect(keeperName)
+    if err != nil {
+        return fmt.Errorf("%w: %s: %v", ErrKeeperUnreachable, keeperName, err)
     }
 
-    // Prepare network message
-    networkMessage := network.Message{
-        From:      "task_manager",
-        To:        keeperName,
-        Content:   job,
-        Type:      "JOB_TRANSMISSION",
-        Timestamp: time.Now().UTC().Format(time.RFC3339),
+    ctx, cancel := context.WithTimeout(js.ctx, jobTransmissionTimeout)
+    defer cancel()
+    var ack network.JobAck
+    err = js.rpc.Call(ctx, keeperName, peerID, network.TaskAssignmentMessage, assignment, &ack,
+        network.CallOptions{Idempotent: true, Retries: 2})
+    var rejection *network.ReplyError
+    if errors.As(err, &rejection) {
+        return fmt.Errorf("%w: %s: %v", ErrJobRejected, keeperName, err)
     }
-
-    // Send the message
-    err = js.networkClient.SendMessage(keeperName, peerID, networkMessage)
     if err != nil {
-        return fmt.Errorf("failed to send job to keeper %s: %v", keeperName, err)
+        return fmt.Errorf("failed to assign task %d to keeper %s: %v", assignment.TaskID, keeperName, err)
     }
 
-    log.Printf("Job %s transmitted to keeper %s", job.JobID, keeperName)
+    log.Printf("Task %d of job %s assigned to keeper %s", assignment.TaskID, job.JobID, keeperName)
     return nil
 }
 
+// SetMessageSigner signs the messages sent to keepers and the job
+// broadcasts, keepers only accept task_manager messages signed with the key
+// bound to it
+func (js *JobScheduler) SetMessageSigner(signer network.MessageSigner) {
+    js.networkClient.SetSigner(signer)
+
+    js.mu.RLock()
+    pubsub := js.pubsub
+    js.mu.RUnlock()
+    if pubsub != nil {
+        pubsub.SetSigner(signer)
+    }
+}
 
+// SetMessageVerifier drops keeper messages, heartbeats and results that the
+// verifier does not authenticate
+func (js *JobScheduler) SetMessageVerifier(verifier *network.Verifier) {
+    js.networkClient.SetVerifier(verifier)
 
-// Helper method to load peer information
-func (js *JobScheduler) loadPeerInfo() (map[string]network.PeerInfo, error) {
-    file, err := os.Open(network.PeerInfoFilePath)
+    js.mu.RLock()
+    pubsub := js.pubsub
+    js.mu.RUnlock()
+    if pubsub != nil {
+        pubsub.SetVerifier(verifier)
+    }
+}
+
+// StartDiscovery advertises the task manager and lets dispatch find keepers
+// through the backends configured for network.SourceFromEnv
+func (js *JobScheduler) StartDiscovery(db *database.Connection) error {
+    host := js.networkClient.GetHost()
+    source, err := network.SourceFromEnv(host, db)
     if err != nil {
-        return nil, fmt.Errorf("unable to open peer info file: %v", err)
+        return err
     }
-    defer file.Close()
 
-    var peerInfos map[string]network.PeerInfo
-    decoder := json.NewDecoder(file)
-    if err := decoder.Decode(&peerInfos); err != nil {
-        return nil, fmt.Errorf("unable to decode peer info: %v", err)
+    discovery := network.NewDiscovery(js.ctx, host, network.ManagerPeerName, source)
+    if err := discovery.Advertise(); err != nil {
+        return err
+    }
+    if err := discovery.AdvertiseOnChange(); err != nil {
+        return err
     }
 
-    return peerInfos, nil
+    // Keepers are pinged and redialed in the background, and their health
+    // ranks them for dispatch
+    peerManager := network.NewPeerManager(discovery)
+    go peerManager.Run(js.ctx, peerCheckInterval)
+
+    js.mu.Lock()
+    js.discovery = discovery
+    js.peerManager = peerManager
+    quorums := js.quorums
+    js.mu.Unlock()
+
+    for _, quorum := range quorums {
+        for _, keeper := range quorum.ActiveNodes {
+            peerManager.Track(keeper)
+        }
+    }
+    return nil
+}
+
+// KeeperHealth returns the liveness, latency and failures of every keeper
+// the scheduler keeps connected
+func (js *JobScheduler) KeeperHealth() map[string]network.PeerHealth {
+    js.mu.RLock()
+    peerManager := js.peerManager
+    js.mu.RUnlock()
+    if peerManager == nil {
+        return nil
+    }
+    return peerManager.Peers()
 }
 
 // monitorResources continuously monitors system resources
@@ -279,7 +379,8 @@ func (js *JobScheduler) scheduleJob(job *Job) error {
         currentJob := js.jobs[job.JobID]
         shouldQueue := currentJob.Status != "processing" && 
                       currentJob.Status != "completed" && 
-                      currentJob.Status != "failed"
+                      currentJob.Status != "failed" &&
+                      currentJob.Status != "paused"
         js.mu.RUnlock()
 
         if shouldQueue {
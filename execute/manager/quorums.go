@@ -0,0 +1,121 @@
+// github.com/trigg3rX/go-backend/execute/manager/quorums.go
+package manager
+
+import (
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// keeperInfo is the part of keeper_data dispatch needs
+type keeperInfo struct {
+    active bool
+}
+
+// LoadQuorums replaces the scheduler's quorums with the operator sets the
+// quorum snapshotter keeps in quorum_data. Keepers that are inactive in
+// keeper_data are left out.
+func (js *JobScheduler) LoadQuorums(db *database.Connection) error {
+    keepers, err := loadKeepers(db)
+    if err != nil {
+        return err
+    }
+
+    iter := db.Session().Query(`
+        SELECT quorum_id, keepers FROM triggerx.quorum_data`).Iter()
+
+    quorums := make(map[string]*Quorum)
+    var quorumID int64
+    var operators []string
+    now := time.Now()
+    for iter.Scan(&quorumID, &operators) {
+        quorum := &Quorum{
+            QuorumID:  strconv.FormatInt(quorumID, 10),
+            NodeCount: len(operators),
+            Status:    "active",
+            ChainID:   "17000",
+            CreatedAt: now,
+            UpdatedAt: now,
+        }
+        for _, operator := range operators {
+            keeper, known := keepers[strings.ToLower(operator)]
+            if known && !keeper.active {
+                continue
+            }
+            quorum.ActiveNodes = append(quorum.ActiveNodes, operator)
+        }
+        if len(quorum.ActiveNodes) == 0 {
+            quorum.Status = "inactive"
+        }
+        quorums[quorum.QuorumID] = quorum
+    }
+    if err := iter.Close(); err != nil {
+        return fmt.Errorf("failed to load quorums: %v", err)
+    }
+
+    js.mu.Lock()
+    js.quorums = quorums
+    guard := js.guard
+    peerManager := js.peerManager
+    js.mu.Unlock()
+
+    if peerManager != nil {
+        for _, quorum := range quorums {
+            for _, keeper := range quorum.ActiveNodes {
+                peerManager.Track(keeper)
+            }
+        }
+    }
+
+    js.joinQuorumTopics(quorums)
+
+    if guard != nil {
+        var keepers []string
+        for _, quorum := range quorums {
+            keepers = append(keepers, quorum.ActiveNodes...)
+        }
+        if err := guard.CheckKeepers(js.ctx, keepers); err != nil {
+            log.Printf("Failed to check keeper blacklist: %v", err)
+        }
+    }
+
+    log.Printf("Loaded %d quorums", len(quorums))
+    return nil
+}
+
+// WatchQuorums reloads quorums every interval until the scheduler stops
+func (js *JobScheduler) WatchQuorums(db *database.Connection, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-js.ctx.Done():
+            return
+        case <-ticker.C:
+            if err := js.LoadQuorums(db); err != nil {
+                log.Printf("Failed to reload quorums: %v", err)
+            }
+        }
+    }
+}
+
+func loadKeepers(db *database.Connection) (map[string]keeperInfo, error) {
+    iter := db.Session().Query(`
+        SELECT withdrawal_address, status FROM triggerx.keeper_data`).Iter()
+
+    keepers := make(map[string]keeperInfo)
+    var address string
+    var status bool
+    for iter.Scan(&address, &status) {
+        keepers[strings.ToLower(address)] = keeperInfo{active: status}
+    }
+    if err := iter.Close(); err != nil {
+        return nil, fmt.Errorf("failed to load keepers: %v", err)
+    }
+    return keepers, nil
+}
@@ -0,0 +1,228 @@
+// github.com/trigg3rX/go-backend/execute/manager/tasks.go
+package manager
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "fmt"
+    "log"
+    "math/big"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ethereum/go-ethereum/accounts/abi/bind"
+    "github.com/ethereum/go-ethereum/common"
+    "github.com/ethereum/go-ethereum/common/hexutil"
+    "github.com/ethereum/go-ethereum/ethclient"
+    "gopkg.in/inf.v0"
+
+    taskmanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXTaskManager"
+    "github.com/trigg3rX/go-backend/pkg/chain"
+    "github.com/trigg3rX/go-backend/pkg/database"
+)
+
+const createTaskTimeout = 2 * time.Minute
+
+// TaskConfig controls how executions map to on-chain tasks
+type TaskConfig struct {
+    QuorumNumbers   []byte
+    QuorumThreshold uint8
+    // BatchSize is the number of executions covered by one task, 1 creates
+    // a task for every execution
+    BatchSize int
+}
+
+// TaskCreator creates TriggerXTaskManager tasks for job executions and
+// records them in task_data
+type TaskCreator struct {
+    db          *database.Connection
+    client      *ethclient.Client
+    taskManager *taskmanager.ContractTriggerXTaskManager
+    auth        *bind.TransactOpts
+    config      TaskConfig
+}
+
+func NewTaskCreator(db *database.Connection, client *ethclient.Client, privateKey *ecdsa.PrivateKey, chainID int64, config TaskConfig) (*TaskCreator, error) {
+    taskManager, err := taskmanager.NewContractTriggerXTaskManager(common.HexToAddress(chain.TaskManagerAddress), client)
+    if err != nil {
+        return nil, fmt.Errorf("failed to bind task manager: %v", err)
+    }
+    auth, err := bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(chainID))
+    if err != nil {
+        return nil, fmt.Errorf("failed to create transactor: %v", err)
+    }
+    if config.BatchSize < 1 {
+        config.BatchSize = 1
+    }
+
+    return &TaskCreator{
+        db:          db,
+        client:      client,
+        taskManager: taskManager,
+        auth:        auth,
+        config:      config,
+    }, nil
+}
+
+// SetTaskCreator enables on-chain task creation for job executions
+func (js *JobScheduler) SetTaskCreator(tc *TaskCreator) {
+    js.mu.Lock()
+    defer js.mu.Unlock()
+    js.taskCreator = tc
+}
+
+// assignTask makes sure the job's next execution belongs to an on-chain
+// task assigned to keeper, creating a new one when the current task has
+// covered a full batch or belongs to another keeper
+func (js *JobScheduler) assignTask(job *Job, keeper string) error {
+    if js.taskCreator == nil {
+        return nil
+    }
+    // Only job_data jobs have an on-chain job ID
+    jobID, err := strconv.ParseInt(job.JobID, 10, 64)
+    if err != nil {
+        return nil
+    }
+
+    js.mu.RLock()
+    current := job.TaskID != 0 && job.TaskExecutions < js.taskCreator.config.BatchSize && job.TaskKeeper == keeper
+    js.mu.RUnlock()
+    if current {
+        js.mu.Lock()
+        job.TaskExecutions++
+        js.mu.Unlock()
+        return nil
+    }
+
+    ctx, cancel := context.WithTimeout(js.ctx, createTaskTimeout)
+    defer cancel()
+
+    taskID, err := js.taskCreator.CreateTask(ctx, jobID, keeper)
+    if err != nil {
+        return err
+    }
+
+    js.mu.Lock()
+    job.TaskID = taskID
+    job.TaskExecutions = 1
+    job.TaskKeeper = keeper
+    js.mu.Unlock()
+    return nil
+}
+
+// CreateTask sends createNewTask for a job, waits for it to be mined and
+// stores the resulting task as assigned to keeper. It returns the task_data
+// key of the new task.
+func (tc *TaskCreator) CreateTask(ctx context.Context, jobID int64, keeper string) (int64, error) {
+    if jobID <= 0 || jobID > int64(^uint32(0)) {
+        return 0, fmt.Errorf("job %d does not fit an on-chain job ID", jobID)
+    }
+    onChainJobID := uint32(jobID)
+
+    taskNum, err := tc.taskManager.JobToTaskCounter(&bind.CallOpts{Context: ctx}, onChainJobID)
+    if err != nil {
+        return 0, fmt.Errorf("failed to get task counter of job %d: %v", jobID, err)
+    }
+
+    opts := *tc.auth
+    opts.Context = ctx
+    tx, err := tc.taskManager.CreateNewTask(&opts, onChainJobID, tc.config.QuorumNumbers, tc.config.QuorumThreshold)
+    if err != nil {
+        return 0, fmt.Errorf("failed to send createNewTask for job %d: %v", jobID, err)
+    }
+
+    receipt, err := bind.WaitMined(ctx, tc.client, tx)
+    if err != nil {
+        return 0, fmt.Errorf("failed waiting for createNewTask %s: %v", tx.Hash().Hex(), err)
+    }
+    if receipt.Status != 1 {
+        return 0, fmt.Errorf("createNewTask %s reverted", tx.Hash().Hex())
+    }
+
+    var created *taskmanager.ContractTriggerXTaskManagerTaskCreated
+    for _, vLog := range receipt.Logs {
+        if event, err := tc.taskManager.ParseTaskCreated(*vLog); err == nil {
+            created = event
+            break
+        }
+    }
+    if created == nil {
+        return 0, fmt.Errorf("createNewTask %s emitted no TaskCreated event", tx.Hash().Hex())
+    }
+
+    // The counter read before sending may be stale if another task of the
+    // same job landed first, so confirm it against the emitted ID
+    expected, err := tc.taskManager.GenerateTaskId(&bind.CallOpts{Context: ctx}, onChainJobID, taskNum)
+    resolved := err == nil && expected == created.TaskId
+    if err != nil {
+        log.Printf("Failed to generate task ID of job %d, leaving the task number to the indexer: %v", jobID, err)
+    } else if !resolved {
+        log.Printf("Task %s of job %d does not match task number %d, leaving it to the indexer",
+            hexutil.Encode(created.TaskId[:]), jobID, taskNum)
+    }
+
+    // The task exists on-chain from here on, so a failed write must not
+    // make the caller create another one. The indexer stores it instead.
+    taskID := chain.TaskIDToInt64(created.TaskId)
+    if err := tc.record(taskID, jobID, keeper, taskNum, resolved, created); err != nil {
+        log.Printf("Task %s of job %d was created but not stored, leaving it to the indexer: %v",
+            hexutil.Encode(created.TaskId[:]), jobID, err)
+    }
+
+    log.Printf("Created task %s (task_id %d) for job %d in %s",
+        hexutil.Encode(created.TaskId[:]), taskID, jobID, tx.Hash().Hex())
+    return taskID, nil
+}
+
+// record stores a created task and the keeper it is assigned to. task_no is
+// only written when it was resolved, otherwise the indexer fills it in.
+func (tc *TaskCreator) record(taskID, jobID int64, keeper string, taskNum uint32, resolved bool, event *taskmanager.ContractTriggerXTaskManagerTaskCreated) error {
+    session := tc.db.Session()
+
+    quorumNumber := 0
+    if len(tc.config.QuorumNumbers) > 0 {
+        quorumNumber = int(tc.config.QuorumNumbers[0])
+    }
+
+    if err := session.Query(`
+        UPDATE triggerx.task_data
+        SET job_id = ?, quorum_number = ?, quorum_numbers = ?, quorum_threshold = ?,
+            task_created_block = ?, task_created_tx_hash = ?, task_hash = ?, assigned_keeper = ?
+        WHERE task_id = ?`,
+        jobID, quorumNumber, tc.config.QuorumNumbers, inf.NewDec(int64(tc.config.QuorumThreshold), 0),
+        int64(event.Raw.BlockNumber), event.Raw.TxHash.Hex(), hexutil.Encode(event.TaskHash[:]), keeper,
+        taskID).Exec(); err != nil {
+        return fmt.Errorf("failed to store task %d: %v", taskID, err)
+    }
+
+    if resolved {
+        if err := session.Query(`
+            UPDATE triggerx.task_data SET task_no = ? WHERE task_id = ?`,
+            int(taskNum), taskID).Exec(); err != nil {
+            return fmt.Errorf("failed to store task number of task %d: %v", taskID, err)
+        }
+    }
+
+    if err := session.Query(`
+        INSERT INTO triggerx.task_history (task_id, quorum_id)
+        VALUES (?, ?) IF NOT EXISTS`,
+        taskID, int64(quorumNumber)).Exec(); err != nil {
+        return fmt.Errorf("failed to create task history of task %d: %v", taskID, err)
+    }
+
+    return nil
+}
+
+// ParseQuorumNumbers parses a comma separated list such as "0,1"
+func ParseQuorumNumbers(value string) ([]byte, error) {
+    var quorums []byte
+    for _, part := range strings.Split(value, ",") {
+        n, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+        if err != nil {
+            return nil, fmt.Errorf("invalid quorum number %q", part)
+        }
+        quorums = append(quorums, byte(n))
+    }
+    return quorums, nil
+}
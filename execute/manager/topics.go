@@ -0,0 +1,157 @@
+// github.com/trigg3rX/go-backend/execute/manager/topics.go
+package manager
+
+import (
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/trigg3rX/go-backend/pkg/network"
+)
+
+const (
+    // HeartbeatTimeout is the age after which heartbeats are ignored
+    HeartbeatTimeout = 2 * time.Minute
+)
+
+// jobBroadcast announces a dispatched job and its keeper to the quorum
+type jobBroadcast struct {
+    Job      *Job   `json:"job"`
+    Keeper   string `json:"keeper"`
+    QuorumID string `json:"quorum_id"`
+}
+
+// StartTopics joins the pub/sub topics of a chain. Jobs are broadcast on the
+// job topic of each quorum, which only the task manager may publish on, and
+// keeper heartbeats and results are accepted from registered keepers only.
+func (js *JobScheduler) StartTopics(chainID string) error {
+    pubsub, err := network.NewPubSub(js.ctx, js.networkClient.GetHost(), network.ManagerPeerName)
+    if err != nil {
+        return err
+    }
+    pubsub.SetCompression(js.networkClient.Compression())
+    if signer := js.networkClient.Signer(); signer != nil {
+        pubsub.SetSigner(signer)
+    }
+    if verifier := js.networkClient.Verifier(); verifier != nil {
+        pubsub.SetVerifier(verifier)
+    }
+
+    heartbeats := network.HeartbeatTopic(chainID)
+    pubsub.SetAccessControl(heartbeats, js.keeperPublishers)
+    pubsub.RegisterValidator(heartbeats, network.MaxAge(HeartbeatTimeout))
+    subscription, err := pubsub.Subscribe(heartbeats)
+    if err != nil {
+        return err
+    }
+
+    js.mu.Lock()
+    js.pubsub = pubsub
+    quorums := js.quorums
+    js.mu.Unlock()
+
+    js.joinQuorumTopics(quorums)
+    go js.recordHeartbeats(subscription)
+    return nil
+}
+
+// KeeperHeartbeats returns when each keeper last sent a heartbeat
+func (js *JobScheduler) KeeperHeartbeats() map[string]time.Time {
+    js.mu.RLock()
+    defer js.mu.RUnlock()
+
+    heartbeats := make(map[string]time.Time, len(js.heartbeats))
+    for keeper, at := range js.heartbeats {
+        heartbeats[keeper] = at
+    }
+    return heartbeats
+}
+
+// joinQuorumTopics sets up the job and result topics of quorums joined for
+// the first time, and allows the quorums' keepers to publish
+func (js *JobScheduler) joinQuorumTopics(quorums map[string]*Quorum) {
+    js.mu.Lock()
+    pubsub := js.pubsub
+    var keepers []string
+    var joined []*Quorum
+    for id, quorum := range quorums {
+        keepers = append(keepers, quorum.ActiveNodes...)
+        if !js.joinedQuorums[id] {
+            js.joinedQuorums[id] = true
+            joined = append(joined, quorum)
+        }
+    }
+    js.mu.Unlock()
+
+    js.keeperPublishers.Set(keepers)
+    if pubsub == nil {
+        return
+    }
+
+    managerOnly := network.NewPublisherSet(network.ManagerPeerName)
+    for _, quorum := range joined {
+        pubsub.SetAccessControl(network.JobTopic(quorum.ChainID, quorum.QuorumID), managerOnly)
+
+        results := network.ResultTopic(quorum.ChainID, quorum.QuorumID)
+        pubsub.SetAccessControl(results, js.keeperPublishers)
+        subscription, err := pubsub.Subscribe(results)
+        if err != nil {
+            log.Printf("Failed to subscribe to %s: %v", results, err)
+            continue
+        }
+        go js.logResults(subscription)
+    }
+}
+
+// broadcastJob announces the keeper a job was sent to on the job topic of
+// the keeper's quorum
+func (js *JobScheduler) broadcastJob(keeper string, job *Job) error {
+    js.mu.RLock()
+    pubsub := js.pubsub
+    var quorum *Quorum
+    for _, q := range js.quorums {
+        for _, node := range q.ActiveNodes {
+            if node == keeper {
+                quorum = q
+            }
+        }
+    }
+    js.mu.RUnlock()
+
+    if pubsub == nil {
+        return nil
+    }
+    if quorum == nil {
+        return fmt.Errorf("keeper %s is in no quorum", keeper)
+    }
+
+    broadcast := jobBroadcast{
+        Job:      job,
+        Keeper:   keeper,
+        QuorumID: quorum.QuorumID,
+    }
+    return pubsub.Publish(network.JobTopic(quorum.ChainID, quorum.QuorumID), network.JobBroadcastMessage, broadcast)
+}
+
+func (js *JobScheduler) recordHeartbeats(subscription *network.Subscription) {
+    for {
+        msg, err := subscription.Next(js.ctx)
+        if err != nil {
+            return
+        }
+
+        js.mu.Lock()
+        js.heartbeats[msg.From] = time.Unix(msg.Timestamp, 0)
+        js.mu.Unlock()
+    }
+}
+
+func (js *JobScheduler) logResults(subscription *network.Subscription) {
+    for {
+        msg, err := subscription.Next(js.ctx)
+        if err != nil {
+            return
+        }
+        log.Printf("Keeper %s announced a result on %s", msg.From, msg.Topic)
+    }
+}
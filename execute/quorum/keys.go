@@ -0,0 +1,44 @@
+package quorum
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+
+	regcoord "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/RegistryCoordinator"
+	"github.com/trigg3rX/go-backend/pkg/bls"
+)
+
+// PubkeyRegistrationParams signs the registry coordinator's registration
+// message for operator, proving ownership of the BLS key
+func PubkeyRegistrationParams(ctx context.Context, contract *regcoord.ContractRegistryCoordinator, keys *bls.KeyPair, operator common.Address) (regcoord.IBLSApkRegistryPubkeyRegistrationParams, error) {
+	messageHash, err := contract.PubkeyRegistrationMessageHash(&bind.CallOpts{Context: ctx}, operator)
+	if err != nil {
+		return regcoord.IBLSApkRegistryPubkeyRegistrationParams{}, fmt.Errorf("failed to get pubkey registration message hash: %v", err)
+	}
+	return SignPubkeyRegistration(keys, messageHash), nil
+}
+
+// SignPubkeyRegistration builds the registration params from a message hash
+// that is already on G1
+func SignPubkeyRegistration(keys *bls.KeyPair, messageHash regcoord.BN254G1Point) regcoord.IBLSApkRegistryPubkeyRegistrationParams {
+	signature := keys.SignHashedToCurveMessage(bls.NewG1Point(messageHash.X, messageHash.Y))
+
+	sigX, sigY := signature.BigInts()
+	g1X, g1Y := keys.PubG1.BigInts()
+	g2X, g2Y := keys.PubG2.BigInts()
+
+	return regcoord.IBLSApkRegistryPubkeyRegistrationParams{
+		PubkeyRegistrationSignature: regcoord.BN254G1Point{X: sigX, Y: sigY},
+		PubkeyG1:                    regcoord.BN254G1Point{X: g1X, Y: g1Y},
+		PubkeyG2:                    regcoord.BN254G2Point{X: g2X, Y: g2Y},
+	}
+}
+
+// PubkeyRegistrationParams signs the registration message for operator with
+// the client's registry coordinator
+func (c *Client) PubkeyRegistrationParams(ctx context.Context, keys *bls.KeyPair, operator common.Address) (regcoord.IBLSApkRegistryPubkeyRegistrationParams, error) {
+	return PubkeyRegistrationParams(ctx, c.contract, keys, operator)
+}
@@ -0,0 +1,222 @@
+package quorum
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Plan actions
+const (
+	ActionJoin         = "join"
+	ActionCreateQuorum = "create_quorum"
+	ActionChurn        = "churn"
+	ActionNone         = "none"
+)
+
+const bipsDenominator = 10000
+
+// QuorumState is a quorum's operator set with the limits that apply to it
+type QuorumState struct {
+	Snapshot
+	MaxOperatorCount        uint32
+	KickBIPsOfOperatorStake uint16
+	KickBIPsOfTotalStake    uint16
+}
+
+// Capacity is the number of operators the planner fills a quorum to, the
+// lower of the contract limit and MAX_OPERATORS_PER_QUORUM
+func (q QuorumState) Capacity() int {
+	if q.MaxOperatorCount == 0 || q.MaxOperatorCount > MAX_OPERATORS_PER_QUORUM {
+		return MAX_OPERATORS_PER_QUORUM
+	}
+	return int(q.MaxOperatorCount)
+}
+
+// Kick is an operator that can be churned out of a quorum
+type Kick struct {
+	QuorumNumber uint8          `json:"quorum_number"`
+	Operator     common.Address `json:"operator"`
+	Stake        *big.Int       `json:"stake"`
+	Reason       string         `json:"reason"`
+}
+
+// Plan is where a new keeper should be placed
+type Plan struct {
+	Action       string         `json:"action"`
+	QuorumNumber uint8          `json:"quorum_number"`
+	Operator     common.Address `json:"operator"`
+	Kick         *Kick          `json:"kick,omitempty"`
+	Reason       string         `json:"reason"`
+	// Ejections lists operators the churn rules would already allow to be
+	// replaced, for review
+	Ejections []Kick `json:"ejections,omitempty"`
+}
+
+// ReadState returns every quorum's operator set and limits at block
+func (s *Snapshotter) ReadState(ctx context.Context, block uint32) ([]QuorumState, error) {
+	snapshots, err := s.Read(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(uint64(block))}
+	states := make([]QuorumState, len(snapshots))
+	for i, snapshot := range snapshots {
+		params, err := s.contract.GetOperatorSetParams(opts, snapshot.QuorumNumber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get params of quorum %d: %v", snapshot.QuorumNumber, err)
+		}
+		states[i] = QuorumState{
+			Snapshot:                snapshot,
+			MaxOperatorCount:        params.MaxOperatorCount,
+			KickBIPsOfOperatorStake: params.KickBIPsOfOperatorStake,
+			KickBIPsOfTotalStake:    params.KickBIPsOfTotalStake,
+		}
+	}
+	return states, nil
+}
+
+// LatestState reads quorum state at the latest confirmed block
+func (s *Snapshotter) LatestState(ctx context.Context) ([]QuorumState, error) {
+	head, err := s.client.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block number: %v", err)
+	}
+	if head < s.confirmations {
+		return nil, fmt.Errorf("chain head %d is below the confirmation depth", head)
+	}
+	return s.ReadState(ctx, uint32(head-s.confirmations))
+}
+
+// PlanPlacement decides which quorum a new operator should join. Quorums
+// with free slots are filled evenly, fewest operators first. When all are
+// full a new quorum is opened until TOTAL_QUORUMS exist, after which the
+// operator can only churn out an operator under the kick rules. stake is the
+// new operator's stake and may be nil when it is not known yet.
+func PlanPlacement(states []QuorumState, operator common.Address, stake *big.Int) Plan {
+	plan := Plan{Operator: operator, Ejections: SuggestEjections(states)}
+
+	for _, q := range states {
+		for _, existing := range q.Operators {
+			if existing == operator {
+				plan.Action = ActionNone
+				plan.QuorumNumber = q.QuorumNumber
+				plan.Reason = fmt.Sprintf("operator is already in quorum %d", q.QuorumNumber)
+				return plan
+			}
+		}
+	}
+
+	var open []QuorumState
+	for _, q := range states {
+		if len(q.Operators) < q.Capacity() {
+			open = append(open, q)
+		}
+	}
+	if len(open) > 0 {
+		sort.SliceStable(open, func(i, j int) bool {
+			return len(open[i].Operators) < len(open[j].Operators)
+		})
+		q := open[0]
+		plan.Action = ActionJoin
+		plan.QuorumNumber = q.QuorumNumber
+		plan.Reason = fmt.Sprintf("quorum %d has %d of %d operators", q.QuorumNumber, len(q.Operators), q.Capacity())
+		return plan
+	}
+
+	if len(states) < TOTAL_QUORUMS {
+		plan.Action = ActionCreateQuorum
+		plan.QuorumNumber = uint8(len(states))
+		plan.Reason = fmt.Sprintf("all %d quorums are full", len(states))
+		return plan
+	}
+
+	if stake != nil {
+		for _, q := range states {
+			if kick := churnCandidate(q, stake); kick != nil {
+				plan.Action = ActionChurn
+				plan.QuorumNumber = q.QuorumNumber
+				plan.Kick = kick
+				plan.Reason = fmt.Sprintf("all %d quorums are full, operator can replace %s in quorum %d",
+					TOTAL_QUORUMS, kick.Operator.Hex(), q.QuorumNumber)
+				return plan
+			}
+		}
+	}
+
+	plan.Action = ActionNone
+	plan.Reason = fmt.Sprintf("all %d quorums are full and no operator can be churned out", TOTAL_QUORUMS)
+	return plan
+}
+
+// churnCandidate returns the lowest stake operator of a quorum if an
+// operator with newStake may replace it. The registry coordinator allows a
+// kick when newStake > kicked * KickBIPsOfOperatorStake / 10000 and
+// kicked < total * KickBIPsOfTotalStake / 10000.
+func churnCandidate(q QuorumState, newStake *big.Int) *Kick {
+	lowest := lowestStake(q)
+	if lowest < 0 {
+		return nil
+	}
+	kicked := q.Stakes[lowest]
+
+	// newStake * 10000 > kicked * kickBIPsOfOperatorStake
+	required := new(big.Int).Mul(kicked, big.NewInt(int64(q.KickBIPsOfOperatorStake)))
+	if new(big.Int).Mul(newStake, big.NewInt(bipsDenominator)).Cmp(required) <= 0 {
+		return nil
+	}
+	if !belowTotalShare(q, kicked) {
+		return nil
+	}
+
+	return &Kick{
+		QuorumNumber: q.QuorumNumber,
+		Operator:     q.Operators[lowest],
+		Stake:        kicked,
+		Reason:       "lowest stake in a full quorum",
+	}
+}
+
+// SuggestEjections lists, for each full quorum, the lowest stake operator
+// when its stake is below the quorum's KickBIPsOfTotalStake share
+func SuggestEjections(states []QuorumState) []Kick {
+	var ejections []Kick
+	for _, q := range states {
+		if len(q.Operators) < q.Capacity() {
+			continue
+		}
+		lowest := lowestStake(q)
+		if lowest < 0 || !belowTotalShare(q, q.Stakes[lowest]) {
+			continue
+		}
+		ejections = append(ejections, Kick{
+			QuorumNumber: q.QuorumNumber,
+			Operator:     q.Operators[lowest],
+			Stake:        q.Stakes[lowest],
+			Reason: fmt.Sprintf("stake is below %d BIPs of the quorum total",
+				q.KickBIPsOfTotalStake),
+		})
+	}
+	return ejections
+}
+
+// belowTotalShare reports whether stake * 10000 < total * KickBIPsOfTotalStake
+func belowTotalShare(q QuorumState, stake *big.Int) bool {
+	share := new(big.Int).Mul(q.StakeTotal, big.NewInt(int64(q.KickBIPsOfTotalStake)))
+	return new(big.Int).Mul(stake, big.NewInt(bipsDenominator)).Cmp(share) < 0
+}
+
+func lowestStake(q QuorumState) int {
+	lowest := -1
+	for i, stake := range q.Stakes {
+		if lowest < 0 || stake.Cmp(q.Stakes[lowest]) < 0 {
+			lowest = i
+		}
+	}
+	return lowest
+}
@@ -1,180 +1,323 @@
 package quorum
 
 import (
+	"context"
+	"crypto/ecdsa"
 	"fmt"
+	"io"
 	"log"
-    "context"
 	"math/big"
-    
-    "github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/common"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
 
 	regcoord "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/RegistryCoordinator"
+	socketregistry "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/SocketRegistry"
+	"github.com/trigg3rX/go-backend/pkg/chain"
 )
 
 const (
 	MAX_OPERATORS_PER_QUORUM = 50
 	TOTAL_QUORUMS            = 5
-	CONTRACT_ADDRESS         = "0x13a05d12b8061f8F12beCa62a42b981531021439"
-	HOLESKY_RPC              = "https://ethereum-holesky-rpc.publicnode.com/"
 )
 
+// Operator statuses in the registry coordinator
+const (
+	OperatorNeverRegistered uint8 = iota
+	OperatorRegistered
+	OperatorDeregistered
+)
+
+// Client sends operator and quorum management transactions to the registry
+// coordinator. In dry run mode transactions are printed instead of sent.
+type Client struct {
+	client   *ethclient.Client
+	contract *regcoord.ContractRegistryCoordinator
+	bound    *bind.BoundContract
+	abi      *abi.ABI
+	address  common.Address
+	sockets  *socketregistry.ContractSocketRegistry
+	auth     *bind.TransactOpts
+	dryRun   bool
+	out      io.Writer
+}
+
+// NewClient connects to the registry coordinator on Holesky. privateKey may
+// be nil for read only use and dry runs.
+func NewClient(privateKey *ecdsa.PrivateKey, dryRun bool) (*Client, error) {
+	client, err := chain.Dial(chain.HoleskyChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	address := common.HexToAddress(chain.RegistryCoordinatorAddress)
+	contract, err := regcoord.NewContractRegistryCoordinator(address, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind registry coordinator: %v", err)
+	}
+	parsed, err := regcoord.ContractRegistryCoordinatorMetaData.GetAbi()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry coordinator ABI: %v", err)
+	}
+	sockets, err := socketregistry.NewContractSocketRegistry(common.HexToAddress(chain.SocketRegistryAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind socket registry: %v", err)
+	}
+
+	c := &Client{
+		client:   client,
+		contract: contract,
+		bound:    bind.NewBoundContract(address, *parsed, client, client, client),
+		abi:      parsed,
+		address:  address,
+		sockets:  sockets,
+		dryRun:   dryRun,
+		out:      os.Stdout,
+	}
+
+	if privateKey != nil {
+		c.auth, err = bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(chain.HoleskyChainID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create transactor: %v", err)
+		}
+	}
+	return c, nil
+}
 
-func Create() error {
-	log.Println("Creating quorum...")
-	client, err := ethclient.Dial(HOLESKY_RPC)
+// PrivateKeyFromHex parses a hex encoded ECDSA key, with or without 0x
+func PrivateKeyFromHex(key string) (*ecdsa.PrivateKey, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(key, "0x"))
 	if err != nil {
-		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+	return privateKey, nil
+}
+
+// Sender returns the address transactions are sent from
+func (c *Client) Sender() (common.Address, error) {
+	if c.auth == nil {
+		return common.Address{}, fmt.Errorf("no private key configured")
 	}
+	return c.auth.From, nil
+}
 
-	contract, err := regcoord.NewContractRegistryCoordinator(common.HexToAddress(CONTRACT_ADDRESS), client)
+// transact sends a registry coordinator call and waits for it to be mined.
+// In dry run mode it prints the calldata and returns nil.
+func (c *Client) transact(ctx context.Context, method string, args ...interface{}) (*types.Receipt, error) {
+	data, err := c.abi.Pack(method, args...)
 	if err != nil {
-		log.Fatalf("Failed to create contract: %v", err)
+		return nil, fmt.Errorf("failed to encode %s: %v", method, err)
 	}
 
-	count, err := contract.QuorumCount(nil)
+	if c.dryRun {
+		fmt.Fprintf(c.out, "method: %s\nto:     %s\ndata:   %s\n", method, c.address.Hex(), hexutil.Encode(data))
+		return nil, nil
+	}
+	if c.auth == nil {
+		return nil, fmt.Errorf("a private key is required to send %s", method)
+	}
+
+	opts := *c.auth
+	opts.Context = ctx
+	tx, err := c.bound.RawTransact(&opts, data)
 	if err != nil {
-		log.Fatalf("Failed to get quorum count: %v", err)
-	}
-	fmt.Printf("Current number of quorums: %v\n", count)
-
-	auth, err := createAuthTransactor()
-    if err != nil {
-        log.Fatalf("failed to create auth transactor: %v", err)
-    }
-
-    // Define operator set parameters
-    operatorSetParams := regcoord.IRegistryCoordinatorOperatorSetParam{
-        MaxOperatorCount:         uint32(MAX_OPERATORS_PER_QUORUM),
-        KickBIPsOfOperatorStake: uint16(100), // 1% in basis points (100 = 1%)
-        KickBIPsOfTotalStake:    uint16(200), // 2% in basis points (200 = 2%)
-    }
-
-    // Define minimum stake (example: 32 ETH in wei)
-    minimumStake := big.NewInt(0)
-    minimumStake.SetString("32000000000000000000", 10) // 32 ETH in wei
-
-	// Define strategy parameters
-    // This is an example - adjust according to your needs
-    strategyParams := []regcoord.IStakeRegistryStrategyParams{
-        {
-            Strategy:   common.HexToAddress("0x..."), // Add strategy contract address
-            Multiplier: big.NewInt(1000), // Multiplier in basis points (1000 = 10%)
-        },
-        // Add more strategies if needed
-    }
-
-    // Create the quorum
-    tx, err := contract.CreateQuorum(
-        auth,
-        operatorSetParams,
-        minimumStake,
-        strategyParams,
-    )
+		return nil, fmt.Errorf("failed to send %s: %v", method, err)
+	}
+	log.Printf("%s transaction submitted: %s", method, tx.Hash().Hex())
+
+	receipt, err := bind.WaitMined(ctx, c.client, tx)
 	if err != nil {
-        log.Fatalf("failed to create quorum: %v", err)
-    }
-
-    log.Printf("Quorum creation transaction submitted: %s", tx.Hash().Hex())
-
-    // Wait for transaction to be mined
-    receipt, err := bind.WaitMined(context.Background(), client, tx)
-    if err != nil {
-        log.Fatalf("failed to wait for quorum creation: %v", err)
-    }
-
-    if receipt.Status == 0 {
-        log.Fatalf("quorum creation transaction failed")
-    }
-
-    // Get updated quorum count
-    newCount, err := contract.QuorumCount(nil)
-    if err != nil {
-        log.Fatalf("failed to get updated quorum count: %v", err)
-    }
-    log.Printf("New number of quorums: %v\n", newCount)
-
-    return nil
-}
-
-// RegisterOperator registers an operator for specific quorums
-func RegisterOperator(quorumNumbers []byte, socket string, 
-    pubkeyParams regcoord.IBLSApkRegistryPubkeyRegistrationParams,
-    operatorSignature regcoord.ISignatureUtilsSignatureWithSaltAndExpiry) error {
-    client, err := ethclient.Dial(HOLESKY_RPC)
-    if err != nil {
-        return fmt.Errorf("failed to connect to the Ethereum client: %v", err)
-    }
-
-    contract, err := regcoord.NewContractRegistryCoordinator(common.HexToAddress(CONTRACT_ADDRESS), client)
-    if err != nil {
-        return fmt.Errorf("failed to create contract instance: %v", err)
-    }
-
-    // Create auth transaction options (you'll need to add your private key)
-    auth, err := createAuthTransactor()
-    if err != nil {
-        return fmt.Errorf("failed to create auth transactor: %v", err)
-    }
-
-    tx, err := contract.RegisterOperator(auth, quorumNumbers, socket, pubkeyParams, operatorSignature)
-    if err != nil {
-        return fmt.Errorf("failed to register operator: %v", err)
-    }
-
-    log.Printf("Operator registration transaction submitted: %s", tx.Hash().Hex())
-    return nil
-}
-
-func DeregisterOperator(quorumNumbers []byte) error {
-    client, err := ethclient.Dial(HOLESKY_RPC)
-    if err != nil {
-        return fmt.Errorf("failed to connect to the Ethereum client: %v", err)
-    }
-
-    contract, err := regcoord.NewContractRegistryCoordinator(common.HexToAddress(CONTRACT_ADDRESS), client)
-    if err != nil {
-        return fmt.Errorf("failed to create contract instance: %v", err)
-    }
-
-    auth, err := createAuthTransactor()
-    if err != nil {
-        return fmt.Errorf("failed to create auth transactor: %v", err)
-    }
-
-    tx, err := contract.DeregisterOperator(auth, quorumNumbers)
-    if err != nil {
-        return fmt.Errorf("failed to deregister operator: %v", err)
-    }
-
-    log.Printf("Operator deregistration transaction submitted: %s", tx.Hash().Hex())
-    return nil
-}
-
-func createAuthTransactor() (*bind.TransactOpts, error) {
-    privateKey, err := crypto.HexToECDSA("your-private-key-here")
-    if err != nil {
-        return nil, fmt.Errorf("failed to parse private key: %v", err)
-    }
-
-    // Create client first
-    client, err := ethclient.Dial(HOLESKY_RPC)
-    if err != nil {
-        return nil, fmt.Errorf("failed to connect to the Ethereum client: %v", err)
-    }
-
-    // Get chainID from client
-    chainID, err := client.ChainID(context.Background())
-    if err != nil {
-        return nil, fmt.Errorf("failed to get chain ID: %v", err)
-    }
-
-    auth, err := bind.NewKeyedTransactorWithChainID(privateKey, chainID)
-    if err != nil {
-        return nil, fmt.Errorf("failed to create transactor: %v", err)
-    }
-
-    return auth, nil
-}
\ No newline at end of file
+		return nil, fmt.Errorf("failed waiting for %s %s: %v", method, tx.Hash().Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return receipt, fmt.Errorf("%s transaction %s reverted", method, tx.Hash().Hex())
+	}
+	return receipt, nil
+}
+
+// StrategyParams weights one strategy's shares in a quorum's stake
+type StrategyParams struct {
+	Strategy   string `json:"strategy"`
+	Multiplier string `json:"multiplier"`
+}
+
+// QuorumParams are the settings of a new quorum
+type QuorumParams struct {
+	MaxOperatorCount        uint32           `json:"max_operator_count"`
+	KickBIPsOfOperatorStake uint16           `json:"kick_bips_of_operator_stake"`
+	KickBIPsOfTotalStake    uint16           `json:"kick_bips_of_total_stake"`
+	MinimumStake            string           `json:"minimum_stake"` // in wei
+	Strategies              []StrategyParams `json:"strategies"`
+}
+
+// CreateQuorum creates a quorum, only the registry coordinator owner can
+func (c *Client) CreateQuorum(ctx context.Context, params QuorumParams) error {
+	if params.MaxOperatorCount == 0 {
+		params.MaxOperatorCount = MAX_OPERATORS_PER_QUORUM
+	}
+	minimumStake, ok := new(big.Int).SetString(params.MinimumStake, 10)
+	if !ok {
+		return fmt.Errorf("invalid minimum stake %q", params.MinimumStake)
+	}
+	if len(params.Strategies) == 0 {
+		return fmt.Errorf("at least one strategy is required")
+	}
+
+	strategies := make([]regcoord.IStakeRegistryStrategyParams, len(params.Strategies))
+	for i, s := range params.Strategies {
+		if !common.IsHexAddress(s.Strategy) {
+			return fmt.Errorf("invalid strategy address %q", s.Strategy)
+		}
+		multiplier, ok := new(big.Int).SetString(s.Multiplier, 10)
+		if !ok || multiplier.Sign() <= 0 {
+			return fmt.Errorf("invalid multiplier %q for strategy %s", s.Multiplier, s.Strategy)
+		}
+		strategies[i] = regcoord.IStakeRegistryStrategyParams{
+			Strategy:   common.HexToAddress(s.Strategy),
+			Multiplier: multiplier,
+		}
+	}
+
+	operatorSetParams := regcoord.IRegistryCoordinatorOperatorSetParam{
+		MaxOperatorCount:        params.MaxOperatorCount,
+		KickBIPsOfOperatorStake: params.KickBIPsOfOperatorStake,
+		KickBIPsOfTotalStake:    params.KickBIPsOfTotalStake,
+	}
+
+	_, err := c.transact(ctx, "createQuorum", operatorSetParams, minimumStake, strategies)
+	return err
+}
+
+// RegisterOperator registers the sender for quorums with its BLS key
+func (c *Client) RegisterOperator(ctx context.Context, quorumNumbers []byte, socket string,
+	pubkeyParams regcoord.IBLSApkRegistryPubkeyRegistrationParams,
+	operatorSignature regcoord.ISignatureUtilsSignatureWithSaltAndExpiry) (*types.Receipt, error) {
+	if len(quorumNumbers) == 0 {
+		return nil, fmt.Errorf("no quorum numbers given")
+	}
+	return c.transact(ctx, "registerOperator", quorumNumbers, socket, pubkeyParams, operatorSignature)
+}
+
+// DeregisterOperator removes the sender from quorums
+func (c *Client) DeregisterOperator(ctx context.Context, quorumNumbers []byte) error {
+	if len(quorumNumbers) == 0 {
+		return fmt.Errorf("no quorum numbers given")
+	}
+	_, err := c.transact(ctx, "deregisterOperator", quorumNumbers)
+	return err
+}
+
+// UpdateSocket changes the socket of the sender
+func (c *Client) UpdateSocket(ctx context.Context, socket string) error {
+	_, err := c.transact(ctx, "updateSocket", socket)
+	return err
+}
+
+// QuorumInfo describes an existing quorum
+type QuorumInfo struct {
+	QuorumNumber            uint8  `json:"quorum_number"`
+	MaxOperatorCount        uint32 `json:"max_operator_count"`
+	KickBIPsOfOperatorStake uint16 `json:"kick_bips_of_operator_stake"`
+	KickBIPsOfTotalStake    uint16 `json:"kick_bips_of_total_stake"`
+	UpdateBlockNumber       uint64 `json:"update_block_number"`
+}
+
+// ListQuorums returns the parameters of every quorum
+func (c *Client) ListQuorums(ctx context.Context) ([]QuorumInfo, error) {
+	opts := &bind.CallOpts{Context: ctx}
+	count, err := c.contract.QuorumCount(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quorum count: %v", err)
+	}
+
+	quorums := make([]QuorumInfo, 0, count)
+	for q := uint8(0); q < count; q++ {
+		params, err := c.contract.GetOperatorSetParams(opts, q)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get params of quorum %d: %v", q, err)
+		}
+		updated, err := c.contract.QuorumUpdateBlockNumber(opts, q)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get update block of quorum %d: %v", q, err)
+		}
+		quorums = append(quorums, QuorumInfo{
+			QuorumNumber:            q,
+			MaxOperatorCount:        params.MaxOperatorCount,
+			KickBIPsOfOperatorStake: params.KickBIPsOfOperatorStake,
+			KickBIPsOfTotalStake:    params.KickBIPsOfTotalStake,
+			UpdateBlockNumber:       updated.Uint64(),
+		})
+	}
+	return quorums, nil
+}
+
+// OperatorInfo is an operator's registration in the registry coordinator
+type OperatorInfo struct {
+	Address    string  `json:"address"`
+	OperatorID string  `json:"operator_id"`
+	Status     string  `json:"status"`
+	Quorums    []uint8 `json:"quorums"`
+	Socket     string  `json:"socket"`
+}
+
+// ShowOperator returns the registration of an operator
+func (c *Client) ShowOperator(ctx context.Context, operator common.Address) (*OperatorInfo, error) {
+	opts := &bind.CallOpts{Context: ctx}
+	registration, err := c.contract.GetOperator(opts, operator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get operator %s: %v", operator.Hex(), err)
+	}
+
+	info := &OperatorInfo{
+		Address:    operator.Hex(),
+		OperatorID: hexutil.Encode(registration.OperatorId[:]),
+		Status:     operatorStatus(registration.Status),
+		Quorums:    []uint8{},
+	}
+	if registration.Status == OperatorNeverRegistered {
+		return info, nil
+	}
+
+	bitmap, err := c.contract.GetCurrentQuorumBitmap(opts, registration.OperatorId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quorums of %s: %v", operator.Hex(), err)
+	}
+	info.Quorums = BitmapToQuorums(bitmap)
+
+	info.Socket, err = c.sockets.GetOperatorSocket(opts, registration.OperatorId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get socket of %s: %v", operator.Hex(), err)
+	}
+	return info, nil
+}
+
+func operatorStatus(status uint8) string {
+	switch status {
+	case OperatorNeverRegistered:
+		return "never_registered"
+	case OperatorRegistered:
+		return "registered"
+	case OperatorDeregistered:
+		return "deregistered"
+	default:
+		return fmt.Sprintf("unknown(%d)", status)
+	}
+}
+
+// BitmapToQuorums lists the quorum numbers set in a quorum bitmap
+func BitmapToQuorums(bitmap *big.Int) []uint8 {
+	quorums := []uint8{}
+	for i := 0; i < bitmap.BitLen() && i < 256; i++ {
+		if bitmap.Bit(i) == 1 {
+			quorums = append(quorums, uint8(i))
+		}
+	}
+	return quorums
+}
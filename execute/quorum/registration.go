@@ -0,0 +1,362 @@
+package quorum
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gocql/gocql"
+
+	regcoord "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/RegistryCoordinator"
+	servicemanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXServiceManager"
+	"github.com/trigg3rX/go-backend/pkg/bls"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// Registration steps, each one is recorded once it completes
+const (
+	StepStarted                  = "started"
+	StepCoordinatorRegistered    = "coordinator_registered"
+	StepServiceManagerRegistered = "service_manager_registered"
+	StepStored                   = "stored"
+)
+
+const defaultSignatureExpiry = time.Hour
+
+// avsDirectoryABI covers the AVSDirectory call used to build operator
+// signatures, there is no binding for the EigenLayer core contracts
+const avsDirectoryABI = `[{"inputs":[{"name":"operator","type":"address"},{"name":"avs","type":"address"},{"name":"salt","type":"bytes32"},{"name":"expiry","type":"uint256"}],"name":"calculateOperatorAVSRegistrationDigestHash","outputs":[{"name":"","type":"bytes32"}],"stateMutability":"view","type":"function"}]`
+
+// RegistrationRequest describes the keeper to register
+type RegistrationRequest struct {
+	QuorumNumbers     []byte
+	Socket            string
+	BLSKeys           *bls.KeyPair
+	ConnectionAddress string
+	// SignatureExpiry is how long operator signatures stay valid, an hour
+	// when zero
+	SignatureExpiry time.Duration
+}
+
+// RegistrationState is the progress of a registration, stored in
+// keeper_registrations so an interrupted run can resume
+type RegistrationState struct {
+	Operator         common.Address `json:"operator"`
+	Step             string         `json:"step"`
+	QuorumNumbers    []byte         `json:"quorum_numbers"`
+	CoordinatorTx    string         `json:"coordinator_tx,omitempty"`
+	CoordinatorBlock uint64         `json:"coordinator_block,omitempty"`
+	ServiceManagerTx string         `json:"service_manager_tx,omitempty"`
+	KeeperID         int64          `json:"keeper_id,omitempty"`
+}
+
+// Registrar registers a keeper with the registry coordinator and the
+// TriggerX service manager, then records it in keeper_data
+type Registrar struct {
+	db             *database.Connection
+	coordinator    *Client
+	serviceManager *servicemanager.ContractTriggerXServiceManager
+	avsDirectory   *bind.BoundContract
+	operatorKey    *ecdsa.PrivateKey
+	serviceAuth    *bind.TransactOpts
+}
+
+// NewRegistrar creates a registrar for the operator whose key signs the
+// coordinator client's transactions. serviceManagerKey sends
+// registerKeeperToTriggerX and defaults to the operator key when nil.
+func NewRegistrar(ctx context.Context, db *database.Connection, coordinator *Client, operatorKey, serviceManagerKey *ecdsa.PrivateKey) (*Registrar, error) {
+	if coordinator.dryRun {
+		return nil, fmt.Errorf("registration cannot run as a dry run")
+	}
+	if serviceManagerKey == nil {
+		serviceManagerKey = operatorKey
+	}
+
+	serviceManager, err := servicemanager.NewContractTriggerXServiceManager(common.HexToAddress(chain.ServiceManagerAddress), coordinator.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind service manager: %v", err)
+	}
+	avsDirectoryAddress, err := serviceManager.AvsDirectory(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AVS directory: %v", err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(avsDirectoryABI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AVS directory ABI: %v", err)
+	}
+	serviceAuth, err := bind.NewKeyedTransactorWithChainID(serviceManagerKey, big.NewInt(chain.HoleskyChainID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transactor: %v", err)
+	}
+
+	return &Registrar{
+		db:             db,
+		coordinator:    coordinator,
+		serviceManager: serviceManager,
+		avsDirectory:   bind.NewBoundContract(avsDirectoryAddress, parsed, coordinator.client, nil, nil),
+		operatorKey:    operatorKey,
+		serviceAuth:    serviceAuth,
+	}, nil
+}
+
+// Register runs the registration from wherever a previous run stopped.
+// Completed steps are also detected on-chain, so a transaction that was
+// mined after the process died is not sent twice.
+func (r *Registrar) Register(ctx context.Context, req RegistrationRequest) (*RegistrationState, error) {
+	operator := crypto.PubkeyToAddress(r.operatorKey.PublicKey)
+	if req.SignatureExpiry == 0 {
+		req.SignatureExpiry = defaultSignatureExpiry
+	}
+
+	state, found, err := r.loadState(operator)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		state = &RegistrationState{Operator: operator, Step: StepStarted, QuorumNumbers: req.QuorumNumbers}
+		if err := r.saveState(state); err != nil {
+			return nil, err
+		}
+	} else if state.Step != StepStored {
+		log.Printf("Resuming registration of %s after step %s", operator.Hex(), state.Step)
+	}
+
+	if state.Step == StepStarted {
+		if err := r.registerWithCoordinator(ctx, state, req); err != nil {
+			return state, err
+		}
+	}
+	if state.Step == StepCoordinatorRegistered {
+		if err := r.registerWithServiceManager(ctx, state, req); err != nil {
+			return state, err
+		}
+	}
+	if state.Step == StepServiceManagerRegistered {
+		if err := r.storeKeeper(state, req); err != nil {
+			return state, err
+		}
+	}
+
+	return state, nil
+}
+
+func (r *Registrar) registerWithCoordinator(ctx context.Context, state *RegistrationState, req RegistrationRequest) error {
+	opts := &bind.CallOpts{Context: ctx}
+	registration, err := r.coordinator.contract.GetOperator(opts, state.Operator)
+	if err != nil {
+		return fmt.Errorf("failed to get operator status: %v", err)
+	}
+
+	if registration.Status == OperatorRegistered {
+		// The registration block is unknown, so KeeperAdded is searched for
+		// from genesis
+		log.Printf("%s is already registered with the registry coordinator", state.Operator.Hex())
+	} else {
+		if req.BLSKeys == nil {
+			return fmt.Errorf("a BLS key is required to register with the registry coordinator")
+		}
+		pubkeyParams, err := r.coordinator.PubkeyRegistrationParams(ctx, req.BLSKeys, state.Operator)
+		if err != nil {
+			return err
+		}
+		signature, err := r.OperatorSignature(ctx, req.SignatureExpiry)
+		if err != nil {
+			return err
+		}
+
+		receipt, err := r.coordinator.RegisterOperator(ctx, state.QuorumNumbers, req.Socket, pubkeyParams, signature)
+		if err != nil {
+			return err
+		}
+		state.CoordinatorTx = receipt.TxHash.Hex()
+		state.CoordinatorBlock = receipt.BlockNumber.Uint64()
+	}
+
+	state.Step = StepCoordinatorRegistered
+	return r.saveState(state)
+}
+
+func (r *Registrar) registerWithServiceManager(ctx context.Context, state *RegistrationState, req RegistrationRequest) error {
+	if txHash, found, err := r.keeperAdded(ctx, state); err != nil {
+		return err
+	} else if found {
+		log.Printf("%s is already registered with the service manager", state.Operator.Hex())
+		state.ServiceManagerTx = txHash
+	} else {
+		signature, err := r.OperatorSignature(ctx, req.SignatureExpiry)
+		if err != nil {
+			return err
+		}
+
+		opts := *r.serviceAuth
+		opts.Context = ctx
+		tx, err := r.serviceManager.RegisterKeeperToTriggerX(&opts, state.Operator,
+			servicemanager.ISignatureUtilsSignatureWithSaltAndExpiry(signature))
+		if err != nil {
+			return fmt.Errorf("failed to send registerKeeperToTriggerX: %v", err)
+		}
+		log.Printf("registerKeeperToTriggerX transaction submitted: %s", tx.Hash().Hex())
+
+		receipt, err := bind.WaitMined(ctx, r.coordinator.client, tx)
+		if err != nil {
+			return fmt.Errorf("failed waiting for registerKeeperToTriggerX %s: %v", tx.Hash().Hex(), err)
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			return fmt.Errorf("registerKeeperToTriggerX transaction %s reverted", tx.Hash().Hex())
+		}
+		state.ServiceManagerTx = tx.Hash().Hex()
+	}
+
+	state.Step = StepServiceManagerRegistered
+	return r.saveState(state)
+}
+
+// keeperAdded looks for a KeeperAdded event of the operator since the
+// registry coordinator step
+func (r *Registrar) keeperAdded(ctx context.Context, state *RegistrationState) (string, bool, error) {
+	iter, err := r.serviceManager.FilterKeeperAdded(&bind.FilterOpts{Start: state.CoordinatorBlock, Context: ctx}, []common.Address{state.Operator})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to filter KeeperAdded events: %v", err)
+	}
+	defer iter.Close()
+
+	if iter.Next() {
+		return iter.Event.Raw.TxHash.Hex(), true, nil
+	}
+	if err := iter.Error(); err != nil {
+		return "", false, fmt.Errorf("failed to read KeeperAdded events: %v", err)
+	}
+	return "", false, nil
+}
+
+// OperatorSignature signs the AVS directory registration digest for the
+// TriggerX service manager with a fresh salt
+func (r *Registrar) OperatorSignature(ctx context.Context, validFor time.Duration) (regcoord.ISignatureUtilsSignatureWithSaltAndExpiry, error) {
+	var sig regcoord.ISignatureUtilsSignatureWithSaltAndExpiry
+	if _, err := rand.Read(sig.Salt[:]); err != nil {
+		return sig, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	sig.Expiry = big.NewInt(time.Now().Add(validFor).Unix())
+
+	operator := crypto.PubkeyToAddress(r.operatorKey.PublicKey)
+	var out []interface{}
+	if err := r.avsDirectory.Call(&bind.CallOpts{Context: ctx}, &out, "calculateOperatorAVSRegistrationDigestHash",
+		operator, common.HexToAddress(chain.ServiceManagerAddress), sig.Salt, sig.Expiry); err != nil {
+		return sig, fmt.Errorf("failed to calculate registration digest: %v", err)
+	}
+	digest := *abi.ConvertType(out[0], new([32]byte)).(*[32]byte)
+
+	signature, err := crypto.Sign(digest[:], r.operatorKey)
+	if err != nil {
+		return sig, fmt.Errorf("failed to sign registration digest: %v", err)
+	}
+	// ecrecover on-chain expects v to be 27 or 28
+	signature[64] += 27
+	sig.Signature = signature
+	return sig, nil
+}
+
+// storeKeeper writes the keeper_data row, reusing the keeper's ID when the
+// operator is already known
+func (r *Registrar) storeKeeper(state *RegistrationState, req RegistrationRequest) error {
+	session := r.db.Session()
+
+	if state.KeeperID == 0 {
+		keeperID, err := r.keeperID(state.Operator)
+		if err != nil {
+			return err
+		}
+		state.KeeperID = keeperID
+	}
+
+	currentQuorum := 0
+	if len(state.QuorumNumbers) > 0 {
+		currentQuorum = int(state.QuorumNumbers[0])
+	}
+	var blsKeys []string
+	if req.BLSKeys != nil {
+		blsKeys = []string{hexutil.Encode(req.BLSKeys.PubG1.Bytes())}
+	}
+
+	if err := session.Query(`
+        UPDATE triggerx.keeper_data
+        SET withdrawal_address = ?, verified = ?, current_quorum_no = ?, registered_tx = ?,
+            status = ?, bls_signing_keys = ?, connection_address = ?
+        WHERE keeper_id = ?`,
+		state.Operator.Hex(), true, currentQuorum, state.CoordinatorTx,
+		true, blsKeys, req.ConnectionAddress, state.KeeperID).Exec(); err != nil {
+		return fmt.Errorf("failed to store keeper %s: %v", state.Operator.Hex(), err)
+	}
+
+	state.Step = StepStored
+	return r.saveState(state)
+}
+
+func (r *Registrar) keeperID(operator common.Address) (int64, error) {
+	session := r.db.Session()
+
+	var keeperID int64
+	for _, address := range []string{operator.Hex(), strings.ToLower(operator.Hex())} {
+		err := session.Query(`
+            SELECT keeper_id FROM triggerx.keeper_data WHERE withdrawal_address = ? ALLOW FILTERING`,
+			address).Scan(&keeperID)
+		if err == nil {
+			return keeperID, nil
+		}
+		if err != gocql.ErrNotFound {
+			return 0, fmt.Errorf("failed to look up keeper %s: %v", address, err)
+		}
+	}
+
+	var maxKeeperID int64
+	if err := session.Query(`
+        SELECT MAX(keeper_id) FROM triggerx.keeper_data`).Scan(&maxKeeperID); err != nil && err != gocql.ErrNotFound {
+		return 0, fmt.Errorf("failed to allocate keeper ID: %v", err)
+	}
+	return maxKeeperID + 1, nil
+}
+
+func (r *Registrar) loadState(operator common.Address) (*RegistrationState, bool, error) {
+	state := &RegistrationState{Operator: operator}
+	var coordinatorBlock int64
+	err := r.db.Session().Query(`
+        SELECT step, quorum_numbers, coordinator_tx, coordinator_block, service_manager_tx, keeper_id
+        FROM triggerx.keeper_registrations WHERE operator_address = ?`,
+		operator.Hex()).Scan(&state.Step, &state.QuorumNumbers, &state.CoordinatorTx,
+		&coordinatorBlock, &state.ServiceManagerTx, &state.KeeperID)
+	if err == gocql.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load registration of %s: %v", operator.Hex(), err)
+	}
+
+	state.CoordinatorBlock = uint64(coordinatorBlock)
+	return state, true, nil
+}
+
+func (r *Registrar) saveState(state *RegistrationState) error {
+	if err := r.db.Session().Query(`
+        INSERT INTO triggerx.keeper_registrations (
+            operator_address, step, quorum_numbers, coordinator_tx, coordinator_block,
+            service_manager_tx, keeper_id, updated_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		state.Operator.Hex(), state.Step, state.QuorumNumbers, state.CoordinatorTx,
+		int64(state.CoordinatorBlock), state.ServiceManagerTx, state.KeeperID,
+		time.Now().UTC()).Exec(); err != nil {
+		return fmt.Errorf("failed to save registration of %s: %v", state.Operator.Hex(), err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package quorum
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	stateretriever "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/OperatorStateRetriever"
+	regcoord "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/RegistryCoordinator"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// Snapshot is the operator set of a quorum at a block. Stakes are in wei.
+type Snapshot struct {
+	QuorumNumber uint8
+	BlockNumber  uint32
+	Operators    []common.Address
+	OperatorIDs  [][32]byte
+	Stakes       []*big.Int
+	StakeTotal   *big.Int
+}
+
+// Snapshotter records quorum operator sets and keeps quorum_data current
+type Snapshotter struct {
+	db                  *database.Connection
+	client              *ethclient.Client
+	registryCoordinator common.Address
+	contract            *regcoord.ContractRegistryCoordinator
+	stateRetriever      *stateretriever.ContractOperatorStateRetriever
+	confirmations       uint64
+}
+
+// NewSnapshotter binds the contracts snapshots are read from. db may be nil
+// when snapshots are only read, not stored.
+func NewSnapshotter(db *database.Connection, client *ethclient.Client) (*Snapshotter, error) {
+	registryCoordinator := common.HexToAddress(chain.RegistryCoordinatorAddress)
+	contract, err := regcoord.NewContractRegistryCoordinator(registryCoordinator, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind registry coordinator: %v", err)
+	}
+	stateRetriever, err := stateretriever.NewContractOperatorStateRetriever(common.HexToAddress(chain.OperatorStateRetrieverAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind operator state retriever: %v", err)
+	}
+	config, err := chain.GetConfig(chain.HoleskyChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshotter{
+		db:                  db,
+		client:              client,
+		registryCoordinator: registryCoordinator,
+		contract:            contract,
+		stateRetriever:      stateRetriever,
+		confirmations:       config.Confirmations,
+	}, nil
+}
+
+// Run takes a snapshot at the latest confirmed block every interval until
+// ctx is cancelled
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.snapshotLatest(ctx); err != nil {
+			log.Printf("Quorum snapshot failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Snapshotter) snapshotLatest(ctx context.Context) error {
+	head, err := s.client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block number: %v", err)
+	}
+	if head < s.confirmations {
+		return nil
+	}
+
+	_, err = s.SnapshotAt(ctx, uint32(head-s.confirmations))
+	return err
+}
+
+// SnapshotAt reads every quorum at block and stores the result
+func (s *Snapshotter) SnapshotAt(ctx context.Context, block uint32) ([]Snapshot, error) {
+	snapshots, err := s.Read(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		if err := s.save(snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Stored snapshots of %d quorums at block %d", len(snapshots), block)
+	return snapshots, nil
+}
+
+// Read returns the operator sets of quorums 0 to QuorumCount-1 at block
+func (s *Snapshotter) Read(ctx context.Context, block uint32) ([]Snapshot, error) {
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(uint64(block))}
+
+	count, err := s.contract.QuorumCount(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quorum count: %v", err)
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	quorumNumbers := make([]byte, count)
+	for i := range quorumNumbers {
+		quorumNumbers[i] = byte(i)
+	}
+
+	state, err := s.stateRetriever.GetOperatorState(opts, s.registryCoordinator, quorumNumbers, block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get operator state at block %d: %v", block, err)
+	}
+
+	snapshots := make([]Snapshot, len(state))
+	for i, operators := range state {
+		snapshot := Snapshot{
+			QuorumNumber: quorumNumbers[i],
+			BlockNumber:  block,
+			StakeTotal:   new(big.Int),
+		}
+		for _, operator := range operators {
+			snapshot.Operators = append(snapshot.Operators, operator.Operator)
+			snapshot.OperatorIDs = append(snapshot.OperatorIDs, operator.OperatorId)
+			snapshot.Stakes = append(snapshot.Stakes, operator.Stake)
+			snapshot.StakeTotal.Add(snapshot.StakeTotal, operator.Stake)
+		}
+		snapshots[i] = snapshot
+	}
+	return snapshots, nil
+}
+
+// save stores a snapshot and updates the quorum's quorum_data row, whose
+// quorum_id is the quorum number. quorum_stake_total is kept in Gwei.
+func (s *Snapshotter) save(snapshot Snapshot) error {
+	session := s.db.Session()
+
+	operators := make([]string, len(snapshot.Operators))
+	for i, operator := range snapshot.Operators {
+		operators[i] = operator.Hex()
+	}
+	operatorIDs := make([]string, len(snapshot.OperatorIDs))
+	for i, id := range snapshot.OperatorIDs {
+		operatorIDs[i] = hexutil.Encode(id[:])
+	}
+
+	if err := session.Query(`
+        INSERT INTO triggerx.quorum_snapshots (
+            quorum_no, block_number, operators, operator_ids, stakes, stake_total, taken_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		int(snapshot.QuorumNumber), int64(snapshot.BlockNumber), operators, operatorIDs,
+		snapshot.Stakes, snapshot.StakeTotal, time.Now().UTC()).Exec(); err != nil {
+		return fmt.Errorf("failed to store snapshot of quorum %d: %v", snapshot.QuorumNumber, err)
+	}
+
+	if err := session.Query(`
+        UPDATE triggerx.quorum_data
+        SET quorum_no = ?, keepers = ?, quorum_stake_total = ?
+        WHERE quorum_id = ?`,
+		int(snapshot.QuorumNumber), operators, chain.WeiToGwei(snapshot.StakeTotal).Int64(),
+		int64(snapshot.QuorumNumber)).Exec(); err != nil {
+		return fmt.Errorf("failed to update quorum %d: %v", snapshot.QuorumNumber, err)
+	}
+
+	return nil
+}
+
+// LatestSnapshot returns the most recent stored snapshot of a quorum
+func LatestSnapshot(db *database.Connection, quorumNumber uint8) (*Snapshot, bool, error) {
+	var blockNumber int64
+	var operators, operatorIDs []string
+	var stakes []*big.Int
+	var stakeTotal *big.Int
+
+	iter := db.Session().Query(`
+        SELECT block_number, operators, operator_ids, stakes, stake_total
+        FROM triggerx.quorum_snapshots WHERE quorum_no = ? LIMIT 1`,
+		int(quorumNumber)).Iter()
+	found := iter.Scan(&blockNumber, &operators, &operatorIDs, &stakes, &stakeTotal)
+	if err := iter.Close(); err != nil {
+		return nil, false, fmt.Errorf("failed to load snapshot of quorum %d: %v", quorumNumber, err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	snapshot := &Snapshot{
+		QuorumNumber: quorumNumber,
+		BlockNumber:  uint32(blockNumber),
+		Stakes:       stakes,
+		StakeTotal:   stakeTotal,
+	}
+	for _, operator := range operators {
+		snapshot.Operators = append(snapshot.Operators, common.HexToAddress(operator))
+	}
+	for _, id := range operatorIDs {
+		var operatorID [32]byte
+		copy(operatorID[:], common.FromHex(id))
+		snapshot.OperatorIDs = append(snapshot.OperatorIDs, operatorID)
+	}
+	return snapshot, true, nil
+}
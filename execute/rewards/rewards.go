@@ -0,0 +1,292 @@
+package rewards
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	servicemanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXServiceManager"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// Reward formulas
+const (
+	// FormulaProportional splits EpochReward by each keeper's share of the
+	// epoch's executions
+	FormulaProportional = "proportional"
+	// FormulaPerExecution pays RewardPerExecution for every execution
+	FormulaPerExecution = "per_execution"
+)
+
+// CalculationInterval is the RewardsCoordinator's calculation interval.
+// Submissions must start on and last a multiple of it.
+const CalculationInterval = 24 * time.Hour
+
+// StrategyConfig is a strategy rewards are paid against
+type StrategyConfig struct {
+	Strategy   string `json:"strategy"`
+	Multiplier string `json:"multiplier"`
+}
+
+// Config describes how keeper work is turned into rewards. Amounts are in
+// the smallest unit of Token.
+type Config struct {
+	Token              string           `json:"token"`
+	Strategies         []StrategyConfig `json:"strategies"`
+	Formula            string           `json:"formula"`
+	EpochReward        string           `json:"epoch_reward"`
+	RewardPerExecution string           `json:"reward_per_execution"`
+	// MinExecutions is the work a keeper needs in an epoch to be paid
+	MinExecutions int `json:"min_executions"`
+	// MaxRewardPerOperator caps a single keeper's reward, empty for no cap
+	MaxRewardPerOperator string `json:"max_reward_per_operator"`
+	Description          string `json:"description"`
+}
+
+// Epoch is a reward period, aligned to the calculation interval
+type Epoch struct {
+	Start    time.Time
+	Duration time.Duration
+}
+
+// End is the first instant after the epoch
+func (e Epoch) End() time.Time {
+	return e.Start.Add(e.Duration)
+}
+
+// NewEpoch validates an epoch against the RewardsCoordinator's rules
+func NewEpoch(start time.Time, duration time.Duration) (Epoch, error) {
+	start = start.UTC()
+	if start.Unix()%int64(CalculationInterval.Seconds()) != 0 {
+		return Epoch{}, fmt.Errorf("epoch start %s is not aligned to %s", start, CalculationInterval)
+	}
+	if duration <= 0 || duration%CalculationInterval != 0 {
+		return Epoch{}, fmt.Errorf("epoch duration %s is not a multiple of %s", duration, CalculationInterval)
+	}
+	epoch := Epoch{Start: start, Duration: duration}
+	if epoch.End().After(time.Now()) {
+		return Epoch{}, fmt.Errorf("epoch ending %s has not finished", epoch.End())
+	}
+	return epoch, nil
+}
+
+// KeeperWork is the work a keeper did in an epoch
+type KeeperWork struct {
+	Keeper     string `json:"keeper"`
+	Executions int    `json:"executions"`
+}
+
+// Tally counts, per keeper, the tasks validated in the epoch that the task
+// manager assigned to the keeper. Only valid verdicts on authenticated
+// reports by the assigned keeper count, which the validator only reaches
+// for executions the keeper sent itself, and the task's task_history entry
+// must be validated. Each task counts once.
+func Tally(db *database.Connection, epoch Epoch) ([]KeeperWork, error) {
+	session := db.Session()
+
+	// Valid verdicts on authenticated reports, by task and keeper
+	verdicts := make(map[int64]map[string]bool)
+
+	iter := session.Query(`
+        SELECT task_id, keeper, valid, authenticated FROM triggerx.task_validations
+        WHERE validated_at >= ? AND validated_at < ? ALLOW FILTERING`,
+		epoch.Start, epoch.End()).Iter()
+	var taskID int64
+	var keeper string
+	var valid, authenticated bool
+	for iter.Scan(&taskID, &keeper, &valid, &authenticated) {
+		if !valid || !authenticated {
+			continue
+		}
+		if verdicts[taskID] == nil {
+			verdicts[taskID] = make(map[string]bool)
+		}
+		verdicts[taskID][strings.ToLower(keeper)] = true
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read task validations: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for taskID, keepers := range verdicts {
+		var status bool
+		if err := session.Query(`
+            SELECT validation_status FROM triggerx.task_history WHERE task_id = ?`,
+			taskID).Scan(&status); err != nil {
+			log.Printf("Skipping task %d without a readable task history: %v", taskID, err)
+			continue
+		}
+		if !status {
+			continue
+		}
+		var assigned string
+		if err := session.Query(`
+            SELECT assigned_keeper FROM triggerx.task_data WHERE task_id = ?`,
+			taskID).Scan(&assigned); err != nil {
+			log.Printf("Skipping task %d without a readable assignment: %v", taskID, err)
+			continue
+		}
+		keeper := strings.ToLower(assigned)
+		if keeper != "" && keepers[keeper] {
+			counts[keeper]++
+		}
+	}
+
+	work := make([]KeeperWork, 0, len(counts))
+	for keeper, executions := range counts {
+		work = append(work, KeeperWork{Keeper: keeper, Executions: executions})
+	}
+	sort.Slice(work, func(i, j int) bool {
+		return work[i].Keeper < work[j].Keeper
+	})
+	return work, nil
+}
+
+// OperatorReward is a keeper's reward for an epoch
+type OperatorReward struct {
+	Operator   common.Address `json:"operator"`
+	Executions int            `json:"executions"`
+	Amount     *big.Int       `json:"amount"`
+}
+
+// Compute applies the configured formula to the epoch's work. Keepers that
+// are not operator addresses, or fall below MinExecutions, get nothing.
+// Rewards are sorted by operator address as the RewardsCoordinator requires.
+func Compute(config Config, work []KeeperWork) ([]OperatorReward, error) {
+	var eligible []KeeperWork
+	totalExecutions := 0
+	for _, w := range work {
+		if !common.IsHexAddress(w.Keeper) {
+			log.Printf("Skipping keeper %q, it is not an operator address", w.Keeper)
+			continue
+		}
+		if w.Executions == 0 || w.Executions < config.MinExecutions {
+			continue
+		}
+		eligible = append(eligible, w)
+		totalExecutions += w.Executions
+	}
+
+	var capAmount *big.Int
+	if config.MaxRewardPerOperator != "" {
+		var err error
+		if capAmount, err = parseAmount("max_reward_per_operator", config.MaxRewardPerOperator); err != nil {
+			return nil, err
+		}
+	}
+
+	var rewards []OperatorReward
+	switch config.Formula {
+	case FormulaProportional:
+		epochReward, err := parseAmount("epoch_reward", config.EpochReward)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range eligible {
+			amount := new(big.Int).Mul(epochReward, big.NewInt(int64(w.Executions)))
+			amount.Div(amount, big.NewInt(int64(totalExecutions)))
+			rewards = append(rewards, OperatorReward{Operator: common.HexToAddress(w.Keeper), Executions: w.Executions, Amount: amount})
+		}
+
+	case FormulaPerExecution:
+		perExecution, err := parseAmount("reward_per_execution", config.RewardPerExecution)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range eligible {
+			amount := new(big.Int).Mul(perExecution, big.NewInt(int64(w.Executions)))
+			rewards = append(rewards, OperatorReward{Operator: common.HexToAddress(w.Keeper), Executions: w.Executions, Amount: amount})
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown reward formula %q", config.Formula)
+	}
+
+	// Zero amounts are rejected on-chain
+	paid := rewards[:0]
+	for _, reward := range rewards {
+		if capAmount != nil && reward.Amount.Cmp(capAmount) > 0 {
+			reward.Amount = new(big.Int).Set(capAmount)
+		}
+		if reward.Amount.Sign() > 0 {
+			paid = append(paid, reward)
+		}
+	}
+	sort.Slice(paid, func(i, j int) bool {
+		return bytes.Compare(paid[i].Operator.Bytes(), paid[j].Operator.Bytes()) < 0
+	})
+	return paid, nil
+}
+
+// BuildSubmission assembles the operator-directed rewards submission for an
+// epoch. Strategies are sorted by address as the RewardsCoordinator requires.
+func BuildSubmission(config Config, epoch Epoch, rewards []OperatorReward) (servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission, error) {
+	var submission servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission
+
+	if !common.IsHexAddress(config.Token) {
+		return submission, fmt.Errorf("invalid reward token %q", config.Token)
+	}
+	if len(config.Strategies) == 0 {
+		return submission, fmt.Errorf("at least one strategy is required")
+	}
+	if len(rewards) == 0 {
+		return submission, fmt.Errorf("no keeper earned rewards in the epoch")
+	}
+
+	for _, s := range config.Strategies {
+		if !common.IsHexAddress(s.Strategy) {
+			return submission, fmt.Errorf("invalid strategy address %q", s.Strategy)
+		}
+		multiplier, err := parseAmount("multiplier", s.Multiplier)
+		if err != nil {
+			return submission, err
+		}
+		submission.StrategiesAndMultipliers = append(submission.StrategiesAndMultipliers, servicemanager.IRewardsCoordinatorStrategyAndMultiplier{
+			Strategy:   common.HexToAddress(s.Strategy),
+			Multiplier: multiplier,
+		})
+	}
+	sort.Slice(submission.StrategiesAndMultipliers, func(i, j int) bool {
+		return bytes.Compare(submission.StrategiesAndMultipliers[i].Strategy.Bytes(),
+			submission.StrategiesAndMultipliers[j].Strategy.Bytes()) < 0
+	})
+
+	for _, reward := range rewards {
+		submission.OperatorRewards = append(submission.OperatorRewards, servicemanager.IRewardsCoordinatorOperatorReward{
+			Operator: reward.Operator,
+			Amount:   reward.Amount,
+		})
+	}
+
+	submission.Token = common.HexToAddress(config.Token)
+	submission.StartTimestamp = uint32(epoch.Start.Unix())
+	submission.Duration = uint32(epoch.Duration.Seconds())
+	submission.Description = config.Description
+	if submission.Description == "" {
+		submission.Description = fmt.Sprintf("TriggerX keeper rewards %s", epoch.Start.Format("2006-01-02"))
+	}
+	return submission, nil
+}
+
+// Total is the amount of token a submission pays out
+func Total(submission servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission) *big.Int {
+	total := new(big.Int)
+	for _, reward := range submission.OperatorRewards {
+		total.Add(total, reward.Amount)
+	}
+	return total
+}
+
+func parseAmount(name, value string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return amount, nil
+}
@@ -0,0 +1,100 @@
+package rewards
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const (
+	keeperA = "0x00000000000000000000000000000000000000aa"
+	keeperB = "0x00000000000000000000000000000000000000bb"
+	keeperC = "0x00000000000000000000000000000000000000cc"
+)
+
+func testWork() []KeeperWork {
+	// Unsorted, so the result order comes from Compute
+	return []KeeperWork{
+		{Keeper: keeperC, Executions: 1},
+		{Keeper: keeperA, Executions: 6},
+		{Keeper: "task_manager", Executions: 4},
+		{Keeper: keeperB, Executions: 3},
+	}
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   map[string]int64
+	}{
+		{
+			name:   "proportional",
+			config: Config{Formula: FormulaProportional, EpochReward: "1000"},
+			// 1000 split 6:3:1, the non-operator's work is left out
+			want: map[string]int64{keeperA: 600, keeperB: 300, keeperC: 100},
+		},
+		{
+			name:   "proportional rounds down",
+			config: Config{Formula: FormulaProportional, EpochReward: "100", MinExecutions: 3},
+			// 100 split 6:3
+			want: map[string]int64{keeperA: 66, keeperB: 33},
+		},
+		{
+			name:   "per execution",
+			config: Config{Formula: FormulaPerExecution, RewardPerExecution: "25"},
+			want:   map[string]int64{keeperA: 150, keeperB: 75, keeperC: 25},
+		},
+		{
+			name:   "capped",
+			config: Config{Formula: FormulaPerExecution, RewardPerExecution: "25", MaxRewardPerOperator: "100"},
+			want:   map[string]int64{keeperA: 100, keeperB: 75, keeperC: 25},
+		},
+		{
+			name:   "zero amounts are dropped",
+			config: Config{Formula: FormulaProportional, EpochReward: "5"},
+			// keeperC's share rounds down to zero
+			want: map[string]int64{keeperA: 3, keeperB: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewards, err := Compute(tt.config, testWork())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rewards) != len(tt.want) {
+				t.Fatalf("got %d rewards, want %d: %+v", len(rewards), len(tt.want), rewards)
+			}
+			for i, reward := range rewards {
+				if i > 0 && bytes.Compare(rewards[i-1].Operator.Bytes(), reward.Operator.Bytes()) >= 0 {
+					t.Fatalf("rewards are not sorted by operator")
+				}
+				want, ok := tt.want[strings.ToLower(reward.Operator.Hex())]
+				if !ok || reward.Amount.Cmp(big.NewInt(want)) != 0 {
+					t.Errorf("operator %s got %v", reward.Operator.Hex(), reward.Amount)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"unknown formula", Config{Formula: "flat"}},
+		{"invalid epoch reward", Config{Formula: FormulaProportional, EpochReward: "-1"}},
+		{"missing reward per execution", Config{Formula: FormulaPerExecution}},
+		{"invalid cap", Config{Formula: FormulaPerExecution, RewardPerExecution: "1", MaxRewardPerOperator: "lots"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compute(tt.config, testWork()); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package rewards
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gocql/gocql"
+
+	servicemanager "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXServiceManager"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// Submission statuses. A proposal is only sent once it has been approved.
+const (
+	StatusProposed  = "proposed"
+	StatusApproved  = "approved"
+	StatusSent      = "sent"
+	StatusSubmitted = "submitted"
+)
+
+// erc20ABI covers the token calls needed to fund a submission, the service
+// manager pulls the reward total from the rewards initiator
+const erc20ABI = `[{"inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"name":"allowance","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},{"inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"name":"approve","outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"}]`
+
+// Proposal is a rewards submission stored for review
+type Proposal struct {
+	EpochStart  time.Time                                                           `json:"epoch_start"`
+	Duration    time.Duration                                                       `json:"duration"`
+	Status      string                                                              `json:"status"`
+	Work        []OperatorReward                                                    `json:"rewards"`
+	Submission  servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission `json:"submission"`
+	Total       *big.Int                                                            `json:"total"`
+	Digest      string                                                              `json:"digest"`
+	ApprovedBy  string                                                              `json:"approved_by,omitempty"`
+	ApprovedAt  time.Time                                                           `json:"approved_at,omitempty"`
+	TxHash      string                                                              `json:"tx_hash,omitempty"`
+	SubmittedAt time.Time                                                           `json:"submitted_at,omitempty"`
+}
+
+// Digest is the keccak256 hash of the createOperatorDirectedAVSRewardsSubmission
+// calldata. Approving a digest approves exactly what will be sent.
+func Digest(submission servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission) (string, error) {
+	calldata, err := calldata(submission)
+	if err != nil {
+		return "", err
+	}
+	return crypto.Keccak256Hash(calldata).Hex(), nil
+}
+
+func calldata(submission servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission) ([]byte, error) {
+	parsed, err := servicemanager.ContractTriggerXServiceManagerMetaData.GetAbi()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service manager ABI: %v", err)
+	}
+	data, err := parsed.Pack("createOperatorDirectedAVSRewardsSubmission",
+		[]servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission{submission})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode rewards submission: %v", err)
+	}
+	return data, nil
+}
+
+// Propose tallies an epoch, computes its rewards and stores the submission
+// for review. An existing proposal is replaced unless it was approved.
+func Propose(db *database.Connection, config Config, epoch Epoch) (*Proposal, error) {
+	existing, found, err := LoadProposal(db, epoch)
+	if err != nil {
+		return nil, err
+	}
+	if found && existing.Status != StatusProposed {
+		return nil, fmt.Errorf("rewards for epoch %s are already %s", epoch.Start.Format(time.RFC3339), existing.Status)
+	}
+
+	work, err := Tally(db, epoch)
+	if err != nil {
+		return nil, err
+	}
+	rewards, err := Compute(config, work)
+	if err != nil {
+		return nil, err
+	}
+	submission, err := BuildSubmission(config, epoch, rewards)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := Digest(submission)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal := &Proposal{
+		EpochStart: epoch.Start,
+		Duration:   epoch.Duration,
+		Status:     StatusProposed,
+		Work:       rewards,
+		Submission: submission,
+		Total:      Total(submission),
+		Digest:     digest,
+	}
+	if err := saveProposal(db, proposal); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+// Approve marks a proposal approved. digest must be the digest the approver
+// reviewed, so a proposal that changed since cannot be approved by mistake.
+func Approve(db *database.Connection, epoch Epoch, digest, approvedBy string) (*Proposal, error) {
+	proposal, found, err := LoadProposal(db, epoch)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no rewards proposal for epoch %s", epoch.Start.Format(time.RFC3339))
+	}
+	if proposal.Status != StatusProposed {
+		return nil, fmt.Errorf("rewards proposal for epoch %s is %s", epoch.Start.Format(time.RFC3339), proposal.Status)
+	}
+	if !strings.EqualFold(proposal.Digest, digest) {
+		return nil, fmt.Errorf("digest %s does not match the proposal digest %s", digest, proposal.Digest)
+	}
+	if approvedBy == "" {
+		return nil, fmt.Errorf("the approver must be named")
+	}
+
+	proposal.Status = StatusApproved
+	proposal.ApprovedBy = approvedBy
+	proposal.ApprovedAt = time.Now().UTC()
+	if err := saveProposal(db, proposal); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+// Submitter sends approved proposals from the service manager's rewards
+// initiator
+type Submitter struct {
+	db             *database.Connection
+	client         *ethclient.Client
+	serviceManager *servicemanager.ContractTriggerXServiceManager
+	auth           *bind.TransactOpts
+}
+
+func NewSubmitter(db *database.Connection, client *ethclient.Client, privateKey *ecdsa.PrivateKey) (*Submitter, error) {
+	serviceManager, err := servicemanager.NewContractTriggerXServiceManager(common.HexToAddress(chain.ServiceManagerAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind service manager: %v", err)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(chain.HoleskyChainID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transactor: %v", err)
+	}
+
+	return &Submitter{
+		db:             db,
+		client:         client,
+		serviceManager: serviceManager,
+		auth:           auth,
+	}, nil
+}
+
+// Submit sends an approved proposal. A proposal whose transaction was sent
+// but not confirmed is checked for its receipt instead of being sent again.
+func (s *Submitter) Submit(ctx context.Context, epoch Epoch) (*Proposal, error) {
+	proposal, found, err := LoadProposal(s.db, epoch)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no rewards proposal for epoch %s", epoch.Start.Format(time.RFC3339))
+	}
+
+	switch proposal.Status {
+	case StatusSent:
+		return proposal, s.confirm(ctx, proposal)
+	case StatusApproved:
+	default:
+		return nil, fmt.Errorf("rewards proposal for epoch %s is %s, only approved proposals are submitted",
+			epoch.Start.Format(time.RFC3339), proposal.Status)
+	}
+
+	digest, err := Digest(proposal.Submission)
+	if err != nil {
+		return nil, err
+	}
+	if digest != proposal.Digest {
+		return nil, fmt.Errorf("stored submission no longer matches the approved digest %s", proposal.Digest)
+	}
+
+	initiator, err := s.serviceManager.RewardsInitiator(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rewards initiator: %v", err)
+	}
+	if initiator != s.auth.From {
+		return nil, fmt.Errorf("%s is not the rewards initiator %s", s.auth.From.Hex(), initiator.Hex())
+	}
+
+	if err := s.ensureAllowance(ctx, proposal.Submission.Token, proposal.Total); err != nil {
+		return nil, err
+	}
+
+	opts := *s.auth
+	opts.Context = ctx
+	tx, err := s.serviceManager.CreateOperatorDirectedAVSRewardsSubmission(&opts,
+		[]servicemanager.IRewardsCoordinatorOperatorDirectedRewardsSubmission{proposal.Submission})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send rewards submission: %v", err)
+	}
+	log.Printf("Rewards submission for epoch %s sent: %s", epoch.Start.Format(time.RFC3339), tx.Hash().Hex())
+
+	proposal.Status = StatusSent
+	proposal.TxHash = tx.Hash().Hex()
+	if err := saveProposal(s.db, proposal); err != nil {
+		return nil, err
+	}
+	return proposal, s.confirm(ctx, proposal)
+}
+
+func (s *Submitter) confirm(ctx context.Context, proposal *Proposal) error {
+	txHash := common.HexToHash(proposal.TxHash)
+	var receipt *types.Receipt
+	for {
+		var err error
+		receipt, err = s.client.TransactionReceipt(ctx, txHash)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			return fmt.Errorf("failed to get receipt of %s: %v", proposal.TxHash, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		// The submission can be sent again once the cause is fixed
+		proposal.Status = StatusApproved
+		proposal.TxHash = ""
+		if err := saveProposal(s.db, proposal); err != nil {
+			return err
+		}
+		return fmt.Errorf("rewards submission %s reverted", txHash.Hex())
+	}
+
+	proposal.Status = StatusSubmitted
+	proposal.SubmittedAt = time.Now().UTC()
+	return saveProposal(s.db, proposal)
+}
+
+// ensureAllowance lets the service manager pull amount of token from the
+// rewards initiator
+func (s *Submitter) ensureAllowance(ctx context.Context, token common.Address, amount *big.Int) error {
+	parsed, err := abi.JSON(strings.NewReader(erc20ABI))
+	if err != nil {
+		return fmt.Errorf("failed to parse ERC20 ABI: %v", err)
+	}
+	contract := bind.NewBoundContract(token, parsed, s.client, s.client, s.client)
+	serviceManager := common.HexToAddress(chain.ServiceManagerAddress)
+
+	var out []interface{}
+	if err := contract.Call(&bind.CallOpts{Context: ctx}, &out, "allowance", s.auth.From, serviceManager); err != nil {
+		return fmt.Errorf("failed to get token allowance: %v", err)
+	}
+	allowance := *abi.ConvertType(out[0], new(*big.Int)).(**big.Int)
+	if allowance.Cmp(amount) >= 0 {
+		return nil
+	}
+
+	opts := *s.auth
+	opts.Context = ctx
+	tx, err := contract.Transact(&opts, "approve", serviceManager, amount)
+	if err != nil {
+		return fmt.Errorf("failed to approve reward token: %v", err)
+	}
+	receipt, err := bind.WaitMined(ctx, s.client, tx)
+	if err != nil {
+		return fmt.Errorf("failed waiting for token approval %s: %v", tx.Hash().Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("token approval %s reverted", tx.Hash().Hex())
+	}
+	return nil
+}
+
+// LoadProposal returns the stored proposal of an epoch
+func LoadProposal(db *database.Connection, epoch Epoch) (*Proposal, bool, error) {
+	var data string
+	err := db.Session().Query(`
+        SELECT proposal FROM triggerx.reward_submissions
+        WHERE epoch_start = ? AND duration_seconds = ?`,
+		epoch.Start, int64(epoch.Duration.Seconds())).Scan(&data)
+	if err == gocql.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load rewards proposal: %v", err)
+	}
+
+	var proposal Proposal
+	if err := json.Unmarshal([]byte(data), &proposal); err != nil {
+		return nil, false, fmt.Errorf("invalid stored rewards proposal: %v", err)
+	}
+	return &proposal, true, nil
+}
+
+func saveProposal(db *database.Connection, proposal *Proposal) error {
+	data, err := json.Marshal(proposal)
+	if err != nil {
+		return fmt.Errorf("failed to encode rewards proposal: %v", err)
+	}
+
+	if err := db.Session().Query(`
+        INSERT INTO triggerx.reward_submissions (
+            epoch_start, duration_seconds, status, digest, total, proposal, tx_hash, updated_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		proposal.EpochStart, int64(proposal.Duration.Seconds()), proposal.Status, proposal.Digest,
+		proposal.Total, string(data), proposal.TxHash, time.Now().UTC()).Exec(); err != nil {
+		return fmt.Errorf("failed to save rewards proposal: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package stakesync
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gocql/gocql"
+
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// eventStore holds the indexed stake events and the users they belong to
+type eventStore interface {
+	// UserID finds the user of an address, creating it when create is set.
+	// It returns gocql.ErrNotFound for an unknown address otherwise.
+	UserID(user common.Address, create bool) (int64, error)
+	RecordEvent(event stakeEvent) error
+	RemoveEvent(event stakeEvent) error
+	// Events returns the recorded events mined in blocks from to to
+	Events(from, to uint64) ([]stakeEvent, error)
+	RecordDrift(user common.Address, userID int64, blockNumber uint64, ledgerAmount, chainAmount, difference *big.Int) error
+}
+
+// dbStore keeps stake events in stake_events and users in user_data
+type dbStore struct {
+	db *database.Connection
+}
+
+// UserID tries both the checksummed and lowercase forms of an address, as
+// addresses are stored as sent by the frontend
+func (s *dbStore) UserID(user common.Address, create bool) (int64, error) {
+	var userID int64
+	for _, address := range []string{user.Hex(), strings.ToLower(user.Hex())} {
+		err := s.db.Session().Query(`
+            SELECT user_id FROM triggerx.user_data WHERE user_address = ? ALLOW FILTERING`,
+			address).Scan(&userID)
+		if err == nil {
+			return userID, nil
+		}
+		if err != gocql.ErrNotFound {
+			return 0, fmt.Errorf("failed to look up user %s: %v", address, err)
+		}
+	}
+
+	if !create {
+		return 0, gocql.ErrNotFound
+	}
+
+	var maxUserID int64
+	if err := s.db.Session().Query(`
+        SELECT MAX(user_id) FROM triggerx.user_data`).Scan(&maxUserID); err != nil && err != gocql.ErrNotFound {
+		return 0, fmt.Errorf("failed to get max user ID: %v", err)
+	}
+	userID = maxUserID + 1
+
+	if err := s.db.Session().Query(`
+        INSERT INTO triggerx.user_data (user_id, user_address, job_ids, stake_amount)
+        VALUES (?, ?, ?, ?)`,
+		userID, user.Hex(), []int64{}, big.NewInt(0)).Exec(); err != nil {
+		return 0, fmt.Errorf("failed to create user %s: %v", user.Hex(), err)
+	}
+
+	log.Printf("Created user %d for staker %s", userID, user.Hex())
+	return userID, nil
+}
+
+func (s *dbStore) RecordEvent(event stakeEvent) error {
+	if err := s.db.Session().Query(`
+        INSERT INTO triggerx.stake_events (
+            tx_hash, log_index, block_number, block_hash, event_type, user_address, amount, reason
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.Raw.TxHash.Hex(), int(event.Raw.Index), int64(event.Raw.BlockNumber), event.Raw.BlockHash.Hex(),
+		event.Type, event.User.Hex(), event.Amount, event.Reason).Exec(); err != nil {
+		return fmt.Errorf("failed to record %s event %s: %v", event.Type, event.Raw.TxHash.Hex(), err)
+	}
+	return nil
+}
+
+func (s *dbStore) RemoveEvent(event stakeEvent) error {
+	if err := s.db.Session().Query(`
+        DELETE FROM triggerx.stake_events WHERE tx_hash = ? AND log_index = ?`,
+		event.Raw.TxHash.Hex(), int(event.Raw.Index)).Exec(); err != nil {
+		return fmt.Errorf("failed to remove orphaned %s event %s: %v", event.Type, event.Raw.TxHash.Hex(), err)
+	}
+	return nil
+}
+
+func (s *dbStore) Events(from, to uint64) ([]stakeEvent, error) {
+	iter := s.db.Session().Query(`
+        SELECT tx_hash, log_index, block_number, block_hash, event_type, user_address, amount
+        FROM triggerx.stake_events
+        WHERE block_number >= ? AND block_number <= ? ALLOW FILTERING`,
+		int64(from), int64(to)).Iter()
+
+	var events []stakeEvent
+	var txHash, blockHash, eventType, userAddress string
+	var logIndex int
+	var blockNumber int64
+	var amount *big.Int
+	for iter.Scan(&txHash, &logIndex, &blockNumber, &blockHash, &eventType, &userAddress, &amount) {
+		events = append(events, stakeEvent{
+			Type:   eventType,
+			User:   common.HexToAddress(userAddress),
+			Amount: amount,
+			Raw: types.Log{
+				TxHash:      common.HexToHash(txHash),
+				BlockNumber: uint64(blockNumber),
+				BlockHash:   common.HexToHash(blockHash),
+				Index:       uint(logIndex),
+			},
+		})
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read orphaned stake events: %v", err)
+	}
+	return events, nil
+}
+
+func (s *dbStore) RecordDrift(user common.Address, userID int64, blockNumber uint64, ledgerAmount, chainAmount, difference *big.Int) error {
+	if err := s.db.Session().Query(`
+        INSERT INTO triggerx.stake_drift (
+            user_address, detected_at, user_id, block_number, ledger_amount, chain_amount, difference
+        ) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		strings.ToLower(user.Hex()), gocql.TimeUUID(), userID, int64(blockNumber),
+		ledgerAmount, chainAmount, difference).Exec(); err != nil {
+		return fmt.Errorf("failed to record stake drift: %v", err)
+	}
+	return nil
+}
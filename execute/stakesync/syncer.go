@@ -0,0 +1,423 @@
+package stakesync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gocql/gocql"
+
+	stakeregistry "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/TriggerXStakeRegistry"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/ledger"
+)
+
+const (
+	CheckpointName = "stake_sync"
+
+	DefaultPollInterval = 15 * time.Second
+
+	EventStaked       = "staked"
+	EventUnstaked     = "unstaked"
+	EventStakeRemoved = "stake_removed"
+)
+
+type Config struct {
+	ChainID         int64
+	RegistryAddress string
+	StartBlock      uint64
+	BatchSize       uint64
+	PollInterval    time.Duration
+}
+
+func NewConfig() Config {
+	return Config{
+		ChainID:         chain.OpSepoliaChainID,
+		RegistryAddress: chain.TriggerXStakeRegistryAddress,
+		BatchSize:       chain.DefaultBatchSize,
+		PollInterval:    DefaultPollInterval,
+	}
+}
+
+// stakeEvent is a Staked, Unstaked or StakeRemoved log in a common shape
+type stakeEvent struct {
+	Type   string
+	User   common.Address
+	Amount *big.Int
+	Reason string
+	Raw    types.Log
+}
+
+// StakeReader reads a user's stake from the stake registry
+type StakeReader interface {
+	GetStake(opts *bind.CallOpts, user common.Address) (struct {
+		Amount *big.Int
+		Exists bool
+	}, error)
+}
+
+// Syncer indexes TriggerXStakeRegistry events into the stake ledger and
+// checks the resulting user balances against on-chain GetStake
+type Syncer struct {
+	db       *database.Connection
+	client   *ethclient.Client
+	registry *stakeregistry.ContractTriggerXStakeRegistry
+	stakes   StakeReader
+	store    eventStore
+	ledger   *ledger.Ledger
+	tracker  *chain.BlockTracker
+	config   Config
+}
+
+func NewSyncer(db *database.Connection, client *ethclient.Client, config Config) (*Syncer, error) {
+	registry, err := stakeregistry.NewContractTriggerXStakeRegistry(common.HexToAddress(config.RegistryAddress), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind stake registry: %v", err)
+	}
+	tracker, err := chain.NewBlockTrackerForChain(client, config.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	tracker.SetBatchSize(config.BatchSize)
+
+	return &Syncer{
+		db:       db,
+		client:   client,
+		registry: registry,
+		stakes:   &registry.ContractTriggerXStakeRegistryCaller,
+		store:    &dbStore{db: db},
+		ledger:   ledger.NewLedger(db),
+		tracker:  tracker,
+		config:   config,
+	}, nil
+}
+
+// Run syncs new blocks until the context is cancelled
+func (s *Syncer) Run(ctx context.Context) error {
+	if err := s.resume(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.tracker.Poll(ctx, s); err != nil {
+			log.Printf("Stake sync failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// resume positions the block tracker after the stored checkpoint
+func (s *Syncer) resume() error {
+	checkpoint, hashes, found, err := database.LoadCheckpoint(s.db.Session(), CheckpointName)
+	if err != nil {
+		return err
+	}
+	if found {
+		s.tracker.Resume(chain.CheckpointFromHex(checkpoint, hashes))
+	} else {
+		s.tracker.Start(s.config.StartBlock)
+	}
+	return nil
+}
+
+// HandleBlocks implements chain.BlockHandler
+func (s *Syncer) HandleBlocks(ctx context.Context, blocks *chain.Blocks) error {
+	return s.syncRange(ctx, blocks)
+}
+
+// Checkpoint implements chain.BlockHandler
+func (s *Syncer) Checkpoint(checkpoint chain.Checkpoint) error {
+	return database.SaveCheckpoint(s.db.Session(), CheckpointName, checkpoint.Block, checkpoint.Hex())
+}
+
+// Rollback implements chain.BlockHandler. Ledger entries are append only, so
+// events from orphaned blocks are undone with reversing postings and their
+// stake_events rows are removed to let the new branch record them again.
+func (s *Syncer) Rollback(ctx context.Context, from, to uint64) error {
+	events, err := s.store.Events(from, to)
+	if err != nil {
+		return err
+	}
+
+	touched := make(map[common.Address]struct{})
+	for _, event := range events {
+		if err := s.revertEvent(event); err != nil {
+			return err
+		}
+		touched[event.User] = struct{}{}
+	}
+
+	for user := range touched {
+		if err := s.Reconcile(ctx, user, from-1); err != nil {
+			log.Printf("Failed to reconcile stake for %s: %v", user.Hex(), err)
+		}
+	}
+
+	log.Printf("Stake sync rolled back %d events in blocks %d-%d", len(events), from, to)
+	return nil
+}
+
+func (s *Syncer) syncRange(ctx context.Context, blocks *chain.Blocks) error {
+	from, to := blocks.From, blocks.To
+	events, err := s.fetchEvents(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	// Postings are keyed by the block hash, so an event is only posted once
+	// its block is known to be canonical
+	for _, event := range events {
+		if err := blocks.Check(ctx, event.Raw); err != nil {
+			return err
+		}
+	}
+
+	touched := make(map[common.Address]struct{})
+	for _, event := range events {
+		if err := s.applyEvent(event); err != nil {
+			return err
+		}
+		touched[event.User] = struct{}{}
+	}
+
+	for user := range touched {
+		if err := s.Reconcile(ctx, user, to); err != nil {
+			log.Printf("Failed to reconcile stake for %s: %v", user.Hex(), err)
+		}
+	}
+
+	if len(events) > 0 {
+		log.Printf("Stake sync processed %d events in blocks %d-%d", len(events), from, to)
+	}
+	return nil
+}
+
+func (s *Syncer) fetchEvents(ctx context.Context, from, to uint64) ([]stakeEvent, error) {
+	opts := &bind.FilterOpts{Start: from, End: &to, Context: ctx}
+	var events []stakeEvent
+
+	staked, err := s.registry.FilterStaked(opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter Staked events: %v", err)
+	}
+	for staked.Next() {
+		events = append(events, stakeEvent{Type: EventStaked, User: staked.Event.User, Amount: staked.Event.Amount, Raw: staked.Event.Raw})
+	}
+	if err := staked.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read Staked events: %v", err)
+	}
+	staked.Close()
+
+	unstaked, err := s.registry.FilterUnstaked(opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter Unstaked events: %v", err)
+	}
+	for unstaked.Next() {
+		events = append(events, stakeEvent{Type: EventUnstaked, User: unstaked.Event.User, Amount: unstaked.Event.Amount, Raw: unstaked.Event.Raw})
+	}
+	if err := unstaked.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read Unstaked events: %v", err)
+	}
+	unstaked.Close()
+
+	removed, err := s.registry.FilterStakeRemoved(opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter StakeRemoved events: %v", err)
+	}
+	for removed.Next() {
+		events = append(events, stakeEvent{Type: EventStakeRemoved, User: removed.Event.User, Amount: removed.Event.Amount, Reason: removed.Event.Reason, Raw: removed.Event.Raw})
+	}
+	if err := removed.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read StakeRemoved events: %v", err)
+	}
+	removed.Close()
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Raw.BlockNumber != events[j].Raw.BlockNumber {
+			return events[i].Raw.BlockNumber < events[j].Raw.BlockNumber
+		}
+		return events[i].Raw.Index < events[j].Raw.Index
+	})
+
+	return events, nil
+}
+
+// postingKey identifies the ledger posting of an event. The block hash is
+// part of it so an event that is mined again after a reorg is posted again.
+func postingKey(event stakeEvent) string {
+	return fmt.Sprintf("%s:%s:%d", event.Raw.BlockHash.Hex(), event.Raw.TxHash.Hex(), event.Raw.Index)
+}
+
+// applyEvent posts an event to the ledger and then records it. Replaying a
+// range after a restart is safe because postings are keyed by the event,
+// and an event is only recorded once its posting was written.
+func (s *Syncer) applyEvent(event stakeEvent) error {
+	userID, err := s.store.UserID(event.User, true)
+	if err != nil {
+		return err
+	}
+
+	amount := chain.WeiToGwei(event.Amount)
+	if amount.Sign() > 0 {
+		key := postingKey(event)
+		memo := fmt.Sprintf("%s %s:%d", event.Type, event.Raw.TxHash.Hex(), event.Raw.Index)
+
+		switch event.Type {
+		case EventStaked:
+			_, err = s.ledger.Deposit(userID, amount, key, memo)
+		case EventUnstaked:
+			_, err = s.ledger.Withdraw(userID, amount, key, memo)
+		case EventStakeRemoved:
+			_, err = s.ledger.Withdraw(userID, amount, key, memo+" "+event.Reason)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.store.RecordEvent(event)
+}
+
+func (s *Syncer) revertEvent(event stakeEvent) error {
+	userID, err := s.store.UserID(event.User, false)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+
+	amount := chain.WeiToGwei(event.Amount)
+	if err == nil && amount.Sign() > 0 {
+		key := "revert:" + postingKey(event)
+		memo := fmt.Sprintf("reorg revert %s %s:%d", event.Type, event.Raw.TxHash.Hex(), event.Raw.Index)
+		if event.Type == EventStaked {
+			_, err = s.ledger.Withdraw(userID, amount, key, memo)
+		} else {
+			_, err = s.ledger.Deposit(userID, amount, key, memo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.store.RemoveEvent(event)
+}
+
+// Reconcile compares a user's ledger funding with GetStake at blockNumber,
+// flags any drift and writes the ledger balance to user_data.stake_amount
+func (s *Syncer) Reconcile(ctx context.Context, user common.Address, blockNumber uint64) error {
+	stake, err := s.stakes.GetStake(&bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(blockNumber)}, user)
+	if err != nil {
+		return fmt.Errorf("failed to get on-chain stake: %v", err)
+	}
+	chainAmount := chain.WeiToGwei(stake.Amount)
+
+	userID, err := s.store.UserID(user, false)
+	if err == gocql.ErrNotFound {
+		if chainAmount.Sign() > 0 {
+			return s.flagDrift(user, 0, blockNumber, new(big.Int), chainAmount)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	funding, err := s.ledger.Funding(userID)
+	if err != nil {
+		return err
+	}
+	if funding.Cmp(chainAmount) != 0 {
+		if err := s.flagDrift(user, userID, blockNumber, funding, chainAmount); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.ledger.SyncUserStake(userID)
+	return err
+}
+
+// ReconcileAll checks every known user against the chain at the checkpoint
+func (s *Syncer) ReconcileAll(ctx context.Context) error {
+	checkpoint, _, found, err := database.LoadCheckpoint(s.db.Session(), CheckpointName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("stake sync has not processed any blocks yet")
+	}
+
+	iter := s.db.Session().Query(`SELECT user_address FROM triggerx.user_data`).Iter()
+	var addresses []string
+	var address string
+	for iter.Scan(&address) {
+		addresses = append(addresses, address)
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("failed to list users: %v", err)
+	}
+
+	for _, address := range addresses {
+		if !common.IsHexAddress(address) {
+			continue
+		}
+		if err := s.Reconcile(ctx, common.HexToAddress(address), checkpoint); err != nil {
+			log.Printf("Failed to reconcile stake for %s: %v", address, err)
+		}
+	}
+
+	return nil
+}
+
+// OpenAccounts records the user_data.stake_amount of every user without
+// ledger entries as their opening deposit. It is a one-time migration of
+// users created before the ledger existed, run before the API and the stake
+// sync start so their balances are not counted from request data again.
+func (s *Syncer) OpenAccounts() (int, error) {
+	iter := s.db.Session().Query(`SELECT user_id, stake_amount FROM triggerx.user_data`).Iter()
+	stakes := make(map[int64]*big.Int)
+	var userID int64
+	var stake *big.Int
+	for iter.Scan(&userID, &stake) {
+		stakes[userID] = stake
+		stake = nil
+	}
+	if err := iter.Close(); err != nil {
+		return 0, fmt.Errorf("failed to list users: %v", err)
+	}
+
+	opened := 0
+	for userID, stake := range stakes {
+		ok, err := s.ledger.OpenAccount(userID, stake)
+		if err != nil {
+			return opened, fmt.Errorf("failed to open ledger account of user %d: %v", userID, err)
+		}
+		if ok {
+			opened++
+		}
+	}
+
+	log.Printf("Opened %d ledger accounts from pre-ledger stake", opened)
+	return opened, nil
+}
+
+func (s *Syncer) flagDrift(user common.Address, userID int64, blockNumber uint64, ledgerAmount, chainAmount *big.Int) error {
+	difference := new(big.Int).Sub(chainAmount, ledgerAmount)
+	log.Printf("WARNING: stake drift for %s (user %d) at block %d: ledger %v Gwei, chain %v Gwei, difference %v Gwei",
+		user.Hex(), userID, blockNumber, ledgerAmount, chainAmount, difference)
+
+	return s.store.RecordDrift(user, userID, blockNumber, ledgerAmount, chainAmount, difference)
+}
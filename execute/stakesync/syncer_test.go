@@ -0,0 +1,186 @@
+package stakesync
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gocql/gocql"
+
+	"github.com/trigg3rX/go-backend/pkg/ledger"
+)
+
+var staker = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+// fakeStore keeps stake events and users in memory
+type fakeStore struct {
+	users  map[common.Address]int64
+	events map[string]stakeEvent
+	drifts []*big.Int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{users: make(map[common.Address]int64), events: make(map[string]stakeEvent)}
+}
+
+func eventKey(event stakeEvent) string {
+	return event.Raw.TxHash.Hex() + ":" + big.NewInt(int64(event.Raw.Index)).String()
+}
+
+func (s *fakeStore) UserID(user common.Address, create bool) (int64, error) {
+	if userID, ok := s.users[user]; ok {
+		return userID, nil
+	}
+	if !create {
+		return 0, gocql.ErrNotFound
+	}
+	s.users[user] = int64(len(s.users) + 1)
+	return s.users[user], nil
+}
+
+func (s *fakeStore) RecordEvent(event stakeEvent) error {
+	s.events[eventKey(event)] = event
+	return nil
+}
+
+func (s *fakeStore) RemoveEvent(event stakeEvent) error {
+	delete(s.events, eventKey(event))
+	return nil
+}
+
+func (s *fakeStore) Events(from, to uint64) ([]stakeEvent, error) {
+	var events []stakeEvent
+	for _, event := range s.events {
+		if event.Raw.BlockNumber >= from && event.Raw.BlockNumber <= to {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *fakeStore) RecordDrift(user common.Address, userID int64, blockNumber uint64, ledgerAmount, chainAmount, difference *big.Int) error {
+	s.drifts = append(s.drifts, difference)
+	return nil
+}
+
+// fakeStakes answers GetStake with a fixed on-chain stake in Wei
+type fakeStakes struct {
+	amount *big.Int
+}
+
+func (f *fakeStakes) GetStake(opts *bind.CallOpts, user common.Address) (struct {
+	Amount *big.Int
+	Exists bool
+}, error) {
+	return struct {
+		Amount *big.Int
+		Exists bool
+	}{Amount: f.amount, Exists: f.amount.Sign() > 0}, nil
+}
+
+func newTestSyncer() (*Syncer, *fakeStore, *fakeStakes, *ledger.Ledger) {
+	store := newFakeStore()
+	stakes := &fakeStakes{amount: new(big.Int)}
+	l := ledger.NewLedgerWithStore(ledger.NewMemoryStore())
+	return &Syncer{store: store, stakes: stakes, ledger: l}, store, stakes, l
+}
+
+func gwei(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e9))
+}
+
+func stakedEvent(block uint64, blockHash byte, amount int64) stakeEvent {
+	return stakeEvent{
+		Type:   EventStaked,
+		User:   staker,
+		Amount: gwei(amount),
+		Raw: types.Log{
+			BlockNumber: block,
+			BlockHash:   common.Hash{blockHash},
+			TxHash:      common.HexToHash("0x01"),
+			Index:       0,
+		},
+	}
+}
+
+func assertUserBalance(t *testing.T, l *ledger.Ledger, want int64) {
+	t.Helper()
+	balance, err := l.UserBalance(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cmp(big.NewInt(want)) != 0 {
+		t.Fatalf("user balance is %v Gwei, want %d", balance, want)
+	}
+}
+
+func TestApplyEventIsIdempotent(t *testing.T) {
+	syncer, _, _, l := newTestSyncer()
+
+	// A range replayed after a restart applies its events again
+	for i := 0; i < 2; i++ {
+		if err := syncer.applyEvent(stakedEvent(10, 0xa, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertUserBalance(t, l, 100)
+}
+
+func TestRollbackRevertsReorgedStake(t *testing.T) {
+	syncer, store, stakes, l := newTestSyncer()
+	ctx := context.Background()
+
+	if err := syncer.applyEvent(stakedEvent(10, 0xa, 100)); err != nil {
+		t.Fatal(err)
+	}
+	assertUserBalance(t, l, 100)
+
+	// Block 10 is orphaned, the chain no longer holds the stake
+	if err := syncer.Rollback(ctx, 10, 12); err != nil {
+		t.Fatal(err)
+	}
+	assertUserBalance(t, l, 0)
+	if len(store.events) != 0 {
+		t.Fatalf("expected the orphaned event to be removed, got %d events", len(store.events))
+	}
+	if len(store.drifts) != 0 {
+		t.Fatalf("expected no drift after the rollback, got %v", store.drifts)
+	}
+
+	// A second rollback of the range finds nothing to revert
+	if err := syncer.Rollback(ctx, 10, 12); err != nil {
+		t.Fatal(err)
+	}
+	assertUserBalance(t, l, 0)
+
+	// The same transaction mined again on the new branch is posted again
+	stakes.amount = gwei(100)
+	if err := syncer.applyEvent(stakedEvent(11, 0xb, 100)); err != nil {
+		t.Fatal(err)
+	}
+	assertUserBalance(t, l, 100)
+	if err := syncer.Reconcile(ctx, staker, 11); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.drifts) != 0 {
+		t.Fatalf("expected the ledger to match the chain, got drift %v", store.drifts)
+	}
+}
+
+func TestReconcileFlagsDrift(t *testing.T) {
+	syncer, store, stakes, _ := newTestSyncer()
+
+	if err := syncer.applyEvent(stakedEvent(10, 0xa, 100)); err != nil {
+		t.Fatal(err)
+	}
+	stakes.amount = gwei(60)
+	if err := syncer.Reconcile(context.Background(), staker, 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.drifts) != 1 || store.drifts[0].Cmp(big.NewInt(-40)) != 0 {
+		t.Fatalf("expected a drift of -40 Gwei, got %v", store.drifts)
+	}
+}
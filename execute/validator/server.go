@@ -0,0 +1,57 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+// HandleReport accepts a keeper execution report and replies with the verdict
+func (v *Validator) HandleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var report models.ExecutionReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, "invalid report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if report.TaskID == 0 || report.JobID == 0 || report.TxHash == "" || report.Keeper == "" || report.Signature == "" {
+		http.Error(w, "task_id, job_id, keeper, tx_hash and signature are required", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Validating task %d reported by %s (tx %s)", report.TaskID, report.Keeper, report.TxHash)
+
+	validation, err := v.Validate(r.Context(), report)
+	switch {
+	case errors.Is(err, ErrTransactionPending), errors.Is(err, ErrTaskPending):
+		// The keeper should report again once the transaction is mined
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrUnauthenticated):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrNotAssigned):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrJobMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrJobNotFound), errors.Is(err, ErrTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to validate task %d: %v", report.TaskID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(validation)
+}
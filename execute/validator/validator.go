@@ -0,0 +1,361 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gocql/gocql"
+
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/models"
+	"github.com/trigg3rX/go-backend/pkg/network"
+)
+
+const validateTimeout = 20 * time.Second
+
+var (
+	ErrTransactionPending = errors.New("transaction is still pending")
+	ErrJobNotFound        = errors.New("job not found")
+	ErrTaskNotFound       = errors.New("task not found")
+	ErrTaskPending        = errors.New("task creation is not recorded yet")
+	ErrJobMismatch        = errors.New("task belongs to another job")
+	ErrNotAssigned        = errors.New("task is not assigned to the keeper")
+	ErrUnauthenticated    = errors.New("report not authenticated")
+)
+
+// taskRecord is the part of a task_data row a report is checked against
+type taskRecord struct {
+	JobID        int64
+	CreatedBlock uint64
+	// Keeper is the keeper the task manager dispatched the task to
+	Keeper string
+}
+
+// ChainClient is the subset of ethclient.Client used to check executions
+type ChainClient interface {
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// Validator checks keeper execution reports against the chain and records
+// a verdict for each task. Reports must be signed by a registered
+// operator, who must also have sent the execution transaction.
+type Validator struct {
+	db        *database.Connection
+	operators network.OperatorSet
+	clients   map[int]ChainClient
+	mu        sync.Mutex
+}
+
+func NewValidator(db *database.Connection, operators network.OperatorSet) *Validator {
+	return &Validator{
+		db:        db,
+		operators: operators,
+		clients:   make(map[int]ChainClient),
+	}
+}
+
+// SetClient overrides the client used for a chain
+func (v *Validator) SetClient(chainID int, client ChainClient) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.clients[chainID] = client
+}
+
+func (v *Validator) getClient(chainID int) (ChainClient, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if client, exists := v.clients[chainID]; exists {
+		return client, nil
+	}
+
+	client, err := chain.Dial(int64(chainID))
+	if err != nil {
+		return nil, err
+	}
+	v.clients[chainID] = client
+	return client, nil
+}
+
+// Validate checks the transaction a keeper claims to have sent for a task and
+// records the verdict. Errors mean no verdict could be reached yet.
+func (v *Validator) Validate(ctx context.Context, report models.ExecutionReport) (*models.TaskValidation, error) {
+	ctx, cancel := context.WithTimeout(ctx, validateTimeout)
+	defer cancel()
+
+	keeper, err := v.authenticate(ctx, report)
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := v.loadTask(report.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	if task.JobID != report.JobID {
+		return nil, fmt.Errorf("%w: task %d is for job %d, not %d", ErrJobMismatch, report.TaskID, task.JobID, report.JobID)
+	}
+	// Only the keeper the task was dispatched to can get a verdict on it
+	if !strings.EqualFold(task.Keeper, keeper.Hex()) {
+		return nil, fmt.Errorf("%w: task %d is assigned to %q, not %s", ErrNotAssigned, report.TaskID, task.Keeper, keeper.Hex())
+	}
+	if task.CreatedBlock == 0 {
+		return nil, fmt.Errorf("%w: task %d", ErrTaskPending, report.TaskID)
+	}
+
+	job, err := v.loadJob(report.JobID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := v.getClient(job.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	reason, err := CheckExecution(ctx, client, job, keeper, task.CreatedBlock, report.TxHash)
+	if err != nil {
+		return nil, err
+	}
+
+	txHash := common.HexToHash(report.TxHash)
+	if reason == "" {
+		// One transaction cannot be reported as the execution of two tasks
+		owner, err := v.claimTransaction(txHash, report.TaskID, keeper.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if owner != report.TaskID {
+			reason = fmt.Sprintf("transaction was already reported for task %d", owner)
+		}
+	}
+	valid := reason == ""
+	responseHash := chain.TaskResponseHash(chain.Int64ToTaskID(report.TaskID), txHash, valid)
+
+	validation := &models.TaskValidation{
+		TaskID:       report.TaskID,
+		Keeper:       keeper.Hex(),
+		TxHash:       txHash.Hex(),
+		Valid:        valid,
+		Reason:       reason,
+		ResponseHash: responseHash.Hex(),
+		ValidatedAt:  time.Now().UTC(),
+	}
+	first, err := v.record(validation)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		log.Printf("Task %d already has a verdict, keeping it over the report of %s", report.TaskID, validation.Keeper)
+	}
+
+	if valid {
+		log.Printf("Task %d executed by %s is valid", report.TaskID, report.Keeper)
+	} else {
+		log.Printf("Task %d executed by %s is invalid: %s", report.TaskID, report.Keeper, reason)
+	}
+	return validation, nil
+}
+
+// authenticate checks that a report is signed by the operator key of the
+// keeper it names and that the keeper is a registered operator. It returns
+// the keeper's address.
+func (v *Validator) authenticate(ctx context.Context, report models.ExecutionReport) (common.Address, error) {
+	if !common.IsHexAddress(report.Keeper) {
+		return common.Address{}, fmt.Errorf("%w: keeper %q is not an operator address", ErrUnauthenticated, report.Keeper)
+	}
+	keeper := common.HexToAddress(report.Keeper)
+
+	signature, err := hexutil.Decode(report.Signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("%w: invalid signature encoding", ErrUnauthenticated)
+	}
+	digest := chain.ExecutionReportHash(report.TaskID, report.JobID, keeper, common.HexToHash(report.TxHash), report.ExecutedAt.Unix())
+	pubkey, err := crypto.SigToPub(digest[:], signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("%w: invalid signature: %v", ErrUnauthenticated, err)
+	}
+	if crypto.PubkeyToAddress(*pubkey) != keeper {
+		return common.Address{}, fmt.Errorf("%w: report is not signed by %s", ErrUnauthenticated, keeper.Hex())
+	}
+
+	registered, err := v.operators.IsOperator(ctx, network.SchemeECDSA, keeper.Hex())
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to check operator %s: %v", keeper.Hex(), err)
+	}
+	if !registered {
+		return common.Address{}, fmt.Errorf("%w: %s is not a registered operator", ErrUnauthenticated, keeper.Hex())
+	}
+	return keeper, nil
+}
+
+// CheckExecution returns an empty reason when the transaction was sent by
+// the keeper, matches the job and was mined no earlier than the block the
+// task was created in, otherwise why it doesn't
+func CheckExecution(ctx context.Context, client ChainClient, job *models.JobData, keeper common.Address, createdBlock uint64, rawHash string) (string, error) {
+	if len(strings.TrimPrefix(rawHash, "0x")) != 64 {
+		return "malformed transaction hash", nil
+	}
+	txHash := common.HexToHash(rawHash)
+
+	tx, pending, err := client.TransactionByHash(ctx, txHash)
+	if err == ethereum.NotFound {
+		return "transaction not found", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get transaction %s: %v", txHash.Hex(), err)
+	}
+	if pending {
+		return "", ErrTransactionPending
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return fmt.Sprintf("transaction sender cannot be recovered: %v", err), nil
+	}
+	if sender != keeper {
+		return fmt.Sprintf("transaction sender %s is not keeper %s", sender.Hex(), keeper.Hex()), nil
+	}
+
+	if tx.To() == nil || *tx.To() != common.HexToAddress(job.ContractAddress) {
+		return fmt.Sprintf("transaction target %v does not match job contract %s", tx.To(), job.ContractAddress), nil
+	}
+
+	if reason := checkCalldata(tx.Data(), job); reason != "" {
+		return reason, nil
+	}
+
+	receipt, err := client.TransactionReceipt(ctx, txHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to get receipt of %s: %v", txHash.Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return "transaction reverted", nil
+	}
+	if receipt.BlockNumber == nil || receipt.BlockNumber.Uint64() < createdBlock {
+		return fmt.Sprintf("transaction was mined in block %v, before the task was created in block %d", receipt.BlockNumber, createdBlock), nil
+	}
+
+	return "", nil
+}
+
+// checkCalldata compares the full calldata when the job's arguments can be
+// encoded, and only the selector for jobs whose arguments are computed at
+// execution time
+func checkCalldata(data []byte, job *models.JobData) string {
+	expected, err := chain.EncodeCall(job.TargetFunction, job.Arguments)
+	if err == nil {
+		if !bytes.Equal(data, expected) {
+			return fmt.Sprintf("calldata %s does not match expected %s", hexutil.Encode(data), hexutil.Encode(expected))
+		}
+		return ""
+	}
+
+	if !strings.Contains(job.TargetFunction, "(") {
+		return fmt.Sprintf("calldata cannot be verified: %v", err)
+	}
+	selector, err := chain.Selector(job.TargetFunction, nil)
+	if err != nil {
+		return fmt.Sprintf("calldata cannot be verified: %v", err)
+	}
+	if len(data) < 4 || !bytes.Equal(data[:4], selector) {
+		return fmt.Sprintf("function selector does not match %s", job.TargetFunction)
+	}
+	return ""
+}
+
+// loadTask returns the job a task was created for, its creation block and
+// the keeper it was dispatched to
+func (v *Validator) loadTask(taskID int64) (*taskRecord, error) {
+	var jobID, createdBlock int64
+	var keeper string
+	err := v.db.Session().Query(`
+        SELECT job_id, task_created_block, assigned_keeper FROM triggerx.task_data WHERE task_id = ?`,
+		taskID).Scan(&jobID, &createdBlock, &keeper)
+	if err == gocql.ErrNotFound {
+		return nil, fmt.Errorf("%w: %d", ErrTaskNotFound, taskID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load task %d: %v", taskID, err)
+	}
+	return &taskRecord{JobID: jobID, CreatedBlock: uint64(createdBlock), Keeper: keeper}, nil
+}
+
+// claimTransaction records the task a transaction executed and returns the
+// task it was first reported for
+func (v *Validator) claimTransaction(txHash common.Hash, taskID int64, keeper string) (int64, error) {
+	existing := make(map[string]interface{})
+	applied, err := v.db.Session().Query(`
+        INSERT INTO triggerx.task_transactions (tx_hash, task_id, keeper)
+        VALUES (?, ?, ?) IF NOT EXISTS`,
+		txHash.Hex(), taskID, keeper).MapScanCAS(existing)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record transaction %s of task %d: %v", txHash.Hex(), taskID, err)
+	}
+	if applied {
+		return taskID, nil
+	}
+	owner, _ := existing["task_id"].(int64)
+	return owner, nil
+}
+
+func (v *Validator) loadJob(jobID int64) (*models.JobData, error) {
+	var job models.JobData
+	err := v.db.Session().Query(`
+        SELECT job_id, chain_id, contract_address, target_function, arguments
+        FROM triggerx.job_data WHERE job_id = ?`, jobID).Scan(
+		&job.JobID, &job.ChainID, &job.ContractAddress, &job.TargetFunction, &job.Arguments)
+	if err == gocql.ErrNotFound {
+		return nil, fmt.Errorf("%w: %d", ErrJobNotFound, jobID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load job %d: %v", jobID, err)
+	}
+
+	return &job, nil
+}
+
+// record stores a verdict and, when the task has none yet, makes it the
+// task's verdict in task_history. Reports only reach here from the assigned
+// keeper, so a later report is a retry and kept in task_validations only.
+// It reports whether the verdict was the first.
+func (v *Validator) record(validation *models.TaskValidation) (bool, error) {
+	session := v.db.Session()
+
+	if err := session.Query(`
+        INSERT INTO triggerx.task_validations (
+            task_id, validation_id, keeper, tx_hash, valid, reason, response_hash, validated_at, authenticated
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		validation.TaskID, gocql.UUIDFromTime(validation.ValidatedAt), validation.Keeper,
+		validation.TxHash, validation.Valid, validation.Reason, validation.ResponseHash,
+		validation.ValidatedAt, true).Exec(); err != nil {
+		return false, fmt.Errorf("failed to record validation of task %d: %v", validation.TaskID, err)
+	}
+
+	existing := make(map[string]interface{})
+	applied, err := session.Query(`
+        UPDATE triggerx.task_history
+        SET validation_status = ?, keepers = ?, responses = ?
+        WHERE task_id = ?
+        IF validation_status = null`,
+		validation.Valid, []string{validation.Keeper}, []string{validation.ResponseHash},
+		validation.TaskID).MapScanCAS(existing)
+	if err != nil {
+		return false, fmt.Errorf("failed to update task history of task %d: %v", validation.TaskID, err)
+	}
+
+	return applied, nil
+}
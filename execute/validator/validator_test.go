@@ -0,0 +1,213 @@
+package validator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+const testChainID = 17000
+
+// registeredOperators is an OperatorSet of operator addresses
+type registeredOperators map[common.Address]bool
+
+func (o registeredOperators) IsOperator(ctx context.Context, scheme, signer string) (bool, error) {
+	return o[common.HexToAddress(signer)], nil
+}
+
+// fakeClient serves mined transactions and their receipts
+type fakeClient struct {
+	txs      map[common.Hash]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		txs:      make(map[common.Hash]*types.Transaction),
+		receipts: make(map[common.Hash]*types.Receipt),
+	}
+}
+
+func (c *fakeClient) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	tx, ok := c.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, false, nil
+}
+
+func (c *fakeClient) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	receipt, ok := c.receipts[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+// send adds a transaction from key calling the job's target, mined in
+// block, and returns its hash
+func (c *fakeClient) send(t *testing.T, key *ecdsa.PrivateKey, job *models.JobData, status uint64, block int64) common.Hash {
+	t.Helper()
+	data, err := chain.EncodeCall(job.TargetFunction, job.Arguments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress(job.ContractAddress)
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(testChainID)), &types.DynamicFeeTx{
+		ChainID:   big.NewInt(testChainID),
+		Nonce:     uint64(len(c.txs)),
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(1),
+		Gas:       100000,
+		To:        &to,
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.txs[tx.Hash()] = tx
+	c.receipts[tx.Hash()] = &types.Receipt{Status: status, BlockNumber: big.NewInt(block)}
+	return tx.Hash()
+}
+
+func testJob() *models.JobData {
+	return &models.JobData{
+		JobID:           7,
+		ChainID:         testChainID,
+		ContractAddress: "0xa5854f4835769c3D84319DcB41cb449f6b858F83",
+		TargetFunction:  "updatePrice(uint256)",
+		Arguments:       []string{"42"},
+	}
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signedReport is a report of txHash signed by key
+func signedReport(t *testing.T, key *ecdsa.PrivateKey, txHash common.Hash) models.ExecutionReport {
+	t.Helper()
+	report := models.ExecutionReport{
+		TaskID:     3,
+		JobID:      7,
+		Keeper:     crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		TxHash:     txHash.Hex(),
+		ExecutedAt: time.Unix(1700000000, 0),
+	}
+	digest := chain.ExecutionReportHash(report.TaskID, report.JobID, common.HexToAddress(report.Keeper), txHash, report.ExecutedAt.Unix())
+	signature, err := crypto.Sign(digest[:], key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.Signature = hexutil.Encode(signature)
+	return report
+}
+
+func TestAuthenticateAcceptsRegisteredKeeper(t *testing.T) {
+	key := generateKey(t)
+	keeper := crypto.PubkeyToAddress(key.PublicKey)
+	v := NewValidator(nil, registeredOperators{keeper: true})
+
+	got, err := v.authenticate(context.Background(), signedReport(t, key, common.HexToHash("0x01")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != keeper {
+		t.Fatalf("got keeper %s, want %s", got.Hex(), keeper.Hex())
+	}
+}
+
+func TestAuthenticateRejectsReports(t *testing.T) {
+	key := generateKey(t)
+	other := generateKey(t)
+	operators := registeredOperators{
+		crypto.PubkeyToAddress(key.PublicKey):   true,
+		crypto.PubkeyToAddress(other.PublicKey): true,
+	}
+
+	unsigned := signedReport(t, key, common.HexToHash("0x01"))
+	unsigned.Signature = ""
+
+	// Signed by one operator while naming another
+	impersonated := signedReport(t, other, common.HexToHash("0x01"))
+	impersonated.Keeper = crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	// The signature does not cover another transaction
+	tampered := signedReport(t, key, common.HexToHash("0x01"))
+	tampered.TxHash = common.HexToHash("0x02").Hex()
+
+	unregistered := signedReport(t, generateKey(t), common.HexToHash("0x01"))
+
+	for name, report := range map[string]models.ExecutionReport{
+		"unsigned":     unsigned,
+		"impersonated": impersonated,
+		"tampered":     tampered,
+		"unregistered": unregistered,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewValidator(nil, operators).authenticate(context.Background(), report)
+			if !errors.Is(err, ErrUnauthenticated) {
+				t.Fatalf("got %v, want ErrUnauthenticated", err)
+			}
+		})
+	}
+}
+
+func TestCheckExecution(t *testing.T) {
+	key := generateKey(t)
+	keeper := crypto.PubkeyToAddress(key.PublicKey)
+	job := testJob()
+	client := newFakeClient()
+
+	const createdBlock = 100
+	executed := client.send(t, key, job, types.ReceiptStatusSuccessful, createdBlock+2)
+	// Mined in the block that created the task
+	sameBlock := client.send(t, key, job, types.ReceiptStatusSuccessful, createdBlock)
+	reverted := client.send(t, key, job, types.ReceiptStatusFailed, createdBlock+2)
+	// An earlier execution of the job reported for a new task
+	stale := client.send(t, key, job, types.ReceiptStatusSuccessful, createdBlock-1)
+	// Another account executed the job the keeper reports
+	foreign := client.send(t, generateKey(t), job, types.ReceiptStatusSuccessful, createdBlock+2)
+
+	tests := []struct {
+		name   string
+		txHash common.Hash
+		reason string
+	}{
+		{"executed by keeper", executed, ""},
+		{"mined with the task", sameBlock, ""},
+		{"reverted", reverted, "transaction reverted"},
+		{"mined before the task", stale, "transaction was mined in block"},
+		{"sent by another account", foreign, "transaction sender"},
+		{"unknown", common.HexToHash("0x01"), "transaction not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := CheckExecution(context.Background(), client, job, keeper, createdBlock, tt.txHash.Hex())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.reason == "" && reason != "" || !strings.HasPrefix(reason, tt.reason) {
+				t.Fatalf("got reason %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
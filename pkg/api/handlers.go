@@ -2,27 +2,40 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"math"
 	"math/big"
 
 	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
 	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/estimator"
+	"github.com/trigg3rX/go-backend/pkg/ledger"
 	"github.com/trigg3rX/go-backend/pkg/models"
+	"gopkg.in/inf.v0"
 )
 
 type Handler struct {
-	db *database.Connection
+	db        *database.Connection
+	estimator *estimator.Estimator
+	ledger    *ledger.Ledger
 }
 
-func NewHandler(db *database.Connection) *Handler {
-	return &Handler{db: db}
+func NewHandler(db *database.Connection, estimator *estimator.Estimator) *Handler {
+	return &Handler{db: db, estimator: estimator, ledger: ledger.NewLedger(db)}
 }
 
+// errStakeAmountReadOnly rejects user writes that set stake_amount, which is
+// derived from the stake ledger
+const errStakeAmountReadOnly = "stake_amount is derived from the stake ledger and cannot be set"
+
 // User Handlers
 // User Handlers
 func (h *Handler) CreateUserData(w http.ResponseWriter, r *http.Request) {
@@ -33,22 +46,31 @@ func (h *Handler) CreateUserData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if userData.StakeAmount != nil {
+		http.Error(w, errStakeAmountReadOnly, http.StatusBadRequest)
+		return
+	}
+
 	log.Printf("Creating user with ID: %d, Address: %s", userData.UserID, userData.UserAddress)
-	
-	// Convert stake amount to Gwei and store as varint
-	stakeAmountGwei := new(big.Int)
-	stakeAmountGwei = userData.StakeAmount
 
 	if err := h.db.Session().Query(`
-        INSERT INTO triggerx.user_data (user_id, user_address, job_ids, stake_amount)
-        VALUES (?, ?, ?, ?)`,
-		userData.UserID, userData.UserAddress, userData.JobIDs, stakeAmountGwei).Exec(); err != nil {
+        INSERT INTO triggerx.user_data (user_id, user_address, job_ids)
+        VALUES (?, ?, ?)`,
+		userData.UserID, userData.UserAddress, userData.JobIDs).Exec(); err != nil {
 		log.Printf("Error inserting user data: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Successfully created user with ID: %d and stake amount: %v Gwei", userData.UserID, stakeAmountGwei)
+	stakeAmount, err := h.ledger.SyncUserStake(userData.UserID)
+	if err != nil {
+		log.Printf("Error syncing user stake: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	userData.StakeAmount = stakeAmount
+
+	log.Printf("Successfully created user with ID: %d and stake amount: %v Gwei", userData.UserID, stakeAmount)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(userData)
 }
@@ -99,6 +121,37 @@ func (h *Handler) GetUserData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetUserLedger returns a user's balance derived from the stake ledger and their recent entries
+func (h *Handler) GetUserLedger(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Handling GetUserLedger request for ID: %d", userID)
+
+	balance, err := h.ledger.UserBalance(userID)
+	if err != nil {
+		log.Printf("Error retrieving user balance: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := h.ledger.Entries(ledger.UserAccount(userID), 100)
+	if err != nil {
+		log.Printf("Error retrieving ledger entries: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id": userID,
+		"balance": balance,
+		"entries": entries,
+	})
+}
+
 func (h *Handler) UpdateUserData(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
@@ -111,13 +164,18 @@ func (h *Handler) UpdateUserData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if userData.StakeAmount != nil {
+		http.Error(w, errStakeAmountReadOnly, http.StatusBadRequest)
+		return
+	}
+
 	log.Printf("Updating user data: %+v", userData)
 
 	if err := h.db.Session().Query(`
         UPDATE triggerx.user_data 
-        SET user_address = ?, job_ids = ?, stake_amount = ?
+        SET user_address = ?, job_ids = ?
         WHERE user_id = ?`,
-		userData.UserAddress, userData.JobIDs, userData.StakeAmount, userID).Exec(); err != nil {
+		userData.UserAddress, userData.JobIDs, userID).Exec(); err != nil {
 		log.Printf("Error updating user data: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -145,6 +203,50 @@ func (h *Handler) DeleteUserData(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// jobRequest is the job payload sent by the frontend, where chain_id is a hex string
+type jobRequest struct {
+	JobID             int64    `json:"job_id"`
+	JobType           int64    `json:"jobType"`
+	UserAddress       string   `json:"user_address"`
+	ChainID           string   `json:"chain_id"`
+	TimeFrame         int64    `json:"time_frame"`
+	TimeInterval      int64    `json:"time_interval"`
+	ContractAddress   string   `json:"contract_address"`
+	TargetFunction    string   `json:"target_function"`
+	ArgType           int64    `json:"arg_type"`
+	Arguments         []string `json:"arguments"`
+	Status            bool     `json:"status"`
+	JobCostPrediction int64    `json:"job_cost_prediction"`
+	ScriptFunction    string   `json:"script_function"`
+	ScriptIpfsUrl     string   `json:"script_ipfs_url"`
+}
+
+func (j jobRequest) toJobData() (models.JobData, error) {
+	// Remove "0x" prefix and parse as hex
+	chainID, err := strconv.ParseInt(strings.TrimPrefix(j.ChainID, "0x"), 16, 64)
+	if err != nil {
+		return models.JobData{}, err
+	}
+
+	return models.JobData{
+		JobID:             j.JobID,
+		JobType:           int(j.JobType),
+		UserAddress:       j.UserAddress,
+		ChainID:           int(chainID),
+		TimeFrame:         j.TimeFrame,
+		TimeInterval:      int(j.TimeInterval),
+		ContractAddress:   j.ContractAddress,
+		TargetFunction:    j.TargetFunction,
+		ArgType:           int(j.ArgType),
+		Arguments:         j.Arguments,
+		Status:            j.Status,
+		JobCostPrediction: int(j.JobCostPrediction),
+		ScriptFunction:    j.ScriptFunction,
+		ScriptIpfsUrl:     j.ScriptIpfsUrl,
+		TimeCheck:         time.Now().UTC(),
+	}, nil
+}
+
 // Job Handlers
 func (h *Handler) CreateJobData(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Received request method: %s", r.Method)
@@ -167,80 +269,77 @@ func (h *Handler) CreateJobData(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Received body: %s", string(body))
 
-	// Create a temporary struct to handle string chain_id
-	type tempJobData struct {
-		JobID             int64    `json:"job_id"`
-		JobType           int64    `json:"jobType"`
-		UserAddress       string   `json:"user_address"`
-		ChainID           string   `json:"chain_id"` // Changed to string
-		TimeFrame         int64    `json:"time_frame"`
-		TimeInterval      int64    `json:"time_interval"`
-		ContractAddress   string   `json:"contract_address"`
-		TargetFunction    string   `json:"target_function"`
-		ArgType           int64    `json:"arg_type"`
-		Arguments         []string `json:"arguments"`
-		Status            bool     `json:"status"`
-		JobCostPrediction int64    `json:"job_cost_prediction"`
-		ScriptFunction    string   `json:"script_function"`
-		ScriptIpfsUrl     string   `json:"script_ipfs_url"`
-		StakeAmount       float64  `json:"stake_amount"`
-	}
-
-	var tempJob tempJobData
+	var tempJob jobRequest
 	if err := json.Unmarshal(body, &tempJob); err != nil {
 		log.Printf("Error decoding JSON: %v", err)
 		http.Error(w, "Error decoding request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Convert hex string to int64
-	chainID, err := strconv.ParseInt(tempJob.ChainID[2:], 16, 64) // Remove "0x" prefix and parse as hex
+	jobData, err := tempJob.toJobData()
 	if err != nil {
 		log.Printf("Error parsing chain_id: %v", err)
 		http.Error(w, "Invalid chain_id format", http.StatusBadRequest)
 		return
 	}
 
-	// Create the actual JobData struct
-	jobData := models.JobData{
-		JobID:             tempJob.JobID,
-		JobType:           int(tempJob.JobType),
-		UserAddress:       tempJob.UserAddress,
-		ChainID:           int(chainID),
-		TimeFrame:         tempJob.TimeFrame,
-		TimeInterval:      int(tempJob.TimeInterval),
-		ContractAddress:   tempJob.ContractAddress,
-		TargetFunction:    tempJob.TargetFunction,
-		ArgType:           int(tempJob.ArgType),
-		Arguments:         tempJob.Arguments,
-		Status:            tempJob.Status,
-		JobCostPrediction: int(tempJob.JobCostPrediction),
-		ScriptFunction:    tempJob.ScriptFunction,
-		ScriptIpfsUrl:     tempJob.ScriptIpfsUrl,
-		TimeCheck:         time.Now().UTC(),
+	// Predict the job cost on the backend instead of trusting the client value
+	estimate, err := h.estimator.EstimateJob(r.Context(), jobData)
+	if err != nil {
+		log.Printf("Error estimating job cost: %v", err)
+		http.Error(w, "Error estimating job cost: "+err.Error(), estimateErrorStatus(err))
+		return
+	}
+	// job_cost_prediction is a 32-bit int column
+	if !estimate.ExpectedCost.IsInt64() || estimate.ExpectedCost.Int64() > math.MaxInt32 {
+		log.Printf("Job %d is expected to cost %v Gwei, more than can be recorded", jobData.JobID, estimate.ExpectedCost)
+		http.Error(w, fmt.Sprintf("Job is expected to cost %v Gwei, more than the maximum of %d Gwei",
+			estimate.ExpectedCost, math.MaxInt32), http.StatusBadRequest)
+		return
 	}
+	jobData.JobCostPrediction = int(estimate.ExpectedCost.Int64())
 
 	log.Printf("Created job data: %+v", jobData)
 
 	// Check if user exists by user_address
 	var existingUserID int64
 	var existingJobIDs []int64
-	var existingStakeAmount *big.Int
 
 	err = h.db.Session().Query(`
-        SELECT user_id, job_ids, stake_amount 
+        SELECT user_id, job_ids
         FROM triggerx.user_data 
         WHERE user_address = ? ALLOW FILTERING`,
-		jobData.UserAddress).Scan(&existingUserID, &existingJobIDs, &existingStakeAmount)
+		jobData.UserAddress).Scan(&existingUserID, &existingJobIDs)
 
 	if err != nil && err != gocql.ErrNotFound {
 		log.Printf("Error checking user existence: %v", err)
 		http.Error(w, "Error checking user existence: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	userExists := err == nil
+
+	availableStake := new(big.Int)
+	if userExists {
+		balance, err := h.ledger.UserBalance(existingUserID)
+		if err != nil {
+			log.Printf("Error reading user balance: %v", err)
+			http.Error(w, "Error reading user balance: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		availableStake.Set(balance)
+	}
+
+	// The user's ledger balance must cover the predicted cost. Stake sent
+	// along with the job only counts once the stake sync has posted it.
+	if availableStake.Cmp(estimate.ExpectedCost) < 0 {
+		log.Printf("Insufficient stake for job %d: have %v Gwei, need %v Gwei", jobData.JobID, availableStake, estimate.ExpectedCost)
+		http.Error(w, fmt.Sprintf("Insufficient stake: job is expected to cost %v Gwei but only %v Gwei is staked",
+			estimate.ExpectedCost, availableStake), http.StatusBadRequest)
+		return
+	}
 
 	// Get new user ID if user doesn't exist
-	if err == gocql.ErrNotFound {
+	if !userExists {
 		log.Printf("User not found, creating new user")
 		var maxUserID int64
 		if err := h.db.Session().Query(`
@@ -251,42 +350,41 @@ func (h *Handler) CreateJobData(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		existingUserID = maxUserID + 1
-		
-		// Convert stake amount to Gwei and store as varint
-		stakeAmountGwei := new(big.Float).SetFloat64(tempJob.StakeAmount)
-		stakeAmountInt, _ := stakeAmountGwei.Int(nil)
 
 		if err := h.db.Session().Query(`
             INSERT INTO triggerx.user_data (
                 user_id, user_address, job_ids, stake_amount
             ) VALUES (?, ?, ?, ?)`,
-            existingUserID, jobData.UserAddress, []int64{jobData.JobID}, stakeAmountInt).Exec(); err != nil {
+            existingUserID, jobData.UserAddress, []int64{jobData.JobID}, big.NewInt(0)).Exec(); err != nil {
 			log.Printf("Error creating user data: %v", err)
 			http.Error(w, "Error creating user data: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		log.Printf("Created new user with ID: %d and stake amount: %v Gwei", existingUserID, stakeAmountInt)
+		log.Printf("Created new user with ID: %d", existingUserID)
 	} else {
-		// Update existing user's job IDs and add to existing stake amount
+		// Update existing user's job IDs
 		updatedJobIDs := append(existingJobIDs, jobData.JobID)
-		
-		// Convert new stake amount to big.Int and add to existing
-		newStakeFloat := new(big.Float).SetFloat64(tempJob.StakeAmount)
-		newStakeInt, _ := newStakeFloat.Int(nil)
-		newStakeAmount := new(big.Int).Add(existingStakeAmount, newStakeInt)
-		
+
 		if err := h.db.Session().Query(`
             UPDATE triggerx.user_data 
-            SET job_ids = ?, stake_amount = ?
+            SET job_ids = ?
             WHERE user_id = ?`,
-            updatedJobIDs, newStakeAmount, existingUserID).Exec(); err != nil {
+            updatedJobIDs, existingUserID).Exec(); err != nil {
 			log.Printf("Error updating user data: %v", err)
 			http.Error(w, "Error updating user data: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		log.Printf("Updated existing user data for user ID: %d, new stake amount: %v", existingUserID, newStakeAmount)
+		log.Printf("Updated existing user data for user ID: %d", existingUserID)
 	}
 
+	stakeAmount, err := h.ledger.SyncUserStake(existingUserID)
+	if err != nil {
+		log.Printf("Error syncing user stake: %v", err)
+		http.Error(w, "Error syncing user stake: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d stake amount is now %v Gwei", existingUserID, stakeAmount)
+
 	// Create the job
 	if err := h.db.Session().Query(`
         INSERT INTO triggerx.job_data (
@@ -326,6 +424,48 @@ func (h *Handler) CreateJobData(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Job created successfully")
 }
 
+// estimateErrorStatus maps an estimator error to a status, failures of the
+// chain's RPC are not the client's fault
+func estimateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, estimator.ErrInvalidJob):
+		return http.StatusBadRequest
+	case errors.Is(err, estimator.ErrChainUnavailable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// EstimateJobCost predicts the cost of a job before it is created
+func (h *Handler) EstimateJobCost(w http.ResponseWriter, r *http.Request) {
+	var tempJob jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&tempJob); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobData, err := tempJob.toJobData()
+	if err != nil {
+		log.Printf("Error parsing chain_id: %v", err)
+		http.Error(w, "Invalid chain_id format", http.StatusBadRequest)
+		return
+	}
+
+	estimate, err := h.estimator.EstimateJob(r.Context(), jobData)
+	if err != nil {
+		log.Printf("Error estimating job cost: %v", err)
+		http.Error(w, "Error estimating job cost: "+err.Error(), estimateErrorStatus(err))
+		return
+	}
+
+	log.Printf("Estimated job cost: %v Gwei (range %v - %v) over %d executions",
+		estimate.ExpectedCost, estimate.MinCost, estimate.MaxCost, estimate.Executions)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(estimate)
+}
+
 func (h *Handler) GetJobData(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	jobID := vars["id"]
@@ -491,21 +631,27 @@ func (h *Handler) GetTaskData(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Handling GetTaskData request for ID: %s", taskID)
 
 	var taskData models.TaskData
+	var threshold *inf.Dec
 	if err := h.db.Session().Query(`
         SELECT task_id, job_id, task_no, quorum_id, quorum_number, 
                quorum_threshold, task_created_block, task_created_tx_hash,
                task_responded_block, task_responded_tx_hash, task_hash, 
-               task_response_hash, quorum_keeper_hash
+               task_response_hash, quorum_keeper_hash, assigned_keeper
         FROM triggerx.task_data 
         WHERE task_id = ?`, taskID).Scan(
 		&taskData.TaskID, &taskData.JobID, &taskData.TaskNo, &taskData.QuorumID,
-		&taskData.QuorumNumber, &taskData.QuorumThreshold, &taskData.TaskCreatedBlock,
+		&taskData.QuorumNumber, &threshold, &taskData.TaskCreatedBlock,
 		&taskData.TaskCreatedTxHash, &taskData.TaskRespondedBlock, &taskData.TaskRespondedTxHash,
-		&taskData.TaskHash, &taskData.TaskResponseHash, &taskData.QuorumKeeperHash); err != nil {
+		&taskData.TaskHash, &taskData.TaskResponseHash, &taskData.QuorumKeeperHash,
+		&taskData.AssignedKeeper); err != nil {
 		log.Printf("Error retrieving task data: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if threshold != nil {
+		// quorum_threshold is a decimal column
+		taskData.QuorumThreshold, _ = strconv.ParseFloat(threshold.String(), 64)
+	}
 
 	log.Printf("Retrieved task data: %+v", taskData)
 	json.NewEncoder(w).Encode(taskData)
@@ -807,6 +953,37 @@ func (h *Handler) GetTaskHistory(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(taskHistory)
 }
 
+// GetTaskValidations returns the validator's verdicts on a task, newest first
+func (h *Handler) GetTaskValidations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Handling GetTaskValidations request for ID: %d", taskID)
+
+	iter := h.db.Session().Query(`
+        SELECT task_id, keeper, tx_hash, valid, reason, response_hash, validated_at
+        FROM triggerx.task_validations
+        WHERE task_id = ?`, taskID).Iter()
+
+	validations := []models.TaskValidation{}
+	var validation models.TaskValidation
+	for iter.Scan(&validation.TaskID, &validation.Keeper, &validation.TxHash, &validation.Valid,
+		&validation.Reason, &validation.ResponseHash, &validation.ValidatedAt) {
+		validations = append(validations, validation)
+		validation = models.TaskValidation{}
+	}
+	if err := iter.Close(); err != nil {
+		log.Printf("Error retrieving task validations: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(validations)
+}
+
 func (h *Handler) UpdateTaskHistory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	taskID := vars["id"]
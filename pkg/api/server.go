@@ -8,12 +8,14 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/estimator"
 )
 
 type Server struct {
-	router *mux.Router
-	db     *database.Connection
-	cors   *cors.Cors
+	router    *mux.Router
+	db        *database.Connection
+	cors      *cors.Cors
+	estimator *estimator.Estimator
 }
 
 func NewServer(db *database.Connection) *Server {
@@ -32,9 +34,10 @@ func NewServer(db *database.Connection) *Server {
 	})
 
 	s := &Server{
-		router: router,
-		db:     db,
-		cors:   corsHandler,
+		router:    router,
+		db:        db,
+		cors:      corsHandler,
+		estimator: estimator.NewEstimator(),
 	}
 
 	s.routes()
@@ -42,7 +45,7 @@ func NewServer(db *database.Connection) *Server {
 }
 
 func (s *Server) routes() {
-	handler := NewHandler(s.db)
+	handler := NewHandler(s.db, s.estimator)
 
 	// Add the base /api prefix to all routes
 	api := s.router.PathPrefix("/api").Subrouter()
@@ -51,12 +54,14 @@ func (s *Server) routes() {
 	// User routes
 	api.HandleFunc("/users", handler.CreateUserData).Methods("POST")
 	api.HandleFunc("/users/{id}", handler.GetUserData).Methods("GET")
+	api.HandleFunc("/users/{id}/ledger", handler.GetUserLedger).Methods("GET")
 	api.HandleFunc("/users/{id}", handler.UpdateUserData).Methods("PUT")
 	api.HandleFunc("/users/{id}", handler.DeleteUserData).Methods("DELETE")
 
 	// Job routes
 	api.HandleFunc("/jobs/latest-id", handler.GetLatestJobID).Methods("GET")
 	api.HandleFunc("/jobs", handler.CreateJobData).Methods("POST")
+	api.HandleFunc("/jobs/estimate", handler.EstimateJobCost).Methods("POST")
 	api.HandleFunc("/jobs/{id}", handler.GetJobData).Methods("GET")
 	api.HandleFunc("/jobs/{id}", handler.UpdateJobData).Methods("PUT")
 	api.HandleFunc("/jobs/{id}", handler.DeleteJobData).Methods("DELETE")
@@ -82,6 +87,7 @@ func (s *Server) routes() {
 	// Task History routes
 	api.HandleFunc("/task_history", handler.CreateTaskHistory).Methods("POST")
 	api.HandleFunc("/task_history/{id}", handler.GetTaskHistory).Methods("GET")
+	api.HandleFunc("/task_history/{id}/validations", handler.GetTaskValidations).Methods("GET")
 	api.HandleFunc("/task_history/{id}", handler.UpdateTaskHistory).Methods("PUT")
 	api.HandleFunc("/task_history/{id}", handler.DeleteTaskHistory).Methods("DELETE")
 }
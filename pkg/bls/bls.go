@@ -0,0 +1,253 @@
+// Package bls implements BLS signatures on BN254 compatible with the
+// EigenLayer BLSSignatureChecker used by TriggerXTaskManager
+package bls
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var ErrInvalidPoint = errors.New("point is not on the curve or not in the subgroup")
+
+type G1Point struct {
+	bn254.G1Affine
+}
+
+type G2Point struct {
+	bn254.G2Affine
+}
+
+// Signature is a point on G1, signatures are aggregated by adding them
+type Signature struct {
+	G1Point
+}
+
+func NewZeroG1Point() *G1Point {
+	return &G1Point{}
+}
+
+func NewZeroG2Point() *G2Point {
+	return &G2Point{}
+}
+
+// NewG1Point builds a point from the X and Y coordinates used on-chain
+func NewG1Point(x, y *big.Int) *G1Point {
+	p := &G1Point{}
+	p.X.SetBigInt(x)
+	p.Y.SetBigInt(y)
+	return p
+}
+
+// NewG2Point builds a point from on-chain coordinates, which list the
+// imaginary part of each coordinate first
+func NewG2Point(x, y [2]*big.Int) *G2Point {
+	p := &G2Point{}
+	p.X.A1.SetBigInt(x[0])
+	p.X.A0.SetBigInt(x[1])
+	p.Y.A1.SetBigInt(y[0])
+	p.Y.A0.SetBigInt(y[1])
+	return p
+}
+
+func (p *G1Point) Add(q *G1Point) *G1Point {
+	p.G1Affine.Add(&p.G1Affine, &q.G1Affine)
+	return p
+}
+
+func (p *G1Point) Sub(q *G1Point) *G1Point {
+	p.G1Affine.Sub(&p.G1Affine, &q.G1Affine)
+	return p
+}
+
+func (p *G1Point) Clone() *G1Point {
+	return &G1Point{p.G1Affine}
+}
+
+// BigInts returns the coordinates in the form expected by BN254.G1Point
+func (p *G1Point) BigInts() (*big.Int, *big.Int) {
+	return p.X.BigInt(new(big.Int)), p.Y.BigInt(new(big.Int))
+}
+
+func (p *G1Point) Bytes() []byte {
+	raw := p.RawBytes()
+	return raw[:]
+}
+
+func G1PointFromBytes(data []byte) (*G1Point, error) {
+	p := &G1Point{}
+	if _, err := p.SetBytes(data); err != nil {
+		return nil, fmt.Errorf("invalid G1 point: %v", err)
+	}
+	return p, nil
+}
+
+func (p *G2Point) Add(q *G2Point) *G2Point {
+	p.G2Affine.Add(&p.G2Affine, &q.G2Affine)
+	return p
+}
+
+func (p *G2Point) Clone() *G2Point {
+	return &G2Point{p.G2Affine}
+}
+
+// BigInts returns the coordinates in the form expected by BN254.G2Point
+func (p *G2Point) BigInts() ([2]*big.Int, [2]*big.Int) {
+	return [2]*big.Int{p.X.A1.BigInt(new(big.Int)), p.X.A0.BigInt(new(big.Int))},
+		[2]*big.Int{p.Y.A1.BigInt(new(big.Int)), p.Y.A0.BigInt(new(big.Int))}
+}
+
+func (p *G2Point) Bytes() []byte {
+	raw := p.RawBytes()
+	return raw[:]
+}
+
+func G2PointFromBytes(data []byte) (*G2Point, error) {
+	p := &G2Point{}
+	if _, err := p.SetBytes(data); err != nil {
+		return nil, fmt.Errorf("invalid G2 point: %v", err)
+	}
+	return p, nil
+}
+
+func (s *Signature) Add(other *Signature) *Signature {
+	s.G1Point.Add(&other.G1Point)
+	return s
+}
+
+func NewZeroSignature() *Signature {
+	return &Signature{}
+}
+
+func SignatureFromBytes(data []byte) (*Signature, error) {
+	p, err := G1PointFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{*p}, nil
+}
+
+// Verify checks the signature of message against a G2 public key:
+// e(sigma, g2) == e(H(m), pk)
+func (s *Signature) Verify(pubkey *G2Point, message [32]byte) (bool, error) {
+	if !s.IsOnCurve() || !pubkey.IsOnCurve() || !pubkey.IsInSubGroup() {
+		return false, ErrInvalidPoint
+	}
+
+	_, _, _, g2Gen := bn254.Generators()
+	var negG2 bn254.G2Affine
+	negG2.Neg(&g2Gen)
+
+	hashed := HashToG1(message)
+	return bn254.PairingCheck(
+		[]bn254.G1Affine{s.G1Affine, hashed.G1Affine},
+		[]bn254.G2Affine{negG2, pubkey.G2Affine},
+	)
+}
+
+// KeyPair is a BLS private key with its public keys on both groups
+type KeyPair struct {
+	PrivKey *fr.Element
+	PubG1   *G1Point
+	PubG2   *G2Point
+}
+
+func NewKeyPair(privKey *fr.Element) *KeyPair {
+	_, _, g1Gen, g2Gen := bn254.Generators()
+	scalar := privKey.BigInt(new(big.Int))
+
+	pubG1 := &G1Point{}
+	pubG1.ScalarMultiplication(&g1Gen, scalar)
+	pubG2 := &G2Point{}
+	pubG2.ScalarMultiplication(&g2Gen, scalar)
+
+	return &KeyPair{PrivKey: privKey, PubG1: pubG1, PubG2: pubG2}
+}
+
+// GenerateKeyPair creates a key pair from a random private key
+func GenerateKeyPair() (*KeyPair, error) {
+	max := fr.Modulus()
+	scalar, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate BLS private key: %v", err)
+	}
+	privKey := new(fr.Element).SetBigInt(scalar)
+	return NewKeyPair(privKey), nil
+}
+
+// KeyPairFromString loads a private key given as a decimal string
+func KeyPairFromString(privKey string) (*KeyPair, error) {
+	scalar, ok := new(big.Int).SetString(privKey, 10)
+	if !ok || scalar.Sign() <= 0 || scalar.Cmp(fr.Modulus()) >= 0 {
+		return nil, fmt.Errorf("invalid BLS private key")
+	}
+	return NewKeyPair(new(fr.Element).SetBigInt(scalar)), nil
+}
+
+// SignMessage signs a 32 byte message such as a task response hash
+func (k *KeyPair) SignMessage(message [32]byte) *Signature {
+	return k.SignHashedToCurveMessage(HashToG1(message))
+}
+
+// SignHashedToCurveMessage signs a point that is already on G1, which is how
+// pubkey registration messages are returned by the registry coordinator
+func (k *KeyPair) SignHashedToCurveMessage(point *G1Point) *Signature {
+	sig := &Signature{}
+	sig.ScalarMultiplication(&point.G1Affine, k.PrivKey.BigInt(new(big.Int)))
+	return sig
+}
+
+// OperatorID is the ID assigned by the BLSApkRegistry: keccak256(X, Y) of
+// the G1 public key
+func OperatorID(pubG1 *G1Point) [32]byte {
+	x, y := pubG1.BigInts()
+	var id [32]byte
+	copy(id[:], crypto.Keccak256(padded32(x), padded32(y)))
+	return id
+}
+
+// CheckG1AndG2DiscreteLogEquality verifies that both public keys belong to
+// the same private key: e(pkG1, g2) == e(g1, pkG2)
+func CheckG1AndG2DiscreteLogEquality(pubG1 *G1Point, pubG2 *G2Point) (bool, error) {
+	_, _, g1Gen, g2Gen := bn254.Generators()
+	var negG1 bn254.G1Affine
+	negG1.Neg(&g1Gen)
+
+	return bn254.PairingCheck(
+		[]bn254.G1Affine{pubG1.G1Affine, negG1},
+		[]bn254.G2Affine{g2Gen, pubG2.G2Affine},
+	)
+}
+
+// HashToG1 maps a message to G1 the same way BN254.hashToG1 does on-chain:
+// x starts at the message and is incremented until x^3 + 3 has a square root
+func HashToG1(message [32]byte) *G1Point {
+	modulus := fp.Modulus()
+	three := big.NewInt(3)
+	one := big.NewInt(1)
+
+	x := new(big.Int).SetBytes(message[:])
+	x.Mod(x, modulus)
+	for {
+		beta := new(big.Int).Exp(x, three, modulus)
+		beta.Add(beta, three).Mod(beta, modulus)
+
+		// The modulus is 3 mod 4, so this is beta^((p+1)/4) like the contract
+		if y := new(big.Int).ModSqrt(beta, modulus); y != nil {
+			return NewG1Point(x, y)
+		}
+		x.Add(x, one).Mod(x, modulus)
+	}
+}
+
+func padded32(value *big.Int) []byte {
+	out := make([]byte, 32)
+	value.FillBytes(out)
+	return out
+}
@@ -0,0 +1,74 @@
+package bls
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// keyFile is the encrypted key format. The public key is kept in the clear
+// so a key can be identified without the password.
+type keyFile struct {
+	PubKey string              `json:"pubKey"`
+	Crypto keystore.CryptoJSON `json:"crypto"`
+}
+
+// SaveKeyFile encrypts the private key with password using the same scrypt
+// parameters as Ethereum keystores and writes it to path
+func (k *KeyPair) SaveKeyFile(path, password string) error {
+	privKey := k.PrivKey.Bytes()
+	encrypted, err := keystore.EncryptDataV3(privKey[:], []byte(password), keystore.StandardScryptN, keystore.StandardScryptP)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt BLS key: %v", err)
+	}
+
+	data, err := json.MarshalIndent(keyFile{
+		PubKey: hexutil.Encode(k.PubG1.Bytes()),
+		Crypto: encrypted,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode BLS key file: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write BLS key file: %v", err)
+	}
+	return nil
+}
+
+// ReadKeyFile decrypts a key file written by SaveKeyFile
+func ReadKeyFile(path, password string) (*KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read BLS key file: %v", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid BLS key file: %v", err)
+	}
+
+	privKey, err := keystore.DecryptDataV3(file.Crypto, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt BLS key: %v", err)
+	}
+
+	var scalar fr.Element
+	if err := scalar.SetBytesCanonical(privKey); err != nil {
+		return nil, fmt.Errorf("invalid BLS private key: %v", err)
+	}
+	keys := NewKeyPair(&scalar)
+
+	if file.PubKey != "" && file.PubKey != hexutil.Encode(keys.PubG1.Bytes()) {
+		return nil, fmt.Errorf("BLS key file public key does not match its private key")
+	}
+	return keys, nil
+}
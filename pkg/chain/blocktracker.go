@@ -0,0 +1,412 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// DefaultHistorySize is how many recent block hashes a tracker keeps to
+	// find the fork point of a reorg
+	DefaultHistorySize = 128
+	// DefaultBatchSize is the largest block range handed to a listener at once
+	DefaultBatchSize uint64 = 2000
+)
+
+// ErrNonCanonicalLog is returned for a log that was not emitted in the block
+// the tracker fetched at its height, because the chain reorganised while a
+// range was being read
+var ErrNonCanonicalLog = errors.New("log is not from a canonical block")
+
+// HeaderSource is the subset of ethclient.Client a BlockTracker needs. A nil
+// number requests the latest header.
+type HeaderSource interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// BlockHandler is implemented by chain listeners driven by a BlockTracker
+type BlockHandler interface {
+	// HandleBlocks processes all logs in a block range, passing each log to
+	// blocks.Check before applying it
+	HandleBlocks(ctx context.Context, blocks *Blocks) error
+	// Rollback undoes every DB row derived from blocks from..to, which have
+	// been orphaned. The blocks are handed to HandleBlocks again once the new
+	// branch is confirmed.
+	Rollback(ctx context.Context, from, to uint64) error
+	// Checkpoint persists the last processed block and the recent hashes
+	Checkpoint(checkpoint Checkpoint) error
+}
+
+// Checkpoint is the last processed block with the hashes of the blocks up to
+// it, oldest first, so a restarted tracker can find the fork point of a reorg
+// below the checkpoint
+type Checkpoint struct {
+	Block  uint64
+	Hashes []common.Hash
+}
+
+// CheckpointFromHex builds a checkpoint from stored hex hashes
+func CheckpointFromHex(block uint64, hashes []string) Checkpoint {
+	checkpoint := Checkpoint{Block: block}
+	for _, hash := range hashes {
+		checkpoint.Hashes = append(checkpoint.Hashes, common.HexToHash(hash))
+	}
+	return checkpoint
+}
+
+// Hash returns the hash of the checkpoint block, or the zero hash if unknown
+func (c Checkpoint) Hash() common.Hash {
+	if len(c.Hashes) == 0 {
+		return common.Hash{}
+	}
+	return c.Hashes[len(c.Hashes)-1]
+}
+
+// Hex returns the hashes as hex strings for storage
+func (c Checkpoint) Hex() []string {
+	hashes := make([]string, len(c.Hashes))
+	for i, hash := range c.Hashes {
+		hashes[i] = hash.Hex()
+	}
+	return hashes
+}
+
+// Blocks is a range of confirmed blocks handed to a BlockHandler. The
+// tracker fetches the headers it keeps before the logs of the range are
+// read, and the other headers are fetched as logs in them are checked.
+type Blocks struct {
+	From, To uint64
+
+	source  HeaderSource
+	headers map[uint64]*types.Header
+}
+
+// NewBlocks describes blocks from..to, for handlers replaying a range
+// outside a tracker
+func NewBlocks(source HeaderSource, from, to uint64) *Blocks {
+	return &Blocks{From: from, To: to, source: source, headers: make(map[uint64]*types.Header)}
+}
+
+// Hash returns the hash of a block in the range
+func (b *Blocks) Hash(ctx context.Context, block uint64) (common.Hash, error) {
+	header, err := b.header(ctx, block)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return header.Hash(), nil
+}
+
+// Check returns ErrNonCanonicalLog unless a log was emitted in the block the
+// range holds at its height
+func (b *Blocks) Check(ctx context.Context, l types.Log) error {
+	if l.BlockNumber < b.From || l.BlockNumber > b.To {
+		return fmt.Errorf("log of block %d is outside blocks %d-%d", l.BlockNumber, b.From, b.To)
+	}
+	hash, err := b.Hash(ctx, l.BlockNumber)
+	if err != nil {
+		return err
+	}
+	if l.Removed || l.BlockHash != hash {
+		return fmt.Errorf("%w: tx %s in block %d %s, canonical %s", ErrNonCanonicalLog,
+			l.TxHash.Hex(), l.BlockNumber, l.BlockHash.Hex(), hash.Hex())
+	}
+	return nil
+}
+
+func (b *Blocks) header(ctx context.Context, block uint64) (*types.Header, error) {
+	if header, ok := b.headers[block]; ok {
+		return header, nil
+	}
+	header, err := b.source.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get header %d: %v", block, err)
+	}
+	b.headers[block] = header
+	return header, nil
+}
+
+// fetch gets the headers of blocks from..to and checks that they form one
+// chain, so a reorg during the fetch is not mistaken for history
+func (b *Blocks) fetch(ctx context.Context, from, to uint64) error {
+	for block := from; block <= to; block++ {
+		header, err := b.header(ctx, block)
+		if err != nil {
+			return err
+		}
+		if block > from && header.ParentHash != b.headers[block-1].Hash() {
+			return fmt.Errorf("chain reorganised while fetching blocks %d-%d", from, to)
+		}
+	}
+	return nil
+}
+
+// BlockTracker feeds confirmed block ranges to a BlockHandler and detects
+// reorgs by comparing the hashes of processed blocks with the chain
+type BlockTracker struct {
+	source        HeaderSource
+	confirmations uint64
+	historySize   int
+	batchSize     uint64
+
+	tip     uint64
+	started bool
+	hashes  map[uint64]common.Hash
+}
+
+func NewBlockTracker(source HeaderSource, confirmations uint64) *BlockTracker {
+	return &BlockTracker{
+		source:        source,
+		confirmations: confirmations,
+		historySize:   DefaultHistorySize,
+		batchSize:     DefaultBatchSize,
+		hashes:        make(map[uint64]common.Hash),
+	}
+}
+
+// NewBlockTrackerForChain uses the configured confirmation depth of a chain
+func NewBlockTrackerForChain(source HeaderSource, chainID int64) (*BlockTracker, error) {
+	config, err := GetConfig(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockTracker(source, config.Confirmations), nil
+}
+
+func (t *BlockTracker) SetBatchSize(size uint64) {
+	if size > 0 {
+		t.batchSize = size
+	}
+}
+
+func (t *BlockTracker) SetHistorySize(size int) {
+	if size > 0 {
+		t.historySize = size
+	}
+}
+
+// Resume starts tracking after a checkpoint. Without hashes the checkpoint
+// block is trusted as is.
+func (t *BlockTracker) Resume(checkpoint Checkpoint) {
+	t.tip = checkpoint.Block
+	t.started = true
+	t.hashes = make(map[uint64]common.Hash)
+	hashes := checkpoint.Hashes
+	if len(hashes) > t.historySize {
+		hashes = hashes[len(hashes)-t.historySize:]
+	}
+	first := checkpoint.Block + 1 - uint64(len(hashes))
+	for i, hash := range hashes {
+		if hash != (common.Hash{}) {
+			t.hashes[first+uint64(i)] = hash
+		}
+	}
+}
+
+// Start begins tracking at startBlock when there is no checkpoint
+func (t *BlockTracker) Start(startBlock uint64) {
+	t.hashes = make(map[uint64]common.Hash)
+	t.started = startBlock > 0
+	if t.started {
+		t.tip = startBlock - 1
+	}
+}
+
+// Tip returns the last processed block
+func (t *BlockTracker) Tip() uint64 {
+	return t.tip
+}
+
+// Poll rolls back orphaned blocks if the chain reorganised and then hands
+// every newly confirmed block to the handler
+func (t *BlockTracker) Poll(ctx context.Context, handler BlockHandler) error {
+	head, err := t.source.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get latest header: %v", err)
+	}
+	if head.Number.Uint64() < t.confirmations {
+		return nil
+	}
+	safeHead := head.Number.Uint64() - t.confirmations
+
+	if t.started {
+		if err := t.checkReorg(ctx, handler); err != nil {
+			return err
+		}
+	}
+
+	from := uint64(0)
+	if t.started {
+		from = t.tip + 1
+	}
+	for from <= safeHead {
+		to := from + t.batchSize - 1
+		if to > safeHead {
+			to = safeHead
+		}
+
+		// The headers that are kept are fetched before the logs, so the
+		// logs are checked against the hashes the checkpoint records
+		blocks := NewBlocks(t.source, from, to)
+		start := t.windowStart(from, to)
+		if err := blocks.fetch(ctx, start, to); err != nil {
+			return err
+		}
+		if parent, known := t.hashes[from-1]; known && start == from && blocks.headers[from].ParentHash != parent {
+			return fmt.Errorf("chain reorganised below block %d, retrying", from)
+		}
+
+		if err := handler.HandleBlocks(ctx, blocks); err != nil {
+			if errors.Is(err, ErrNonCanonicalLog) {
+				// Rows written before the stale log was found are undone
+				if rollbackErr := handler.Rollback(ctx, from, to); rollbackErr != nil {
+					return fmt.Errorf("failed to roll back blocks %d-%d: %v", from, to, rollbackErr)
+				}
+			}
+			return err
+		}
+		t.record(blocks, start, to)
+		if err := handler.Checkpoint(t.checkpoint(to)); err != nil {
+			return err
+		}
+
+		t.tip = to
+		t.started = true
+		from = to + 1
+	}
+
+	return nil
+}
+
+// checkReorg compares the hash of the tip with the chain and, if it changed,
+// walks back through the history to the last common block
+func (t *BlockTracker) checkReorg(ctx context.Context, handler BlockHandler) error {
+	stored, known := t.hashes[t.tip]
+	if !known {
+		return nil
+	}
+	current, err := t.hashAt(ctx, t.tip)
+	if err != nil {
+		return err
+	}
+	if current == stored {
+		return nil
+	}
+
+	ancestor, found, err := t.findAncestor(ctx)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// The fork is deeper than the history, so everything we can still
+		// verify is rolled back
+		oldest := t.oldestKnown()
+		if oldest == 0 {
+			return fmt.Errorf("reorg reaches the genesis block")
+		}
+		ancestor = oldest - 1
+		// The checkpoint needs a hash to resume from, and the ancestor's
+		// rows are stale either way
+		hash, err := t.hashAt(ctx, ancestor)
+		if err != nil {
+			return err
+		}
+		t.hashes[ancestor] = hash
+		log.Printf("WARNING: reorg deeper than %d tracked blocks, rolling back to block %d", t.historySize, ancestor)
+	}
+
+	orphanedFrom, orphanedTo := ancestor+1, t.tip
+	log.Printf("Reorg detected: blocks %d-%d orphaned", orphanedFrom, orphanedTo)
+	if err := handler.Rollback(ctx, orphanedFrom, orphanedTo); err != nil {
+		return fmt.Errorf("failed to roll back blocks %d-%d: %v", orphanedFrom, orphanedTo, err)
+	}
+
+	for block := range t.hashes {
+		if block > ancestor {
+			delete(t.hashes, block)
+		}
+	}
+	t.tip = ancestor
+	return handler.Checkpoint(t.checkpoint(ancestor))
+}
+
+func (t *BlockTracker) findAncestor(ctx context.Context) (uint64, bool, error) {
+	for block := t.tip; block > 0; block-- {
+		stored, known := t.hashes[block-1]
+		if !known {
+			return 0, false, nil
+		}
+		current, err := t.hashAt(ctx, block-1)
+		if err != nil {
+			return 0, false, err
+		}
+		if current == stored {
+			return block - 1, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func (t *BlockTracker) oldestKnown() uint64 {
+	oldest := t.tip
+	for block := range t.hashes {
+		if block < oldest {
+			oldest = block
+		}
+	}
+	return oldest
+}
+
+// windowStart returns the first block of a range whose hash is kept
+func (t *BlockTracker) windowStart(from, to uint64) uint64 {
+	if to-from+1 > uint64(t.historySize) {
+		return to - uint64(t.historySize) + 1
+	}
+	return from
+}
+
+// record keeps the hashes of the fetched blocks of a processed range and
+// drops those older than the history
+func (t *BlockTracker) record(blocks *Blocks, start, to uint64) {
+	for block := start; block <= to; block++ {
+		t.hashes[block] = blocks.headers[block].Hash()
+	}
+
+	if len(t.hashes) > t.historySize {
+		for block := range t.hashes {
+			if block+uint64(t.historySize) <= to {
+				delete(t.hashes, block)
+			}
+		}
+	}
+}
+
+// checkpoint returns block with the contiguous hashes known up to it
+func (t *BlockTracker) checkpoint(block uint64) Checkpoint {
+	first := block + 1
+	for first > 0 {
+		if _, known := t.hashes[first-1]; !known || block-(first-1) >= uint64(t.historySize) {
+			break
+		}
+		first--
+	}
+
+	checkpoint := Checkpoint{Block: block}
+	for b := first; b <= block; b++ {
+		checkpoint.Hashes = append(checkpoint.Hashes, t.hashes[b])
+	}
+	return checkpoint
+}
+
+func (t *BlockTracker) hashAt(ctx context.Context, block uint64) (common.Hash, error) {
+	header, err := t.source.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to get header %d: %v", block, err)
+	}
+	return header.Hash(), nil
+}
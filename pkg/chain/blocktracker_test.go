@@ -0,0 +1,298 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeChain is a scripted chain whose blocks can be replaced to simulate forks
+type fakeChain struct {
+	headers []*types.Header
+	branch  byte
+}
+
+func newFakeChain(length int) *fakeChain {
+	c := &fakeChain{}
+	c.extend(length)
+	return c
+}
+
+// extend mines blocks on the current branch
+func (c *fakeChain) extend(count int) {
+	for i := 0; i < count; i++ {
+		header := &types.Header{
+			Number:     big.NewInt(int64(len(c.headers))),
+			Difficulty: big.NewInt(1),
+			Extra:      []byte{c.branch},
+		}
+		if len(c.headers) > 0 {
+			header.ParentHash = c.headers[len(c.headers)-1].Hash()
+		}
+		c.headers = append(c.headers, header)
+	}
+}
+
+// fork drops every block from block onwards and mines length blocks on a new branch
+func (c *fakeChain) fork(block, length int) {
+	c.headers = c.headers[:block]
+	c.branch++
+	c.extend(length)
+}
+
+func (c *fakeChain) head() uint64 {
+	return uint64(len(c.headers) - 1)
+}
+
+func (c *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		return c.headers[len(c.headers)-1], nil
+	}
+	if number.Uint64() >= uint64(len(c.headers)) {
+		return nil, fmt.Errorf("block %v not found", number)
+	}
+	return c.headers[number.Uint64()], nil
+}
+
+// recordingHandler stores the hash of every processed block the way a
+// listener would store rows derived from it
+type recordingHandler struct {
+	chain      *fakeChain
+	rows       map[uint64]common.Hash
+	rollbacks  [][2]uint64
+	checkpoint Checkpoint
+	// beforeLogs runs after the tracker fetched headers and before the
+	// handler reads the logs of a range
+	beforeLogs func()
+}
+
+func newRecordingHandler(chain *fakeChain) *recordingHandler {
+	return &recordingHandler{chain: chain, rows: make(map[uint64]common.Hash)}
+}
+
+// HandleBlocks reads one log per block from the chain as it is now
+func (h *recordingHandler) HandleBlocks(ctx context.Context, blocks *Blocks) error {
+	if h.beforeLogs != nil {
+		h.beforeLogs()
+		h.beforeLogs = nil
+	}
+	for block := blocks.From; block <= blocks.To; block++ {
+		if _, exists := h.rows[block]; exists {
+			return fmt.Errorf("block %d processed twice without a rollback", block)
+		}
+		hash := h.chain.headers[block].Hash()
+		if err := blocks.Check(ctx, types.Log{BlockNumber: block, BlockHash: hash}); err != nil {
+			return err
+		}
+		h.rows[block] = hash
+	}
+	return nil
+}
+
+func (h *recordingHandler) Rollback(ctx context.Context, from, to uint64) error {
+	h.rollbacks = append(h.rollbacks, [2]uint64{from, to})
+	for block := from; block <= to; block++ {
+		delete(h.rows, block)
+	}
+	return nil
+}
+
+func (h *recordingHandler) Checkpoint(checkpoint Checkpoint) error {
+	h.checkpoint = checkpoint
+	return nil
+}
+
+// assertCanonical checks that every stored row belongs to the current branch
+func (h *recordingHandler) assertCanonical(t *testing.T, upTo uint64) {
+	t.Helper()
+	for block := uint64(0); block <= upTo; block++ {
+		hash, exists := h.rows[block]
+		if !exists {
+			t.Fatalf("block %d was not processed", block)
+		}
+		if hash != h.chain.headers[block].Hash() {
+			t.Fatalf("block %d holds rows from an orphaned branch", block)
+		}
+	}
+	if uint64(len(h.rows)) != upTo+1 {
+		t.Fatalf("expected %d processed blocks, got %d", upTo+1, len(h.rows))
+	}
+}
+
+func TestBlockTrackerRespectsConfirmations(t *testing.T) {
+	chain := newFakeChain(20)
+	handler := newRecordingHandler(chain)
+	tracker := NewBlockTracker(chain, 5)
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	if tracker.Tip() != chain.head()-5 {
+		t.Fatalf("expected tip %d, got %d", chain.head()-5, tracker.Tip())
+	}
+	handler.assertCanonical(t, tracker.Tip())
+}
+
+func TestBlockTrackerRollsBackFork(t *testing.T) {
+	chain := newFakeChain(30)
+	handler := newRecordingHandler(chain)
+	tracker := NewBlockTracker(chain, 2)
+	tracker.SetBatchSize(7)
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	oldTip := tracker.Tip()
+
+	// Replace the last six processed blocks with a longer branch
+	chain.fork(int(oldTip)-5, 12)
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handler.rollbacks) != 1 {
+		t.Fatalf("expected one rollback, got %d", len(handler.rollbacks))
+	}
+	if rollback := handler.rollbacks[0]; rollback != [2]uint64{oldTip - 5, oldTip} {
+		t.Fatalf("expected rollback of %d-%d, got %d-%d", oldTip-5, oldTip, rollback[0], rollback[1])
+	}
+	if tracker.Tip() != chain.head()-2 {
+		t.Fatalf("expected tip %d, got %d", chain.head()-2, tracker.Tip())
+	}
+	handler.assertCanonical(t, tracker.Tip())
+}
+
+func TestBlockTrackerIgnoresForksAboveTip(t *testing.T) {
+	chain := newFakeChain(30)
+	handler := newRecordingHandler(chain)
+	tracker := NewBlockTracker(chain, 5)
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks within the confirmation depth are replaced, none processed yet
+	chain.fork(int(chain.head())-3, 10)
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handler.rollbacks) != 0 {
+		t.Fatalf("expected no rollback, got %v", handler.rollbacks)
+	}
+	handler.assertCanonical(t, tracker.Tip())
+}
+
+func TestBlockTrackerForkDeeperThanHistory(t *testing.T) {
+	chain := newFakeChain(40)
+	handler := newRecordingHandler(chain)
+	tracker := NewBlockTracker(chain, 1)
+	tracker.SetHistorySize(4)
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	oldTip := tracker.Tip()
+
+	chain.fork(int(oldTip)-10, 15)
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the tracked blocks can be rolled back, older rows stay stale
+	if len(handler.rollbacks) != 1 || handler.rollbacks[0] != [2]uint64{oldTip - 3, oldTip} {
+		t.Fatalf("expected rollback of %d-%d, got %v", oldTip-3, oldTip, handler.rollbacks)
+	}
+	if tracker.Tip() != chain.head()-1 {
+		t.Fatalf("expected tip %d, got %d", chain.head()-1, tracker.Tip())
+	}
+}
+
+func TestBlockTrackerResumeDetectsFork(t *testing.T) {
+	chain := newFakeChain(20)
+	handler := newRecordingHandler(chain)
+	tracker := NewBlockTracker(chain, 0)
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := handler.checkpoint
+	if checkpoint.Hash() != chain.headers[checkpoint.Block].Hash() {
+		t.Fatalf("checkpoint of block %d holds hash %s", checkpoint.Block, checkpoint.Hash().Hex())
+	}
+
+	// A restarted listener knows the hashes stored with its checkpoint, so
+	// it finds a fork point below the checkpoint block
+	restarted := NewBlockTracker(chain, 0)
+	restarted.Resume(checkpoint)
+
+	chain.fork(int(checkpoint.Block)-3, 6)
+	if err := restarted.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handler.rollbacks) != 1 || handler.rollbacks[0] != [2]uint64{checkpoint.Block - 3, checkpoint.Block} {
+		t.Fatalf("expected rollback of blocks %d-%d, got %v", checkpoint.Block-3, checkpoint.Block, handler.rollbacks)
+	}
+	handler.assertCanonical(t, restarted.Tip())
+}
+
+func TestBlockTrackerDeepRollbackCheckpointsHash(t *testing.T) {
+	chain := newFakeChain(40)
+	handler := newRecordingHandler(chain)
+	tracker := NewBlockTracker(chain, 0)
+	tracker.SetHistorySize(4)
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	oldTip := tracker.Tip()
+
+	// The rollback stops at a block whose hash was not tracked
+	chain.fork(int(oldTip)-10, 15)
+	if err := tracker.checkReorg(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if handler.checkpoint.Block != oldTip-4 {
+		t.Fatalf("expected checkpoint at block %d, got %d", oldTip-4, handler.checkpoint.Block)
+	}
+	if handler.checkpoint.Hash() != chain.headers[oldTip-4].Hash() {
+		t.Fatalf("checkpoint of block %d holds hash %s", oldTip-4, handler.checkpoint.Hash().Hex())
+	}
+}
+
+func TestBlockTrackerRejectsLogsFromReorgedBlocks(t *testing.T) {
+	chain := newFakeChain(20)
+	handler := newRecordingHandler(chain)
+	tracker := NewBlockTracker(chain, 2)
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	oldTip := tracker.Tip()
+
+	// The chain reorganises above the tip between the header and log reads
+	chain.extend(5)
+	handler.beforeLogs = func() { chain.fork(int(oldTip)+2, 8) }
+	err := tracker.Poll(context.Background(), handler)
+	if !errors.Is(err, ErrNonCanonicalLog) {
+		t.Fatalf("expected ErrNonCanonicalLog, got %v", err)
+	}
+	if tracker.Tip() != oldTip {
+		t.Fatalf("tip moved to %d past a stale range", tracker.Tip())
+	}
+	if len(handler.rollbacks) != 1 || handler.rollbacks[0][0] != oldTip+1 {
+		t.Fatalf("expected the partial range from %d to be rolled back, got %v", oldTip+1, handler.rollbacks)
+	}
+
+	if err := tracker.Poll(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	handler.assertCanonical(t, tracker.Tip())
+}
@@ -0,0 +1,141 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var ErrUnknownSignature = errors.New("target function has arguments but no parameter types")
+
+// FunctionSignature normalises a job's target function to its canonical
+// form, e.g. "updatePrice" becomes "updatePrice()"
+func FunctionSignature(targetFunction string, args []string) (string, error) {
+	targetFunction = strings.ReplaceAll(strings.TrimSpace(targetFunction), " ", "")
+	if strings.Contains(targetFunction, "(") {
+		return targetFunction, nil
+	}
+	if len(args) > 0 {
+		return "", ErrUnknownSignature
+	}
+	return targetFunction + "()", nil
+}
+
+// Selector returns the 4 byte function selector of a target function
+func Selector(targetFunction string, args []string) ([]byte, error) {
+	signature, err := FunctionSignature(targetFunction, args)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256([]byte(signature))[:4], nil
+}
+
+// EncodeCall builds the calldata for calling targetFunction with the string
+// arguments stored on a job. Only elementary Solidity types are supported.
+func EncodeCall(targetFunction string, args []string) ([]byte, error) {
+	signature, err := FunctionSignature(targetFunction, args)
+	if err != nil {
+		return nil, err
+	}
+
+	open := strings.Index(signature, "(")
+	if open <= 0 || !strings.HasSuffix(signature, ")") {
+		return nil, fmt.Errorf("invalid function signature: %s", signature)
+	}
+
+	var typeNames []string
+	if params := signature[open+1 : len(signature)-1]; params != "" {
+		typeNames = strings.Split(params, ",")
+	}
+	if len(typeNames) != len(args) {
+		return nil, fmt.Errorf("function %s expects %d arguments, got %d", signature, len(typeNames), len(args))
+	}
+
+	arguments := make(abi.Arguments, len(typeNames))
+	values := make([]interface{}, len(typeNames))
+	for i, typeName := range typeNames {
+		typ, err := abi.NewType(typeName, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter type %s: %v", typeName, err)
+		}
+		value, err := convertArgument(typ, args[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %d for %s: %v", i, signature, err)
+		}
+		arguments[i] = abi.Argument{Type: typ}
+		values[i] = value
+	}
+
+	packed, err := arguments.Pack(values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack arguments: %v", err)
+	}
+
+	return append(crypto.Keccak256([]byte(signature))[:4], packed...), nil
+}
+
+func convertArgument(typ abi.Type, arg string) (interface{}, error) {
+	arg = strings.TrimSpace(arg)
+
+	switch typ.T {
+	case abi.UintTy, abi.IntTy:
+		value, ok := new(big.Int).SetString(arg, 0)
+		if !ok {
+			return nil, fmt.Errorf("not an integer: %s", arg)
+		}
+		goType := typ.GetType()
+		if goType == reflect.TypeOf(value) {
+			return value, nil
+		}
+		converted := reflect.New(goType).Elem()
+		if typ.T == abi.UintTy {
+			if value.Sign() < 0 || !value.IsUint64() {
+				return nil, fmt.Errorf("value out of range: %s", arg)
+			}
+			converted.SetUint(value.Uint64())
+		} else {
+			if !value.IsInt64() {
+				return nil, fmt.Errorf("value out of range: %s", arg)
+			}
+			converted.SetInt(value.Int64())
+		}
+		return converted.Interface(), nil
+
+	case abi.AddressTy:
+		if !common.IsHexAddress(arg) {
+			return nil, fmt.Errorf("not an address: %s", arg)
+		}
+		return common.HexToAddress(arg), nil
+
+	case abi.BoolTy:
+		return strconv.ParseBool(arg)
+
+	case abi.StringTy:
+		return arg, nil
+
+	case abi.BytesTy:
+		return hexutil.Decode(arg)
+
+	case abi.FixedBytesTy:
+		raw, err := hexutil.Decode(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > typ.Size {
+			return nil, fmt.Errorf("value longer than %d bytes", typ.Size)
+		}
+		fixed := reflect.New(typ.GetType()).Elem()
+		reflect.Copy(fixed, reflect.ValueOf(raw))
+		return fixed.Interface(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported parameter type %s", typ.String())
+}
@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	HoleskyChainID   int64 = 17000
+	OpSepoliaChainID int64 = 11155420
+)
+
+// Config holds the connection settings for a single chain
+type Config struct {
+	ChainID       int64
+	Name          string
+	RPCURL        string
+	Confirmations uint64 // blocks a chain listener stays behind the head
+}
+
+// Configs lists the chains the backend knows about, keyed by chain ID
+var Configs = map[int64]Config{
+	HoleskyChainID: {
+		ChainID:       HoleskyChainID,
+		Name:          "holesky",
+		RPCURL:        "https://ethereum-holesky-rpc.publicnode.com/",
+		Confirmations: 3,
+	},
+	OpSepoliaChainID: {
+		ChainID:       OpSepoliaChainID,
+		Name:          "opsepolia",
+		RPCURL:        "https://sepolia.optimism.io",
+		Confirmations: 10,
+	},
+}
+
+// GetConfig returns the config for a chain. The RPC URL and confirmation
+// depth can be overridden with the RPC_URL_<chainID> and
+// CONFIRMATIONS_<chainID> environment variables.
+func GetConfig(chainID int64) (Config, error) {
+	config, exists := Configs[chainID]
+	if !exists {
+		return Config{}, fmt.Errorf("unsupported chain ID: %d", chainID)
+	}
+
+	suffix := strconv.FormatInt(chainID, 10)
+	if url := os.Getenv("RPC_URL_" + suffix); url != "" {
+		config.RPCURL = url
+	}
+	if depth := os.Getenv("CONFIRMATIONS_" + suffix); depth != "" {
+		confirmations, err := strconv.ParseUint(depth, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid CONFIRMATIONS_%s: %v", suffix, err)
+		}
+		config.Confirmations = confirmations
+	}
+
+	return config, nil
+}
+
+// Dial connects to the RPC endpoint of a chain
+func Dial(chainID int64) (*ethclient.Client, error) {
+	config, err := GetConfig(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ethclient.Dial(config.RPCURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", config.Name, err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,16 @@
+package chain
+
+// AVS contract proxies on Holesky, from pkg/avsinterface/deployments.holesky.json
+const (
+	RegistryCoordinatorAddress    = "0x13a05d12b8061f8F12beCa62a42b981531021439"
+	StakeRegistryAddress          = "0x25f38BB000EDdB89bA2547167aBCf6dc3996f568"
+	ApkRegistryAddress            = "0x7BF086541b1eB91ebDb35E96636233e34BaD0609"
+	IndexRegistryAddress          = "0x34083F20Ec671A0C68a4558400C1a7d7fB922651"
+	SocketRegistryAddress         = "0xF6b24BA392f07CdcABD931004bf2257E573216BC"
+	OperatorStateRetrieverAddress = "0x0bFAf958319205DDEE91881De142e3139A4E9730"
+	ServiceManagerAddress         = "0xD13cb4e9092D0c489819647E59B4CED2746b9980"
+	TaskManagerAddress            = "0xcA7e65Fe4f3FF3d23F30fa7ee78Ac375b7548fdA"
+)
+
+// User stake registry proxy on OP Sepolia, from pkg/avsinterface/stake.opsepolia.json
+const TriggerXStakeRegistryAddress = "0xb21F282d7Ed242210e6E35AD323C5F6a467Cb34b"
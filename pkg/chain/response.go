@@ -0,0 +1,44 @@
+package chain
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var taskResponseArguments = func() abi.Arguments {
+	bytes8, _ := abi.NewType("bytes8", "", nil)
+	bytes32, _ := abi.NewType("bytes32", "", nil)
+	boolean, _ := abi.NewType("bool", "", nil)
+	return abi.Arguments{{Type: bytes8}, {Type: bytes32}, {Type: boolean}}
+}()
+
+var executionReportArguments = func() abi.Arguments {
+	int64Type, _ := abi.NewType("int64", "", nil)
+	address, _ := abi.NewType("address", "", nil)
+	bytes32, _ := abi.NewType("bytes32", "", nil)
+	return abi.Arguments{{Type: int64Type}, {Type: int64Type}, {Type: address}, {Type: bytes32}, {Type: int64Type}}
+}()
+
+// TaskResponseHash is the hash keepers sign and the aggregator submits as
+// TaskResponse.TaskResponseHash: keccak256(abi.encode(taskId, txHash, valid))
+func TaskResponseHash(taskID [8]byte, txHash common.Hash, valid bool) common.Hash {
+	packed, err := taskResponseArguments.Pack(taskID, [32]byte(txHash), valid)
+	if err != nil {
+		// The argument types are fixed, so packing cannot fail
+		panic(err)
+	}
+	return crypto.Keccak256Hash(packed)
+}
+
+// ExecutionReportHash is the hash keepers sign with their operator key when
+// reporting an execution to the validator:
+// keccak256(abi.encode(taskId, jobId, keeper, txHash, executedAt))
+func ExecutionReportHash(taskID, jobID int64, keeper common.Address, txHash common.Hash, executedAt int64) common.Hash {
+	packed, err := executionReportArguments.Pack(taskID, jobID, keeper, [32]byte(txHash), executedAt)
+	if err != nil {
+		// The argument types are fixed, so packing cannot fail
+		panic(err)
+	}
+	return crypto.Keccak256Hash(packed)
+}
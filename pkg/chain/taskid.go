@@ -0,0 +1,16 @@
+package chain
+
+import "encoding/binary"
+
+// TaskIDToInt64 converts an on-chain bytes8 task ID to the bigint task_id
+// used as the task_data key
+func TaskIDToInt64(taskID [8]byte) int64 {
+	return int64(binary.BigEndian.Uint64(taskID[:]))
+}
+
+// Int64ToTaskID converts a task_data key back to its on-chain bytes8 form
+func Int64ToTaskID(taskID int64) [8]byte {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], uint64(taskID))
+	return id
+}
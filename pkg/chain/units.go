@@ -0,0 +1,21 @@
+package chain
+
+import "math/big"
+
+var gwei = big.NewInt(1e9)
+
+// WeiToGwei converts a wei amount to Gwei, truncating any remainder
+func WeiToGwei(wei *big.Int) *big.Int {
+	if wei == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Quo(wei, gwei)
+}
+
+// GweiToWei converts a Gwei amount to wei
+func GweiToWei(gweiAmount *big.Int) *big.Int {
+	if gweiAmount == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Mul(gweiAmount, gwei)
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// LoadCheckpoint returns the last block a chain listener has fully processed
+// and the hashes of the recent blocks up to it, oldest first
+func LoadCheckpoint(session *gocql.Session, name string) (uint64, []string, bool, error) {
+	var blockNumber int64
+	var blockHash string
+	var recentHashes []string
+	err := session.Query(`
+        SELECT block_number, block_hash, recent_hashes FROM triggerx.sync_checkpoints WHERE name = ?`,
+		name).Scan(&blockNumber, &blockHash, &recentHashes)
+	if err == gocql.ErrNotFound {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("failed to load checkpoint %s: %v", name, err)
+	}
+
+	if len(recentHashes) == 0 && blockHash != "" {
+		recentHashes = []string{blockHash}
+	}
+	return uint64(blockNumber), recentHashes, true, nil
+}
+
+// SaveCheckpoint records that a chain listener has processed up to blockNumber,
+// together with the hashes of the recent blocks up to it
+func SaveCheckpoint(session *gocql.Session, name string, blockNumber uint64, recentHashes []string) error {
+	blockHash := ""
+	if len(recentHashes) > 0 {
+		blockHash = recentHashes[len(recentHashes)-1]
+	}
+	if err := session.Query(`
+        INSERT INTO triggerx.sync_checkpoints (name, block_number, block_hash, recent_hashes, updated_at)
+        VALUES (?, ?, ?, ?, ?)`,
+		name, int64(blockNumber), blockHash, recentHashes, time.Now().UTC()).Exec(); err != nil {
+		return fmt.Errorf("failed to save checkpoint %s: %v", name, err)
+	}
+
+	return nil
+}
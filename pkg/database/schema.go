@@ -1,6 +1,7 @@
 package database
 
 import (
+	"fmt"
 	"github.com/gocql/gocql"
 	"log"
 )
@@ -60,6 +61,7 @@ func InitSchema(session *gocql.Session) error {
 			task_no int,
 			quorum_id bigint,
 			quorum_number int,
+			quorum_numbers blob,
 			quorum_threshold decimal,
 			task_created_block bigint,
 			task_created_tx_hash text,
@@ -68,10 +70,17 @@ func InitSchema(session *gocql.Session) error {
 			task_hash text,
 			task_response_hash text,
 			quorum_keeper_hash text,
+			assigned_keeper text,
 			PRIMARY KEY (task_id)
 		)`).Exec(); err != nil {
 		return err
 	}
+	if err := addColumn(session, "task_data", "quorum_numbers", "blob"); err != nil {
+		return err
+	}
+	if err := addColumn(session, "task_data", "assigned_keeper", "text"); err != nil {
+		return err
+	}
 
 	// Create Quorum_data table
 	if err := session.Query(`
@@ -119,6 +128,197 @@ func InitSchema(session *gocql.Session) error {
 		return err
 	}
 
+	// Create Stake_ledger table. Every transaction writes two rows, a debit on
+	// one account and a credit on its counter account, so amounts sum to zero.
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.stake_ledger (
+			account text,
+			entry_id timeuuid,
+			transaction_id uuid,
+			entry_type text,
+			counter_account text,
+			amount varint,
+			job_id bigint,
+			task_id bigint,
+			memo text,
+			created_at timestamp,
+			PRIMARY KEY (account, entry_id)
+		) WITH CLUSTERING ORDER BY (entry_id DESC)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Ledger_postings table so postings with an idempotency key are written once
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.ledger_postings (
+			idempotency_key text PRIMARY KEY,
+			transaction_id uuid,
+			entry_id timeuuid,
+			created_at timestamp
+		)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Task_validations table for validator decisions on keeper execution reports
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.task_validations (
+			task_id bigint,
+			validation_id timeuuid,
+			keeper text,
+			tx_hash text,
+			valid boolean,
+			reason text,
+			response_hash text,
+			validated_at timestamp,
+			authenticated boolean,
+			PRIMARY KEY (task_id, validation_id)
+		) WITH CLUSTERING ORDER BY (validation_id DESC)`).Exec(); err != nil {
+		return err
+	}
+	if err := addColumn(session, "task_validations", "authenticated", "boolean"); err != nil {
+		return err
+	}
+
+	// Create Task_transactions table so an execution is only counted for one task
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.task_transactions (
+			tx_hash text PRIMARY KEY,
+			task_id bigint,
+			keeper text
+		)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Sync_checkpoints table for chain listeners
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.sync_checkpoints (
+			name text PRIMARY KEY,
+			block_number bigint,
+			block_hash text,
+			recent_hashes list<text>,
+			updated_at timestamp
+		)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Stake_events table for events indexed from TriggerXStakeRegistry
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.stake_events (
+			tx_hash text,
+			log_index int,
+			block_number bigint,
+			block_hash text,
+			event_type text,
+			user_address text,
+			amount varint,
+			reason text,
+			PRIMARY KEY (tx_hash, log_index)
+		)`).Exec(); err != nil {
+		return err
+	}
+	if err := addColumn(session, "stake_events", "block_hash", "text"); err != nil {
+		return err
+	}
+
+	// Create Keeper_events table for keeper lifecycle events indexed from TriggerXServiceManager
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.keeper_events (
+			tx_hash text,
+			log_index int,
+			block_number bigint,
+			event_type text,
+			operator_address text,
+			PRIMARY KEY (tx_hash, log_index)
+		)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Stake_drift table for mismatches between the ledger and on-chain stake
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.stake_drift (
+			user_address text,
+			detected_at timeuuid,
+			user_id bigint,
+			block_number bigint,
+			ledger_amount varint,
+			chain_amount varint,
+			difference varint,
+			PRIMARY KEY (user_address, detected_at)
+		) WITH CLUSTERING ORDER BY (detected_at DESC)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Quorum_snapshots table for operator sets read from OperatorStateRetriever
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.quorum_snapshots (
+			quorum_no int,
+			block_number bigint,
+			operators list<text>,
+			operator_ids list<text>,
+			stakes list<varint>,
+			stake_total varint,
+			taken_at timestamp,
+			PRIMARY KEY (quorum_no, block_number)
+		) WITH CLUSTERING ORDER BY (block_number DESC)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Keeper_registrations table to resume interrupted keeper registrations
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.keeper_registrations (
+			operator_address text PRIMARY KEY,
+			step text,
+			quorum_numbers blob,
+			coordinator_tx text,
+			coordinator_block bigint,
+			service_manager_tx text,
+			keeper_id bigint,
+			updated_at timestamp
+		)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Reward_submissions table for rewards proposals awaiting approval
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.reward_submissions (
+			epoch_start timestamp,
+			duration_seconds bigint,
+			status text,
+			digest text,
+			total varint,
+			proposal text,
+			tx_hash text,
+			updated_at timestamp,
+			PRIMARY KEY (epoch_start, duration_seconds)
+		)`).Exec(); err != nil {
+		return err
+	}
+
+	// Create Peer_registry table for the p2p addresses of nodes that are not keepers
+	if err := session.Query(`
+		CREATE TABLE IF NOT EXISTS triggerx.peer_registry (
+			name text PRIMARY KEY,
+			address text,
+			updated_at timestamp
+		)`).Exec(); err != nil {
+		return err
+	}
+
 	log.Println("Database schema initialized successfully")
 	return nil
-} 
\ No newline at end of file
+} 
+
+// addColumn adds a column to a table created before the column existed
+func addColumn(session *gocql.Session, table, column, columnType string) error {
+	var name string
+	err := session.Query(`
+		SELECT column_name FROM system_schema.columns
+		WHERE keyspace_name = 'triggerx' AND table_name = ? AND column_name = ?`,
+		table, column).Scan(&name)
+	if err == nil {
+		return nil
+	}
+	if err != gocql.ErrNotFound {
+		return err
+	}
+	return session.Query(fmt.Sprintf("ALTER TABLE triggerx.%s ADD %s %s", table, column, columnType)).Exec()
+}
@@ -0,0 +1,241 @@
+package estimator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/trigg3rX/go-backend/pkg/chain"
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+const (
+	// DefaultExecutionGas is used when the target call cannot be simulated
+	DefaultExecutionGas uint64 = 100000
+	// ScriptOverheadGas accounts for fetching and running a job's script
+	ScriptOverheadGas uint64 = 50000
+	// GasMarginPercent is added to the gas estimate for the upper bound
+	GasMarginPercent = 20
+
+	feeHistoryBlocks = 20
+	estimateTimeout  = 15 * time.Second
+)
+
+var (
+	// ErrInvalidJob is returned for jobs that cannot be estimated as given
+	ErrInvalidJob = errors.New("invalid job")
+	// ErrChainUnavailable is returned when the chain's RPC cannot be reached
+	// or fails to answer
+	ErrChainUnavailable = errors.New("chain unavailable")
+)
+
+var (
+	gweiDivisor       = big.NewInt(1e9)
+	rewardPercentiles = []float64{25, 50, 90}
+)
+
+// ChainClient is the subset of ethclient.Client used to estimate costs
+type ChainClient interface {
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+}
+
+// Estimate is the predicted cost of running a job over its whole time frame.
+// Gas prices are in wei, costs are in Gwei to match user stake amounts.
+type Estimate struct {
+	ChainID           int      `json:"chain_id"`
+	Executions        int64    `json:"executions"`
+	GasPerExecution   uint64   `json:"gas_per_execution"`
+	ScriptOverheadGas uint64   `json:"script_overhead_gas"`
+	GasEstimated      bool     `json:"gas_estimated"`
+	GasPriceLow       *big.Int `json:"gas_price_low"`
+	GasPriceExpected  *big.Int `json:"gas_price_expected"`
+	GasPriceHigh      *big.Int `json:"gas_price_high"`
+	MinCost           *big.Int `json:"min_cost"`
+	ExpectedCost      *big.Int `json:"expected_cost"`
+	MaxCost           *big.Int `json:"max_cost"`
+	CostPerExecution  *big.Int `json:"cost_per_execution"`
+}
+
+// Estimator predicts job costs from on-chain gas estimates and fee history
+type Estimator struct {
+	clients map[int]ChainClient
+	mu      sync.Mutex
+}
+
+func NewEstimator() *Estimator {
+	return &Estimator{
+		clients: make(map[int]ChainClient),
+	}
+}
+
+// SetClient overrides the client used for a chain
+func (e *Estimator) SetClient(chainID int, client ChainClient) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clients[chainID] = client
+}
+
+func (e *Estimator) getClient(chainID int) (ChainClient, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if client, exists := e.clients[chainID]; exists {
+		return client, nil
+	}
+	if _, exists := chain.Configs[int64(chainID)]; !exists {
+		return nil, fmt.Errorf("%w: unsupported chain ID: %d", ErrInvalidJob, chainID)
+	}
+
+	client, err := chain.Dial(int64(chainID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChainUnavailable, err)
+	}
+	e.clients[chainID] = client
+	return client, nil
+}
+
+// Executions returns how many times a job runs over its time frame
+func Executions(timeFrame int64, timeInterval int) int64 {
+	if timeInterval <= 0 || timeFrame < int64(timeInterval) {
+		return 1
+	}
+	return timeFrame / int64(timeInterval)
+}
+
+// EstimateJob computes the expected cost of a job and a cost range
+func (e *Estimator) EstimateJob(ctx context.Context, job models.JobData) (*Estimate, error) {
+	if job.TimeFrame <= 0 {
+		return nil, fmt.Errorf("%w: invalid time frame: %d", ErrInvalidJob, job.TimeFrame)
+	}
+	if !common.IsHexAddress(job.ContractAddress) {
+		return nil, fmt.Errorf("%w: invalid contract address: %s", ErrInvalidJob, job.ContractAddress)
+	}
+
+	client, err := e.getClient(job.ChainID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, estimateTimeout)
+	defer cancel()
+
+	estimate := &Estimate{
+		ChainID:    job.ChainID,
+		Executions: Executions(job.TimeFrame, job.TimeInterval),
+	}
+
+	estimate.GasPerExecution, estimate.GasEstimated = estimateExecutionGas(ctx, client, job)
+	if job.ScriptFunction != "" || job.ScriptIpfsUrl != "" {
+		estimate.ScriptOverheadGas = ScriptOverheadGas
+	}
+
+	estimate.GasPriceLow, estimate.GasPriceExpected, estimate.GasPriceHigh, err = gasPrices(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	gas := new(big.Int).SetUint64(estimate.GasPerExecution + estimate.ScriptOverheadGas)
+	maxGas := new(big.Int).Mul(gas, big.NewInt(100+GasMarginPercent))
+	maxGas.Div(maxGas, big.NewInt(100))
+	executions := big.NewInt(estimate.Executions)
+
+	estimate.CostPerExecution = toGwei(new(big.Int).Mul(gas, estimate.GasPriceExpected))
+	estimate.MinCost = toGwei(new(big.Int).Mul(new(big.Int).Mul(gas, estimate.GasPriceLow), executions))
+	estimate.ExpectedCost = toGwei(new(big.Int).Mul(new(big.Int).Mul(gas, estimate.GasPriceExpected), executions))
+	estimate.MaxCost = toGwei(new(big.Int).Mul(new(big.Int).Mul(maxGas, estimate.GasPriceHigh), executions))
+
+	return estimate, nil
+}
+
+// estimateExecutionGas simulates the target call, falling back to a default
+// when the call cannot be encoded or reverts for the user as sender
+func estimateExecutionGas(ctx context.Context, client ChainClient, job models.JobData) (uint64, bool) {
+	calldata, err := chain.EncodeCall(job.TargetFunction, job.Arguments)
+	if err != nil {
+		log.Printf("Cannot encode %s for gas estimation: %v", job.TargetFunction, err)
+		return DefaultExecutionGas, false
+	}
+
+	to := common.HexToAddress(job.ContractAddress)
+	msg := ethereum.CallMsg{
+		To:   &to,
+		Data: calldata,
+	}
+	if common.IsHexAddress(job.UserAddress) {
+		msg.From = common.HexToAddress(job.UserAddress)
+	}
+
+	gas, err := client.EstimateGas(ctx, msg)
+	if err != nil {
+		log.Printf("Gas estimation for %s on %s failed: %v", job.TargetFunction, job.ContractAddress, err)
+		return DefaultExecutionGas, false
+	}
+
+	return gas, true
+}
+
+// gasPrices derives low, expected and high gas prices from recent fee history
+func gasPrices(ctx context.Context, client ChainClient) (*big.Int, *big.Int, *big.Int, error) {
+	history, err := client.FeeHistory(ctx, feeHistoryBlocks, nil, rewardPercentiles)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to fetch fee history: %v", ErrChainUnavailable, err)
+	}
+	if len(history.BaseFee) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: empty fee history", ErrChainUnavailable)
+	}
+
+	// The last entry is the base fee of the next block
+	nextBaseFee := history.BaseFee[len(history.BaseFee)-1]
+	minBaseFee := new(big.Int).Set(nextBaseFee)
+	maxBaseFee := new(big.Int).Set(nextBaseFee)
+	for _, baseFee := range history.BaseFee {
+		if baseFee.Cmp(minBaseFee) < 0 {
+			minBaseFee.Set(baseFee)
+		}
+		if baseFee.Cmp(maxBaseFee) > 0 {
+			maxBaseFee.Set(baseFee)
+		}
+	}
+
+	tips := make([]*big.Int, len(rewardPercentiles))
+	for i := range tips {
+		tips[i] = averageReward(history.Reward, i)
+	}
+
+	low := new(big.Int).Add(minBaseFee, tips[0])
+	expected := new(big.Int).Add(nextBaseFee, tips[1])
+	high := new(big.Int).Add(new(big.Int).Mul(maxBaseFee, big.NewInt(2)), tips[2])
+
+	return low, expected, high, nil
+}
+
+func averageReward(rewards [][]*big.Int, percentile int) *big.Int {
+	sum := new(big.Int)
+	count := int64(0)
+	for _, blockRewards := range rewards {
+		if percentile < len(blockRewards) && blockRewards[percentile] != nil {
+			sum.Add(sum, blockRewards[percentile])
+			count++
+		}
+	}
+	if count == 0 {
+		return sum
+	}
+	return sum.Div(sum, big.NewInt(count))
+}
+
+// toGwei converts wei to Gwei, rounding up so predictions never undershoot
+func toGwei(wei *big.Int) *big.Int {
+	gwei, remainder := new(big.Int).QuoRem(wei, gweiDivisor, new(big.Int))
+	if remainder.Sign() > 0 {
+		gwei.Add(gwei, big.NewInt(1))
+	}
+	return gwei
+}
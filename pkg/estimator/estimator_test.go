@@ -0,0 +1,182 @@
+package estimator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+const testChainID = 17000
+
+// fakeClient answers gas estimates and fee history from fixed values
+type fakeClient struct {
+	gas        uint64
+	gasErr     error
+	history    *ethereum.FeeHistory
+	historyErr error
+}
+
+func (c *fakeClient) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return c.gas, c.gasErr
+}
+
+func (c *fakeClient) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	return c.history, c.historyErr
+}
+
+func gwei(n float64) *big.Int {
+	value, _ := new(big.Float).Mul(big.NewFloat(n), big.NewFloat(1e9)).Int(nil)
+	return value
+}
+
+// testHistory has base fees of 1, 3 and 2 Gwei, the last being the next
+// block's, and average tips of 0.2, 0.3 and 0.6 Gwei
+func testHistory() *ethereum.FeeHistory {
+	return &ethereum.FeeHistory{
+		BaseFee: []*big.Int{gwei(1), gwei(3), gwei(2)},
+		Reward: [][]*big.Int{
+			{gwei(0.1), gwei(0.2), gwei(0.5)},
+			{gwei(0.3), gwei(0.4), gwei(0.7)},
+		},
+	}
+}
+
+func testJob() models.JobData {
+	return models.JobData{
+		ChainID:         testChainID,
+		TimeFrame:       100,
+		TimeInterval:    10,
+		ContractAddress: "0xa5854f4835769c3D84319DcB41cb449f6b858F83",
+		TargetFunction:  "updatePrice(uint256)",
+		Arguments:       []string{"42"},
+	}
+}
+
+func newTestEstimator(client ChainClient) *Estimator {
+	e := NewEstimator()
+	e.SetClient(testChainID, client)
+	return e
+}
+
+func TestEstimateJob(t *testing.T) {
+	e := newTestEstimator(&fakeClient{gas: 50000, history: testHistory()})
+
+	estimate, err := e.EstimateJob(context.Background(), testJob())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate.Executions != 10 || estimate.GasPerExecution != 50000 || !estimate.GasEstimated {
+		t.Fatalf("got %d executions of %d gas (estimated %v), want 10 of 50000",
+			estimate.Executions, estimate.GasPerExecution, estimate.GasEstimated)
+	}
+	// low is the lowest base fee plus the 25th percentile tip, expected the
+	// next base fee plus the median tip and high twice the highest base fee
+	// plus the 90th percentile tip. The maximum cost adds the gas margin.
+	tests := []struct {
+		name string
+		got  *big.Int
+		want *big.Int
+	}{
+		{"low gas price", estimate.GasPriceLow, gwei(1.2)},
+		{"expected gas price", estimate.GasPriceExpected, gwei(2.3)},
+		{"high gas price", estimate.GasPriceHigh, gwei(6.6)},
+		{"cost per execution", estimate.CostPerExecution, big.NewInt(115000)},
+		{"min cost", estimate.MinCost, big.NewInt(600000)},
+		{"expected cost", estimate.ExpectedCost, big.NewInt(1150000)},
+		{"max cost", estimate.MaxCost, big.NewInt(3960000)},
+	}
+	for _, tt := range tests {
+		if tt.got.Cmp(tt.want) != 0 {
+			t.Errorf("got %s %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestEstimateJobFallsBackToDefaultGas(t *testing.T) {
+	e := newTestEstimator(&fakeClient{gasErr: errors.New("execution reverted"), history: testHistory()})
+
+	job := testJob()
+	job.ScriptIpfsUrl = "QmPQcutXx7M4tPR1SkvNbosKcjFTaDxTZsizgKbZnVkA9e"
+	estimate, err := e.EstimateJob(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate.GasEstimated || estimate.GasPerExecution != DefaultExecutionGas {
+		t.Fatalf("got %d gas (estimated %v), want the default %d", estimate.GasPerExecution, estimate.GasEstimated, DefaultExecutionGas)
+	}
+	if estimate.ScriptOverheadGas != ScriptOverheadGas {
+		t.Fatalf("got script overhead %d, want %d", estimate.ScriptOverheadGas, ScriptOverheadGas)
+	}
+}
+
+func TestEstimateJobErrors(t *testing.T) {
+	badTimeFrame := testJob()
+	badTimeFrame.TimeFrame = 0
+	badAddress := testJob()
+	badAddress.ContractAddress = "0x1234"
+	unsupportedChain := testJob()
+	unsupportedChain.ChainID = 1
+
+	tests := []struct {
+		name   string
+		client *fakeClient
+		job    models.JobData
+		want   error
+	}{
+		{"invalid time frame", &fakeClient{history: testHistory()}, badTimeFrame, ErrInvalidJob},
+		{"invalid contract address", &fakeClient{history: testHistory()}, badAddress, ErrInvalidJob},
+		{"unsupported chain", &fakeClient{history: testHistory()}, unsupportedChain, ErrInvalidJob},
+		{"fee history fails", &fakeClient{historyErr: errors.New("connection refused")}, testJob(), ErrChainUnavailable},
+		{"empty fee history", &fakeClient{history: &ethereum.FeeHistory{}}, testJob(), ErrChainUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestEstimator(tt.client).EstimateJob(context.Background(), tt.job)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutions(t *testing.T) {
+	tests := []struct {
+		timeFrame    int64
+		timeInterval int
+		want         int64
+	}{
+		{100, 10, 10},
+		{105, 10, 10},
+		{5, 10, 1},
+		{100, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := Executions(tt.timeFrame, tt.timeInterval); got != tt.want {
+			t.Errorf("Executions(%d, %d) = %d, want %d", tt.timeFrame, tt.timeInterval, got, tt.want)
+		}
+	}
+}
+
+func TestToGweiRoundsUp(t *testing.T) {
+	tests := []struct {
+		wei  int64
+		want int64
+	}{
+		{0, 0},
+		{1, 1},
+		{1e9, 1},
+		{1e9 + 1, 2},
+	}
+	for _, tt := range tests {
+		if got := toGwei(big.NewInt(tt.wei)); got.Int64() != tt.want {
+			t.Errorf("toGwei(%d) = %v, want %d", tt.wei, got, tt.want)
+		}
+	}
+}
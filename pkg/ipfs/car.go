@@ -0,0 +1,170 @@
+package ipfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-varint"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codecs of the blocks a UnixFS file is made of
+const (
+	CodecRaw    = 0x55
+	CodecDagPB  = 0x70
+	maxDAGDepth = 64
+)
+
+var ErrInvalidCAR = errors.New("invalid CAR")
+
+// VerifyCAR checks that a CARv1 holds the complete DAG of root: every block
+// must hash to its CID and every block linked from root must be present.
+// Blocks not reachable from root are ignored.
+func VerifyCAR(root cid.Cid, car []byte) error {
+	blocks, err := readCAR(car)
+	if err != nil {
+		return err
+	}
+	return walk(root, blocks, 0)
+}
+
+// readCAR returns the verified blocks of a CARv1 by multihash
+func readCAR(car []byte) (map[string][]byte, error) {
+	header, rest, err := readSection(car)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+	}
+	// The header is a DAG-CBOR map, a CARv1's holds version 1
+	if !bytes.Contains(header, []byte("\x67version\x01")) {
+		return nil, fmt.Errorf("%w: not a CARv1", ErrInvalidCAR)
+	}
+
+	blocks := make(map[string][]byte)
+	for len(rest) > 0 {
+		var section []byte
+		section, rest, err = readSection(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+		}
+		n, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid block CID: %v", ErrInvalidCAR, err)
+		}
+		data := section[n:]
+
+		sum, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cannot hash block %s: %v", ErrInvalidCAR, c, err)
+		}
+		if !sum.Equals(c) {
+			return nil, fmt.Errorf("%w: block %s does not match its CID", ErrInvalidCAR, c)
+		}
+		blocks[string(c.Hash())] = data
+	}
+	return blocks, nil
+}
+
+// readSection reads a varint length prefixed section
+func readSection(data []byte) ([]byte, []byte, error) {
+	length, n, err := varint.FromUvarint(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid section length: %v", err)
+	}
+	if length == 0 || length > uint64(len(data)-n) {
+		return nil, nil, fmt.Errorf("section of %d bytes overruns the data", length)
+	}
+	return data[n : n+int(length)], data[n+int(length):], nil
+}
+
+// walk checks that the DAG under c is in blocks
+func walk(c cid.Cid, blocks map[string][]byte, depth int) error {
+	if depth > maxDAGDepth {
+		return fmt.Errorf("%w: DAG deeper than %d", ErrInvalidCAR, maxDAGDepth)
+	}
+	data, ok := blocks[string(c.Hash())]
+	if !ok {
+		return fmt.Errorf("%w: block %s is missing", ErrInvalidCAR, c)
+	}
+
+	switch c.Type() {
+	case CodecRaw:
+		return nil
+	case CodecDagPB:
+		links, err := pbLinks(data)
+		if err != nil {
+			return fmt.Errorf("%w: block %s: %v", ErrInvalidCAR, c, err)
+		}
+		for _, link := range links {
+			if err := walk(link, blocks, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: block %s has unsupported codec 0x%x", ErrInvalidCAR, c, c.Type())
+	}
+}
+
+// pbLinks returns the link CIDs of a dag-pb node
+func pbLinks(node []byte) ([]cid.Cid, error) {
+	var links []cid.Cid
+	for len(node) > 0 {
+		field, wireType, n := protowire.ConsumeTag(node)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		node = node[n:]
+
+		if field != 2 || wireType != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(field, wireType, node)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			node = node[n:]
+			continue
+		}
+
+		link, n := protowire.ConsumeBytes(node)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		node = node[n:]
+		c, err := pbLinkHash(link)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, c)
+	}
+	return links, nil
+}
+
+// pbLinkHash returns the Hash field of a dag-pb PBLink
+func pbLinkHash(link []byte) (cid.Cid, error) {
+	for len(link) > 0 {
+		field, wireType, n := protowire.ConsumeTag(link)
+		if n < 0 {
+			return cid.Undef, protowire.ParseError(n)
+		}
+		link = link[n:]
+
+		if field == 1 && wireType == protowire.BytesType {
+			hash, n := protowire.ConsumeBytes(link)
+			if n < 0 {
+				return cid.Undef, protowire.ParseError(n)
+			}
+			_, c, err := cid.CidFromBytes(hash)
+			if err != nil {
+				return cid.Undef, fmt.Errorf("invalid link: %v", err)
+			}
+			return c, nil
+		}
+		n = protowire.ConsumeFieldValue(field, wireType, link)
+		if n < 0 {
+			return cid.Undef, protowire.ParseError(n)
+		}
+		link = link[n:]
+	}
+	return cid.Undef, fmt.Errorf("link without a hash")
+}
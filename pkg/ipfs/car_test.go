@@ -0,0 +1,133 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type block struct {
+	cid  cid.Cid
+	data []byte
+}
+
+func newBlock(t *testing.T, codec uint64, data []byte) block {
+	t.Helper()
+	c, err := cid.Prefix{Version: 1, Codec: codec, MhType: mh.SHA2_256, MhLength: -1}.Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block{cid: c, data: data}
+}
+
+// newFile builds a dag-pb root linking raw leaves, the layout of a chunked
+// UnixFS file
+func newFile(t *testing.T, chunks ...string) (block, []block) {
+	t.Helper()
+	var leaves []block
+	var node []byte
+	for _, chunk := range chunks {
+		leaf := newBlock(t, CodecRaw, []byte(chunk))
+		leaves = append(leaves, leaf)
+
+		var link []byte
+		link = protowire.AppendTag(link, 1, protowire.BytesType)
+		link = protowire.AppendBytes(link, leaf.cid.Bytes())
+		link = protowire.AppendTag(link, 3, protowire.VarintType)
+		link = protowire.AppendVarint(link, uint64(len(chunk)))
+		node = protowire.AppendTag(node, 2, protowire.BytesType)
+		node = protowire.AppendBytes(node, link)
+	}
+	// UnixFS file metadata, which VerifyCAR skips
+	node = protowire.AppendTag(node, 1, protowire.BytesType)
+	node = protowire.AppendBytes(node, []byte{0x08, 0x02})
+	return newBlock(t, CodecDagPB, node), leaves
+}
+
+func section(data []byte) []byte {
+	return append(varint.ToUvarint(uint64(len(data))), data...)
+}
+
+func encodeCAR(blocks ...block) []byte {
+	car := section([]byte("\xa2\x65roots\x80\x67version\x01"))
+	for _, b := range blocks {
+		car = append(car, section(append(b.cid.Bytes(), b.data...))...)
+	}
+	return car
+}
+
+func TestVerifyCAR(t *testing.T) {
+	root, leaves := newFile(t, "console.log(", "'hello')")
+	unrelated := newBlock(t, CodecRaw, []byte("unrelated"))
+	tampered := leaves[1]
+	tampered.data = []byte("'pwned')")
+
+	tests := []struct {
+		name string
+		root cid.Cid
+		car  []byte
+		ok   bool
+	}{
+		{"complete file", root.cid, encodeCAR(root, leaves[0], leaves[1]), true},
+		{"extra blocks", root.cid, encodeCAR(unrelated, root, leaves[0], leaves[1]), true},
+		{"single raw block", leaves[0].cid, encodeCAR(leaves[0]), true},
+		{"tampered block", root.cid, encodeCAR(root, leaves[0], tampered), false},
+		{"missing block", root.cid, encodeCAR(root, leaves[0]), false},
+		{"another root", unrelated.cid, encodeCAR(root, leaves[0], leaves[1]), false},
+		{"truncated", root.cid, encodeCAR(root, leaves[0], leaves[1])[:40], false},
+		{"CARv2", leaves[0].cid, append(section([]byte("\xa1\x67version\x02")), section(append(leaves[0].cid.Bytes(), leaves[0].data...))...), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyCAR(tt.root, tt.car)
+			if tt.ok && err != nil {
+				t.Fatalf("expected a valid CAR, got %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidCAR) {
+				t.Fatalf("expected ErrInvalidCAR, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFetchCARVerifiesGatewayContent(t *testing.T) {
+	root, leaves := newFile(t, "console.log(", "'hello')")
+	tampered := leaves[1]
+	tampered.data = []byte("'pwned')")
+
+	car := encodeCAR(root, leaves[0], leaves[1])
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ipfs/"+root.cid.String() || r.URL.Query().Get("format") != "car" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(car)
+	}))
+	defer gateway.Close()
+
+	client := NewClient(gateway.URL)
+	got, err := client.FetchCAR(context.Background(), "ipfs://"+root.cid.String(), root.cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(car) {
+		t.Fatal("fetched CAR differs from the served one")
+	}
+
+	// A gateway URL is asked for the CAR itself
+	if _, err := client.FetchCAR(context.Background(), gateway.URL+"/ipfs/"+root.cid.String(), root.cid); err != nil {
+		t.Fatal(err)
+	}
+
+	car = encodeCAR(root, leaves[0], tampered)
+	if _, err := client.FetchCAR(context.Background(), "ipfs://"+root.cid.String(), root.cid); !errors.Is(err, ErrInvalidCAR) {
+		t.Fatalf("expected ErrInvalidCAR from a tampering gateway, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	// DefaultGateway serves scripts published under an ipfs:// URL
+	DefaultGateway = "https://ipfs.io"
+	// MaxCARSize bounds the size of a fetched CAR
+	MaxCARSize = 32 << 20
+)
+
+// GatewayFromEnv returns the gateway set in TRIGGERX_IPFS_GATEWAY, or
+// DefaultGateway
+func GatewayFromEnv() string {
+	if gateway := os.Getenv("TRIGGERX_IPFS_GATEWAY"); gateway != "" {
+		return strings.TrimSuffix(gateway, "/")
+	}
+	return DefaultGateway
+}
+
+// CARURL returns where the CAR of a CID published at rawURL is fetched
+// from. HTTP gateway URLs are asked for the CAR themselves, other URLs go
+// through gateway.
+func CARURL(rawURL string, root cid.Cid, gateway string) string {
+	u, err := url.Parse(rawURL)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") && strings.Contains(u.Path, "/ipfs/") {
+		return fmt.Sprintf("%s://%s/ipfs/%s?format=car", u.Scheme, u.Host, root)
+	}
+	return fmt.Sprintf("%s/ipfs/%s?format=car", gateway, root)
+}
+
+// Client fetches content from IPFS gateways. Content is verified against
+// its CID, so gateways need not be trusted.
+type Client struct {
+	gateway string
+	http    *http.Client
+}
+
+func NewClient(gateway string) *Client {
+	return &Client{gateway: gateway, http: &http.Client{Timeout: 2 * time.Minute}}
+}
+
+// FetchCAR fetches the CAR of root published at rawURL and verifies it
+func (c *Client) FetchCAR(ctx context.Context, rawURL string, root cid.Cid) ([]byte, error) {
+	carURL := CARURL(rawURL, root, c.gateway)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, carURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car;version=1")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", root, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", carURL, resp.Status)
+	}
+
+	car, err := io.ReadAll(io.LimitReader(resp.Body, MaxCARSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", root, err)
+	}
+	if len(car) > MaxCARSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", root, MaxCARSize)
+	}
+	if err := VerifyCAR(root, car); err != nil {
+		return nil, err
+	}
+	return car, nil
+}
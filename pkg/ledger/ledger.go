@@ -0,0 +1,341 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+// Entry types recorded in the stake ledger
+const (
+	EntryDeposit      = "deposit"
+	EntryWithdrawal   = "withdrawal"
+	EntryExecutionFee = "execution_fee"
+	EntryRefund       = "refund"
+	EntrySlash        = "slash"
+)
+
+// System accounts that balance the user and keeper accounts
+const (
+	ExternalAccount = "system:external"
+	SlashedAccount  = "system:slashed"
+)
+
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// Posting moves amount from one account to another. It is stored as a debit
+// row on From and a credit row on To sharing one transaction ID.
+type Posting struct {
+	EntryType string
+	From      string
+	To        string
+	Amount    *big.Int
+	JobID     int64
+	TaskID    int64
+	Memo      string
+	// IdempotencyKey makes retrying a posting safe, a posting with a key
+	// that was posted before writes the same entries again
+	IdempotencyKey string
+}
+
+// Ledger is a double-entry record of stake movements. Balances are never
+// stored, they are derived by summing an account's entries.
+type Ledger struct {
+	store Store
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewLedger(db *database.Connection) *Ledger {
+	return NewLedgerWithStore(NewDBStore(db))
+}
+
+func NewLedgerWithStore(store Store) *Ledger {
+	return &Ledger{store: store, locks: make(map[string]*sync.Mutex)}
+}
+
+func UserAccount(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func KeeperAccount(keeper string) string {
+	return "keeper:" + strings.ToLower(keeper)
+}
+
+// Post writes both sides of a posting in a single logged batch
+func (l *Ledger) Post(p Posting) (gocql.UUID, error) {
+	if p.Amount == nil || p.Amount.Sign() <= 0 {
+		return gocql.UUID{}, fmt.Errorf("ledger amount must be positive")
+	}
+	if p.From == p.To {
+		return gocql.UUID{}, fmt.Errorf("cannot post from %s to itself", p.From)
+	}
+
+	transactionID, err := gocql.RandomUUID()
+	if err != nil {
+		return gocql.UUID{}, fmt.Errorf("failed to generate transaction ID: %v", err)
+	}
+	now := time.Now().UTC()
+	reservation := Reservation{TransactionID: transactionID, EntryID: gocql.UUIDFromTime(now), CreatedAt: now}
+
+	if p.IdempotencyKey != "" {
+		// Entries are keyed by account and entry ID, so writing them again
+		// with the reserved IDs overwrites rather than duplicates them
+		reservation, err = l.store.Reserve(p.IdempotencyKey, reservation)
+		if err != nil {
+			return gocql.UUID{}, err
+		}
+		transactionID = reservation.TransactionID
+	}
+
+	entry := func(account, counter string, amount *big.Int) models.LedgerEntry {
+		return models.LedgerEntry{
+			Account:        account,
+			EntryID:        reservation.EntryID.String(),
+			TransactionID:  transactionID.String(),
+			EntryType:      p.EntryType,
+			CounterAccount: counter,
+			Amount:         amount,
+			JobID:          p.JobID,
+			TaskID:         p.TaskID,
+			Memo:           p.Memo,
+			CreatedAt:      reservation.CreatedAt,
+		}
+	}
+	if err := l.store.Write([]models.LedgerEntry{
+		entry(p.From, p.To, new(big.Int).Neg(p.Amount)),
+		entry(p.To, p.From, new(big.Int).Set(p.Amount)),
+	}); err != nil {
+		return gocql.UUID{}, err
+	}
+
+	log.Printf("Ledger %s: %v from %s to %s (tx %s)", p.EntryType, p.Amount, p.From, p.To, transactionID)
+	return transactionID, nil
+}
+
+// Deposit credits a user with newly staked funds, once per key
+func (l *Ledger) Deposit(userID int64, amount *big.Int, key, memo string) (gocql.UUID, error) {
+	return l.Post(Posting{
+		EntryType:      EntryDeposit,
+		From:           ExternalAccount,
+		To:             UserAccount(userID),
+		Amount:         amount,
+		Memo:           memo,
+		IdempotencyKey: key,
+	})
+}
+
+// Withdraw debits a user for stake that left the stake registry, once per key
+func (l *Ledger) Withdraw(userID int64, amount *big.Int, key, memo string) (gocql.UUID, error) {
+	return l.Post(Posting{
+		EntryType:      EntryWithdrawal,
+		From:           UserAccount(userID),
+		To:             ExternalAccount,
+		Amount:         amount,
+		Memo:           memo,
+		IdempotencyKey: key,
+	})
+}
+
+// ChargeExecution debits a user for one execution and credits the keeper
+// that ran it. The balance check and the posting hold the user's account
+// lock, so concurrent charges through this Ledger cannot overdraw it. key
+// identifies the execution, charging it again posts nothing new.
+func (l *Ledger) ChargeExecution(userID int64, keeper string, amount *big.Int, jobID, taskID int64, key string) (gocql.UUID, error) {
+	account := UserAccount(userID)
+	unlock := l.lockAccount(account)
+	defer unlock()
+
+	if err := l.ensureBalance(account, amount); err != nil {
+		return gocql.UUID{}, err
+	}
+
+	return l.Post(Posting{
+		EntryType:      EntryExecutionFee,
+		From:           account,
+		To:             KeeperAccount(keeper),
+		Amount:         amount,
+		JobID:          jobID,
+		TaskID:         taskID,
+		IdempotencyKey: key,
+	})
+}
+
+// lockAccount serializes debits of an account and returns its unlock
+func (l *Ledger) lockAccount(account string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[account]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[account] = lock
+	}
+	l.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// Refund returns an execution fee from a keeper to the user, once per key
+func (l *Ledger) Refund(userID int64, keeper string, amount *big.Int, jobID, taskID int64, memo, key string) (gocql.UUID, error) {
+	return l.Post(Posting{
+		EntryType:      EntryRefund,
+		From:           KeeperAccount(keeper),
+		To:             UserAccount(userID),
+		Amount:         amount,
+		JobID:          jobID,
+		TaskID:         taskID,
+		Memo:           memo,
+		IdempotencyKey: key,
+	})
+}
+
+// Slash removes up to amount of a keeper's earned fees, once per key. It
+// returns the amount slashed, which is less than asked when the keeper has
+// not earned that much.
+func (l *Ledger) Slash(keeper string, amount *big.Int, taskID int64, reason, key string) (*big.Int, error) {
+	account := KeeperAccount(keeper)
+	unlock := l.lockAccount(account)
+	defer unlock()
+
+	balance, err := l.Balance(account)
+	if err != nil {
+		return nil, err
+	}
+	slashed := new(big.Int).Set(amount)
+	if balance.Cmp(slashed) < 0 {
+		slashed.Set(balance)
+	}
+	if slashed.Sign() <= 0 {
+		return new(big.Int), nil
+	}
+
+	if _, err := l.Post(Posting{
+		EntryType:      EntrySlash,
+		From:           account,
+		To:             SlashedAccount,
+		Amount:         slashed,
+		TaskID:         taskID,
+		Memo:           reason,
+		IdempotencyKey: key,
+	}); err != nil {
+		return nil, err
+	}
+	return slashed, nil
+}
+
+// Balance sums all entries of an account
+func (l *Ledger) Balance(account string) (*big.Int, error) {
+	entries, err := l.store.Entries(account, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := new(big.Int)
+	for _, entry := range entries {
+		if entry.Amount != nil {
+			balance.Add(balance, entry.Amount)
+		}
+	}
+	return balance, nil
+}
+
+// Funding returns a user's deposits minus withdrawals, which should match
+// the stake held for them on-chain
+func (l *Ledger) Funding(userID int64) (*big.Int, error) {
+	entries, err := l.store.Entries(UserAccount(userID), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	funding := new(big.Int)
+	for _, entry := range entries {
+		if entry.Amount != nil && (entry.EntryType == EntryDeposit || entry.EntryType == EntryWithdrawal) {
+			funding.Add(funding, entry.Amount)
+		}
+	}
+	return funding, nil
+}
+
+func (l *Ledger) UserBalance(userID int64) (*big.Int, error) {
+	return l.Balance(UserAccount(userID))
+}
+
+// CanCover reports whether a user's balance covers the given cost
+func (l *Ledger) CanCover(userID int64, cost *big.Int) (bool, error) {
+	balance, err := l.UserBalance(userID)
+	if err != nil {
+		return false, err
+	}
+	return balance.Cmp(cost) >= 0, nil
+}
+
+func (l *Ledger) ensureBalance(account string, amount *big.Int) error {
+	balance, err := l.Balance(account)
+	if err != nil {
+		return err
+	}
+	if balance.Cmp(amount) < 0 {
+		return fmt.Errorf("%w: %s has %v, needs %v", ErrInsufficientBalance, account, balance, amount)
+	}
+	return nil
+}
+
+// Entries returns the most recent entries of an account
+func (l *Ledger) Entries(account string, limit int) ([]models.LedgerEntry, error) {
+	return l.store.Entries(account, limit)
+}
+
+// OpenAccount records a user's pre-ledger stake as an opening deposit, so
+// users created before the ledger existed keep their balance. Accounts with
+// entries are left alone. It reports whether a deposit was posted.
+func (l *Ledger) OpenAccount(userID int64, existingStake *big.Int) (bool, error) {
+	if existingStake == nil || existingStake.Sign() <= 0 {
+		return false, nil
+	}
+
+	entries, err := l.store.Entries(UserAccount(userID), 1)
+	if err != nil {
+		return false, err
+	}
+	if len(entries) > 0 {
+		return false, nil
+	}
+
+	if _, err := l.Deposit(userID, existingStake, fmt.Sprintf("opening:%d", userID), "opening balance"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SyncUserStake writes the ledger balance back to user_data.stake_amount
+func (l *Ledger) SyncUserStake(userID int64) (*big.Int, error) {
+	balance, err := l.UserBalance(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.store.SetUserStake(userID, balance); err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// PauseJob marks a job inactive once its owner can no longer pay for it
+func (l *Ledger) PauseJob(jobID int64) error {
+	if err := l.store.PauseJob(jobID); err != nil {
+		return err
+	}
+
+	log.Printf("Paused job %d: balance cannot cover the next execution", jobID)
+	return nil
+}
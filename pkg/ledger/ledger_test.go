@@ -0,0 +1,166 @@
+package ledger
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+)
+
+func newTestLedger() (*Ledger, *MemoryStore) {
+	store := NewMemoryStore()
+	return NewLedgerWithStore(store), store
+}
+
+func assertBalance(t *testing.T, l *Ledger, account string, want int64) {
+	t.Helper()
+	balance, err := l.Balance(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cmp(big.NewInt(want)) != 0 {
+		t.Fatalf("%s has balance %v, want %d", account, balance, want)
+	}
+}
+
+func TestPostIsIdempotent(t *testing.T) {
+	l, store := newTestLedger()
+
+	first, err := l.Deposit(1, big.NewInt(100), "staked:0xabc:0", "stake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.Deposit(1, big.NewInt(100), "staked:0xabc:0", "stake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("retried posting got transaction %s, want %s", second, first)
+	}
+
+	assertBalance(t, l, UserAccount(1), 100)
+	assertBalance(t, l, ExternalAccount, -100)
+	entries, _ := store.Entries(UserAccount(1), 0)
+	if len(entries) != 1 {
+		t.Fatalf("expected one entry after posting twice, got %d", len(entries))
+	}
+
+	// A different key is a different posting
+	if _, err := l.Deposit(1, big.NewInt(50), "staked:0xabc:1", "stake"); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, l, UserAccount(1), 150)
+}
+
+func TestChargeExecutionRejectsOverdraft(t *testing.T) {
+	l, _ := newTestLedger()
+	if _, err := l.Deposit(1, big.NewInt(10), "deposit", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := l.ChargeExecution(1, "0xKeeper", big.NewInt(11), 7, 70, "execution:0x1")
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance, got %v", err)
+	}
+	assertBalance(t, l, UserAccount(1), 10)
+	assertBalance(t, l, KeeperAccount("0xkeeper"), 0)
+}
+
+func TestConcurrentChargesCannotOverdraw(t *testing.T) {
+	l, _ := newTestLedger()
+	if _, err := l.Deposit(1, big.NewInt(100), "deposit", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// 25 executions of 10 against a balance of 100, only 10 can be paid
+	const charges = 25
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	charged, rejected := 0, 0
+	for i := 0; i < charges; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := l.ChargeExecution(1, "0xkeeper", big.NewInt(10), 7, int64(i), "execution:"+big.NewInt(int64(i)).String())
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				charged++
+			case errors.Is(err, ErrInsufficientBalance):
+				rejected++
+			default:
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if charged != 10 || rejected != charges-10 {
+		t.Fatalf("charged %d and rejected %d executions, want 10 and %d", charged, rejected, charges-10)
+	}
+	assertBalance(t, l, UserAccount(1), 0)
+	assertBalance(t, l, KeeperAccount("0xkeeper"), 100)
+}
+
+func TestChargeExecutionIsIdempotent(t *testing.T) {
+	l, _ := newTestLedger()
+	if _, err := l.Deposit(1, big.NewInt(100), "deposit", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.ChargeExecution(1, "0xkeeper", big.NewInt(30), 7, 70, "execution:0x1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertBalance(t, l, UserAccount(1), 70)
+	assertBalance(t, l, KeeperAccount("0xkeeper"), 30)
+}
+
+func TestRefundAndSlash(t *testing.T) {
+	l, _ := newTestLedger()
+	if _, err := l.Deposit(1, big.NewInt(100), "deposit", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.ChargeExecution(1, "0xkeeper", big.NewInt(40), 7, 70, "execution:0x1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := l.Refund(1, "0xkeeper", big.NewInt(40), 7, 70, "no task response", "refund:0x1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertBalance(t, l, UserAccount(1), 100)
+	assertBalance(t, l, KeeperAccount("0xkeeper"), 0)
+
+	if _, err := l.ChargeExecution(1, "0xkeeper", big.NewInt(25), 7, 71, "execution:0x2"); err != nil {
+		t.Fatal(err)
+	}
+	// Only what the keeper earned can be slashed
+	slashed, err := l.Slash("0xkeeper", big.NewInt(40), 72, "invalid execution", "slash:0x3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slashed.Cmp(big.NewInt(25)) != 0 {
+		t.Fatalf("slashed %v, want 25", slashed)
+	}
+	assertBalance(t, l, KeeperAccount("0xkeeper"), 0)
+	assertBalance(t, l, SlashedAccount, 25)
+}
+
+func TestOpenAccount(t *testing.T) {
+	l, _ := newTestLedger()
+
+	opened, err := l.OpenAccount(1, big.NewInt(500))
+	if err != nil || !opened {
+		t.Fatalf("expected the account to be opened, got %v, %v", opened, err)
+	}
+	// Running the migration again leaves the account alone
+	opened, err = l.OpenAccount(1, big.NewInt(800))
+	if err != nil || opened {
+		t.Fatalf("expected the opened account to be left alone, got %v, %v", opened, err)
+	}
+	assertBalance(t, l, UserAccount(1), 500)
+}
@@ -0,0 +1,101 @@
+package ledger
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/gocql/gocql"
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+// MemoryStore keeps the ledger in memory, for tests and tools that run
+// without a database
+type MemoryStore struct {
+	mu           sync.Mutex
+	reservations map[string]Reservation
+	entries      map[string]map[string]models.LedgerEntry // account -> entry ID -> entry
+	UserStakes   map[int64]*big.Int
+	PausedJobs   map[int64]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		reservations: make(map[string]Reservation),
+		entries:      make(map[string]map[string]models.LedgerEntry),
+		UserStakes:   make(map[int64]*big.Int),
+		PausedJobs:   make(map[int64]bool),
+	}
+}
+
+func (s *MemoryStore) Reserve(key string, reservation Reservation) (Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.reservations[key]; ok {
+		return existing, nil
+	}
+	s.reservations[key] = reservation
+	return reservation, nil
+}
+
+func (s *MemoryStore) Write(entries []models.LedgerEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range entries {
+		account, ok := s.entries[entry.Account]
+		if !ok {
+			account = make(map[string]models.LedgerEntry)
+			s.entries[entry.Account] = account
+		}
+		account[entry.EntryID] = entry
+	}
+	return nil
+}
+
+func (s *MemoryStore) Entries(account string, limit int) ([]models.LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]models.LedgerEntry, 0, len(s.entries[account]))
+	for _, entry := range s.entries[account] {
+		entries = append(entries, entry)
+	}
+	// Newest first, like the clustering order of stake_ledger
+	sort.Slice(entries, func(i, j int) bool {
+		return timeUUIDAfter(entries[i].EntryID, entries[j].EntryID)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) SetUserStake(userID int64, stake *big.Int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.UserStakes[userID] = new(big.Int).Set(stake)
+	return nil
+}
+
+func (s *MemoryStore) PauseJob(jobID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.PausedJobs[jobID] = true
+	return nil
+}
+
+// timeUUIDAfter orders time UUIDs by time, then by their bytes
+func timeUUIDAfter(a, b string) bool {
+	ua, errA := gocql.ParseUUID(a)
+	ub, errB := gocql.ParseUUID(b)
+	if errA != nil || errB != nil {
+		return a > b
+	}
+	if !ua.Time().Equal(ub.Time()) {
+		return ua.Time().After(ub.Time())
+	}
+	return bytes.Compare(ua.Bytes(), ub.Bytes()) > 0
+}
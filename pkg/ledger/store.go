@@ -0,0 +1,136 @@
+package ledger
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/trigg3rX/go-backend/pkg/database"
+	"github.com/trigg3rX/go-backend/pkg/models"
+)
+
+// Reservation holds the IDs a keyed posting is written with
+type Reservation struct {
+	TransactionID gocql.UUID
+	EntryID       gocql.UUID
+	CreatedAt     time.Time
+}
+
+// Store persists ledger entries and the rows derived from balances
+type Store interface {
+	// Reserve stores the IDs of a keyed posting unless the key was reserved
+	// before, and returns the IDs stored under the key
+	Reserve(key string, reservation Reservation) (Reservation, error)
+	// Write stores the entries of one transaction atomically. Entries are
+	// keyed by account and entry ID, writing one again overwrites it.
+	Write(entries []models.LedgerEntry) error
+	// Entries returns an account's entries newest first, all of them when
+	// limit is 0
+	Entries(account string, limit int) ([]models.LedgerEntry, error)
+	SetUserStake(userID int64, stake *big.Int) error
+	PauseJob(jobID int64) error
+}
+
+// DBStore keeps the ledger in stake_ledger and ledger_postings
+type DBStore struct {
+	db *database.Connection
+}
+
+func NewDBStore(db *database.Connection) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Reserve(key string, reservation Reservation) (Reservation, error) {
+	existing := make(map[string]interface{})
+	applied, err := s.db.Session().Query(`
+        INSERT INTO triggerx.ledger_postings (idempotency_key, transaction_id, entry_id, created_at)
+        VALUES (?, ?, ?, ?) IF NOT EXISTS`,
+		key, reservation.TransactionID, reservation.EntryID, reservation.CreatedAt).MapScanCAS(existing)
+	if err != nil {
+		return Reservation{}, fmt.Errorf("failed to reserve posting %s: %v", key, err)
+	}
+	if applied {
+		return reservation, nil
+	}
+
+	return Reservation{
+		TransactionID: existing["transaction_id"].(gocql.UUID),
+		EntryID:       existing["entry_id"].(gocql.UUID),
+		CreatedAt:     existing["created_at"].(time.Time),
+	}, nil
+}
+
+func (s *DBStore) Write(entries []models.LedgerEntry) error {
+	batch := s.db.Session().NewBatch(gocql.LoggedBatch)
+	for _, entry := range entries {
+		entryID, err := gocql.ParseUUID(entry.EntryID)
+		if err != nil {
+			return fmt.Errorf("invalid entry ID %s: %v", entry.EntryID, err)
+		}
+		transactionID, err := gocql.ParseUUID(entry.TransactionID)
+		if err != nil {
+			return fmt.Errorf("invalid transaction ID %s: %v", entry.TransactionID, err)
+		}
+		batch.Query(`
+        INSERT INTO triggerx.stake_ledger (
+            account, entry_id, transaction_id, entry_type, counter_account,
+            amount, job_id, task_id, memo, created_at
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.Account, entryID, transactionID, entry.EntryType, entry.CounterAccount,
+			entry.Amount, entry.JobID, entry.TaskID, entry.Memo, entry.CreatedAt)
+	}
+
+	if err := s.db.Session().ExecuteBatch(batch); err != nil {
+		return fmt.Errorf("failed to write ledger entries: %v", err)
+	}
+	return nil
+}
+
+func (s *DBStore) Entries(account string, limit int) ([]models.LedgerEntry, error) {
+	query := `
+        SELECT account, entry_id, transaction_id, entry_type, counter_account,
+               amount, job_id, task_id, memo, created_at
+        FROM triggerx.stake_ledger WHERE account = ?`
+	values := []interface{}{account}
+	if limit > 0 {
+		query += " LIMIT ?"
+		values = append(values, limit)
+	}
+	iter := s.db.Session().Query(query, values...).Iter()
+
+	var entries []models.LedgerEntry
+	var entry models.LedgerEntry
+	var entryID, transactionID gocql.UUID
+	for iter.Scan(&entry.Account, &entryID, &transactionID, &entry.EntryType, &entry.CounterAccount,
+		&entry.Amount, &entry.JobID, &entry.TaskID, &entry.Memo, &entry.CreatedAt) {
+		entry.EntryID = entryID.String()
+		entry.TransactionID = transactionID.String()
+		entries = append(entries, entry)
+		entry = models.LedgerEntry{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger entries for %s: %v", account, err)
+	}
+
+	return entries, nil
+}
+
+func (s *DBStore) SetUserStake(userID int64, stake *big.Int) error {
+	if err := s.db.Session().Query(`
+        UPDATE triggerx.user_data SET stake_amount = ? WHERE user_id = ?`,
+		stake, userID).Exec(); err != nil {
+		return fmt.Errorf("failed to update stake for user %d: %v", userID, err)
+	}
+	return nil
+}
+
+func (s *DBStore) PauseJob(jobID int64) error {
+	if err := s.db.Session().Query(`
+        UPDATE triggerx.job_data SET status = ? WHERE job_id = ?`,
+		false, jobID).Exec(); err != nil {
+		return fmt.Errorf("failed to pause job %d: %v", jobID, err)
+	}
+	return nil
+}
@@ -45,6 +45,7 @@ type TaskData struct {
     TaskHash            string  `json:"task_hash"`
     TaskResponseHash    string  `json:"task_response_hash"`
     QuorumKeeperHash    string  `json:"quorum_keeper_hash"`
+    AssignedKeeper      string  `json:"assigned_keeper"`
 }
 
 type QuorumData struct {
@@ -79,4 +80,37 @@ type TaskHistory struct {
     ConsensusMethod  string   `json:"consensus_method"`
     ValidationStatus bool     `json:"validation_status"`
     TxHash          string   `json:"tx_hash"`
-} 
\ No newline at end of file
+} 
+type LedgerEntry struct {
+    Account        string    `json:"account"`
+    EntryID        string    `json:"entry_id"`
+    TransactionID  string    `json:"transaction_id"`
+    EntryType      string    `json:"entry_type"`
+    CounterAccount string    `json:"counter_account"`
+    Amount         *big.Int  `json:"amount"`
+    JobID          int64     `json:"job_id"`
+    TaskID         int64     `json:"task_id"`
+    Memo           string    `json:"memo"`
+    CreatedAt      time.Time `json:"created_at"`
+}
+
+type ExecutionReport struct {
+    TaskID     int64     `json:"task_id"`
+    JobID      int64     `json:"job_id"`
+    Keeper     string    `json:"keeper"`
+    TxHash     string    `json:"tx_hash"`
+    ExecutedAt time.Time `json:"executed_at"`
+    // Signature is the keeper's operator key signature of
+    // chain.ExecutionReportHash
+    Signature  string    `json:"signature"`
+}
+
+type TaskValidation struct {
+    TaskID       int64     `json:"task_id"`
+    Keeper       string    `json:"keeper"`
+    TxHash       string    `json:"tx_hash"`
+    Valid        bool      `json:"valid"`
+    Reason       string    `json:"reason"`
+    ResponseHash string    `json:"response_hash"`
+    ValidatedAt  time.Time `json:"validated_at"`
+}
@@ -0,0 +1,409 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/trigg3rX/go-backend/pkg/bls"
+)
+
+// Message signature schemes
+const (
+	// SchemeECDSA signs with an operator's Ethereum key, the signer is the
+	// operator address
+	SchemeECDSA = "ecdsa"
+	// SchemeBLS signs with an operator's BLS key, the signer is the
+	// operator ID
+	SchemeBLS = "bls"
+)
+
+const (
+	// MaxMessageTTL caps how long a signed message stays valid
+	MaxMessageTTL = 5 * time.Minute
+	// DefaultMessageTTL is the validity given to messages that are sent
+	DefaultMessageTTL = time.Minute
+	// MaxClockSkew is how far in the future a message timestamp may be
+	MaxClockSkew = 30 * time.Second
+)
+
+// ErrUnauthenticated is returned for messages whose signature, signer or
+// freshness does not check out
+var ErrUnauthenticated = errors.New("message not authenticated")
+
+// MessageAuth authenticates a Message. The signature covers the message
+// fields, the content as sent, the nonce and the expiry.
+type MessageAuth struct {
+	Scheme    string `json:"scheme"`
+	Signer    string `json:"signer"`
+	Nonce     string `json:"nonce"`
+	Expiry    int64  `json:"expiry"`
+	PubkeyG1  string `json:"pubkey_g1,omitempty"`
+	PubkeyG2  string `json:"pubkey_g2,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// MessageSigner signs outgoing messages
+type MessageSigner interface {
+	// Sign fills in the scheme, signer, public keys and signature of auth
+	// for a message digest
+	Sign(digest [32]byte, auth *MessageAuth) error
+}
+
+// ECDSASigner signs with an operator's Ethereum key
+type ECDSASigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func NewECDSASigner(key *ecdsa.PrivateKey) *ECDSASigner {
+	return &ECDSASigner{key: key}
+}
+
+func (s *ECDSASigner) Sign(digest [32]byte, auth *MessageAuth) error {
+	signature, err := crypto.Sign(digest[:], s.key)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %v", err)
+	}
+	auth.Scheme = SchemeECDSA
+	auth.Signer = crypto.PubkeyToAddress(s.key.PublicKey).Hex()
+	auth.Signature = hexutil.Encode(signature)
+	return nil
+}
+
+// BLSSigner signs with an operator's BLS key
+type BLSSigner struct {
+	keys *bls.KeyPair
+}
+
+func NewBLSSigner(keys *bls.KeyPair) *BLSSigner {
+	return &BLSSigner{keys: keys}
+}
+
+func (s *BLSSigner) Sign(digest [32]byte, auth *MessageAuth) error {
+	operatorID := bls.OperatorID(s.keys.PubG1)
+	auth.Scheme = SchemeBLS
+	auth.Signer = hexutil.Encode(operatorID[:])
+	auth.PubkeyG1 = hexutil.Encode(s.keys.PubG1.Bytes())
+	auth.PubkeyG2 = hexutil.Encode(s.keys.PubG2.Bytes())
+	auth.Signature = hexutil.Encode(s.keys.SignMessage(digest).Bytes())
+	return nil
+}
+
+// signMessage adds a fresh nonce and expiry to a message and signs it
+func signMessage(signer MessageSigner, msg *Message, content json.RawMessage) error {
+	auth, err := newMessageAuth()
+	if err != nil {
+		return err
+	}
+	if err := signer.Sign(messageDigest(msg, content, auth), auth); err != nil {
+		return err
+	}
+	msg.Auth = auth
+	return nil
+}
+
+// signTopicMessage signs a topic message whose ID is set
+func signTopicMessage(signer MessageSigner, msg *TopicMessage) error {
+	auth, err := newMessageAuth()
+	if err != nil {
+		return err
+	}
+	if err := signer.Sign(topicMessageDigest(msg, auth), auth); err != nil {
+		return err
+	}
+	msg.Auth = auth
+	return nil
+}
+
+// signTransferRequest signs a transfer request sent from requester to server
+func signTransferRequest(signer MessageSigner, request *transferRequest, requester, server peer.ID) error {
+	auth, err := newMessageAuth()
+	if err != nil {
+		return err
+	}
+	if err := signer.Sign(transferDigest(request, requester, server, auth), auth); err != nil {
+		return err
+	}
+	request.Auth = auth
+	return nil
+}
+
+// newMessageAuth starts a MessageAuth with a fresh nonce and expiry
+func newMessageAuth() (*MessageAuth, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to create nonce: %v", err)
+	}
+	return &MessageAuth{
+		Nonce:  hexutil.Encode(nonce),
+		Expiry: time.Now().Add(DefaultMessageTTL).Unix(),
+	}, nil
+}
+
+// messageDigest hashes what a message signature covers
+func messageDigest(msg *Message, content json.RawMessage, auth *MessageAuth) [32]byte {
+	var digest [32]byte
+	copy(digest[:], crypto.Keccak256(
+		[]byte(MessageProtocol), []byte{0},
+		[]byte(msg.From), []byte{0},
+		[]byte(msg.To), []byte{0},
+		[]byte(msg.Type), []byte{0},
+		[]byte(msg.Timestamp), []byte{0},
+		[]byte(auth.Nonce), []byte{0},
+		[]byte(fmt.Sprint(auth.Expiry)), []byte{0},
+		content,
+	))
+	return digest
+}
+
+// topicMessageDigest hashes what a topic message signature covers
+func topicMessageDigest(msg *TopicMessage, auth *MessageAuth) [32]byte {
+	var digest [32]byte
+	copy(digest[:], crypto.Keccak256(
+		[]byte(PubSubProtocol), []byte{0},
+		[]byte(msg.ID), []byte{0},
+		[]byte(auth.Nonce), []byte{0},
+		[]byte(fmt.Sprint(auth.Expiry)),
+	))
+	return digest
+}
+
+// transferDigest hashes what a transfer request signature covers. It names
+// the peers at both ends, so another peer cannot replay the request and it
+// cannot be replayed to another server.
+func transferDigest(request *transferRequest, requester, server peer.ID, auth *MessageAuth) [32]byte {
+	var digest [32]byte
+	copy(digest[:], crypto.Keccak256(
+		[]byte(TransferProtocol), []byte{0},
+		[]byte(request.From), []byte{0},
+		[]byte(request.Key), []byte{0},
+		[]byte(request.Timestamp), []byte{0},
+		[]byte(requester), []byte{0},
+		[]byte(server), []byte{0},
+		[]byte(auth.Nonce), []byte{0},
+		[]byte(fmt.Sprint(auth.Expiry)),
+	))
+	return digest
+}
+
+// OperatorSet tells whether a signer is a registered operator
+type OperatorSet interface {
+	// IsOperator takes an operator address for SchemeECDSA and an operator
+	// ID for SchemeBLS
+	IsOperator(ctx context.Context, scheme, signer string) (bool, error)
+}
+
+// servicePeers are the peers that are not operators. They are the only
+// names a Verifier accepts on a static signer binding alone.
+var servicePeers = map[string]bool{
+	ManagerPeerName:    true,
+	AggregatorPeerName: true,
+}
+
+// Verifier authenticates received messages. A message is accepted when its
+// signature is valid, it is fresh, its nonce was not seen before, and its
+// From is either a service peer bound to the signer or the address or
+// operator ID of a registered operator. Other bound names must also be
+// signed by a registered operator.
+type Verifier struct {
+	operators OperatorSet
+	names     map[string]string
+
+	mu     sync.Mutex
+	nonces map[string]int64
+}
+
+// NewVerifier checks operators against operators. names binds peers to the
+// signer they must sign with, only the task manager and the aggregator are
+// exempt from the operator check.
+func NewVerifier(operators OperatorSet, names map[string]string) *Verifier {
+	bound := make(map[string]string, len(names))
+	for name, signer := range names {
+		bound[name] = strings.ToLower(signer)
+	}
+	return &Verifier{
+		operators: operators,
+		names:     bound,
+		nonces:    make(map[string]int64),
+	}
+}
+
+// SignerNamesFromEnv reads TRIGGERX_PEER_SIGNERS, comma separated
+// name=signer entries binding the task manager and the aggregator to an
+// address or operator ID. Keepers are named by their operator address and
+// need no entry.
+func SignerNamesFromEnv() (map[string]string, error) {
+	names := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("TRIGGERX_PEER_SIGNERS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid peer signer %q, expected name=signer", entry)
+		}
+		if !servicePeers[parts[0]] {
+			return nil, fmt.Errorf("peer signer %q binds %s, only %s and %s can be bound",
+				entry, parts[0], ManagerPeerName, AggregatorPeerName)
+		}
+		names[parts[0]] = parts[1]
+	}
+	return names, nil
+}
+
+// Verify authenticates a message with its content as received
+func (v *Verifier) Verify(ctx context.Context, msg *Message, content json.RawMessage) error {
+	if msg.Auth == nil {
+		return fmt.Errorf("%w: message from %s is not signed", ErrUnauthenticated, msg.From)
+	}
+	sent, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrUnauthenticated, msg.Timestamp)
+	}
+	return v.verify(ctx, msg.From, sent, messageDigest(msg, content, msg.Auth), msg.Auth)
+}
+
+// VerifyTopic authenticates a topic message. The signature covers the
+// message ID, which covers everything the publisher set.
+func (v *Verifier) VerifyTopic(ctx context.Context, msg *TopicMessage) error {
+	if msg.Auth == nil {
+		return fmt.Errorf("%w: topic message from %s is not signed", ErrUnauthenticated, msg.From)
+	}
+	return v.verify(ctx, msg.From, time.Unix(msg.Timestamp, 0), topicMessageDigest(msg, msg.Auth), msg.Auth)
+}
+
+// verifyTransfer authenticates a transfer request that requester sent to
+// server
+func (v *Verifier) verifyTransfer(ctx context.Context, request *transferRequest, requester, server peer.ID) error {
+	if request.Auth == nil {
+		return fmt.Errorf("%w: transfer request from %s is not signed", ErrUnauthenticated, request.From)
+	}
+	sent, err := time.Parse(time.RFC3339, request.Timestamp)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrUnauthenticated, request.Timestamp)
+	}
+	return v.verify(ctx, request.From, sent, transferDigest(request, requester, server, request.Auth), request.Auth)
+}
+
+// verify checks that auth is a fresh signature of digest by the signer
+// from is allowed to sign with
+func (v *Verifier) verify(ctx context.Context, from string, sent time.Time, digest [32]byte, auth *MessageAuth) error {
+	now := time.Now()
+	expiry := time.Unix(auth.Expiry, 0)
+	if now.After(expiry) || sent.After(now.Add(MaxClockSkew)) || expiry.Sub(sent) > MaxMessageTTL {
+		return fmt.Errorf("%w: stale message from %s", ErrUnauthenticated, from)
+	}
+
+	if err := verifySignature(digest, auth); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	signer := strings.ToLower(auth.Signer)
+	bound, isBound := v.names[from]
+	if isBound {
+		if bound != signer {
+			return fmt.Errorf("%w: %s is not signed by its bound key", ErrUnauthenticated, from)
+		}
+	} else if strings.ToLower(from) != signer {
+		return fmt.Errorf("%w: %s signed by %s", ErrUnauthenticated, from, auth.Signer)
+	}
+	if !isBound || !servicePeers[from] {
+		registered, err := v.operators.IsOperator(ctx, auth.Scheme, auth.Signer)
+		if err != nil {
+			return fmt.Errorf("failed to check operator %s: %v", auth.Signer, err)
+		}
+		if !registered {
+			return fmt.Errorf("%w: %s is not a registered operator", ErrUnauthenticated, auth.Signer)
+		}
+	}
+
+	return v.useNonce(signer, auth.Nonce, auth.Expiry)
+}
+
+// useNonce records a nonce until its message expires, rejecting replays
+func (v *Verifier) useNonce(signer, nonce string, expiry int64) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now().Unix()
+	for key, until := range v.nonces {
+		if until < now {
+			delete(v.nonces, key)
+		}
+	}
+
+	key := signer + "/" + nonce
+	if _, seen := v.nonces[key]; seen {
+		return fmt.Errorf("%w: replayed message from %s", ErrUnauthenticated, signer)
+	}
+	v.nonces[key] = expiry
+	return nil
+}
+
+func verifySignature(digest [32]byte, auth *MessageAuth) error {
+	signature, err := hexutil.Decode(auth.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+
+	switch auth.Scheme {
+	case SchemeECDSA:
+		pubkey, err := crypto.SigToPub(digest[:], signature)
+		if err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+		if !common.IsHexAddress(auth.Signer) || crypto.PubkeyToAddress(*pubkey) != common.HexToAddress(auth.Signer) {
+			return fmt.Errorf("signature is not from %s", auth.Signer)
+		}
+		return nil
+
+	case SchemeBLS:
+		g1, err := hexutil.Decode(auth.PubkeyG1)
+		if err != nil {
+			return fmt.Errorf("invalid G1 public key encoding")
+		}
+		g2, err := hexutil.Decode(auth.PubkeyG2)
+		if err != nil {
+			return fmt.Errorf("invalid G2 public key encoding")
+		}
+		pubG1, err := bls.G1PointFromBytes(g1)
+		if err != nil {
+			return fmt.Errorf("invalid G1 public key: %v", err)
+		}
+		pubG2, err := bls.G2PointFromBytes(g2)
+		if err != nil {
+			return fmt.Errorf("invalid G2 public key: %v", err)
+		}
+		operatorID := bls.OperatorID(pubG1)
+		if !strings.EqualFold(hexutil.Encode(operatorID[:]), auth.Signer) {
+			return fmt.Errorf("public key is not operator %s", auth.Signer)
+		}
+		if ok, err := bls.CheckG1AndG2DiscreteLogEquality(pubG1, pubG2); err != nil || !ok {
+			return fmt.Errorf("public keys of %s do not match", auth.Signer)
+		}
+		sig, err := bls.SignatureFromBytes(signature)
+		if err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+		if ok, err := sig.Verify(pubG2, digest); err != nil || !ok {
+			return fmt.Errorf("signature is not from %s", auth.Signer)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown signature scheme %q", auth.Scheme)
+	}
+}
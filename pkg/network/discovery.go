@@ -2,22 +2,18 @@ package network
 
 import (
     "context"
-    "encoding/json"
     "fmt"
     "log"
-    "os"
-    "path/filepath"
     "sync"
     "time"
-    "strings"
 
+    "github.com/ethereum/go-ethereum/common"
+    "github.com/libp2p/go-libp2p/core/event"
     "github.com/libp2p/go-libp2p/core/host"
     "github.com/libp2p/go-libp2p/core/peer"
-    "github.com/multiformats/go-multiaddr"
 )
 
 const (
-    PeerInfoFilePath = "/home/prapti_shah/peer_info.json"
     PeerConnectionTimeout = 30 * time.Second
 )
 
@@ -26,137 +22,170 @@ type PeerInfo struct {
     Address string `json:"address"`
 }
 
+// Discovery advertises this node and finds named peers through a PeerSource
 type Discovery struct {
     host    host.Host
     name    string
     context context.Context
+    source  PeerSource
     mutex   sync.RWMutex
+    cache   map[string]peer.AddrInfo
 }
 
-func NewDiscovery(ctx context.Context, h host.Host, name string) *Discovery {
+func NewDiscovery(ctx context.Context, h host.Host, name string, source PeerSource) *Discovery {
+    serveName(h, name)
+
     return &Discovery{
         host:    h,
         name:    name,
         context: ctx,
+        source:  source,
+        cache:   make(map[string]peer.AddrInfo),
     }
 }
 
-// expandHomePath expands the ~ to the user's home directory
-func expandHomePath(path string) (string, error) {
-    if len(path) > 1 && path[:2] == "~/" {
-        homeDir, err := os.UserHomeDir()
-        if err != nil {
-            return "", err
-        }
-        path = filepath.Join(homeDir, path[2:])
+// Advertise makes this node findable under its name
+func (d *Discovery) Advertise() error {
+    info := peer.AddrInfo{ID: d.host.ID(), Addrs: d.host.Addrs()}
+    if err := d.source.Advertise(d.context, d.name, info); err != nil {
+        return fmt.Errorf("failed to advertise %s: %v", d.name, err)
     }
-    return path, nil
-}
 
-// SavePeerInfo saves peer information to a JSON file
-func (d *Discovery) SavePeerInfo() error {
-    d.mutex.Lock()
-    defer d.mutex.Unlock()
+    log.Printf("Advertised %s as %s", d.name, AddrInfoString(info))
+    return nil
+}
 
-    filePath, err := expandHomePath(PeerInfoFilePath)
+// AdvertiseOnChange advertises this node again whenever its addresses
+// change, such as when AutoNAT finds it behind NAT and relay addresses
+// replace the direct ones, until the discovery context ends
+func (d *Discovery) AdvertiseOnChange() error {
+    sub, err := d.host.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
     if err != nil {
-        return fmt.Errorf("error expanding file path: %v", err)
-    }
+        return fmt.Errorf("failed to watch address changes: %v", err)
+    }
+
+    go func() {
+        defer sub.Close()
+        for {
+            select {
+            case <-d.context.Done():
+                return
+            case _, ok := <-sub.Out():
+                if !ok {
+                    return
+                }
+                if err := d.Advertise(); err != nil {
+                    log.Printf("Failed to advertise new addresses: %v", err)
+                }
+            }
+        }
+    }()
+    return nil
+}
 
-    // Ensure directory exists
-    if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-        return fmt.Errorf("unable to create directory: %v", err)
+// FindPeer returns the addresses of a named peer, from the cache when it
+// was found before
+func (d *Discovery) FindPeer(name string) (peer.AddrInfo, error) {
+    d.mutex.RLock()
+    info, cached := d.cache[name]
+    d.mutex.RUnlock()
+    if cached {
+        return info, nil
     }
 
-    
-
-    // Read existing peer infos
-    peerInfos := make(map[string]PeerInfo)
-    
-    // Try to read existing file
-    if existingFile, err := os.Open(filePath); err == nil {
-        defer existingFile.Close()
-        decoder := json.NewDecoder(existingFile)
-        if err := decoder.Decode(&peerInfos); err != nil {
-            log.Printf("Warning: existing peer info file could not be decoded: %v", err)
-        }
-    }
+    ctx, cancel := context.WithTimeout(d.context, PeerConnectionTimeout)
+    defer cancel()
 
-    // Create full address for current host
-    var fullAddrs []string
-    for _, addr := range d.host.Addrs() {
-        fullAddr := fmt.Sprintf("%s/p2p/%s", addr, d.host.ID().String())
-        fullAddrs = append(fullAddrs, fullAddr)
-    }
-    
-    // Add or update peer info
-    peerInfos[d.name] = PeerInfo{
-        Name:    d.name,
-        Address: strings.Join(fullAddrs, ","), // Store multiple addresses
-    }
-    // Write updated peer infos
-    file, err := os.Create(filePath)
+    info, err := d.source.FindPeer(ctx, name)
     if err != nil {
-        return fmt.Errorf("unable to create peer info file: %v", err)
+        return peer.AddrInfo{}, err
     }
-    defer file.Close()
 
-    encoder := json.NewEncoder(file)
-    if err := encoder.Encode(peerInfos); err != nil {
-        return fmt.Errorf("error writing peer info: %v", err)
-    }
-
-    log.Printf("Peer info saved to %s", filePath)
-    return nil
+    d.mutex.Lock()
+    d.cache[name] = info
+    d.mutex.Unlock()
+    return info, nil
 }
 
-// LoadPeerInfo loads peer information from the JSON file
-func LoadPeerInfo() (map[string]PeerInfo, error) {
-    filePath, err := expandHomePath(PeerInfoFilePath)
+// Peers lists every peer the source knows, except this node
+func (d *Discovery) Peers() (map[string]peer.AddrInfo, error) {
+    ctx, cancel := context.WithTimeout(d.context, PeerConnectionTimeout)
+    defer cancel()
+
+    peers, err := d.source.Peers(ctx)
     if err != nil {
-        return nil, fmt.Errorf("error expanding file path: %v", err)
+        return nil, err
     }
+    delete(peers, d.name)
 
-    // If file doesn't exist, return an empty map
-    if _, err := os.Stat(filePath); os.IsNotExist(err) {
-        return make(map[string]PeerInfo), nil
+    d.mutex.Lock()
+    for name, info := range peers {
+        d.cache[name] = info
     }
+    d.mutex.Unlock()
+    return peers, nil
+}
 
-    file, err := os.Open(filePath)
+// Connect finds a named peer and connects to it. A cached address that no
+// longer works is dropped and looked up again.
+func (d *Discovery) Connect(name string) (peer.ID, error) {
+    info, err := d.FindPeer(name)
     if err != nil {
-        return nil, fmt.Errorf("failed to open peer info file: %v", err)
+        return "", err
     }
-    defer file.Close()
 
-    var peerInfos map[string]PeerInfo
-    decoder := json.NewDecoder(file)
-    if err := decoder.Decode(&peerInfos); err != nil {
-        return nil, fmt.Errorf("failed to decode peer info: %v", err)
+    if err := d.connect(info); err != nil {
+        d.mutex.Lock()
+        delete(d.cache, name)
+        d.mutex.Unlock()
+
+        fresh, findErr := d.FindPeer(name)
+        if findErr != nil {
+            return "", err
+        }
+        if err := d.connect(fresh); err != nil {
+            return "", err
+        }
+        info = fresh
+    }
+
+    // A keeper found by operator address must not be bound to another
+    // operator. Peers without a binding are accepted.
+    if common.IsHexAddress(name) {
+        operator, err := QueryOperator(d.context, d.host, info.ID)
+        if err == nil && operator != common.HexToAddress(name) {
+            d.mutex.Lock()
+            delete(d.cache, name)
+            d.mutex.Unlock()
+            return "", fmt.Errorf("peer %s is bound to operator %s, not %s", info.ID, operator.Hex(), name)
+        }
     }
 
-    return peerInfos, nil
+    log.Printf("Connected to peer %s with ID: %s", name, info.ID)
+    return info.ID, nil
 }
 
-// ConnectToPeer connects to a specific peer
+// ConnectToPeer connects to a peer given by a multiaddr ending in /p2p/<ID>
 func (d *Discovery) ConnectToPeer(info PeerInfo) (peer.ID, error) {
-    maddr, err := multiaddr.NewMultiaddr(info.Address)
+    addrInfo, err := AddrInfoFromString(info.Address)
     if err != nil {
-        return "", fmt.Errorf("invalid peer address: %v", err)
+        return "", err
     }
 
-    peerInfo, err := peer.AddrInfoFromP2pAddr(maddr)
-    if err != nil {
-        return "", fmt.Errorf("invalid peer info: %v", err)
+    if err := d.connect(addrInfo); err != nil {
+        return "", err
     }
 
+    log.Printf("Connected to peer %s with ID: %s", info.Name, addrInfo.ID)
+    return addrInfo.ID, nil
+}
+
+func (d *Discovery) connect(info peer.AddrInfo) error {
     ctx, cancel := context.WithTimeout(d.context, PeerConnectionTimeout)
     defer cancel()
 
-    err = d.host.Connect(ctx, *peerInfo)
-    if err != nil {
-        return "", fmt.Errorf("failed to connect to peer: %v", err)
+    if err := d.host.Connect(ctx, info); err != nil {
+        return fmt.Errorf("failed to connect to peer: %v", err)
     }
-
-    log.Printf("Connected to peer %s with ID: %s", info.Name, peerInfo.ID)
-    return peerInfo.ID, nil
-}
\ No newline at end of file
+    return nil
+}
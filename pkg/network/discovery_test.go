@@ -0,0 +1,84 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+func TestDiscoveryConnectsByName(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1", "keeper2")
+
+	peerID := tn.connect("keeper1", testManager)
+	if peerID != tn.node(testManager).host.ID() {
+		t.Fatalf("connected to %s, want %s", peerID, tn.node(testManager).host.ID())
+	}
+	if tn.node("keeper1").host.Network().Connectedness(peerID) != network.Connected {
+		t.Fatal("keeper1 not connected to the task manager")
+	}
+
+	peers, err := tn.node(testManager).discovery.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"keeper1", "keeper2"} {
+		if _, ok := peers[name]; !ok {
+			t.Errorf("%s missing from discovered peers", name)
+		}
+	}
+	if _, ok := peers[testManager]; ok {
+		t.Error("discovered peers include the node itself")
+	}
+
+	if _, err := tn.node("keeper1").discovery.Connect("keeper3"); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("connecting to an unknown peer returned %v, want ErrPeerNotFound", err)
+	}
+}
+
+func TestDiscoveryFollowsRestartedPeer(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	old := tn.connect(testManager, "keeper1")
+
+	// keeper1 restarts with a new peer ID, the manager's cached address of
+	// it no longer works
+	tn.partition(testManager, "keeper1")
+	restarted := tn.addNode("keeper1")
+	tn.heal(testManager, "keeper1")
+
+	peerID := tn.connect(testManager, "keeper1")
+	if peerID == old || peerID != restarted.host.ID() {
+		t.Fatalf("connected to %s, want restarted keeper %s", peerID, restarted.host.ID())
+	}
+}
+
+func TestDiscoveryRejectsPeerBoundToAnotherOperator(t *testing.T) {
+	tn := newTestNetwork(t)
+	keeper := tn.addNode("keeper1")
+	tn.addNode("operator")
+	if err := tn.mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// keeper1's identity is bound to its own operator key, yet it is
+	// advertised under the address of another operator
+	if err := keeper.identity.Bind(keeper.key); err != nil {
+		t.Fatal(err)
+	}
+	claimed := tn.signers["operator"]
+	if err := tn.source.Advertise(tn.ctx, claimed, peerInfo(keeper)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tn.node(testManager).discovery.Connect(claimed); err == nil {
+		t.Fatal("connected to a peer bound to another operator")
+	}
+
+	// Advertised under its own operator address it is accepted
+	own := tn.signers["keeper1"]
+	if err := tn.source.Advertise(tn.ctx, own, peerInfo(keeper)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tn.node(testManager).discovery.Connect(own); err != nil {
+		t.Fatalf("failed to connect to a correctly bound peer: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// A frame is the uvarint length of the rest of the frame, one byte naming
+// the compression, and the payload. Frames replace newline delimited JSON
+// on the newer protocol versions, which bounds what a peer can make a node
+// buffer.
+
+const (
+	// MaxMessageSize bounds a message, both as sent and decompressed
+	MaxMessageSize = 4 << 20
+	// compressionThreshold is the payload size below which compressing
+	// is not worth it
+	compressionThreshold = 1 << 10
+)
+
+// ErrMessageTooLarge is returned for messages above the size limit
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Compression is the codec a frame payload is compressed with
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("compression(%d)", byte(c))
+}
+
+// ParseCompression reads a compression name, none, snappy or zstd
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "zstd":
+		return CompressionZstd, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %q, expected none, snappy or zstd", name)
+}
+
+// CompressionFromEnv reads TRIGGERX_COMPRESSION, the compression of the
+// frames a node sends. Frames are sent uncompressed by default, received
+// frames are decompressed whatever they use.
+func CompressionFromEnv() (Compression, error) {
+	return ParseCompression(os.Getenv("TRIGGERX_COMPRESSION"))
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the shared zstd encoder and decoder, which are safe for
+// concurrent use
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxMessageSize))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// WriteFrame writes data as one frame, compressed when that makes it
+// smaller
+func WriteFrame(w io.Writer, data []byte, compression Compression) error {
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+
+	payload, used := data, CompressionNone
+	if len(data) >= compressionThreshold {
+		var compressed []byte
+		switch compression {
+		case CompressionSnappy:
+			compressed = s2.EncodeSnappy(nil, data)
+		case CompressionZstd:
+			encoder, _, err := zstdCodec()
+			if err != nil {
+				return fmt.Errorf("failed to set up zstd: %v", err)
+			}
+			compressed = encoder.EncodeAll(data, nil)
+		}
+		if compressed != nil && len(compressed) < len(data) {
+			payload, used = compressed, compression
+		}
+	}
+
+	header := make([]byte, binary.MaxVarintLen64+1)
+	n := binary.PutUvarint(header, uint64(len(payload)+1))
+	header[n] = byte(used)
+
+	// One write per frame, so frames of concurrent writers do not interleave
+	frame := make([]byte, 0, n+1+len(payload))
+	frame = append(frame, header[:n+1]...)
+	frame = append(frame, payload...)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads one frame and returns its decompressed payload. Frames
+// and payloads above maxSize are rejected before they are buffered.
+func ReadFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, fmt.Errorf("invalid frame: empty")
+	}
+	if length-1 > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: frame of %d bytes", ErrMessageTooLarge, length-1)
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	payload := frame[1:]
+
+	switch Compression(frame[0]) {
+	case CompressionNone:
+		return payload, nil
+	case CompressionSnappy:
+		size, err := s2.DecodedLen(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snappy frame: %v", err)
+		}
+		if size > maxSize {
+			return nil, fmt.Errorf("%w: decompresses to %d bytes", ErrMessageTooLarge, size)
+		}
+		data, err := s2.Decode(nil, payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snappy frame: %v", err)
+		}
+		return data, nil
+	case CompressionZstd:
+		_, decoder, err := zstdCodec()
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up zstd: %v", err)
+		}
+		data, err := decoder.DecodeAll(payload, nil)
+		if err != nil {
+			if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+				return nil, fmt.Errorf("%w: %v", ErrMessageTooLarge, err)
+			}
+			return nil, fmt.Errorf("invalid zstd frame: %v", err)
+		}
+		if len(data) > maxSize {
+			return nil, fmt.Errorf("%w: decompresses to %d bytes", ErrMessageTooLarge, len(data))
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("invalid frame: unknown compression %d", frame[0])
+}
+
+// readLine reads one newline delimited message of the older protocol
+// versions, which are held to the same size limit as frames
+func readLine(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxSize+1 {
+			return nil, fmt.Errorf("%w: line above %d bytes", ErrMessageTooLarge, maxSize)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(bytes.TrimSpace(line)) > 0 {
+				return line, nil
+			}
+			return nil, err
+		}
+		return line, nil
+	}
+}
+
+// streamCodec reads and writes the messages of a stream, as frames or as
+// lines depending on the protocol version the stream speaks
+type streamCodec struct {
+	stream      network.Stream
+	reader      *bufio.Reader
+	framed      bool
+	compression Compression
+}
+
+func newStreamCodec(stream network.Stream, framed bool, compression Compression) *streamCodec {
+	return &streamCodec{
+		stream:      stream,
+		reader:      bufio.NewReader(stream),
+		framed:      framed,
+		compression: compression,
+	}
+}
+
+// Read returns the next message
+func (c *streamCodec) Read() ([]byte, error) {
+	if c.framed {
+		return ReadFrame(c.reader, MaxMessageSize)
+	}
+	return readLine(c.reader, MaxMessageSize)
+}
+
+// Write sends one message
+func (c *streamCodec) Write(data []byte) error {
+	if c.framed {
+		return WriteFrame(c.stream, data, c.compression)
+	}
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+	_, err := c.stream.Write(append(data, '\n'))
+	return err
+}
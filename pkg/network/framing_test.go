@@ -0,0 +1,184 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+// frameOverhead is the most a frame adds to a payload it does not compress
+const frameOverhead = 11
+
+func TestFrameRoundTrip(t *testing.T) {
+	random := make([]byte, 64<<10)
+	rand.Read(random)
+	payloads := map[string][]byte{
+		"empty":        {},
+		"small":        []byte(`{"job_id":"1"}`),
+		"newlines":     []byte("line one\nline two\n"),
+		"compressible": bytes.Repeat([]byte(`{"script":"print(1)"}`), 10000),
+		"random":       random,
+	}
+
+	for _, compression := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		for name, payload := range payloads {
+			t.Run(compression.String()+"/"+name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := WriteFrame(&buf, payload, compression); err != nil {
+					t.Fatal(err)
+				}
+				if compression != CompressionNone && name == "compressible" && buf.Len() >= len(payload)/2 {
+					t.Errorf("frame of %d bytes for a %d byte payload", buf.Len(), len(payload))
+				}
+				if buf.Len() > len(payload)+frameOverhead {
+					t.Errorf("frame of %d bytes grew a %d byte payload", buf.Len(), len(payload))
+				}
+
+				got, err := ReadFrame(bufio.NewReader(&buf), MaxMessageSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Fatalf("read %d bytes, want %d", len(got), len(payload))
+				}
+			})
+		}
+	}
+}
+
+func TestFrameSizeLimit(t *testing.T) {
+	if err := WriteFrame(io.Discard, make([]byte, MaxMessageSize+1), CompressionNone); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("writing an oversized frame returned %v", err)
+	}
+
+	payload := bytes.Repeat([]byte{'a'}, 64<<10)
+	for _, compression := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd} {
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, payload, compression); err != nil {
+			t.Fatal(err)
+		}
+		// Compressed frames are small, the limit holds for what they
+		// decompress to
+		if _, err := ReadFrame(bufio.NewReader(&buf), 1<<10); !errors.Is(err, ErrMessageTooLarge) {
+			t.Fatalf("%s: reading a frame above the limit returned %v", compression, err)
+		}
+	}
+
+	// A length prefix claiming a huge frame is rejected before reading it
+	header := []byte{0xff, 0xff, 0xff, 0xff, 0x0f}
+	if _, err := ReadFrame(bufio.NewReader(bytes.NewReader(header)), MaxMessageSize); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("reading a huge length prefix returned %v", err)
+	}
+}
+
+func TestReadLineLimit(t *testing.T) {
+	reader := bufio.NewReaderSize(strings.NewReader(strings.Repeat("a", 10000)+"\nnext\n"), 16)
+	if _, err := readLine(reader, 1000); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("reading a long line returned %v", err)
+	}
+
+	reader = bufio.NewReaderSize(strings.NewReader(strings.Repeat("a", 100)+"\n"), 16)
+	line, err := readLine(reader, 1000)
+	if err != nil || len(line) != 101 {
+		t.Fatalf("read %d bytes, %v", len(line), err)
+	}
+}
+
+func TestLargeCompressedMessage(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	keeperID := tn.connect(testManager, "keeper1")
+	tn.node(testManager).messaging.SetCompression(CompressionZstd)
+
+	type batch struct {
+		Jobs []string `json:"jobs"`
+	}
+	received := make(chan batch, 1)
+	HandleTyped(tn.node("keeper1").messaging, JobTransmissionMessage, func(msg Message, jobs batch) error {
+		received <- jobs
+		return nil
+	})
+
+	jobs := make([]string, 20000)
+	for i := range jobs {
+		jobs[i] = strings.Repeat("job\n", 10)
+	}
+	manager := tn.node(testManager).messaging
+	if err := manager.SendTypedMessage("keeper1", keeperID, JobTransmissionMessage, batch{Jobs: jobs}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; len(got.Jobs) != len(jobs) || got.Jobs[0] != jobs[0] {
+		t.Fatalf("received %d jobs", len(got.Jobs))
+	}
+
+	tooLarge := batch{Jobs: []string{strings.Repeat("a", MaxMessageSize)}}
+	if err := manager.SendTypedMessage("keeper1", keeperID, JobTransmissionMessage, tooLarge); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("sending an oversized message returned %v", err)
+	}
+}
+
+func TestFetchItemInChunks(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1", "keeper2")
+	script := make([]byte, 3*ChunkSize+123)
+	rand.Read(script)
+
+	var requester string
+	ServeItems(tn.node("keeper1").messaging, func(from, key string) (io.ReadCloser, int64, error) {
+		requester = from
+		if key != "script.go" {
+			return nil, 0, ErrItemNotFound
+		}
+		return io.NopCloser(bytes.NewReader(script)), int64(len(script)), nil
+	})
+	keeperID := tn.connect("keeper2", "keeper1")
+
+	var buf bytes.Buffer
+	size, err := FetchItem(tn.ctx, tn.node("keeper2").messaging, keeperID, "script.go", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(script)) || !bytes.Equal(buf.Bytes(), script) {
+		t.Fatalf("fetched %d bytes, want %d", size, len(script))
+	}
+	if requester != "keeper2" {
+		t.Fatalf("provider asked for %q, want keeper2", requester)
+	}
+
+	_, err = FetchItem(tn.ctx, tn.node("keeper2").messaging, keeperID, "missing.go", io.Discard)
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != ReplyNotFound {
+		t.Fatalf("fetching a missing item returned %v", err)
+	}
+}
+
+func TestServeItemsRejectsUnauthenticatedRequests(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1", "keeper2")
+	ServeItems(tn.node("keeper1").messaging, func(from, key string) (io.ReadCloser, int64, error) {
+		t.Errorf("provider asked for %s by %s", key, from)
+		return nil, 0, ErrItemNotFound
+	})
+	keeperID := tn.connect("keeper2", "keeper1")
+
+	// A request signed by a key that is not a registered operator
+	stranger := NewMessaging(tn.node("keeper2").host, "keeper2")
+	key, err := ethcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger.SetSigner(NewECDSASigner(key))
+	// An unsigned request
+	unsigned := NewMessaging(tn.node("keeper2").host, "keeper2")
+
+	for name, m := range map[string]*Messaging{"stranger": stranger, "unsigned": unsigned} {
+		_, err := FetchItem(tn.ctx, m, keeperID, "script.go", io.Discard)
+		var replyErr *ReplyError
+		if !errors.As(err, &replyErr) || replyErr.Code != ReplyUnauthenticated {
+			t.Fatalf("%s request returned %v", name, err)
+		}
+	}
+}
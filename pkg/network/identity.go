@@ -0,0 +1,266 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// IdentityProtocol lets a peer ask which operator a peer ID belongs to
+const IdentityProtocol = "/triggerx/identity/1.0.0"
+
+// DefaultIdentityDir holds the identity files of nodes that do not set
+// TRIGGERX_IDENTITY_FILE
+const DefaultIdentityDir = "data/identity"
+
+// ErrNoOperatorBinding is returned for peers whose identity is not tied to
+// an operator
+var ErrNoOperatorBinding = errors.New("peer identity not bound to an operator")
+
+// Identity is a node's libp2p key, optionally tied to the operator that
+// signed its peer ID
+type Identity struct {
+	Key       crypto.PrivKey
+	Operator  string
+	Signature string
+}
+
+// OperatorBinding is the operator's signature over a peer ID
+type OperatorBinding struct {
+	Operator  string `json:"operator"`
+	Signature string `json:"signature"`
+}
+
+// identityFile is the encrypted identity format. The peer ID and operator
+// binding are kept in the clear so a node can be identified without the
+// password.
+type identityFile struct {
+	PeerID    string              `json:"peerId"`
+	Operator  string              `json:"operator,omitempty"`
+	Signature string              `json:"signature,omitempty"`
+	Crypto    keystore.CryptoJSON `json:"crypto"`
+}
+
+// GenerateIdentity creates a new Ed25519 identity
+func GenerateIdentity() (*Identity, error) {
+	key, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %v", err)
+	}
+	return &Identity{Key: key}, nil
+}
+
+// PeerID returns the peer ID of the identity
+func (id *Identity) PeerID() (peer.ID, error) {
+	return peer.IDFromPrivateKey(id.Key)
+}
+
+// Bind signs the identity's peer ID with an operator key, making the peer
+// ID a verifiable handle for the operator
+func (id *Identity) Bind(operatorKey *ecdsa.PrivateKey) error {
+	peerID, err := id.PeerID()
+	if err != nil {
+		return err
+	}
+	signature, err := ethcrypto.Sign(bindingHash(peerID), operatorKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign peer ID: %v", err)
+	}
+	id.Operator = ethcrypto.PubkeyToAddress(operatorKey.PublicKey).Hex()
+	id.Signature = hexutil.Encode(signature)
+	return nil
+}
+
+// Save encrypts the identity with password using the same scrypt parameters
+// as Ethereum keystores and writes it to path
+func (id *Identity) Save(path, password string) error {
+	peerID, err := id.PeerID()
+	if err != nil {
+		return err
+	}
+	raw, err := crypto.MarshalPrivateKey(id.Key)
+	if err != nil {
+		return fmt.Errorf("failed to encode identity key: %v", err)
+	}
+	encrypted, err := keystore.EncryptDataV3(raw, []byte(password), keystore.StandardScryptN, keystore.StandardScryptP)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt identity key: %v", err)
+	}
+
+	data, err := json.MarshalIndent(identityFile{
+		PeerID:    peerID.String(),
+		Operator:  id.Operator,
+		Signature: id.Signature,
+		Crypto:    encrypted,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode identity file: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create identity directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write identity file: %v", err)
+	}
+	return nil
+}
+
+// ReadIdentity decrypts an identity file written by Save
+func ReadIdentity(path, password string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %v", err)
+	}
+
+	var file identityFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid identity file: %v", err)
+	}
+	raw, err := keystore.DecryptDataV3(file.Crypto, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity key: %v", err)
+	}
+	key, err := crypto.UnmarshalPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity key: %v", err)
+	}
+
+	id := &Identity{Key: key, Operator: file.Operator, Signature: file.Signature}
+	peerID, err := id.PeerID()
+	if err != nil {
+		return nil, err
+	}
+	if file.PeerID != "" && file.PeerID != peerID.String() {
+		return nil, fmt.Errorf("identity file peer ID does not match its key")
+	}
+	if id.Operator != "" {
+		if err := VerifyOperatorBinding(peerID, OperatorBinding{Operator: id.Operator, Signature: id.Signature}); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// LoadOrCreateIdentity reads the identity at path, generating and saving
+// one on first start
+func LoadOrCreateIdentity(path, password string) (*Identity, error) {
+	if _, err := os.Stat(path); err == nil {
+		return ReadIdentity(path, password)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read identity file: %v", err)
+	}
+
+	id, err := GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err := id.Save(path, password); err != nil {
+		return nil, err
+	}
+	peerID, _ := id.PeerID()
+	log.Printf("Created identity %s in %s", peerID, path)
+	return id, nil
+}
+
+// IdentityPath is the identity file of a node, TRIGGERX_IDENTITY_FILE or
+// DefaultIdentityDir/<name>.json
+func IdentityPath(name string) string {
+	if path := os.Getenv("TRIGGERX_IDENTITY_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(DefaultIdentityDir, name+".json")
+}
+
+// IdentityFromEnv loads the identity of a node, encrypted with
+// TRIGGERX_IDENTITY_PASSWORD. Without a password the node runs with a
+// random identity and its peer ID changes on every start.
+func IdentityFromEnv(name string) (*Identity, error) {
+	password := os.Getenv("TRIGGERX_IDENTITY_PASSWORD")
+	if password == "" {
+		log.Printf("TRIGGERX_IDENTITY_PASSWORD not set, %s runs with a temporary peer ID", name)
+		return GenerateIdentity()
+	}
+	return LoadOrCreateIdentity(IdentityPath(name), password)
+}
+
+// ServeIdentity answers identity queries with the node's operator binding
+func ServeIdentity(h host.Host, id *Identity) {
+	h.SetStreamHandler(protocol.ID(IdentityProtocol), func(stream network.Stream) {
+		defer stream.Close()
+		data, err := json.Marshal(OperatorBinding{Operator: id.Operator, Signature: id.Signature})
+		if err != nil {
+			return
+		}
+		stream.SetWriteDeadline(time.Now().Add(nameQueryTimeout))
+		if _, err := stream.Write(append(data, '\n')); err != nil {
+			log.Printf("Failed to answer identity query: %v", err)
+		}
+	})
+}
+
+// QueryOperator asks a connected peer for its operator binding and
+// verifies it. It returns ErrNoOperatorBinding for unbound peers.
+func QueryOperator(ctx context.Context, h host.Host, peerID peer.ID) (common.Address, error) {
+	ctx, cancel := context.WithTimeout(ctx, nameQueryTimeout)
+	defer cancel()
+
+	stream, err := h.NewStream(allowRelayed(ctx), peerID, protocol.ID(IdentityProtocol))
+	if err != nil {
+		return common.Address{}, fmt.Errorf("failed to query identity of %s: %v", peerID, err)
+	}
+	defer stream.Close()
+
+	stream.SetReadDeadline(time.Now().Add(nameQueryTimeout))
+	var binding OperatorBinding
+	if err := json.NewDecoder(stream).Decode(&binding); err != nil {
+		return common.Address{}, fmt.Errorf("failed to read identity of %s: %v", peerID, err)
+	}
+	if binding.Operator == "" {
+		return common.Address{}, ErrNoOperatorBinding
+	}
+	if err := VerifyOperatorBinding(peerID, binding); err != nil {
+		return common.Address{}, err
+	}
+	return common.HexToAddress(binding.Operator), nil
+}
+
+// VerifyOperatorBinding checks that the operator signed the peer ID
+func VerifyOperatorBinding(peerID peer.ID, binding OperatorBinding) error {
+	signature, err := hexutil.Decode(binding.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid operator binding signature")
+	}
+	pubkey, err := ethcrypto.SigToPub(bindingHash(peerID), signature)
+	if err != nil {
+		return fmt.Errorf("invalid operator binding signature: %v", err)
+	}
+	if !strings.EqualFold(ethcrypto.PubkeyToAddress(*pubkey).Hex(), binding.Operator) {
+		return fmt.Errorf("peer %s is not bound to operator %s", peerID, binding.Operator)
+	}
+	return nil
+}
+
+// bindingHash is the EIP-191 hash an operator signs to bind a peer ID, so
+// wallets can produce the binding as a personal message
+func bindingHash(peerID peer.ID) []byte {
+	return accounts.TextHash([]byte("TriggerX peer identity: " + peerID.String()))
+}
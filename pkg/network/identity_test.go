@@ -0,0 +1,74 @@
+package network
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestIdentityPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keeper.json")
+	created, err := LoadOrCreateIdentity(path, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	operatorKey, err := ethcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := created.Bind(operatorKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := created.Save(path, "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadOrCreateIdentity(path, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdID, _ := created.PeerID()
+	loadedID, _ := loaded.PeerID()
+	if createdID != loadedID || loaded.Operator != created.Operator {
+		t.Fatalf("loaded %s bound to %s, want %s bound to %s", loadedID, loaded.Operator, createdID, created.Operator)
+	}
+
+	if _, err := ReadIdentity(path, "wrong"); err == nil {
+		t.Fatal("identity decrypted with a wrong password")
+	}
+}
+
+func TestQueryOperator(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	keeper := tn.node("keeper1")
+	keeperID := tn.connect(testManager, "keeper1")
+	manager := tn.node(testManager).host
+
+	if _, err := QueryOperator(tn.ctx, manager, keeperID); !errors.Is(err, ErrNoOperatorBinding) {
+		t.Fatalf("unbound keeper returned %v, want ErrNoOperatorBinding", err)
+	}
+
+	if err := keeper.identity.Bind(keeper.key); err != nil {
+		t.Fatal(err)
+	}
+	operator, err := QueryOperator(tn.ctx, manager, keeperID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if operator.Hex() != tn.signers["keeper1"] {
+		t.Fatalf("keeper bound to %s, want %s", operator.Hex(), tn.signers["keeper1"])
+	}
+
+	// A binding copied from another peer does not verify
+	other, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, _ := other.PeerID()
+	binding := OperatorBinding{Operator: keeper.identity.Operator, Signature: keeper.identity.Signature}
+	if err := VerifyOperatorBinding(otherID, binding); err == nil {
+		t.Fatal("binding of another peer verified")
+	}
+}
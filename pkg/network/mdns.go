@@ -0,0 +1,120 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// MDNSSource finds peers on the local network. mDNS only announces peer
+// IDs, so each found peer is asked for its name over NameProtocol.
+type MDNSSource struct {
+	host host.Host
+
+	mu      sync.Mutex
+	service mdns.Service
+	found   map[peer.ID]peer.AddrInfo
+	names   map[string]peer.ID
+}
+
+func NewMDNSSource(h host.Host) *MDNSSource {
+	return &MDNSSource{
+		host:  h,
+		found: make(map[peer.ID]peer.AddrInfo),
+		names: make(map[string]peer.ID),
+	}
+}
+
+// Advertise starts the mDNS service, which both announces this node and
+// listens for others. The service stops when ctx ends.
+func (s *MDNSSource) Advertise(ctx context.Context, name string, info peer.AddrInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.service != nil {
+		return nil
+	}
+
+	service := mdns.NewMdnsService(s.host, Namespace, s)
+	if err := service.Start(); err != nil {
+		return fmt.Errorf("failed to start mDNS: %v", err)
+	}
+	s.service = service
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.service.Close()
+		s.service = nil
+	}()
+	return nil
+}
+
+// HandlePeerFound implements mdns.Notifee
+func (s *MDNSSource) HandlePeerFound(info peer.AddrInfo) {
+	if info.ID == s.host.ID() {
+		return
+	}
+	s.mu.Lock()
+	s.found[info.ID] = info
+	s.mu.Unlock()
+}
+
+func (s *MDNSSource) FindPeer(ctx context.Context, name string) (peer.AddrInfo, error) {
+	s.mu.Lock()
+	id, known := s.names[name]
+	info := s.found[id]
+	s.mu.Unlock()
+	if known {
+		return info, nil
+	}
+
+	// Resolve the names of peers found since the last lookup
+	peers, err := s.Peers(ctx)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if info, ok := peers[name]; ok {
+		return info, nil
+	}
+	return peer.AddrInfo{}, fmt.Errorf("%w: %s", ErrPeerNotFound, name)
+}
+
+func (s *MDNSSource) Peers(ctx context.Context) (map[string]peer.AddrInfo, error) {
+	s.mu.Lock()
+	named := make(map[peer.ID]bool, len(s.names))
+	for _, id := range s.names {
+		named[id] = true
+	}
+	var unnamed []peer.AddrInfo
+	for id, info := range s.found {
+		if !named[id] {
+			unnamed = append(unnamed, info)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, info := range unnamed {
+		name, err := queryName(ctx, s.host, info)
+		if err != nil {
+			log.Printf("Skipping mDNS peer %s: %v", info.ID, err)
+			continue
+		}
+		s.mu.Lock()
+		s.names[name] = info.ID
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make(map[string]peer.AddrInfo, len(s.names))
+	for name, id := range s.names {
+		peers[name] = s.found[id]
+	}
+	return peers, nil
+}
@@ -2,12 +2,13 @@
 package network
 
 import (
-    "bufio"
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "log"
+    "sync"
     "time"
 
     "github.com/libp2p/go-libp2p/core/host"
@@ -16,92 +17,301 @@ import (
     "github.com/libp2p/go-libp2p/core/protocol"
 )
 
+// MessageProtocol is the first message protocol: one JSON message per line
+// and no reply
 const MessageProtocol = "/keeper/message/1.0.0"
 
+// MessageProtocolV2 answers each message with a Reply, so senders learn
+// about unknown types and rejected payloads. Streams are negotiated down to
+// MessageProtocol for peers that do not speak it.
+const MessageProtocolV2 = "/triggerx/message/2.0.0"
+
+// MessageProtocolV3 is MessageProtocolV2 with messages and replies sent as
+// length prefixed, optionally compressed frames
+const MessageProtocolV3 = "/triggerx/message/3.0.0"
+
+const replyTimeout = 30 * time.Second
+
+const (
+    // TaskSignatureMessage carries a types.TaskSignature from a keeper to the aggregator
+    TaskSignatureMessage = "TASK_SIGNATURE"
+
+    // JobTransmissionMessage carries a job from the task manager to a keeper
+    JobTransmissionMessage = "JOB_TRANSMISSION"
+
+    // TaskAssignmentMessage tells a keeper that accepted a job the on-chain
+    // task to execute it under
+    TaskAssignmentMessage = "TASK_ASSIGNMENT"
+
+    // JSONMessage carries free-form JSON between peers
+    JSONMessage = "JSON_MESSAGE"
+
+    // AggregatorPeerName is the name the aggregator saves its peer info under
+    AggregatorPeerName = "aggregator"
+
+    // ManagerPeerName is the name the task manager saves its peer info under
+    ManagerPeerName = "task_manager"
+)
+
 type Message struct {
-    From      string      `json:"from"`
-    To        string      `json:"to"`
-    Content   interface{} `json:"content"`
-    Type      string      `json:"type"`
-    Timestamp string      `json:"timestamp"`
+    From      string       `json:"from"`
+    To        string       `json:"to"`
+    Content   interface{}  `json:"content"`
+    Type      string       `json:"type"`
+    Timestamp string       `json:"timestamp"`
+    Auth      *MessageAuth `json:"auth,omitempty"`
 }
 
+// wireMessage is a Message as received, with the content kept as sent so
+// its signature can be checked
+type wireMessage struct {
+    Message
+    Content json.RawMessage `json:"content"`
+}
+
+// MessageHandler handles one message type. content is the payload as sent,
+// an error is returned to MessageProtocolV2 senders.
+type MessageHandler func(msg Message, content json.RawMessage) error
+
 type Messaging struct {
-    host  host.Host
-    name  string
-    peers map[string]peer.ID
+    host     host.Host
+    name     string
+    signer   MessageSigner
+    verifier *Verifier
+
+    compression Compression
+
+    handlersMu sync.RWMutex
+    handlers   map[string]MessageHandler
+    fallback   func(Message)
+
+    // peers maps the names of authenticated senders to their peer IDs, it
+    // is written by every stream handler
+    peersMu sync.RWMutex
+    peers   map[string]peer.ID
 }
 
 func NewMessaging(h host.Host, name string) *Messaging {
     return &Messaging{
-        host:  h,
-        name:  name,
-        peers: make(map[string]peer.ID),
+        host:     h,
+        name:     name,
+        peers:    make(map[string]peer.ID),
+        handlers: make(map[string]MessageHandler),
     }
 }
 
-func (m *Messaging) InitMessageHandling(onMessage func(Message)) {
-    m.host.SetStreamHandler(protocol.ID(MessageProtocol), func(stream network.Stream) {
-        m.handleStream(stream, onMessage)
+// rememberPeer records the peer ID a named sender was last seen on
+func (m *Messaging) rememberPeer(name string, peerID peer.ID) {
+    m.peersMu.Lock()
+    defer m.peersMu.Unlock()
+    m.peers[name] = peerID
+}
+
+// Handle registers the handler of a message type
+func (m *Messaging) Handle(msgType string, handler MessageHandler) {
+    m.handlersMu.Lock()
+    defer m.handlersMu.Unlock()
+    m.handlers[msgType] = handler
+}
+
+// HandleTyped registers a handler that gets the payload decoded into T.
+// Payloads with fields T does not have are rejected.
+func HandleTyped[T any](m *Messaging, msgType string, handler func(msg Message, payload T) error) {
+    m.Handle(msgType, func(msg Message, content json.RawMessage) error {
+        var payload T
+        if err := DecodePayload(content, &payload); err != nil {
+            return &ReplyError{Code: ReplyInvalidPayload, Message: err.Error()}
+        }
+        msg.Content = payload
+        return handler(msg, payload)
     })
 }
 
+// Listen starts accepting messages on every protocol version. Messages of
+// types without a handler are answered with ReplyUnknownType.
+func (m *Messaging) Listen() {
+    m.host.SetStreamHandler(protocol.ID(MessageProtocolV3), m.handleStream)
+    m.host.SetStreamHandler(protocol.ID(MessageProtocolV2), m.handleStream)
+    m.host.SetStreamHandler(protocol.ID(MessageProtocol), m.handleStream)
+}
+
+// InitMessageHandling starts accepting messages, passing those of types
+// without a handler to onMessage
+func (m *Messaging) InitMessageHandling(onMessage func(Message)) {
+    m.handlersMu.Lock()
+    m.fallback = onMessage
+    m.handlersMu.Unlock()
+    m.Listen()
+}
+
+// SetSigner signs every message sent from now on
+func (m *Messaging) SetSigner(signer MessageSigner) {
+    m.signer = signer
+}
+
+// SetVerifier drops received messages that the verifier does not
+// authenticate. Without a verifier the sender's From is taken as given.
+func (m *Messaging) SetVerifier(verifier *Verifier) {
+    m.verifier = verifier
+}
+
+// Signer is the signer of the messages this node sends, nil when they are
+// not signed
+func (m *Messaging) Signer() MessageSigner {
+    return m.signer
+}
+
+// Verifier is the verifier of received messages, nil when they are not
+// authenticated
+func (m *Messaging) Verifier() *Verifier {
+    return m.verifier
+}
+
+// SetCompression compresses the frames sent from now on. Peers that only
+// speak the older protocol versions get uncompressed lines.
+func (m *Messaging) SetCompression(compression Compression) {
+    m.compression = compression
+}
+
+// Compression is the compression of the frames this node sends
+func (m *Messaging) Compression() Compression {
+    return m.compression
+}
+
 func (m *Messaging) GetHost() host.Host {
     return m.host
 }
 
-func (m *Messaging) handleStream(stream network.Stream, onMessage func(Message)) {
-    reader := bufio.NewReader(stream)
+func (m *Messaging) handleStream(stream network.Stream) {
     defer stream.Close()
 
     remotePeerID := stream.Conn().RemotePeer()
+    reply := stream.Protocol() != protocol.ID(MessageProtocol)
+    codec := newStreamCodec(stream, stream.Protocol() == protocol.ID(MessageProtocolV3), m.compression)
 
     for {
-        str, err := reader.ReadString('\n')
+        data, err := codec.Read()
         if err != nil {
             if err != io.EOF {
                 log.Printf("Error reading from stream: %v", err)
             }
+            if reply && errors.Is(err, ErrMessageTooLarge) {
+                writeReply(codec, &ReplyError{Code: ReplyInvalidMessage, Message: err.Error()})
+            }
             return
         }
 
-        var msg Message
-        if err := json.Unmarshal([]byte(str), &msg); err != nil {
-            log.Printf("Error decoding message: %v", err)
-            continue
+        err = m.receive(remotePeerID, data)
+        if err != nil {
+            log.Printf("Message from peer %s not handled: %v", remotePeerID, err)
+        }
+        if reply {
+            if err := writeReply(codec, err); err != nil {
+                log.Printf("Error replying to peer %s: %v", remotePeerID, err)
+                return
+            }
         }
+    }
+}
 
-        m.peers[msg.From] = remotePeerID
-        onMessage(msg)
+// receive decodes, authenticates and dispatches one message
+func (m *Messaging) receive(remotePeerID peer.ID, data []byte) error {
+    var wire wireMessage
+    if err := json.Unmarshal(data, &wire); err != nil {
+        return &ReplyError{Code: ReplyInvalidMessage, Message: err.Error()}
     }
+    msg := wire.Message
+
+    if m.verifier != nil {
+        if err := m.verifier.Verify(context.Background(), &msg, wire.Content); err != nil {
+            return &ReplyError{Code: ReplyUnauthenticated, Message: err.Error()}
+        }
+    }
+    m.rememberPeer(msg.From, remotePeerID)
+
+    m.handlersMu.RLock()
+    handler, ok := m.handlers[msg.Type]
+    fallback := m.fallback
+    m.handlersMu.RUnlock()
+
+    if ok {
+        return handler(msg, wire.Content)
+    }
+    if fallback == nil {
+        return &ReplyError{Code: ReplyUnknownType, Message: fmt.Sprintf("unknown message type %q", msg.Type)}
+    }
+
+    if len(wire.Content) > 0 {
+        if err := json.Unmarshal(wire.Content, &msg.Content); err != nil {
+            return &ReplyError{Code: ReplyInvalidPayload, Message: err.Error()}
+        }
+    }
+    fallback(msg)
+    return nil
 }
 
-func (m *Messaging) SendMessage(to string, peerID peer.ID, content interface{}) error {
+// newMessage builds a message from this node, signed when a signer is set
+func (m *Messaging) newMessage(to string, msgType string, content interface{}) (Message, error) {
+    contentBytes, err := json.Marshal(content)
+    if err != nil {
+        return Message{}, fmt.Errorf("error marshaling message content: %v", err)
+    }
+
     msg := Message{
         From:      m.name,
         To:        to,
-        Content:   content,
-        Type:      "JSON_MESSAGE",
+        Content:   json.RawMessage(contentBytes),
+        Type:      msgType,
         Timestamp: time.Now().UTC().Format(time.RFC3339),
     }
+    if m.signer != nil {
+        if err := signMessage(m.signer, &msg, contentBytes); err != nil {
+            return Message{}, err
+        }
+    }
+    return msg, nil
+}
+
+func (m *Messaging) SendMessage(to string, peerID peer.ID, content interface{}) error {
+    return m.SendTypedMessage(to, peerID, JSONMessage, content)
+}
+
+// SendTypedMessage sends content with a message type the receiver dispatches
+// on. When the receiver speaks MessageProtocolV2 or later, a rejection is
+// returned as a *ReplyError.
+func (m *Messaging) SendTypedMessage(to string, peerID peer.ID, msgType string, content interface{}) error {
+    msg, err := m.newMessage(to, msgType, content)
+    if err != nil {
+        return err
+    }
 
     msgBytes, err := json.Marshal(msg)
     if err != nil {
         return fmt.Errorf("error marshaling message: %v", err)
     }
-    msgBytes = append(msgBytes, '\n')
+    if len(msgBytes) > MaxMessageSize {
+        return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(msgBytes))
+    }
 
-    stream, err := m.host.NewStream(context.Background(), peerID, protocol.ID(MessageProtocol))
+    stream, err := m.host.NewStream(allowRelayed(context.Background()), peerID,
+        protocol.ID(MessageProtocolV3), protocol.ID(MessageProtocolV2), protocol.ID(MessageProtocol))
     if err != nil {
         return fmt.Errorf("error opening stream: %v", err)
     }
     defer stream.Close()
 
-    _, err = stream.Write(msgBytes)
+    codec := newStreamCodec(stream, stream.Protocol() == protocol.ID(MessageProtocolV3), m.compression)
+    err = codec.Write(msgBytes)
     if err != nil {
         return fmt.Errorf("error sending message: %v", err)
     }
 
+    if stream.Protocol() != protocol.ID(MessageProtocol) {
+        if err := readReply(codec); err != nil {
+            return err
+        }
+    }
+
     prettyJSON, err := json.MarshalIndent(msg, "", "  ")
     if err != nil {
         log.Printf("Error formatting message: %v", err)
@@ -110,4 +320,4 @@ func (m *Messaging) SendMessage(to string, peerID peer.ID, content interface{})
     }
 
     return nil
-}
\ No newline at end of file
+}
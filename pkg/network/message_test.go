@@ -0,0 +1,145 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+type testJob struct {
+	JobID string `json:"job_id"`
+}
+
+func TestMessageDelivery(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	keeperID := tn.connect(testManager, "keeper1")
+
+	received := make(chan Message, 1)
+	HandleTyped(tn.node("keeper1").messaging, JobTransmissionMessage, func(msg Message, job testJob) error {
+		if job.JobID == "" {
+			return errors.New("job without ID")
+		}
+		received <- msg
+		return nil
+	})
+
+	manager := tn.node(testManager).messaging
+	if err := manager.SendTypedMessage("keeper1", keeperID, JobTransmissionMessage, testJob{JobID: "1"}); err != nil {
+		t.Fatalf("failed to send job: %v", err)
+	}
+	msg := <-received
+	if msg.From != testManager || msg.Content.(testJob).JobID != "1" {
+		t.Fatalf("received %+v", msg)
+	}
+
+	tests := []struct {
+		name    string
+		msgType string
+		content interface{}
+		code    string
+	}{
+		{"unknown type", "UNKNOWN", testJob{JobID: "1"}, ReplyUnknownType},
+		{"unknown field", JobTransmissionMessage, map[string]string{"job": "1"}, ReplyInvalidPayload},
+		{"handler error", JobTransmissionMessage, testJob{}, ReplyRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := manager.SendTypedMessage("keeper1", keeperID, tt.msgType, tt.content)
+			var replyErr *ReplyError
+			if !errors.As(err, &replyErr) || replyErr.Code != tt.code {
+				t.Fatalf("got %v, want reply code %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestMessageFromUnknownSignerIsRejected(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	keeperID := tn.connect(testManager, "keeper1")
+	HandleTyped(tn.node("keeper1").messaging, JobTransmissionMessage, func(msg Message, job testJob) error {
+		return nil
+	})
+
+	// A node posing as the task manager signs with a key of its own
+	impostor := tn.addNode("impostor")
+	tn.heal("impostor", "keeper1")
+	impostor.messaging.name = testManager
+	if _, err := impostor.discovery.Connect("keeper1"); err != nil {
+		t.Fatal(err)
+	}
+	err := impostor.messaging.SendTypedMessage("keeper1", keeperID, JobTransmissionMessage, testJob{JobID: "1"})
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != ReplyUnauthenticated {
+		t.Fatalf("got %v, want reply code %s", err, ReplyUnauthenticated)
+	}
+}
+
+func TestVerifierRejectsReplay(t *testing.T) {
+	tn := newTestNetwork(t)
+	msg, err := tn.node(testManager).messaging.newMessage("keeper1", JobTransmissionMessage, testJob{JobID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := msg.Content.(json.RawMessage)
+
+	verifier := NewVerifier(tn.operators, tn.signers)
+	if err := verifier.Verify(context.Background(), &msg, content); err != nil {
+		t.Fatalf("first delivery rejected: %v", err)
+	}
+	if err := verifier.Verify(context.Background(), &msg, content); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("replay returned %v, want ErrUnauthenticated", err)
+	}
+
+	tampered := append(json.RawMessage(nil), `{"job_id":"2"}`...)
+	if err := NewVerifier(tn.operators, tn.signers).Verify(context.Background(), &msg, tampered); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("tampered content returned %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestVerifierChecksBoundKeeperIsOperator(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	msg, err := tn.node("keeper1").messaging.newMessage(testManager, JobTransmissionMessage, testJob{JobID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := msg.Content.(json.RawMessage)
+
+	// A binding only vouches for service peers, keepers must also be
+	// registered operators
+	if err := NewVerifier(registeredOperators{}, tn.signers).Verify(context.Background(), &msg, content); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("unregistered keeper returned %v, want ErrUnauthenticated", err)
+	}
+	if err := NewVerifier(tn.operators, tn.signers).Verify(context.Background(), &msg, content); err != nil {
+		t.Fatalf("registered keeper rejected: %v", err)
+	}
+}
+
+func TestMessageFallsBackToFirstProtocol(t *testing.T) {
+	tn := newTestNetwork(t)
+
+	// An old keeper only speaks MessageProtocol and sends no replies
+	old := tn.addNode("old_keeper")
+	old.host.RemoveStreamHandler(protocol.ID(MessageProtocolV3))
+	old.host.RemoveStreamHandler(protocol.ID(MessageProtocolV2))
+	tn.heal(testManager, "old_keeper")
+	received := make(chan string, 1)
+	old.host.SetStreamHandler(protocol.ID(MessageProtocol), func(stream network.Stream) {
+		defer stream.Close()
+		var msg Message
+		if err := json.NewDecoder(stream).Decode(&msg); err == nil {
+			received <- msg.Type
+		}
+	})
+
+	peerID := tn.connect(testManager, "old_keeper")
+	if err := tn.node(testManager).messaging.SendTypedMessage("old_keeper", peerID, JobTransmissionMessage, testJob{JobID: "1"}); err != nil {
+		t.Fatalf("failed to send to an old keeper: %v", err)
+	}
+	if msgType := <-received; msgType != JobTransmissionMessage {
+		t.Fatalf("old keeper received %s", msgType)
+	}
+}
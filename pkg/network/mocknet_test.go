@@ -0,0 +1,302 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	testManager = "task_manager"
+	// testTimeout bounds every wait on the mock network
+	testTimeout = 5 * time.Second
+)
+
+// memorySource is a PeerSource shared by every node of a testNetwork, so
+// advertising works like the database or rendezvous backends
+type memorySource struct {
+	mu    sync.RWMutex
+	peers map[string]peer.AddrInfo
+}
+
+func newMemorySource() *memorySource {
+	return &memorySource{peers: make(map[string]peer.AddrInfo)}
+}
+
+func (s *memorySource) Advertise(ctx context.Context, name string, info peer.AddrInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers[name] = info
+	return nil
+}
+
+func (s *memorySource) FindPeer(ctx context.Context, name string) (peer.AddrInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, ok := s.peers[name]
+	if !ok {
+		return peer.AddrInfo{}, fmt.Errorf("%w: %s", ErrPeerNotFound, name)
+	}
+	return info, nil
+}
+
+func (s *memorySource) Peers(ctx context.Context) (map[string]peer.AddrInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make(map[string]peer.AddrInfo, len(s.peers))
+	for name, info := range s.peers {
+		peers[name] = info
+	}
+	return peers, nil
+}
+
+// registeredOperators is an OperatorSet that knows every node of a
+// testNetwork
+type registeredOperators map[string]bool
+
+func (o registeredOperators) IsOperator(ctx context.Context, scheme, signer string) (bool, error) {
+	return o[signer], nil
+}
+
+// testNode is one manager, keeper or aggregator of a testNetwork
+type testNode struct {
+	name      string
+	key       *ecdsa.PrivateKey
+	identity  *Identity
+	host      host.Host
+	discovery *Discovery
+	messaging *Messaging
+	rpc       *RPC
+	pubsub    *PubSub
+}
+
+// testNetwork runs named nodes in one process on a libp2p mocknet. Nodes
+// can reach each other only over links, which can be given latency and
+// dropped to simulate partitions.
+type testNetwork struct {
+	t      *testing.T
+	ctx    context.Context
+	mn     mocknet.Mocknet
+	source *memorySource
+	// signers binds every node name to its signing address
+	signers map[string]string
+	// operators holds the signing address of every node that is not a
+	// service peer
+	operators registeredOperators
+	nodes     map[string]*testNode
+}
+
+// newTestNetwork starts a task manager and the given keepers. Every node
+// signs its messages and verifies those it receives. Nodes are linked but
+// not connected.
+func newTestNetwork(t *testing.T, keepers ...string) *testNetwork {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	tn := &testNetwork{
+		t:         t,
+		ctx:       ctx,
+		mn:        mocknet.New(),
+		source:    newMemorySource(),
+		signers:   make(map[string]string),
+		operators: make(registeredOperators),
+		nodes:     make(map[string]*testNode),
+	}
+	t.Cleanup(func() {
+		cancel()
+		tn.mn.Close()
+	})
+
+	for _, name := range append([]string{testManager}, keepers...) {
+		tn.addNode(name)
+	}
+	for _, node := range tn.nodes {
+		verifier := NewVerifier(tn.operators, tn.signers)
+		node.messaging.SetVerifier(verifier)
+		node.pubsub.SetVerifier(verifier)
+	}
+	if err := tn.mn.LinkAll(); err != nil {
+		t.Fatalf("failed to link nodes: %v", err)
+	}
+	return tn
+}
+
+// addNode starts a node on a fresh identity and advertises it
+func (tn *testNetwork) addNode(name string) *testNode {
+	tn.t.Helper()
+	key, err := ethcrypto.GenerateKey()
+	if err != nil {
+		tn.t.Fatalf("failed to generate key: %v", err)
+	}
+	identity, err := GenerateIdentity()
+	if err != nil {
+		tn.t.Fatal(err)
+	}
+	return tn.addNodeWithIdentity(name, key, identity)
+}
+
+func (tn *testNetwork) addNodeWithIdentity(name string, key *ecdsa.PrivateKey, identity *Identity) *testNode {
+	tn.t.Helper()
+	addr := multiaddr.StringCast(fmt.Sprintf("/ip4/10.0.%d.%d/tcp/4242", len(tn.mn.Peers())/250, len(tn.mn.Peers())%250+1))
+	h, err := tn.mn.AddPeer(identity.Key, addr)
+	if err != nil {
+		tn.t.Fatalf("failed to add %s: %v", name, err)
+	}
+	ping.NewPingService(h)
+	ServeIdentity(h, identity)
+	pubsub, err := NewPubSub(tn.ctx, h, name)
+	if err != nil {
+		tn.t.Fatal(err)
+	}
+
+	node := &testNode{
+		name:      name,
+		key:       key,
+		identity:  identity,
+		host:      h,
+		discovery: NewDiscovery(tn.ctx, h, name, tn.source),
+		messaging: NewMessaging(h, name),
+		pubsub:    pubsub,
+	}
+	node.messaging.SetSigner(NewECDSASigner(key))
+	node.pubsub.SetSigner(NewECDSASigner(key))
+	node.messaging.Listen()
+	node.rpc = NewRPC(node.messaging)
+	if err := node.discovery.Advertise(); err != nil {
+		tn.t.Fatal(err)
+	}
+
+	tn.signers[name] = ethcrypto.PubkeyToAddress(key.PublicKey).Hex()
+	if !servicePeers[name] {
+		tn.operators[tn.signers[name]] = true
+	}
+	tn.nodes[name] = node
+	return node
+}
+
+func (tn *testNetwork) node(name string) *testNode {
+	tn.t.Helper()
+	node, ok := tn.nodes[name]
+	if !ok {
+		tn.t.Fatalf("no node %s", name)
+	}
+	return node
+}
+
+// peerInfo is what a node advertises
+func peerInfo(node *testNode) peer.AddrInfo {
+	return peer.AddrInfo{ID: node.host.ID(), Addrs: node.host.Addrs()}
+}
+
+// connect dials b from a through discovery
+func (tn *testNetwork) connect(a, b string) peer.ID {
+	tn.t.Helper()
+	peerID, err := tn.node(a).discovery.Connect(b)
+	if err != nil {
+		tn.t.Fatalf("%s failed to connect to %s: %v", a, b, err)
+	}
+	return peerID
+}
+
+// partition drops the link between two nodes and closes their connections
+func (tn *testNetwork) partition(a, b string) {
+	tn.t.Helper()
+	idA, idB := tn.node(a).host.ID(), tn.node(b).host.ID()
+	if err := tn.mn.UnlinkPeers(idA, idB); err != nil {
+		tn.t.Fatalf("failed to unlink %s and %s: %v", a, b, err)
+	}
+	if err := tn.mn.DisconnectPeers(idA, idB); err != nil {
+		tn.t.Fatalf("failed to disconnect %s and %s: %v", a, b, err)
+	}
+}
+
+// heal links two nodes, again after a partition
+func (tn *testNetwork) heal(a, b string) {
+	tn.t.Helper()
+	if _, err := tn.mn.LinkPeers(tn.node(a).host.ID(), tn.node(b).host.ID()); err != nil {
+		tn.t.Fatalf("failed to link %s and %s: %v", a, b, err)
+	}
+}
+
+// setLatency delays every write between two nodes
+func (tn *testNetwork) setLatency(a, b string, latency time.Duration) {
+	tn.t.Helper()
+	links := tn.mn.LinksBetweenPeers(tn.node(a).host.ID(), tn.node(b).host.ID())
+	if len(links) == 0 {
+		tn.t.Fatalf("%s and %s are not linked", a, b)
+	}
+	for _, link := range links {
+		link.SetOptions(mocknet.LinkOptions{Latency: latency})
+	}
+}
+
+// subscribe subscribes node to a topic
+func (tn *testNetwork) subscribe(name, topic string) *Subscription {
+	tn.t.Helper()
+	sub, err := tn.node(name).pubsub.Subscribe(topic)
+	if err != nil {
+		tn.t.Fatal(err)
+	}
+	return sub
+}
+
+// waitSubscribed waits until node knows that peers subscribe to a topic,
+// which GossipSub needs to send to them
+func (tn *testNetwork) waitSubscribed(name, topic string, peers ...string) {
+	tn.t.Helper()
+	waitFor(tn.t, name+" to see the subscribers of "+topic, func() bool {
+		subscribers := make(map[peer.ID]bool)
+		for _, p := range tn.node(name).pubsub.ListPeers(topic) {
+			subscribers[p] = true
+		}
+		for _, p := range peers {
+			if !subscribers[tn.node(p).host.ID()] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// waitFor polls cond until it holds or testTimeout passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// nextMessage waits for a subscription's next message
+func nextMessage(t *testing.T, sub *Subscription) *TopicMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	msg, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatalf("no message on %s: %v", sub.topic, err)
+	}
+	return msg
+}
+
+// noMessage checks that a subscription stays empty for a while
+func noMessage(t *testing.T, sub *Subscription) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if msg, err := sub.Next(ctx); err == nil {
+		t.Fatalf("unexpected message on %s from %s", sub.topic, msg.From)
+	}
+}
@@ -0,0 +1,176 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Reachability overrides what AutoNAT finds out about a node
+const (
+	ReachabilityAuto    = ""
+	ReachabilityPublic  = "public"
+	ReachabilityPrivate = "private"
+)
+
+// NATConfig makes a node reachable from other networks. The zero value
+// listens only on the configured address and announces what it listens on.
+type NATConfig struct {
+	// ListenAddrs are listened on in addition to the node's address
+	ListenAddrs []multiaddr.Multiaddr
+	// AnnounceAddrs replace the addresses the node advertises, for nodes
+	// behind a port forward or a load balancer
+	AnnounceAddrs []multiaddr.Multiaddr
+	// PortMapping opens a port on the router with UPnP or NAT-PMP
+	PortMapping bool
+	// AutoNAT answers other nodes' reachability checks. Every node checks
+	// its own reachability unless Reachability is set.
+	AutoNAT bool
+	// HolePunching upgrades relayed connections to direct ones
+	HolePunching bool
+	// RelayService relays connections of nodes that are not reachable,
+	// for nodes with a public address
+	RelayService bool
+	// Relays are used to be reachable while behind NAT
+	Relays []peer.AddrInfo
+	// Reachability is ReachabilityPublic or ReachabilityPrivate to skip
+	// AutoNAT, ReachabilityAuto otherwise
+	Reachability string
+}
+
+// NATConfigFromEnv reads the NAT configuration of a node:
+//
+//	TRIGGERX_LISTEN_ADDRS    extra listen multiaddrs, comma separated
+//	TRIGGERX_ANNOUNCE_ADDRS  multiaddrs to advertise instead of the listen ones
+//	TRIGGERX_NAT_PORTMAP     open a router port with UPnP or NAT-PMP
+//	TRIGGERX_AUTONAT         answer reachability checks of other nodes
+//	TRIGGERX_HOLE_PUNCHING   upgrade relayed connections to direct ones
+//	TRIGGERX_RELAY_SERVICE   relay for nodes behind NAT
+//	TRIGGERX_RELAYS          relay multiaddrs ending in /p2p/<peer ID>
+//	TRIGGERX_REACHABILITY    public or private, detected when unset
+func NATConfigFromEnv() (NATConfig, error) {
+	var config NATConfig
+	var err error
+
+	if config.ListenAddrs, err = parseMultiaddrs(os.Getenv("TRIGGERX_LISTEN_ADDRS")); err != nil {
+		return NATConfig{}, fmt.Errorf("invalid TRIGGERX_LISTEN_ADDRS: %v", err)
+	}
+	if config.AnnounceAddrs, err = parseMultiaddrs(os.Getenv("TRIGGERX_ANNOUNCE_ADDRS")); err != nil {
+		return NATConfig{}, fmt.Errorf("invalid TRIGGERX_ANNOUNCE_ADDRS: %v", err)
+	}
+
+	flags := map[string]*bool{
+		"TRIGGERX_NAT_PORTMAP":   &config.PortMapping,
+		"TRIGGERX_AUTONAT":       &config.AutoNAT,
+		"TRIGGERX_HOLE_PUNCHING": &config.HolePunching,
+		"TRIGGERX_RELAY_SERVICE": &config.RelayService,
+	}
+	for name, flag := range flags {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if *flag, err = strconv.ParseBool(value); err != nil {
+			return NATConfig{}, fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+
+	relays, err := parseMultiaddrs(os.Getenv("TRIGGERX_RELAYS"))
+	if err != nil {
+		return NATConfig{}, fmt.Errorf("invalid TRIGGERX_RELAYS: %v", err)
+	}
+	if config.Relays, err = peer.AddrInfosFromP2pAddrs(relays...); err != nil {
+		return NATConfig{}, fmt.Errorf("invalid TRIGGERX_RELAYS: %v", err)
+	}
+
+	config.Reachability = strings.ToLower(strings.TrimSpace(os.Getenv("TRIGGERX_REACHABILITY")))
+	switch config.Reachability {
+	case ReachabilityAuto, ReachabilityPublic, ReachabilityPrivate:
+	default:
+		return NATConfig{}, fmt.Errorf("invalid TRIGGERX_REACHABILITY %q, expected public or private", config.Reachability)
+	}
+	return config, nil
+}
+
+// Options turns the configuration into libp2p host options
+func (c NATConfig) Options() []libp2p.Option {
+	options := []libp2p.Option{libp2p.AddrsFactory(c.advertisedAddrs)}
+	if len(c.ListenAddrs) > 0 {
+		options = append(options, libp2p.ListenAddrs(c.ListenAddrs...))
+	}
+	if c.PortMapping {
+		options = append(options, libp2p.NATPortMap())
+	}
+	if c.AutoNAT {
+		options = append(options, libp2p.EnableNATService(), libp2p.EnableAutoNATv2())
+	}
+	if c.HolePunching {
+		options = append(options, libp2p.EnableHolePunching())
+	}
+	if c.RelayService {
+		options = append(options, libp2p.EnableRelayService())
+	}
+	if len(c.Relays) > 0 {
+		options = append(options, libp2p.EnableAutoRelayWithStaticRelays(c.Relays))
+	}
+
+	switch c.Reachability {
+	case ReachabilityPublic:
+		options = append(options, libp2p.ForceReachabilityPublic())
+	case ReachabilityPrivate:
+		options = append(options, libp2p.ForceReachabilityPrivate())
+	}
+	return options
+}
+
+// advertisedAddrs picks the addresses other nodes can dial: the announce
+// addresses when set, else the listen addresses without loopback ones
+// unless the node listens on loopback only. Relay addresses are added on
+// top by libp2p while the node is not reachable.
+func (c NATConfig) advertisedAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	if len(c.AnnounceAddrs) > 0 {
+		return c.AnnounceAddrs
+	}
+
+	dialable := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if !manet.IsIPLoopback(addr) {
+			dialable = append(dialable, addr)
+		}
+	}
+	if len(dialable) == 0 {
+		return addrs
+	}
+	return dialable
+}
+
+// allowRelayed lets streams use relayed connections, which libp2p holds
+// back while it waits for a direct one. Relays cap the traffic they carry,
+// so item transfers wait for a direct connection.
+func allowRelayed(ctx context.Context) context.Context {
+	return network.WithAllowLimitedConn(ctx, "triggerx")
+}
+
+func parseMultiaddrs(list string) ([]multiaddr.Multiaddr, error) {
+	var addrs []multiaddr.Multiaddr
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addr, err := multiaddr.NewMultiaddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestNATConfigFromEnv(t *testing.T) {
+	relay := "/ip4/203.0.113.7/tcp/4001/p2p/12D3KooWRynuDBVGde6H9Kaa18PjkD5aHdaWXNuK5eJaKqsibs1N"
+	t.Setenv("TRIGGERX_LISTEN_ADDRS", "/ip4/0.0.0.0/tcp/4001, /ip4/0.0.0.0/udp/4001/quic-v1")
+	t.Setenv("TRIGGERX_ANNOUNCE_ADDRS", "/dns4/keeper.example.com/tcp/4001")
+	t.Setenv("TRIGGERX_HOLE_PUNCHING", "true")
+	t.Setenv("TRIGGERX_RELAYS", relay)
+	t.Setenv("TRIGGERX_REACHABILITY", "Private")
+
+	config, err := NATConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.ListenAddrs) != 2 || len(config.AnnounceAddrs) != 1 {
+		t.Fatalf("got listen %v, announce %v", config.ListenAddrs, config.AnnounceAddrs)
+	}
+	if !config.HolePunching || config.PortMapping || config.AutoNAT || config.RelayService {
+		t.Fatalf("got flags %+v", config)
+	}
+	if len(config.Relays) != 1 || config.Relays[0].ID.String() != "12D3KooWRynuDBVGde6H9Kaa18PjkD5aHdaWXNuK5eJaKqsibs1N" {
+		t.Fatalf("got relays %v", config.Relays)
+	}
+	if config.Reachability != ReachabilityPrivate {
+		t.Fatalf("got reachability %q", config.Reachability)
+	}
+
+	for name, value := range map[string]string{
+		"TRIGGERX_AUTONAT":      "maybe",
+		"TRIGGERX_RELAYS":       "/ip4/203.0.113.7/tcp/4001",
+		"TRIGGERX_REACHABILITY": "sometimes",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := NATConfigFromEnv(); err == nil {
+				t.Fatalf("accepted %s=%s", name, value)
+			}
+		})
+	}
+}
+
+func TestAdvertisedAddrs(t *testing.T) {
+	loopback := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+	lan := multiaddr.StringCast("/ip4/192.168.1.20/tcp/4001")
+	announce := multiaddr.StringCast("/ip4/203.0.113.20/tcp/4001")
+
+	tests := []struct {
+		name   string
+		config NATConfig
+		addrs  []multiaddr.Multiaddr
+		want   []multiaddr.Multiaddr
+	}{
+		{"drops loopback", NATConfig{}, []multiaddr.Multiaddr{loopback, lan}, []multiaddr.Multiaddr{lan}},
+		{"keeps loopback only nodes", NATConfig{}, []multiaddr.Multiaddr{loopback}, []multiaddr.Multiaddr{loopback}},
+		{"announce overrides", NATConfig{AnnounceAddrs: []multiaddr.Multiaddr{announce}}, []multiaddr.Multiaddr{loopback, lan}, []multiaddr.Multiaddr{announce}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.advertisedAddrs(tt.addrs); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallThroughRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, err := SetupP2P(ctx, P2PConfig{Address: "/ip4/127.0.0.1/tcp/0", NAT: NATConfig{RelayService: true, Reachability: ReachabilityPublic}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	relayInfo := peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+
+	keeper, err := SetupP2P(ctx, P2PConfig{Address: "/ip4/127.0.0.1/tcp/0", NAT: NATConfig{Relays: []peer.AddrInfo{relayInfo}, Reachability: ReachabilityPrivate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keeper.Close()
+	manager, err := SetupP2P(ctx, P2PConfig{Address: "/ip4/127.0.0.1/tcp/0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	keeperMessaging := NewMessaging(keeper, "keeper1")
+	acknowledgeJobs(&testNode{name: "keeper1", rpc: NewRPC(keeperMessaging)})
+
+	// Relays on loopback are not advertised, so the circuit address is
+	// built once the keeper holds a reservation
+	waitFor(t, "the keeper to reserve a relay slot", func() bool {
+		return len(keeper.Network().ConnsToPeer(relay.ID())) > 0
+	})
+	circuit := relayInfo.Addrs[0].Encapsulate(multiaddr.StringCast("/p2p/" + relay.ID().String() + "/p2p-circuit"))
+	connect := func() bool {
+		return manager.Connect(ctx, peer.AddrInfo{ID: keeper.ID(), Addrs: []multiaddr.Multiaddr{circuit}}) == nil
+	}
+	waitFor(t, "a relayed connection to the keeper", connect)
+
+	callCtx, cancelCall := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelCall()
+	var ack JobAck
+	if err := NewRPC(NewMessaging(manager, testManager)).Call(callCtx, "keeper1", keeper.ID(), JobTransmissionMessage, testJob{JobID: "1"}, &ack, CallOptions{}); err != nil {
+		t.Fatalf("relayed call failed: %v", err)
+	}
+	if ack.JobID != "1" {
+		t.Fatalf("got ack %+v", ack)
+	}
+}
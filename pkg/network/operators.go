@@ -0,0 +1,100 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	regcoord "github.com/trigg3rX/go-backend/pkg/avsinterface/bindings/RegistryCoordinator"
+)
+
+// operatorCacheTTL is how long a registration lookup is reused
+const operatorCacheTTL = 5 * time.Minute
+
+// operatorRegistered is the RegistryCoordinator OperatorStatus of a
+// registered operator
+const operatorRegistered = 1
+
+type operatorEntry struct {
+	registered bool
+	checkedAt  time.Time
+}
+
+// ChainOperatorSet checks operators against the RegistryCoordinator
+type ChainOperatorSet struct {
+	coordinator *regcoord.ContractRegistryCoordinator
+
+	mu    sync.Mutex
+	cache map[string]operatorEntry
+}
+
+func NewChainOperatorSet(client bind.ContractBackend, coordinator common.Address) (*ChainOperatorSet, error) {
+	contract, err := regcoord.NewContractRegistryCoordinator(coordinator, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind registry coordinator: %v", err)
+	}
+	return &ChainOperatorSet{
+		coordinator: contract,
+		cache:       make(map[string]operatorEntry),
+	}, nil
+}
+
+func (s *ChainOperatorSet) IsOperator(ctx context.Context, scheme, signer string) (bool, error) {
+	key := scheme + "/" + strings.ToLower(signer)
+	s.mu.Lock()
+	entry, cached := s.cache[key]
+	s.mu.Unlock()
+	if cached && time.Since(entry.checkedAt) < operatorCacheTTL {
+		return entry.registered, nil
+	}
+
+	registered, err := s.lookup(ctx, scheme, signer)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.cache[key] = operatorEntry{registered: registered, checkedAt: time.Now()}
+	s.mu.Unlock()
+	return registered, nil
+}
+
+func (s *ChainOperatorSet) lookup(ctx context.Context, scheme, signer string) (bool, error) {
+	opts := &bind.CallOpts{Context: ctx}
+
+	var operator common.Address
+	switch scheme {
+	case SchemeECDSA:
+		if !common.IsHexAddress(signer) {
+			return false, nil
+		}
+		operator = common.HexToAddress(signer)
+	case SchemeBLS:
+		id, err := hexutil.Decode(signer)
+		if err != nil || len(id) != 32 {
+			return false, nil
+		}
+		var operatorID [32]byte
+		copy(operatorID[:], id)
+		if operator, err = s.coordinator.GetOperatorFromId(opts, operatorID); err != nil {
+			return false, fmt.Errorf("failed to get operator of %s: %v", signer, err)
+		}
+		if operator == (common.Address{}) {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	status, err := s.coordinator.GetOperatorStatus(opts, operator)
+	if err != nil {
+		return false, fmt.Errorf("failed to get status of operator %s: %v", operator.Hex(), err)
+	}
+	return status == operatorRegistered, nil
+}
@@ -12,6 +12,11 @@ import (
 type P2PConfig struct {
 	Name    string
 	Address string
+	// Identity keeps the peer ID stable across restarts, a random one is
+	// used when nil
+	Identity *Identity
+	// NAT makes the node reachable from other networks
+	NAT NATConfig
 }
 
 var KeeperConfigs = map[string]string{
@@ -28,12 +33,23 @@ func SetupP2P(ctx context.Context, config P2PConfig) (host.Host, error) {
 		return nil, fmt.Errorf("invalid address: %v", err)
 	}
 
-	h, err := libp2p.New(
-		libp2p.ListenAddrs(maddr),
-	)
+	connManager, err := ConnManagerOption()
+	if err != nil {
+		return nil, err
+	}
+	options := []libp2p.Option{libp2p.ListenAddrs(maddr), connManager}
+	options = append(options, config.NAT.Options()...)
+	if config.Identity != nil {
+		options = append(options, libp2p.Identity(config.Identity.Key))
+	}
+
+	h, err := libp2p.New(options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create host: %v", err)
 	}
+	if config.Identity != nil {
+		ServeIdentity(h, config.Identity)
+	}
 
 	return h, nil
 }
@@ -0,0 +1,317 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	// LowWatermark and HighWatermark bound the connections a node keeps.
+	// Above the high watermark the connection manager trims unprotected
+	// connections down to the low one.
+	LowWatermark  = 32
+	HighWatermark = 96
+
+	// MaxPeerFailures is the number of consecutive failed dials or pings
+	// after which a peer counts as unhealthy
+	MaxPeerFailures = 3
+	// DeadPeerTimeout is how long a peer may go unseen before it is no
+	// longer tracked
+	DeadPeerTimeout = 10 * time.Minute
+
+	minReconnectBackoff = 2 * time.Second
+	maxReconnectBackoff = 5 * time.Minute
+	pingTimeout         = 10 * time.Second
+	protectTag          = "triggerx"
+)
+
+// ConnManagerOption limits a host's connections to the watermarks
+func ConnManagerOption() (libp2p.Option, error) {
+	manager, err := connmgr.NewConnManager(LowWatermark, HighWatermark, connmgr.WithGracePeriod(time.Minute))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection manager: %v", err)
+	}
+	return libp2p.ConnectionManager(manager), nil
+}
+
+// PeerHealth is what a PeerManager knows about a peer
+type PeerHealth struct {
+	Name        string        `json:"name"`
+	ID          peer.ID       `json:"id,omitempty"`
+	Connected   bool          `json:"connected"`
+	Latency     time.Duration `json:"latency"`
+	Failures    int           `json:"failures"`
+	LastSeen    time.Time     `json:"last_seen"`
+	NextAttempt time.Time     `json:"next_attempt"`
+}
+
+// Healthy reports whether the peer answered its last dials or pings
+func (h PeerHealth) Healthy() bool {
+	return h.Failures < MaxPeerFailures
+}
+
+// PeerManager keeps tracked peers connected. It pings connected peers for
+// liveness and latency, and redials lost peers with exponential backoff.
+type PeerManager struct {
+	discovery *Discovery
+
+	mu    sync.RWMutex
+	peers map[string]*PeerHealth
+}
+
+func NewPeerManager(discovery *Discovery) *PeerManager {
+	return &PeerManager{
+		discovery: discovery,
+		peers:     make(map[string]*PeerHealth),
+	}
+}
+
+// Track keeps a named peer connected from now on
+func (pm *PeerManager) Track(name string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if _, ok := pm.peers[name]; !ok {
+		pm.peers[name] = &PeerHealth{Name: name, LastSeen: time.Now()}
+	}
+}
+
+// Connect returns the peer ID of a named peer, reusing a live connection
+// and dialing otherwise. The peer is tracked from then on.
+func (pm *PeerManager) Connect(name string) (peer.ID, error) {
+	pm.Track(name)
+
+	pm.mu.RLock()
+	health := *pm.peers[name]
+	pm.mu.RUnlock()
+
+	host := pm.discovery.host
+	if health.ID != "" && host.Network().Connectedness(health.ID) == network.Connected {
+		return health.ID, nil
+	}
+	return pm.dial(name)
+}
+
+// Health returns what is known about a peer
+func (pm *PeerManager) Health(name string) (PeerHealth, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	health, ok := pm.peers[name]
+	if !ok {
+		return PeerHealth{}, false
+	}
+	return *health, true
+}
+
+// Peers returns the health of every tracked peer
+func (pm *PeerManager) Peers() map[string]PeerHealth {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	peers := make(map[string]PeerHealth, len(pm.peers))
+	for name, health := range pm.peers {
+		peers[name] = *health
+	}
+	return peers
+}
+
+// Peer tiers in order of preference
+const (
+	tierLive = iota
+	tierUntried
+	tierFailing
+)
+
+// score returns the tier, failures and latency of a peer. The caller holds
+// pm.mu.
+func (pm *PeerManager) score(name string) (int, int, time.Duration) {
+	health, ok := pm.peers[name]
+	switch {
+	case !ok || (health.ID == "" && health.Failures == 0):
+		return tierUntried, 0, 0
+	case !health.Healthy() || !health.Connected:
+		return tierFailing, health.Failures, health.Latency
+	default:
+		return tierLive, health.Failures, health.Latency
+	}
+}
+
+// Rank orders peers for selection: peers that answered their last dials or
+// pings by latency, then peers not reached yet, then failing peers by
+// failure count
+func (pm *PeerManager) Rank(names []string) []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	ranked := append([]string(nil), names...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		tierI, failuresI, latencyI := pm.score(ranked[i])
+		tierJ, failuresJ, latencyJ := pm.score(ranked[j])
+		if tierI != tierJ {
+			return tierI < tierJ
+		}
+		if failuresI != failuresJ {
+			return failuresI < failuresJ
+		}
+		return latencyI < latencyJ
+	})
+	return ranked
+}
+
+// TopTier returns the peers of the best tier present: the live peers, else
+// the peers not reached yet, else the failing peers with the fewest
+// failures
+func (pm *PeerManager) TopTier(names []string) []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var top []string
+	bestTier, bestFailures := tierFailing+1, 0
+	for _, name := range names {
+		tier, failures, _ := pm.score(name)
+		if tier == tierLive || tier == tierUntried {
+			failures = 0
+		}
+		switch {
+		case tier < bestTier || (tier == bestTier && failures < bestFailures):
+			top = []string{name}
+			bestTier, bestFailures = tier, failures
+		case tier == bestTier && failures == bestFailures:
+			top = append(top, name)
+		}
+	}
+	return top
+}
+
+// Run checks tracked peers every interval until ctx ends
+func (pm *PeerManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		pm.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pm *PeerManager) check(ctx context.Context) {
+	now := time.Now()
+	host := pm.discovery.host
+
+	pm.mu.Lock()
+	var names []string
+	for name, health := range pm.peers {
+		if now.Sub(health.LastSeen) > DeadPeerTimeout {
+			log.Printf("Dropping peer %s, unseen since %s", name, health.LastSeen.Format(time.RFC3339))
+			if health.ID != "" {
+				host.ConnManager().Unprotect(health.ID, protectTag)
+			}
+			delete(pm.peers, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	pm.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		health, ok := pm.Health(name)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(health PeerHealth) {
+			defer wg.Done()
+			if health.ID != "" && host.Network().Connectedness(health.ID) == network.Connected {
+				pm.ping(ctx, health.Name, health.ID)
+			} else if !now.Before(health.NextAttempt) {
+				pm.dial(health.Name)
+			}
+		}(health)
+	}
+	wg.Wait()
+}
+
+func (pm *PeerManager) dial(name string) (peer.ID, error) {
+	peerID, err := pm.discovery.Connect(name)
+	if err != nil {
+		pm.recordFailure(name, err)
+		return "", err
+	}
+
+	pm.discovery.host.ConnManager().Protect(peerID, protectTag)
+	pm.mu.Lock()
+	if health, ok := pm.peers[name]; ok {
+		health.ID = peerID
+		health.Connected = true
+		health.Failures = 0
+		health.LastSeen = time.Now()
+		health.NextAttempt = time.Time{}
+	}
+	pm.mu.Unlock()
+	return peerID, nil
+}
+
+func (pm *PeerManager) ping(ctx context.Context, name string, peerID peer.ID) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	result := <-ping.Ping(allowRelayed(ctx), pm.discovery.host, peerID)
+	if result.Error != nil {
+		pm.recordFailure(name, result.Error)
+		return
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if health, ok := pm.peers[name]; ok {
+		// Smooth latency so one slow ping does not reorder keepers
+		if health.Latency == 0 {
+			health.Latency = result.RTT
+		} else {
+			health.Latency = (health.Latency*3 + result.RTT) / 4
+		}
+		health.Connected = true
+		health.Failures = 0
+		health.LastSeen = time.Now()
+	}
+}
+
+// ReportFailure counts a failure seen outside the manager, such as a call
+// that got no response
+func (pm *PeerManager) ReportFailure(name string, err error) {
+	pm.recordFailure(name, err)
+}
+
+// recordFailure counts a failed dial or ping and schedules the next dial
+func (pm *PeerManager) recordFailure(name string, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	health, ok := pm.peers[name]
+	if !ok {
+		return
+	}
+
+	health.Connected = false
+	health.Failures++
+	backoff := minReconnectBackoff << (health.Failures - 1)
+	if backoff > maxReconnectBackoff || backoff <= 0 {
+		backoff = maxReconnectBackoff
+	}
+	health.NextAttempt = time.Now().Add(backoff)
+	log.Printf("Peer %s failed (%d in a row), next attempt in %s: %v", name, health.Failures, backoff, err)
+}
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// retryNow lets the peer manager redial a peer without waiting out its
+// backoff
+func retryNow(pm *PeerManager, name string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if health, ok := pm.peers[name]; ok {
+		health.NextAttempt = time.Time{}
+	}
+}
+
+func TestPeerManagerReconnectsAfterPartition(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	pm := NewPeerManager(tn.node(testManager).discovery)
+	pm.Track("keeper1")
+
+	pm.check(tn.ctx)
+	if health, _ := pm.Health("keeper1"); !health.Connected || health.ID != tn.node("keeper1").host.ID() {
+		t.Fatalf("keeper1 not connected: %+v", health)
+	}
+	pm.check(tn.ctx)
+	if health, _ := pm.Health("keeper1"); health.Latency == 0 {
+		t.Fatal("no latency measured for a connected peer")
+	}
+
+	tn.partition(testManager, "keeper1")
+	for i := 1; i <= MaxPeerFailures; i++ {
+		retryNow(pm, "keeper1")
+		pm.check(tn.ctx)
+		health, _ := pm.Health("keeper1")
+		if health.Connected || health.Failures != i {
+			t.Fatalf("after %d failed checks: %+v", i, health)
+		}
+	}
+	health, _ := pm.Health("keeper1")
+	if health.Healthy() {
+		t.Fatal("partitioned keeper still healthy")
+	}
+	if backoff := time.Until(health.NextAttempt); backoff < 3*minReconnectBackoff || backoff > maxReconnectBackoff {
+		t.Fatalf("backoff after %d failures is %s", MaxPeerFailures, backoff)
+	}
+
+	// Within its backoff the peer is not dialed, even with the link back
+	tn.heal(testManager, "keeper1")
+	pm.check(tn.ctx)
+	if health, _ := pm.Health("keeper1"); health.Connected {
+		t.Fatal("peer redialed within its backoff")
+	}
+
+	retryNow(pm, "keeper1")
+	pm.check(tn.ctx)
+	health, _ = pm.Health("keeper1")
+	if !health.Connected || !health.Healthy() || health.Failures != 0 {
+		t.Fatalf("keeper1 not reconnected: %+v", health)
+	}
+}
+
+func TestPeerManagerRanksKeepers(t *testing.T) {
+	tn := newTestNetwork(t, "fast", "slow", "down")
+	tn.setLatency(testManager, "fast", time.Millisecond)
+	tn.setLatency(testManager, "slow", 20*time.Millisecond)
+	tn.partition(testManager, "down")
+
+	pm := NewPeerManager(tn.node(testManager).discovery)
+	for _, name := range []string{"down", "slow", "fast"} {
+		pm.Track(name)
+	}
+	pm.check(tn.ctx)
+	pm.check(tn.ctx)
+
+	ranked := pm.Rank([]string{"down", "untracked", "slow", "fast"})
+	if want := []string{"fast", "slow", "untracked", "down"}; !reflect.DeepEqual(ranked, want) {
+		t.Fatalf("ranked %v, want %v", ranked, want)
+	}
+}
+
+func TestPeerManagerTopTier(t *testing.T) {
+	tn := newTestNetwork(t, "fast", "slow", "down")
+	tn.setLatency(testManager, "fast", time.Millisecond)
+	tn.setLatency(testManager, "slow", 20*time.Millisecond)
+	tn.partition(testManager, "down")
+
+	pm := NewPeerManager(tn.node(testManager).discovery)
+	for _, name := range []string{"down", "slow", "fast"} {
+		pm.Track(name)
+	}
+	pm.check(tn.ctx)
+
+	// Every live peer is a candidate, whatever its latency
+	top := pm.TopTier([]string{"down", "untracked", "slow", "fast"})
+	sort.Strings(top)
+	if want := []string{"fast", "slow"}; !reflect.DeepEqual(top, want) {
+		t.Fatalf("top tier %v, want %v", top, want)
+	}
+	if top := pm.TopTier([]string{"down", "untracked"}); !reflect.DeepEqual(top, []string{"untracked"}) {
+		t.Fatalf("top tier %v, want [untracked]", top)
+	}
+	if top := pm.TopTier([]string{"down"}); !reflect.DeepEqual(top, []string{"down"}) {
+		t.Fatalf("top tier %v, want [down]", top)
+	}
+}
+
+func TestPeerManagerReusesConnection(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	pm := NewPeerManager(tn.node(testManager).discovery)
+
+	first, err := pm.Connect("keeper1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A live connection is reused without looking the peer up again
+	tn.source.mu.Lock()
+	delete(tn.source.peers, "keeper1")
+	tn.source.mu.Unlock()
+	tn.node(testManager).discovery.mutex.Lock()
+	delete(tn.node(testManager).discovery.cache, "keeper1")
+	tn.node(testManager).discovery.mutex.Unlock()
+
+	second, err := pm.Connect("keeper1")
+	if err != nil || second != first {
+		t.Fatalf("reconnect returned %s, %v, want %s", second, err, first)
+	}
+}
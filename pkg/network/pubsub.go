@@ -0,0 +1,439 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PubSubProtocol is the GossipSub version topic messages are carried over.
+// Messages are not signed by libp2p, publishers sign them with their
+// operator keys instead.
+const PubSubProtocol = pubsub.GossipSubID_v11
+
+const (
+	// subscriptionBuffer is the number of undelivered messages a
+	// subscription holds before new ones are dropped
+	subscriptionBuffer = 64
+	publishTimeout     = 10 * time.Second
+	// maxPubSubMessageSize leaves room for the frame header and the
+	// GossipSub envelope around a topic message
+	maxPubSubMessageSize = MaxMessageSize + 1<<10
+)
+
+// Topic message types
+const (
+	JobBroadcastMessage = "JOB_BROADCAST"
+	HeartbeatMessage    = "KEEPER_HEARTBEAT"
+	ResultMessage       = "TASK_RESULT"
+)
+
+// JobTopic carries job broadcasts to the keepers of a quorum
+func JobTopic(chainID, quorumID string) string {
+	return fmt.Sprintf("%s/jobs/%s/%s", Namespace, chainID, quorumID)
+}
+
+// HeartbeatTopic carries keeper heartbeats of a chain
+func HeartbeatTopic(chainID string) string {
+	return fmt.Sprintf("%s/heartbeats/%s", Namespace, chainID)
+}
+
+// ResultTopic carries the task results keepers of a quorum announce
+func ResultTopic(chainID, quorumID string) string {
+	return fmt.Sprintf("%s/results/%s/%s", Namespace, chainID, quorumID)
+}
+
+// TopicMessage is a message published on a topic
+type TopicMessage struct {
+	ID        string          `json:"id"`
+	Topic     string          `json:"topic"`
+	From      string          `json:"from"`
+	Seqno     uint64          `json:"seqno"`
+	Type      string          `json:"type"`
+	Content   json.RawMessage `json:"content"`
+	Timestamp int64           `json:"timestamp"`
+	// Auth signs the message ID, it is not part of the ID
+	Auth *MessageAuth `json:"auth,omitempty"`
+
+	// ReceivedFrom is the peer that forwarded the message, empty for
+	// messages published locally
+	ReceivedFrom peer.ID `json:"-"`
+}
+
+// Decode unmarshals the message content
+func (m *TopicMessage) Decode(v interface{}) error {
+	return json.Unmarshal(m.Content, v)
+}
+
+// ValidationResult decides what happens to a received message
+type ValidationResult int
+
+const (
+	// ValidationAccept delivers and forwards the message
+	ValidationAccept ValidationResult = iota
+	// ValidationReject drops the message as invalid, GossipSub penalizes
+	// the peer that forwarded it
+	ValidationReject
+	// ValidationIgnore drops the message without treating it as invalid
+	ValidationIgnore
+)
+
+// Validator checks a message before it is delivered or forwarded
+type Validator func(ctx context.Context, msg *TopicMessage) ValidationResult
+
+// AccessControl decides who may publish on a topic
+type AccessControl interface {
+	CanPublish(topic, publisher string) bool
+}
+
+// PublisherSet allows a changing set of publishers, such as the registered
+// keepers
+type PublisherSet struct {
+	mu    sync.RWMutex
+	names map[string]bool
+}
+
+func NewPublisherSet(names ...string) *PublisherSet {
+	s := &PublisherSet{}
+	s.Set(names)
+	return s
+}
+
+// Set replaces the allowed publishers
+func (s *PublisherSet) Set(names []string) {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	s.mu.Lock()
+	s.names = allowed
+	s.mu.Unlock()
+}
+
+func (s *PublisherSet) CanPublish(topic, publisher string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.names[publisher]
+}
+
+// Subscription receives the messages of one topic
+type Subscription struct {
+	topic string
+	self  peer.ID
+	sub   *pubsub.Subscription
+}
+
+// Next waits for the next message
+func (s *Subscription) Next(ctx context.Context) (*TopicMessage, error) {
+	received, err := s.sub.Next(ctx)
+	if err != nil {
+		if errors.Is(err, pubsub.ErrSubscriptionCancelled) {
+			return nil, fmt.Errorf("subscription to %s cancelled", s.topic)
+		}
+		return nil, err
+	}
+	// The topic validator decoded the message
+	msg := *received.ValidatorData.(*TopicMessage)
+	if received.ReceivedFrom != s.self {
+		msg.ReceivedFrom = received.ReceivedFrom
+	}
+	return &msg, nil
+}
+
+// Cancel stops the subscription
+func (s *Subscription) Cancel() {
+	s.sub.Cancel()
+}
+
+// PubSub publishes and relays topic messages over GossipSub. Access control,
+// signatures and validators are checked by a topic validator, so messages
+// failing them are neither delivered nor forwarded.
+type PubSub struct {
+	host   host.Host
+	name   string
+	ctx    context.Context
+	gossip *pubsub.PubSub
+
+	mu         sync.RWMutex
+	topics     map[string]*pubsub.Topic
+	validators map[string][]Validator
+	acl        map[string]AccessControl
+	seqno      uint64
+
+	compression Compression
+	signer      MessageSigner
+	verifier    *Verifier
+}
+
+// NewPubSub starts GossipSub on h. name is the publisher name put on this
+// node's messages.
+func NewPubSub(ctx context.Context, h host.Host, name string) (*PubSub, error) {
+	gossip, err := pubsub.NewGossipSub(ctx, h,
+		pubsub.WithNoAuthor(),
+		pubsub.WithMessageIdFn(gossipMessageID),
+		pubsub.WithMaxMessageSize(maxPubSubMessageSize),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start gossipsub: %v", err)
+	}
+	return &PubSub{
+		host:       h,
+		name:       name,
+		ctx:        ctx,
+		gossip:     gossip,
+		topics:     make(map[string]*pubsub.Topic),
+		validators: make(map[string][]Validator),
+		acl:        make(map[string]AccessControl),
+		seqno:      uint64(time.Now().UnixNano()),
+	}, nil
+}
+
+// SetCompression compresses the messages published from now on
+func (ps *PubSub) SetCompression(compression Compression) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.compression = compression
+}
+
+// SetSigner signs the messages published from now on
+func (ps *PubSub) SetSigner(signer MessageSigner) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.signer = signer
+}
+
+// SetVerifier makes the node drop received messages the verifier does not
+// authenticate. Access control and validators only see authenticated
+// messages.
+func (ps *PubSub) SetVerifier(verifier *Verifier) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.verifier = verifier
+}
+
+// Subscribe delivers the messages of a topic that pass validation
+func (ps *PubSub) Subscribe(topic string) (*Subscription, error) {
+	t, err := ps.join(topic)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := t.Subscribe(pubsub.WithBufferSize(subscriptionBuffer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", topic, err)
+	}
+	return &Subscription{topic: topic, self: ps.host.ID(), sub: sub}, nil
+}
+
+// RegisterValidator adds a validation hook for a topic. Every hook must
+// accept a message for it to be delivered and forwarded.
+func (ps *PubSub) RegisterValidator(topic string, validator Validator) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.validators[topic] = append(ps.validators[topic], validator)
+}
+
+// SetAccessControl restricts who may publish on a topic. Messages from
+// other publishers are rejected, both when publishing and when received.
+func (ps *PubSub) SetAccessControl(topic string, acl AccessControl) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.acl[topic] = acl
+}
+
+// ListPeers returns the peers known to subscribe to a topic
+func (ps *PubSub) ListPeers(topic string) []peer.ID {
+	return ps.gossip.ListPeers(topic)
+}
+
+// Publish sends content on a topic to local subscribers and the topic's
+// peers
+func (ps *PubSub) Publish(topic, msgType string, content interface{}) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("error marshaling message: %v", err)
+	}
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+
+	ps.mu.Lock()
+	ps.seqno++
+	seqno := ps.seqno
+	signer := ps.signer
+	compression := ps.compression
+	ps.mu.Unlock()
+
+	msg := &TopicMessage{
+		Topic:     topic,
+		From:      ps.name,
+		Seqno:     seqno,
+		Type:      msgType,
+		Content:   data,
+		Timestamp: time.Now().Unix(),
+	}
+	msg.ID = messageID(msg)
+	if signer != nil {
+		if err := signTopicMessage(signer, msg); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error marshaling message: %v", err)
+	}
+	var frame bytes.Buffer
+	if err := WriteFrame(&frame, encoded, compression); err != nil {
+		return err
+	}
+
+	t, err := ps.join(topic)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ps.ctx, publishTimeout)
+	defer cancel()
+	if err := t.Publish(ctx, frame.Bytes()); err != nil {
+		var invalid pubsub.ValidationError
+		if errors.As(err, &invalid) {
+			return fmt.Errorf("message on %s failed validation", topic)
+		}
+		return fmt.Errorf("failed to publish on %s: %v", topic, err)
+	}
+	return nil
+}
+
+// join joins a topic once, registering its validator first
+func (ps *PubSub) join(topic string) (*pubsub.Topic, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if t, ok := ps.topics[topic]; ok {
+		return t, nil
+	}
+
+	validate := func(ctx context.Context, from peer.ID, received *pubsub.Message) pubsub.ValidationResult {
+		return ps.validateTopic(ctx, topic, received)
+	}
+	if err := ps.gossip.RegisterTopicValidator(topic, validate, pubsub.WithValidatorTimeout(publishTimeout)); err != nil {
+		return nil, fmt.Errorf("failed to register validator of %s: %v", topic, err)
+	}
+	t, err := ps.gossip.Join(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join %s: %v", topic, err)
+	}
+	ps.topics[topic] = t
+	return t, nil
+}
+
+// validateTopic decodes a message and checks it before GossipSub delivers
+// or forwards it. Messages published by this node are not authenticated,
+// access control and validators apply to them as well.
+func (ps *PubSub) validateTopic(ctx context.Context, topic string, received *pubsub.Message) pubsub.ValidationResult {
+	data, err := ReadFrame(bufio.NewReader(bytes.NewReader(received.Data)), MaxMessageSize)
+	if err != nil {
+		log.Printf("Dropping malformed topic message from %s: %v", received.ReceivedFrom, err)
+		return pubsub.ValidationReject
+	}
+	var msg TopicMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("Dropping malformed topic message from %s: %v", received.ReceivedFrom, err)
+		return pubsub.ValidationReject
+	}
+	if msg.Topic != topic || msg.ID != messageID(&msg) {
+		log.Printf("Dropping topic message with a wrong ID from %s", received.ReceivedFrom)
+		return pubsub.ValidationReject
+	}
+
+	if received.ReceivedFrom != ps.host.ID() {
+		if err := ps.authenticate(ctx, &msg); err != nil {
+			log.Printf("Dropping message %s on %s from %s: %v", msg.ID, msg.Topic, msg.From, err)
+			return pubsub.ValidationReject
+		}
+	}
+
+	switch ps.validate(ctx, &msg) {
+	case ValidationAccept:
+		received.ValidatorData = &msg
+		return pubsub.ValidationAccept
+	case ValidationReject:
+		log.Printf("Rejected message %s on %s from %s", msg.ID, msg.Topic, msg.From)
+		return pubsub.ValidationReject
+	default:
+		return pubsub.ValidationIgnore
+	}
+}
+
+// authenticate checks a received message's signature when the node has a
+// verifier
+func (ps *PubSub) authenticate(ctx context.Context, msg *TopicMessage) error {
+	ps.mu.RLock()
+	verifier := ps.verifier
+	ps.mu.RUnlock()
+	if verifier == nil {
+		return nil
+	}
+	return verifier.VerifyTopic(ctx, msg)
+}
+
+func (ps *PubSub) validate(ctx context.Context, msg *TopicMessage) ValidationResult {
+	ps.mu.RLock()
+	acl := ps.acl[msg.Topic]
+	validators := append([]Validator(nil), ps.validators[msg.Topic]...)
+	ps.mu.RUnlock()
+
+	if acl != nil && !acl.CanPublish(msg.Topic, msg.From) {
+		return ValidationReject
+	}
+	for _, validator := range validators {
+		if result := validator(ctx, msg); result != ValidationAccept {
+			return result
+		}
+	}
+	return ValidationAccept
+}
+
+// gossipMessageID identifies a GossipSub message by its bytes. The
+// signature is part of them, so a copy with a bad signature does not keep
+// the genuine message out.
+func gossipMessageID(msg *pb.Message) string {
+	sum := sha256.Sum256(msg.Data)
+	return string(sum[:])
+}
+
+// messageID hashes everything a publisher sets on a message, so relays
+// cannot change a message without changing its ID
+func messageID(msg *TopicMessage) string {
+	h := sha256.New()
+	for _, field := range []string{msg.Topic, msg.From, strconv.FormatUint(msg.Seqno, 10), msg.Type, strconv.FormatInt(msg.Timestamp, 10)} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	h.Write(msg.Content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MaxAge rejects messages published more than maxAge ago, or that far in
+// the future
+func MaxAge(maxAge time.Duration) Validator {
+	return func(ctx context.Context, msg *TopicMessage) ValidationResult {
+		age := time.Since(time.Unix(msg.Timestamp, 0))
+		if age > maxAge || age < -maxAge {
+			return ValidationIgnore
+		}
+		return ValidationAccept
+	}
+}
@@ -0,0 +1,118 @@
+package network
+
+import (
+	"testing"
+)
+
+type testResult struct {
+	JobID  string `json:"job_id"`
+	Keeper string `json:"keeper"`
+}
+
+func TestResultsRelayedToManager(t *testing.T) {
+	// keeper2 only reaches the task manager through keeper1
+	tn := newTestNetwork(t, "keeper1", "keeper2")
+	tn.partition(testManager, "keeper2")
+	tn.connect("keeper1", testManager)
+	tn.connect("keeper2", "keeper1")
+
+	// Keepers subscribe to the results of their quorum, keeper1 relays them.
+	// keeper1 joins once it knows the manager subscribes, so the manager
+	// is in its mesh right away.
+	topic := ResultTopic("17000", "0")
+	results := tn.subscribe(testManager, topic)
+	tn.node(testManager).pubsub.SetAccessControl(topic, NewPublisherSet("keeper1", "keeper2"))
+	tn.waitSubscribed("keeper1", topic, testManager)
+	tn.subscribe("keeper1", topic)
+	tn.waitSubscribed("keeper2", topic, "keeper1")
+
+	if err := tn.node("keeper2").pubsub.Publish(topic, ResultMessage, testResult{JobID: "1", Keeper: "keeper2"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := nextMessage(t, results)
+	var result testResult
+	if err := msg.Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if msg.From != "keeper2" || msg.ReceivedFrom != tn.node("keeper1").host.ID() || result.JobID != "1" {
+		t.Fatalf("got %+v from %s via %s", result, msg.From, msg.ReceivedFrom)
+	}
+	noMessage(t, results)
+}
+
+func TestResultsFromUnknownPublisherDropped(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1", "outsider")
+	tn.connect("keeper1", testManager)
+	tn.connect("outsider", testManager)
+
+	topic := ResultTopic("17000", "0")
+	results := tn.subscribe(testManager, topic)
+	tn.node(testManager).pubsub.SetAccessControl(topic, NewPublisherSet("keeper1"))
+	tn.waitSubscribed("keeper1", topic, testManager)
+	tn.waitSubscribed("outsider", topic, testManager)
+
+	if err := tn.node("outsider").pubsub.Publish(topic, ResultMessage, testResult{JobID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	noMessage(t, results)
+
+	if err := tn.node("keeper1").pubsub.Publish(topic, ResultMessage, testResult{JobID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextMessage(t, results); msg.From != "keeper1" {
+		t.Fatalf("got result from %s", msg.From)
+	}
+}
+
+func TestForgedPublisherDropped(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1", "outsider")
+	tn.connect("keeper1", testManager)
+	tn.connect("outsider", testManager)
+
+	topic := ResultTopic("17000", "0")
+	results := tn.subscribe(testManager, topic)
+	tn.node(testManager).pubsub.SetAccessControl(topic, NewPublisherSet("keeper1"))
+	tn.waitSubscribed("keeper1", topic, testManager)
+	tn.waitSubscribed("outsider", topic, testManager)
+
+	// The outsider is a registered operator but signs as itself while
+	// claiming to be keeper1
+	tn.node("outsider").pubsub.name = "keeper1"
+	if err := tn.node("outsider").pubsub.Publish(topic, ResultMessage, testResult{JobID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	noMessage(t, results)
+
+	if err := tn.node("keeper1").pubsub.Publish(topic, ResultMessage, testResult{JobID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	var result testResult
+	if err := nextMessage(t, results).Decode(&result); err != nil || result.JobID != "2" {
+		t.Fatalf("got %+v, %v", result, err)
+	}
+}
+
+func TestJobBroadcastDeliveredOnce(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1", "keeper2", "keeper3")
+	if err := tn.mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	topic := JobTopic("17000", "0")
+	var subs []*Subscription
+	for _, name := range []string{"keeper1", "keeper2", "keeper3"} {
+		subs = append(subs, tn.subscribe(name, topic))
+	}
+	tn.waitSubscribed(testManager, topic, "keeper1", "keeper2", "keeper3")
+
+	if err := tn.node(testManager).pubsub.Publish(topic, JobBroadcastMessage, testJob{JobID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	// Keepers relay the broadcast to each other, each keeps one copy
+	for _, sub := range subs {
+		if msg := nextMessage(t, sub); msg.From != testManager {
+			t.Fatalf("got broadcast from %s", msg.From)
+		}
+		noMessage(t, sub)
+	}
+}
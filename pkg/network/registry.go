@@ -0,0 +1,140 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gocql/gocql"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+// RegistrySource finds keepers by operator address through
+// keeper_data.connection_address. Nodes that are not keepers, such as the
+// task manager and the aggregator, are kept in peer_registry.
+type RegistrySource struct {
+	db *database.Connection
+}
+
+func NewRegistrySource(db *database.Connection) *RegistrySource {
+	return &RegistrySource{db: db}
+}
+
+// Advertise stores the node's addresses on its keeper_data row when it is a
+// registered keeper, and in peer_registry otherwise
+func (s *RegistrySource) Advertise(ctx context.Context, name string, info peer.AddrInfo) error {
+	address := AddrInfoString(info)
+	session := s.db.Session()
+
+	keeperID, err := s.keeperID(name)
+	if err == nil {
+		if err := session.Query(`
+            UPDATE triggerx.keeper_data SET connection_address = ? WHERE keeper_id = ?`,
+			address, keeperID).Exec(); err != nil {
+			return fmt.Errorf("failed to register keeper %s: %v", name, err)
+		}
+		return nil
+	}
+	if err != gocql.ErrNotFound {
+		return err
+	}
+
+	if err := session.Query(`
+        INSERT INTO triggerx.peer_registry (name, address, updated_at) VALUES (?, ?, ?)`,
+		name, address, time.Now().UTC()).Exec(); err != nil {
+		return fmt.Errorf("failed to register peer %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *RegistrySource) FindPeer(ctx context.Context, name string) (peer.AddrInfo, error) {
+	session := s.db.Session()
+
+	var address string
+	for _, candidate := range addressForms(name) {
+		err := session.Query(`
+            SELECT connection_address FROM triggerx.keeper_data WHERE withdrawal_address = ? ALLOW FILTERING`,
+			candidate).Scan(&address)
+		if err == nil && address != "" {
+			return AddrInfoFromString(address)
+		}
+		if err != nil && err != gocql.ErrNotFound {
+			return peer.AddrInfo{}, fmt.Errorf("failed to look up keeper %s: %v", name, err)
+		}
+	}
+
+	err := session.Query(`
+        SELECT address FROM triggerx.peer_registry WHERE name = ?`, name).Scan(&address)
+	if err == gocql.ErrNotFound || (err == nil && address == "") {
+		return peer.AddrInfo{}, fmt.Errorf("%w: %s", ErrPeerNotFound, name)
+	}
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("failed to look up peer %s: %v", name, err)
+	}
+	return AddrInfoFromString(address)
+}
+
+// Peers lists active keepers with a connection address and every node in
+// peer_registry
+func (s *RegistrySource) Peers(ctx context.Context) (map[string]peer.AddrInfo, error) {
+	session := s.db.Session()
+	peers := make(map[string]peer.AddrInfo)
+
+	iter := session.Query(`
+        SELECT withdrawal_address, connection_address, status FROM triggerx.keeper_data`).Iter()
+	var name, address string
+	var active bool
+	for iter.Scan(&name, &address, &active) {
+		if !active || address == "" {
+			continue
+		}
+		if info, err := AddrInfoFromString(address); err == nil {
+			peers[name] = info
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to list keepers: %v", err)
+	}
+
+	iter = session.Query(`
+        SELECT name, address FROM triggerx.peer_registry`).Iter()
+	for iter.Scan(&name, &address) {
+		if info, err := AddrInfoFromString(address); err == nil {
+			peers[name] = info
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to list registered peers: %v", err)
+	}
+	return peers, nil
+}
+
+func (s *RegistrySource) keeperID(name string) (int64, error) {
+	var keeperID int64
+	for _, candidate := range addressForms(name) {
+		err := s.db.Session().Query(`
+            SELECT keeper_id FROM triggerx.keeper_data WHERE withdrawal_address = ? ALLOW FILTERING`,
+			candidate).Scan(&keeperID)
+		if err == nil {
+			return keeperID, nil
+		}
+		if err != gocql.ErrNotFound {
+			return 0, fmt.Errorf("failed to look up keeper %s: %v", name, err)
+		}
+	}
+	return 0, gocql.ErrNotFound
+}
+
+// addressForms lists the forms keeper addresses are stored in, checksummed
+// or lowercase. Other names are looked up as given.
+func addressForms(name string) []string {
+	if !common.IsHexAddress(name) {
+		return []string{name}
+	}
+	checksummed := common.HexToAddress(name).Hex()
+	return []string{checksummed, strings.ToLower(checksummed)}
+}
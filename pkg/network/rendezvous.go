@@ -0,0 +1,160 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+)
+
+// maxRendezvousCandidates is how many advertisers of a name are checked
+const maxRendezvousCandidates = 8
+
+// RendezvousSource finds peers through rendezvous namespaces. Every node
+// advertises under Namespace, to be listed, and under Namespace/<name>, to
+// be found by name.
+type RendezvousSource struct {
+	host      host.Host
+	discovery discovery.Discovery
+
+	mu         sync.Mutex
+	advertised map[string]bool
+}
+
+// DHTProtocolPrefix keeps the TriggerX DHT apart from the public IPFS one
+const DHTProtocolPrefix = "/" + Namespace
+
+// NewDHT starts a Kademlia DHT node and connects it to the bootstrap peers.
+// Nodes reachable from the internet also serve the DHT to others.
+func NewDHT(ctx context.Context, h host.Host, bootstrap StaticSource) (*dht.IpfsDHT, error) {
+	var peers []peer.AddrInfo
+	for _, info := range bootstrap {
+		peers = append(peers, info)
+	}
+	router, err := dht.New(ctx, h,
+		dht.Mode(dht.ModeAutoServer),
+		dht.ProtocolPrefix(DHTProtocolPrefix),
+		dht.BootstrapPeers(peers...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start DHT: %v", err)
+	}
+	if err := router.Bootstrap(ctx); err != nil {
+		router.Close()
+		return nil, fmt.Errorf("failed to bootstrap DHT: %v", err)
+	}
+	return router, nil
+}
+
+// NewDHTSource uses a Kademlia DHT, or any other content router, for
+// rendezvous
+func NewDHTSource(h host.Host, router routing.ContentRouting) *RendezvousSource {
+	return NewRendezvousSource(h, drouting.NewRoutingDiscovery(router))
+}
+
+func NewRendezvousSource(h host.Host, d discovery.Discovery) *RendezvousSource {
+	return &RendezvousSource{host: h, discovery: d, advertised: make(map[string]bool)}
+}
+
+// Advertise keeps both namespaces advertised until ctx ends. The refreshes
+// pick up the node's current addresses, so advertising a name again while
+// it is advertised does nothing.
+func (s *RendezvousSource) Advertise(ctx context.Context, name string, info peer.AddrInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.advertised[name] {
+		return nil
+	}
+	s.advertised[name] = true
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.advertised, name)
+		s.mu.Unlock()
+	}()
+
+	dutil.Advertise(ctx, s.discovery, Namespace)
+	dutil.Advertise(ctx, s.discovery, nameNamespace(name))
+	return nil
+}
+
+// FindPeer returns the first advertiser of name that proves it runs under
+// name. Anyone can advertise in a namespace, so each candidate is checked
+// with resolveName.
+func (s *RendezvousSource) FindPeer(ctx context.Context, name string) (peer.AddrInfo, error) {
+	peers, err := dutil.FindPeers(ctx, s.discovery, nameNamespace(name), discovery.Limit(maxRendezvousCandidates))
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("failed to find %s: %v", name, err)
+	}
+	for _, info := range peers {
+		if info.ID == s.host.ID() || len(info.Addrs) == 0 {
+			continue
+		}
+		resolved, err := s.resolveName(ctx, info)
+		if err != nil {
+			log.Printf("Skipping rendezvous peer %s for %s: %v", info.ID, name, err)
+			continue
+		}
+		if resolved != name {
+			log.Printf("Skipping rendezvous peer %s for %s: it runs as %s", info.ID, name, resolved)
+			continue
+		}
+		return info, nil
+	}
+	return peer.AddrInfo{}, fmt.Errorf("%w: %s", ErrPeerNotFound, name)
+}
+
+func (s *RendezvousSource) Peers(ctx context.Context) (map[string]peer.AddrInfo, error) {
+	found, err := dutil.FindPeers(ctx, s.discovery, Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list peers: %v", err)
+	}
+
+	peers := make(map[string]peer.AddrInfo, len(found))
+	for _, info := range found {
+		if info.ID == s.host.ID() {
+			continue
+		}
+		name, err := s.resolveName(ctx, info)
+		if err != nil {
+			log.Printf("Skipping rendezvous peer %s: %v", info.ID, err)
+			continue
+		}
+		peers[name] = info
+	}
+	return peers, nil
+}
+
+// resolveName asks a peer for its name. A name that is an operator address
+// must also be proven by the peer's operator binding, so keepers found
+// through rendezvous need a bound identity.
+func (s *RendezvousSource) resolveName(ctx context.Context, info peer.AddrInfo) (string, error) {
+	name, err := queryName(ctx, s.host, info)
+	if err != nil {
+		return "", err
+	}
+	if !common.IsHexAddress(name) {
+		return name, nil
+	}
+	operator, err := QueryOperator(ctx, s.host, info.ID)
+	if err != nil {
+		return "", fmt.Errorf("cannot prove it runs as %s: %v", name, err)
+	}
+	if operator != common.HexToAddress(name) {
+		return "", fmt.Errorf("it runs as %s but is bound to operator %s", name, operator.Hex())
+	}
+	return name, nil
+}
+
+func nameNamespace(name string) string {
+	return Namespace + "/" + name
+}
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+// memoryRendezvous is a rendezvous point anyone can advertise any peer on
+type memoryRendezvous struct {
+	mu         sync.Mutex
+	namespaces map[string][]peer.AddrInfo
+}
+
+func newMemoryRendezvous() *memoryRendezvous {
+	return &memoryRendezvous{namespaces: make(map[string][]peer.AddrInfo)}
+}
+
+func (r *memoryRendezvous) add(ns string, info peer.AddrInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces[ns] = append(r.namespaces[ns], info)
+}
+
+func (r *memoryRendezvous) Advertise(ctx context.Context, ns string, opts ...discovery.Option) (time.Duration, error) {
+	return time.Hour, nil
+}
+
+func (r *memoryRendezvous) FindPeers(ctx context.Context, ns string, opts ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found := make(chan peer.AddrInfo, len(r.namespaces[ns]))
+	for _, info := range r.namespaces[ns] {
+		found <- info
+	}
+	close(found)
+	return found, nil
+}
+
+func TestRendezvousFindPeerSkipsImpostors(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+
+	// A keeper named by its operator address with a bound identity
+	key, err := ethcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := identity.Bind(key); err != nil {
+		t.Fatal(err)
+	}
+	operator := ethcrypto.PubkeyToAddress(key.PublicKey).Hex()
+	keeper := tn.addNodeWithIdentity(operator, key, identity)
+	// A peer that answers with the keeper's name but cannot prove it
+	impostor := tn.addNode(operator)
+	if err := tn.mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	rendezvous := newMemoryRendezvous()
+	rendezvous.add(nameNamespace(operator), peerInfo(impostor))
+	rendezvous.add(nameNamespace(operator), peerInfo(keeper))
+	// keeper1 advertised under a name it does not run as
+	rendezvous.add(nameNamespace(testManager), peerInfo(tn.node("keeper1")))
+	source := NewRendezvousSource(tn.node(testManager).host, rendezvous)
+
+	info, err := source.FindPeer(tn.ctx, operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != keeper.host.ID() {
+		t.Fatalf("found %s, want bound keeper %s", info.ID, keeper.host.ID())
+	}
+
+	if _, err := source.FindPeer(tn.ctx, testManager); !errors.Is(err, ErrPeerNotFound) {
+		t.Fatalf("finding a name only an impostor advertises returned %v", err)
+	}
+}
+
+func TestDHTSourceFindsPeer(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1", "keeper2")
+	bootstrap := StaticSource{testManager: peerInfo(tn.node(testManager))}
+
+	sources := make(map[string]*RendezvousSource)
+	for _, name := range []string{testManager, "keeper1", "keeper2"} {
+		h := tn.node(name).host
+		// Mocknet hosts do not detect their reachability, the DHT serves
+		// others once a node is publicly reachable
+		emitter, err := h.EventBus().Emitter(new(event.EvtLocalReachabilityChanged), eventbus.Stateful)
+		if err != nil {
+			t.Fatal(err)
+		}
+		emitter.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPublic})
+		emitter.Close()
+
+		router, err := NewDHT(tn.ctx, h, bootstrap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { router.Close() })
+		sources[name] = NewDHTSource(h, router)
+	}
+
+	keeper1 := tn.node("keeper1")
+	if err := sources["keeper1"].Advertise(tn.ctx, "keeper1", peerInfo(keeper1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "keeper2 to find keeper1 in the DHT", func() bool {
+		info, err := sources["keeper2"].FindPeer(tn.ctx, "keeper1")
+		return err == nil && info.ID == keeper1.host.ID()
+	})
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reply codes of MessageProtocolV2
+const (
+	ReplyOK              = "ok"
+	ReplyInvalidMessage  = "invalid_message"
+	ReplyUnauthenticated = "unauthenticated"
+	ReplyUnknownType     = "unknown_type"
+	ReplyInvalidPayload  = "invalid_payload"
+	ReplyRejected        = "rejected"
+	ReplyNotFound        = "not_found"
+)
+
+// Reply answers a MessageProtocolV2 or MessageProtocolV3 message
+type Reply struct {
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReplyError is a message the receiver did not accept. Handlers return one
+// to choose the reply code, other errors are sent as ReplyRejected.
+type ReplyError struct {
+	Code    string
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// IsUnknownType reports whether the receiver had no handler for a message
+func IsUnknownType(err error) bool {
+	var replyErr *ReplyError
+	return errors.As(err, &replyErr) && replyErr.Code == ReplyUnknownType
+}
+
+// DecodePayload decodes a message payload, rejecting fields v does not have
+func DecodePayload(content json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+	return nil
+}
+
+func writeReply(codec *streamCodec, handleErr error) error {
+	reply := Reply{Code: ReplyOK}
+	if handleErr != nil {
+		reply = Reply{Code: ReplyRejected, Error: handleErr.Error()}
+		var replyErr *ReplyError
+		if errors.As(handleErr, &replyErr) {
+			reply = Reply{Code: replyErr.Code, Error: replyErr.Message}
+		}
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	codec.stream.SetWriteDeadline(time.Now().Add(replyTimeout))
+	return codec.Write(data)
+}
+
+func readReply(codec *streamCodec) error {
+	codec.stream.SetReadDeadline(time.Now().Add(replyTimeout))
+	line, err := codec.Read()
+	if err != nil {
+		return fmt.Errorf("error reading reply: %v", err)
+	}
+
+	var reply Reply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		return fmt.Errorf("invalid reply: %v", err)
+	}
+	if reply.Code != ReplyOK {
+		return &ReplyError{Code: reply.Code, Message: reply.Error}
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// RPCProtocol carries one request and its response per stream
+const RPCProtocol = "/triggerx/rpc/1.0.0"
+
+// RPCProtocolV2 is RPCProtocol with the request and response sent as
+// frames
+const RPCProtocolV2 = "/triggerx/rpc/2.0.0"
+
+const (
+	// DefaultRPCTimeout bounds calls whose context has no deadline
+	DefaultRPCTimeout = 30 * time.Second
+	// responseCacheTTL is how long responses are kept to answer retried
+	// requests without handling them again
+	responseCacheTTL = 5 * time.Minute
+	retryBackoff     = time.Second
+)
+
+// ErrUnreachable is returned when a call got no response: the peer could
+// not be reached, the stream broke or the deadline passed. A *ReplyError
+// means the peer answered and rejected the request.
+var ErrUnreachable = errors.New("peer unreachable")
+
+// RPCHandler answers a request. The context ends at the caller's deadline.
+// Returned errors are sent back as a *ReplyError, ReplyRejected unless the
+// handler returns a *ReplyError itself.
+type RPCHandler func(ctx context.Context, msg Message, params json.RawMessage) (interface{}, error)
+
+type rpcRequest struct {
+	ID       string `json:"id"`
+	Deadline int64  `json:"deadline"`
+	Message
+}
+
+type rpcRequestWire struct {
+	ID       string `json:"id"`
+	Deadline int64  `json:"deadline"`
+	wireMessage
+}
+
+type rpcResponse struct {
+	ID     string          `json:"id"`
+	Code   string          `json:"code"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+type cachedResponse struct {
+	response rpcResponse
+	at       time.Time
+}
+
+// CallOptions tune a call
+type CallOptions struct {
+	// Retries is how often an idempotent call is sent again after getting
+	// no response. The request keeps its ID, so a peer that did handle it
+	// answers from its response cache.
+	Retries int
+	// Idempotent allows retries
+	Idempotent bool
+}
+
+// RPC sends requests to and answers requests from peers, signing and
+// verifying them like the Messaging it is created on
+type RPC struct {
+	messaging *Messaging
+
+	mu        sync.RWMutex
+	handlers  map[string]RPCHandler
+	responses map[string]cachedResponse
+}
+
+func NewRPC(m *Messaging) *RPC {
+	r := &RPC{
+		messaging: m,
+		handlers:  make(map[string]RPCHandler),
+		responses: make(map[string]cachedResponse),
+	}
+	m.host.SetStreamHandler(protocol.ID(RPCProtocolV2), r.handleStream)
+	m.host.SetStreamHandler(protocol.ID(RPCProtocol), r.handleStream)
+	return r
+}
+
+// Register sets the handler of a method
+func (r *RPC) Register(method string, handler RPCHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[method] = handler
+}
+
+// RegisterTyped sets a handler that gets its params decoded into P.
+// Params with fields P does not have are rejected.
+func RegisterTyped[P, R any](r *RPC, method string, handler func(ctx context.Context, msg Message, params P) (R, error)) {
+	r.Register(method, func(ctx context.Context, msg Message, raw json.RawMessage) (interface{}, error) {
+		var params P
+		if err := DecodePayload(raw, &params); err != nil {
+			return nil, &ReplyError{Code: ReplyInvalidPayload, Message: err.Error()}
+		}
+		msg.Content = params
+		return handler(ctx, msg, params)
+	})
+}
+
+// Call sends a request and decodes the result into result, which may be
+// nil. It returns an error wrapping ErrUnreachable when no response came and
+// a *ReplyError when the peer rejected the request.
+func (r *RPC) Call(ctx context.Context, to string, peerID peer.ID, method string, params, result interface{}, opts CallOptions) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRPCTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	msg, err := r.messaging.newMessage(to, method, params)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to create request ID: %v", err)
+	}
+	request := rpcRequest{ID: hex.EncodeToString(id), Deadline: deadline.UnixMilli(), Message: msg}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error marshaling request: %v", err)
+	}
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+
+	attempts := 1
+	if opts.Idempotent {
+		attempts += opts.Retries
+	}
+
+	var response rpcResponse
+	for attempt := 1; ; attempt++ {
+		response, err = r.roundTrip(ctx, peerID, data)
+		if err == nil || attempt >= attempts {
+			break
+		}
+		log.Printf("Call %s to %s failed, retrying (%d/%d): %v", method, to, attempt, attempts-1, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s: %v", ErrUnreachable, to, ctx.Err())
+		case <-time.After(retryBackoff * time.Duration(attempt)):
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if response.ID != request.ID {
+		return fmt.Errorf("response to %s does not match request %s", response.ID, request.ID)
+	}
+	if response.Code != ReplyOK {
+		return &ReplyError{Code: response.Code, Message: response.Error}
+	}
+	if result != nil && len(response.Result) > 0 {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("invalid result of %s: %v", method, err)
+		}
+	}
+	return nil
+}
+
+func (r *RPC) roundTrip(ctx context.Context, peerID peer.ID, request []byte) (rpcResponse, error) {
+	stream, err := r.messaging.host.NewStream(allowRelayed(ctx), peerID, protocol.ID(RPCProtocolV2), protocol.ID(RPCProtocol))
+	if err != nil {
+		return rpcResponse{}, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer stream.Close()
+
+	deadline, _ := ctx.Deadline()
+	stream.SetDeadline(deadline)
+
+	// Not every transport enforces deadlines, so the stream is also reset
+	// when the context ends
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Reset()
+		case <-done:
+		}
+	}()
+
+	codec := r.codec(stream)
+	if err := codec.Write(request); err != nil {
+		stream.Reset()
+		return rpcResponse{}, fmt.Errorf("%w: error sending request: %v", ErrUnreachable, err)
+	}
+	line, err := codec.Read()
+	if err != nil {
+		stream.Reset()
+		return rpcResponse{}, fmt.Errorf("%w: no response: %v", ErrUnreachable, err)
+	}
+
+	var response rpcResponse
+	if err := json.Unmarshal(line, &response); err != nil {
+		return rpcResponse{}, fmt.Errorf("invalid response: %v", err)
+	}
+	return response, nil
+}
+
+func (r *RPC) handleStream(stream network.Stream) {
+	defer stream.Close()
+	remote := stream.Conn().RemotePeer()
+	codec := r.codec(stream)
+
+	line, err := codec.Read()
+	if err != nil {
+		if errors.Is(err, ErrMessageTooLarge) {
+			r.respond(codec, rpcResponse{Code: ReplyInvalidMessage, Error: err.Error()})
+		}
+		return
+	}
+
+	var request rpcRequestWire
+	if err := json.Unmarshal(line, &request); err != nil {
+		r.respond(codec, rpcResponse{Code: ReplyInvalidMessage, Error: err.Error()})
+		return
+	}
+
+	// Retries are keyed by the sending peer, which the transport
+	// authenticates, so a peer only sees its own cached responses
+	cacheKey := remote.String() + "/" + request.ID
+	if response, ok := r.cached(cacheKey); ok {
+		r.respond(codec, response)
+		return
+	}
+
+	response := r.handle(remote, request)
+	response.ID = request.ID
+	r.cache(cacheKey, response)
+	r.respond(codec, response)
+}
+
+// codec frames a stream when it speaks RPCProtocolV2
+func (r *RPC) codec(stream network.Stream) *streamCodec {
+	return newStreamCodec(stream, stream.Protocol() == protocol.ID(RPCProtocolV2), r.messaging.compression)
+}
+
+func (r *RPC) handle(remote peer.ID, request rpcRequestWire) rpcResponse {
+	deadline := time.UnixMilli(request.Deadline)
+	if request.Deadline == 0 || deadline.After(time.Now().Add(MaxMessageTTL)) {
+		deadline = time.Now().Add(DefaultRPCTimeout)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	msg := request.Message
+	if verifier := r.messaging.verifier; verifier != nil {
+		if err := verifier.Verify(ctx, &msg, request.Content); err != nil {
+			return rpcResponse{Code: ReplyUnauthenticated, Error: err.Error()}
+		}
+	}
+	r.messaging.rememberPeer(msg.From, remote)
+
+	r.mu.RLock()
+	handler, ok := r.handlers[msg.Type]
+	r.mu.RUnlock()
+	if !ok {
+		return rpcResponse{Code: ReplyUnknownType, Error: fmt.Sprintf("unknown method %q", msg.Type)}
+	}
+
+	result, err := handler(ctx, msg, request.Content)
+	if err != nil {
+		var replyErr *ReplyError
+		if errors.As(err, &replyErr) {
+			return rpcResponse{Code: replyErr.Code, Error: replyErr.Message}
+		}
+		return rpcResponse{Code: ReplyRejected, Error: err.Error()}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return rpcResponse{Code: ReplyRejected, Error: fmt.Sprintf("failed to encode result: %v", err)}
+	}
+	return rpcResponse{Code: ReplyOK, Result: data}
+}
+
+func (r *RPC) respond(codec *streamCodec, response rpcResponse) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		return
+	}
+	if len(data) > MaxMessageSize {
+		response = rpcResponse{ID: response.ID, Code: ReplyRejected, Error: fmt.Sprintf("result of %d bytes is too large", len(data))}
+		data, _ = json.Marshal(response)
+	}
+	codec.stream.SetWriteDeadline(time.Now().Add(replyTimeout))
+	if err := codec.Write(data); err != nil {
+		log.Printf("Failed to send response to %s: %v", codec.stream.Conn().RemotePeer(), err)
+	}
+}
+
+func (r *RPC) cached(key string) (rpcResponse, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.responses[key]
+	if !ok || time.Since(entry.at) > responseCacheTTL {
+		return rpcResponse{}, false
+	}
+	return entry.response, true
+}
+
+func (r *RPC) cache(key string, response rpcResponse) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, entry := range r.responses {
+		if time.Since(entry.at) > responseCacheTTL {
+			delete(r.responses, k)
+		}
+	}
+	r.responses[key] = cachedResponse{response: response, at: time.Now()}
+}
+
+// JobAck is a keeper's answer to a JobTransmissionMessage or
+// TaskAssignmentMessage call
+type JobAck struct {
+	JobID  string `json:"job_id"`
+	Keeper string `json:"keeper"`
+}
+
+// TaskAssignment is the content of a TaskAssignmentMessage
+type TaskAssignment struct {
+	JobID  string `json:"job_id"`
+	TaskID int64  `json:"task_id"`
+}
@@ -0,0 +1,86 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// acknowledgeJobs makes a keeper answer job transmissions like the keeper
+// node does, counting the jobs it handled
+func acknowledgeJobs(node *testNode) *int32 {
+	var handled int32
+	RegisterTyped(node.rpc, JobTransmissionMessage, func(ctx context.Context, msg Message, job testJob) (JobAck, error) {
+		if job.JobID == "" {
+			return JobAck{}, errors.New("job without ID")
+		}
+		atomic.AddInt32(&handled, 1)
+		return JobAck{JobID: job.JobID, Keeper: node.name}, nil
+	})
+	return &handled
+}
+
+func TestRPCAcknowledgesJob(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	handled := acknowledgeJobs(tn.node("keeper1"))
+	keeperID := tn.connect(testManager, "keeper1")
+	manager := tn.node(testManager).rpc
+
+	var ack JobAck
+	if err := manager.Call(tn.ctx, "keeper1", keeperID, JobTransmissionMessage, testJob{JobID: "7"}, &ack, CallOptions{}); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if ack.JobID != "7" || ack.Keeper != "keeper1" || atomic.LoadInt32(handled) != 1 {
+		t.Fatalf("got ack %+v after %d jobs", ack, atomic.LoadInt32(handled))
+	}
+
+	err := manager.Call(tn.ctx, "keeper1", keeperID, JobTransmissionMessage, testJob{}, nil, CallOptions{})
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != ReplyRejected {
+		t.Fatalf("got %v, want reply code %s", err, ReplyRejected)
+	}
+	if errors.Is(err, ErrUnreachable) {
+		t.Fatal("a rejection counts as unreachable")
+	}
+}
+
+func TestRPCUnreachableKeeper(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	handled := acknowledgeJobs(tn.node("keeper1"))
+	keeperID := tn.connect(testManager, "keeper1")
+	manager := tn.node(testManager).rpc
+
+	tn.partition(testManager, "keeper1")
+	ctx, cancel := context.WithTimeout(tn.ctx, time.Second)
+	defer cancel()
+	err := manager.Call(ctx, "keeper1", keeperID, JobTransmissionMessage, testJob{JobID: "1"}, nil, CallOptions{Idempotent: true, Retries: 2})
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("call to a partitioned keeper returned %v, want ErrUnreachable", err)
+	}
+	if atomic.LoadInt32(handled) != 0 {
+		t.Fatal("partitioned keeper handled the job")
+	}
+}
+
+func TestRPCDeadlineOnSlowLink(t *testing.T) {
+	tn := newTestNetwork(t, "keeper1")
+	acknowledgeJobs(tn.node("keeper1"))
+	keeperID := tn.connect(testManager, "keeper1")
+	manager := tn.node(testManager).rpc
+
+	tn.setLatency(testManager, "keeper1", 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(tn.ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := manager.Call(ctx, "keeper1", keeperID, JobTransmissionMessage, testJob{JobID: "1"}, nil, CallOptions{}); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("call past its deadline returned %v, want ErrUnreachable", err)
+	}
+
+	ctx, cancel = context.WithTimeout(tn.ctx, testTimeout)
+	defer cancel()
+	if err := manager.Call(ctx, "keeper1", keeperID, JobTransmissionMessage, testJob{JobID: "2"}, nil, CallOptions{}); err != nil {
+		t.Fatalf("call with time to spare failed: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/trigg3rX/go-backend/pkg/database"
+)
+
+const (
+	// NameProtocol lets a node ask a peer found by mDNS or the DHT for the
+	// name it runs under
+	NameProtocol = "/triggerx/name/1.0.0"
+
+	// Namespace prefixes rendezvous and mDNS service names
+	Namespace = "triggerx"
+
+	nameQueryTimeout = 10 * time.Second
+)
+
+// ErrPeerNotFound is returned by a PeerSource that does not know a name
+var ErrPeerNotFound = errors.New("peer not found")
+
+// PeerSource is a peer discovery backend. Peers are named by keeper name or
+// operator address, the task manager and the aggregator by their fixed
+// names.
+type PeerSource interface {
+	// Advertise makes this node findable under name. It returns once the
+	// node is advertised, keeping the advertisement fresh until ctx ends.
+	Advertise(ctx context.Context, name string, info peer.AddrInfo) error
+	// FindPeer returns the addresses of a named peer
+	FindPeer(ctx context.Context, name string) (peer.AddrInfo, error)
+	// Peers lists every peer the backend knows by name
+	Peers(ctx context.Context) (map[string]peer.AddrInfo, error)
+}
+
+// MultiSource combines backends. Nodes advertise on all of them and peers
+// are looked up in order.
+type MultiSource []PeerSource
+
+func (m MultiSource) Advertise(ctx context.Context, name string, info peer.AddrInfo) error {
+	var errs []error
+	for _, source := range m {
+		if err := source.Advertise(ctx, name, info); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSource) FindPeer(ctx context.Context, name string) (peer.AddrInfo, error) {
+	var errs []error
+	for _, source := range m {
+		info, err := source.FindPeer(ctx, name)
+		if err == nil {
+			return info, nil
+		}
+		if !errors.Is(err, ErrPeerNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return peer.AddrInfo{}, errors.Join(errs...)
+	}
+	return peer.AddrInfo{}, fmt.Errorf("%w: %s", ErrPeerNotFound, name)
+}
+
+// Peers merges the peers of every backend, earlier backends win
+func (m MultiSource) Peers(ctx context.Context) (map[string]peer.AddrInfo, error) {
+	peers := make(map[string]peer.AddrInfo)
+	for i := len(m) - 1; i >= 0; i-- {
+		found, err := m[i].Peers(ctx)
+		if err != nil {
+			log.Printf("Peer discovery backend failed: %v", err)
+			continue
+		}
+		for name, info := range found {
+			peers[name] = info
+		}
+	}
+	return peers, nil
+}
+
+// StaticSource is a fixed bootstrap list of named peers
+type StaticSource map[string]peer.AddrInfo
+
+// ParseStaticSource reads a bootstrap list of comma separated
+// name=multiaddr entries. Each multiaddr must end in /p2p/<peer ID>.
+func ParseStaticSource(list string) (StaticSource, error) {
+	source := make(StaticSource)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bootstrap peer %q, expected name=multiaddr", entry)
+		}
+		info, err := AddrInfoFromString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %s: %v", parts[0], err)
+		}
+		if existing, ok := source[parts[0]]; ok && existing.ID == info.ID {
+			info.Addrs = append(existing.Addrs, info.Addrs...)
+		}
+		source[parts[0]] = info
+	}
+	return source, nil
+}
+
+// Advertise does nothing, a static list is configured by hand
+func (s StaticSource) Advertise(ctx context.Context, name string, info peer.AddrInfo) error {
+	return nil
+}
+
+func (s StaticSource) FindPeer(ctx context.Context, name string) (peer.AddrInfo, error) {
+	info, ok := s[name]
+	if !ok {
+		return peer.AddrInfo{}, fmt.Errorf("%w: %s", ErrPeerNotFound, name)
+	}
+	return info, nil
+}
+
+func (s StaticSource) Peers(ctx context.Context) (map[string]peer.AddrInfo, error) {
+	peers := make(map[string]peer.AddrInfo, len(s))
+	for name, info := range s {
+		peers[name] = info
+	}
+	return peers, nil
+}
+
+// SourceFromEnv builds the backends listed in TRIGGERX_DISCOVERY, a comma
+// separated list of registry, static, mdns and dht. The default is
+// "static,mdns", with registry first when db is given. static reads
+// TRIGGERX_BOOTSTRAP_PEERS. registry uses db, or connects to the database
+// itself when db is nil. dht joins the TriggerX Kademlia DHT through the
+// bootstrap peers.
+func SourceFromEnv(h host.Host, db *database.Connection) (PeerSource, error) {
+	backends := os.Getenv("TRIGGERX_DISCOVERY")
+	if backends == "" {
+		backends = "static,mdns"
+		if db != nil {
+			backends = "registry," + backends
+		}
+	}
+
+	bootstrap, err := ParseStaticSource(os.Getenv("TRIGGERX_BOOTSTRAP_PEERS"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sources MultiSource
+	for _, backend := range strings.Split(backends, ",") {
+		switch strings.TrimSpace(backend) {
+		case "static":
+			sources = append(sources, bootstrap)
+		case "mdns":
+			sources = append(sources, NewMDNSSource(h))
+		case "registry":
+			if db == nil {
+				conn, err := database.NewConnection(database.NewConfig())
+				if err != nil {
+					return nil, fmt.Errorf("failed to connect to peer registry: %v", err)
+				}
+				db = conn
+			}
+			sources = append(sources, NewRegistrySource(db))
+		case "dht":
+			router, err := NewDHT(context.Background(), h, bootstrap)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, NewDHTSource(h, router))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown discovery backend %q", backend)
+		}
+	}
+	return sources, nil
+}
+
+// AddrInfoFromString parses a multiaddr ending in /p2p/<peer ID>. Several
+// addresses of one peer may be given comma separated.
+func AddrInfoFromString(addresses string) (peer.AddrInfo, error) {
+	var info peer.AddrInfo
+	for _, address := range strings.Split(addresses, ",") {
+		maddr, err := multiaddr.NewMultiaddr(strings.TrimSpace(address))
+		if err != nil {
+			return peer.AddrInfo{}, fmt.Errorf("invalid peer address: %v", err)
+		}
+		addrInfo, err := peer.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return peer.AddrInfo{}, fmt.Errorf("invalid peer info: %v", err)
+		}
+		if info.ID != "" && info.ID != addrInfo.ID {
+			return peer.AddrInfo{}, fmt.Errorf("addresses of different peers: %s", addresses)
+		}
+		info.ID = addrInfo.ID
+		info.Addrs = append(info.Addrs, addrInfo.Addrs...)
+	}
+	return info, nil
+}
+
+// AddrInfoString formats addresses the way AddrInfoFromString reads them
+func AddrInfoString(info peer.AddrInfo) string {
+	addrs, err := peer.AddrInfoToP2pAddrs(&info)
+	if err != nil {
+		return ""
+	}
+	parts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		parts[i] = addr.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// serveName answers name queries with this node's name
+func serveName(h host.Host, name string) {
+	h.SetStreamHandler(protocol.ID(NameProtocol), func(stream network.Stream) {
+		defer stream.Close()
+		stream.SetWriteDeadline(time.Now().Add(nameQueryTimeout))
+		if _, err := stream.Write([]byte(name + "\n")); err != nil {
+			log.Printf("Failed to answer name query: %v", err)
+		}
+	})
+}
+
+// queryName connects to a peer and asks for its name
+func queryName(ctx context.Context, h host.Host, info peer.AddrInfo) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, nameQueryTimeout)
+	defer cancel()
+
+	if err := h.Connect(ctx, info); err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %v", info.ID, err)
+	}
+	stream, err := h.NewStream(allowRelayed(ctx), info.ID, protocol.ID(NameProtocol))
+	if err != nil {
+		return "", fmt.Errorf("failed to query name of %s: %v", info.ID, err)
+	}
+	defer stream.Close()
+
+	stream.SetReadDeadline(time.Now().Add(nameQueryTimeout))
+	name, err := bufio.NewReader(stream).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read name of %s: %v", info.ID, err)
+	}
+	return strings.TrimSpace(name), nil
+}
@@ -0,0 +1,228 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// TransferProtocol moves items too large for one message, such as cached
+// scripts, between peers. The item is sent as a header frame, chunks of at
+// most ChunkSize and a trailer frame with the hash of the item.
+const TransferProtocol = "/triggerx/transfer/1.0.0"
+
+const (
+	// ChunkSize is the largest chunk an item is sent in
+	ChunkSize = 256 << 10
+	// MaxTransferSize bounds the size of a transferred item
+	MaxTransferSize = 256 << 20
+)
+
+// ErrItemNotFound is returned by an ItemProvider for keys it does not have
+var ErrItemNotFound = errors.New("item not found")
+
+// ItemProvider opens the item stored under key for the named requester and
+// returns its size
+type ItemProvider func(requester, key string) (io.ReadCloser, int64, error)
+
+// transferRequest asks for an item. It is signed like a Message, the
+// signature also covers the peer IDs of both ends.
+type transferRequest struct {
+	Key       string       `json:"key"`
+	From      string       `json:"from,omitempty"`
+	Timestamp string       `json:"timestamp,omitempty"`
+	Auth      *MessageAuth `json:"auth,omitempty"`
+}
+
+type transferHeader struct {
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+	Size  int64  `json:"size"`
+}
+
+type transferTrailer struct {
+	SHA256 string `json:"sha256"`
+}
+
+// ServeItems answers TransferProtocol requests on the host of m with the
+// items of provider. Requests are checked by the verifier of m, so provider
+// is only asked for items on behalf of authenticated peers.
+func ServeItems(m *Messaging, provider ItemProvider) {
+	m.host.SetStreamHandler(protocol.ID(TransferProtocol), func(stream network.Stream) {
+		defer stream.Close()
+		if err := serveItem(stream, m, provider); err != nil {
+			log.Printf("Failed to send item to %s: %v", stream.Conn().RemotePeer(), err)
+			stream.Reset()
+		}
+	})
+}
+
+func serveItem(stream network.Stream, m *Messaging, provider ItemProvider) error {
+	compression := m.Compression()
+	stream.SetReadDeadline(time.Now().Add(replyTimeout))
+	data, err := ReadFrame(bufio.NewReader(stream), MaxMessageSize)
+	if err != nil {
+		return fmt.Errorf("failed to read request: %v", err)
+	}
+	var request transferRequest
+	if err := DecodePayload(data, &request); err != nil {
+		return writeJSONFrame(stream, transferHeader{Code: ReplyInvalidMessage, Error: err.Error()}, compression)
+	}
+
+	if verifier := m.Verifier(); verifier != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+		err := verifier.verifyTransfer(ctx, &request, stream.Conn().RemotePeer(), m.host.ID())
+		cancel()
+		if err != nil {
+			log.Printf("Dropped transfer request from %s: %v", stream.Conn().RemotePeer(), err)
+			return writeJSONFrame(stream, transferHeader{Code: ReplyUnauthenticated, Error: err.Error()}, compression)
+		}
+	}
+
+	item, size, err := provider(request.From, request.Key)
+	if err != nil {
+		code := ReplyRejected
+		if errors.Is(err, ErrItemNotFound) {
+			code = ReplyNotFound
+		}
+		return writeJSONFrame(stream, transferHeader{Code: code, Error: err.Error()}, compression)
+	}
+	defer item.Close()
+	if size < 0 || size > MaxTransferSize {
+		return writeJSONFrame(stream, transferHeader{Code: ReplyRejected, Error: fmt.Sprintf("item of %d bytes is too large", size)}, compression)
+	}
+
+	if err := writeJSONFrame(stream, transferHeader{Code: ReplyOK, Size: size}, compression); err != nil {
+		return err
+	}
+
+	digest := sha256.New()
+	chunk := make([]byte, ChunkSize)
+	for sent := int64(0); sent < size; {
+		n, err := io.ReadFull(item, chunk[:min(int64(ChunkSize), size-sent)])
+		if err != nil {
+			return fmt.Errorf("failed to read item %s: %v", request.Key, err)
+		}
+		digest.Write(chunk[:n])
+		stream.SetWriteDeadline(time.Now().Add(replyTimeout))
+		if err := WriteFrame(stream, chunk[:n], compression); err != nil {
+			return err
+		}
+		sent += int64(n)
+	}
+	return writeJSONFrame(stream, transferTrailer{SHA256: hex.EncodeToString(digest.Sum(nil))}, compression)
+}
+
+// FetchItem copies the item stored under key on a peer to w and returns
+// its size. The request is sent as the node of m and signed by its signer.
+// The item is written chunk by chunk, so on error w may hold part of it.
+func FetchItem(ctx context.Context, m *Messaging, peerID peer.ID, key string, w io.Writer) (int64, error) {
+	request := transferRequest{
+		Key:       key,
+		From:      m.name,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if signer := m.Signer(); signer != nil {
+		if err := signTransferRequest(signer, &request, m.host.ID(), peerID); err != nil {
+			return 0, err
+		}
+	}
+
+	stream, err := m.host.NewStream(ctx, peerID, protocol.ID(TransferProtocol))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer stream.Close()
+
+	// Not every transport enforces deadlines, so the stream is also reset
+	// when the context ends
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Reset()
+		case <-done:
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := writeJSONFrame(stream, request, CompressionNone); err != nil {
+		return 0, fmt.Errorf("error sending request: %v", err)
+	}
+
+	reader := bufio.NewReader(stream)
+	var header transferHeader
+	if err := readJSONFrame(reader, &header); err != nil {
+		return 0, fmt.Errorf("error reading item header: %v", err)
+	}
+	if header.Code != ReplyOK {
+		return 0, &ReplyError{Code: header.Code, Message: header.Error}
+	}
+	if header.Size < 0 || header.Size > MaxTransferSize {
+		return 0, fmt.Errorf("%w: item of %d bytes", ErrMessageTooLarge, header.Size)
+	}
+
+	digest := sha256.New()
+	received, err := copyChunks(reader, io.MultiWriter(w, digest), header.Size)
+	if err != nil {
+		return received, err
+	}
+
+	var trailer transferTrailer
+	if err := readJSONFrame(reader, &trailer); err != nil {
+		return received, fmt.Errorf("error reading item trailer: %v", err)
+	}
+	if sum := hex.EncodeToString(digest.Sum(nil)); sum != trailer.SHA256 {
+		return received, fmt.Errorf("item %s has hash %s, sender announced %s", key, sum, trailer.SHA256)
+	}
+	return received, nil
+}
+
+// copyChunks copies the chunks of an item of size bytes to w
+func copyChunks(r *bufio.Reader, w io.Writer, size int64) (int64, error) {
+	var received int64
+	for received < size {
+		chunk, err := ReadFrame(r, ChunkSize)
+		if err != nil {
+			return received, fmt.Errorf("error reading chunk at %d: %v", received, err)
+		}
+		if len(chunk) == 0 || received+int64(len(chunk)) > size {
+			return received, fmt.Errorf("invalid chunk of %d bytes at %d", len(chunk), received)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return received, err
+		}
+		received += int64(len(chunk))
+	}
+	return received, nil
+}
+
+func writeJSONFrame(w io.Writer, v interface{}, compression Compression) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return WriteFrame(w, data, compression)
+}
+
+func readJSONFrame(r *bufio.Reader, v interface{}) error {
+	data, err := ReadFrame(r, MaxMessageSize)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,22 @@
+package types
+
+// TaskSignature is a keeper's BLS signature over a task response, sent to
+// the aggregator. All fields are 0x-prefixed hex.
+type TaskSignature struct {
+    TaskID       string `json:"task_id"`
+    ResponseHash string `json:"response_hash"`
+    OperatorID   string `json:"operator_id"`
+    Signature    string `json:"signature"`
+    PubkeyG2     string `json:"pubkey_g2"`
+}
+
+// TaskResult is a keeper's announcement of a task it executed, so the other
+// keepers of the task's quorums can check the execution and sign the same
+// response. TaskID, TxHash and ResponseHash are 0x-prefixed hex.
+type TaskResult struct {
+    TaskID       string `json:"task_id"`
+    JobID        int64  `json:"job_id"`
+    TxHash       string `json:"tx_hash"`
+    Valid        bool   `json:"valid"`
+    ResponseHash string `json:"response_hash"`
+}
@@ -0,0 +1,3 @@
+#! /bin/bash
+
+go run ./cmd/quorumsnapshot/main.go "$@"